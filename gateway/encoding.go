@@ -9,6 +9,13 @@ import (
 	"go.sia.tech/core/types"
 )
 
+const (
+	// maxSharedNodes is the maximum number of peers in a ShareNodes response.
+	maxSharedNodes = 100
+	// maxSendBlocks is the maximum number of blocks in a SendBlocks response.
+	maxSendBlocks = 10
+)
+
 func withEncoder(w io.Writer, fn func(*types.Encoder)) error {
 	var buf bytes.Buffer
 	e := types.NewEncoder(&buf)
@@ -74,12 +81,17 @@ func (r *RPCShareNodes) encodeResponse(e *types.Encoder) {
 	}
 }
 func (r *RPCShareNodes) decodeResponse(d *types.Decoder) {
-	r.Peers = make([]string, d.ReadPrefix())
+	n := d.ReadPrefix()
+	if n > maxSharedNodes {
+		d.SetErr(fmt.Errorf("too many peers (%v > %v)", n, maxSharedNodes))
+		return
+	}
+	r.Peers = make([]string, n)
 	for i := range r.Peers {
 		r.Peers[i] = d.ReadString()
 	}
 }
-func (r *RPCShareNodes) maxResponseLen() int { return 100 * 128 }
+func (r *RPCShareNodes) maxResponseLen() int { return maxSharedNodes * 128 }
 
 type RequestBMM struct {
 	emptyRequest
@@ -123,12 +135,17 @@ func (r *RPCSendBlocks) encodeBlocksResponse(e *types.Encoder) {
 	}
 }
 func (r *RPCSendBlocks) decodeBlocksResponse(d *types.Decoder) {
-	r.Blocks = make([]types.Block, d.ReadPrefix())
+	n := d.ReadPrefix()
+	if n > maxSendBlocks {
+		d.SetErr(fmt.Errorf("too many blocks (%v > %v)", n, maxSendBlocks))
+		return
+	}
+	r.Blocks = make([]types.Block, n)
 	for i := range r.Blocks {
 		r.Blocks[i].DecodeFrom(d)
 	}
 }
-func (r *RPCSendBlocks) maxBlocksResponseLen() int { return 10 * 5e6 }
+func (r *RPCSendBlocks) maxBlocksResponseLen() int { return maxSendBlocks * 5e6 }
 func (r *RPCSendBlocks) encodeMoreAvailableResponse(e *types.Encoder) {
 	e.WriteBool(r.MoreAvailable)
 }
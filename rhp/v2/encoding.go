@@ -1,20 +1,68 @@
 package rhp
 
 import (
+	"encoding/binary"
+	"fmt"
+
 	"go.sia.tech/core/types"
 )
 
+const (
+	defaultMaxLen = 10e3 // for revisions, proofs, etc.
+	largeMaxLen   = 1e6  // for transactions
+
+	// maxProofValues is the maximum number of valid or missed proof values in
+	// a request that revises a contract.
+	maxProofValues = 16
+
+	// maxWriteActions is the maximum number of actions in a single Write RPC,
+	// and maxWriteSectors is the maximum amount of data, in sectors, that
+	// those actions may upload in total.
+	maxWriteActions = 1000
+	maxWriteSectors = 4
+
+	// Limits on the number of elements in other decoded slices. Without them,
+	// a length prefix is bounded only by the size of the message, which
+	// permits allocations far larger than the message itself.
+	maxCiphers             = 16
+	maxTransactionSetLen   = 1000 // transactions, inputs, or outputs
+	maxTransactionElements = 10e3 // any slice within a transaction, via SetMaxAlloc
+	maxSignatures          = 100
+	maxReadSections        = 256
+	maxMerkleProofLen      = 1 << 12
+	maxSectorRoots         = 1 << 20
+)
+
+// readPrefix reads a length prefix, setting d's error if it exceeds max.
+func readPrefix(d *types.Decoder, max int) int {
+	n := d.ReadPrefix()
+	if n > max {
+		d.SetErr(fmt.Errorf("encoded object contains too many elements (%v > %v)", n, max))
+		return 0
+	}
+	return n
+}
+
 // A ProtocolObject is an object that can be serialized for transport in the
-// renter-host protocol.
+// renter-host protocol. MaxLen returns the maximum encoded length of the
+// object, which bounds how many bytes are read when receiving it.
 type ProtocolObject interface {
 	types.EncoderTo
 	types.DecoderFrom
+	MaxLen() int
 }
 
 // EncodeTo implements ProtocolObject.
 func (r *RPCError) EncodeTo(e *types.Encoder) {
-	r.Type.EncodeTo(e)
-	e.WriteBytes(r.Data)
+	if r.Code != ErrCodeUnknown {
+		var code [8]byte
+		binary.LittleEndian.PutUint64(code[:], uint64(r.Code))
+		errorTypeCode.EncodeTo(e)
+		e.WriteBytes(code[:])
+	} else {
+		r.Type.EncodeTo(e)
+		e.WriteBytes(r.Data)
+	}
 	e.WriteString(r.Description)
 }
 
@@ -23,6 +71,14 @@ func (r *RPCError) DecodeFrom(d *types.Decoder) {
 	r.Type.DecodeFrom(d)
 	r.Data = d.ReadBytes()
 	r.Description = d.ReadString()
+	if r.Type == errorTypeCode && len(r.Data) == 8 {
+		r.Code = ErrorCode(binary.LittleEndian.Uint64(r.Data))
+	}
+}
+
+// MaxLen implements ProtocolObject.
+func (r *RPCError) MaxLen() int {
+	return 1024 // arbitrary
 }
 
 // EncodeTo implements ProtocolObject.
@@ -45,6 +101,15 @@ func (resp *rpcResponse) DecodeFrom(d *types.Decoder) {
 	resp.data.DecodeFrom(d)
 }
 
+// MaxLen implements ProtocolObject.
+func (resp *rpcResponse) MaxLen() int {
+	n := (*RPCError)(nil).MaxLen()
+	if resp.data != nil && resp.data.MaxLen() > n {
+		n = resp.data.MaxLen()
+	}
+	return 1 + n
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *loopKeyExchangeRequest) EncodeTo(e *types.Encoder) {
 	loopEnter.EncodeTo(e)
@@ -59,12 +124,17 @@ func (r *loopKeyExchangeRequest) EncodeTo(e *types.Encoder) {
 func (r *loopKeyExchangeRequest) DecodeFrom(d *types.Decoder) {
 	new(types.Specifier).DecodeFrom(d) // loopEnter
 	d.Read(r.PublicKey[:])
-	r.Ciphers = make([]types.Specifier, d.ReadPrefix())
+	r.Ciphers = make([]types.Specifier, readPrefix(d, maxCiphers))
 	for i := range r.Ciphers {
 		r.Ciphers[i].DecodeFrom(d)
 	}
 }
 
+// MaxLen implements ProtocolObject.
+func (r *loopKeyExchangeRequest) MaxLen() int {
+	return minMessageSize
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *loopKeyExchangeResponse) EncodeTo(e *types.Encoder) {
 	e.Write(r.PublicKey[:])
@@ -79,6 +149,26 @@ func (r *loopKeyExchangeResponse) DecodeFrom(d *types.Decoder) {
 	r.Cipher.DecodeFrom(d)
 }
 
+// MaxLen implements ProtocolObject.
+func (r *loopKeyExchangeResponse) MaxLen() int {
+	return 32 + 8 + 64 + 16
+}
+
+// EncodeTo implements ProtocolObject.
+func (r *loopRekeyMessage) EncodeTo(e *types.Encoder) {
+	e.Write(r.PublicKey[:])
+}
+
+// DecodeFrom implements ProtocolObject.
+func (r *loopRekeyMessage) DecodeFrom(d *types.Decoder) {
+	d.Read(r.PublicKey[:])
+}
+
+// MaxLen implements ProtocolObject.
+func (r *loopRekeyMessage) MaxLen() int {
+	return 32
+}
+
 // RPCFormContract
 
 // EncodeTo implements ProtocolObject.
@@ -92,13 +182,19 @@ func (r *RPCFormContractRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCFormContractRequest) DecodeFrom(d *types.Decoder) {
-	r.Transactions = make([]types.Transaction, d.ReadPrefix())
+	d.SetMaxAlloc(maxTransactionElements)
+	r.Transactions = make([]types.Transaction, readPrefix(d, maxTransactionSetLen))
 	for i := range r.Transactions {
 		r.Transactions[i].DecodeFrom(d)
 	}
 	r.RenterKey.DecodeFrom(d)
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCFormContractRequest) MaxLen() int {
+	return largeMaxLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCFormContractAdditions) EncodeTo(e *types.Encoder) {
 	e.WritePrefix(len(r.Parents))
@@ -117,20 +213,26 @@ func (r *RPCFormContractAdditions) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCFormContractAdditions) DecodeFrom(d *types.Decoder) {
-	r.Parents = make([]types.Transaction, d.ReadPrefix())
+	d.SetMaxAlloc(maxTransactionElements)
+	r.Parents = make([]types.Transaction, readPrefix(d, maxTransactionSetLen))
 	for i := range r.Parents {
 		r.Parents[i].DecodeFrom(d)
 	}
-	r.Inputs = make([]types.SiacoinInput, d.ReadPrefix())
+	r.Inputs = make([]types.SiacoinInput, readPrefix(d, maxTransactionSetLen))
 	for i := range r.Inputs {
 		r.Inputs[i].DecodeFrom(d)
 	}
-	r.Outputs = make([]types.SiacoinOutput, d.ReadPrefix())
+	r.Outputs = make([]types.SiacoinOutput, readPrefix(d, maxTransactionSetLen))
 	for i := range r.Outputs {
 		r.Outputs[i].DecodeFrom(d)
 	}
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCFormContractAdditions) MaxLen() int {
+	return largeMaxLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCFormContractSignatures) EncodeTo(e *types.Encoder) {
 	e.WritePrefix(len(r.ContractSignatures))
@@ -142,13 +244,18 @@ func (r *RPCFormContractSignatures) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCFormContractSignatures) DecodeFrom(d *types.Decoder) {
-	r.ContractSignatures = make([]types.TransactionSignature, d.ReadPrefix())
+	r.ContractSignatures = make([]types.TransactionSignature, readPrefix(d, maxSignatures))
 	for i := range r.ContractSignatures {
 		r.ContractSignatures[i].DecodeFrom(d)
 	}
 	r.RevisionSignature.DecodeFrom(d)
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCFormContractSignatures) MaxLen() int {
+	return defaultMaxLen
+}
+
 // RPCRenewAndClear
 
 // EncodeTo implements ProtocolObject.
@@ -170,21 +277,27 @@ func (r *RPCRenewAndClearContractRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCRenewAndClearContractRequest) DecodeFrom(d *types.Decoder) {
-	r.Transactions = make([]types.Transaction, d.ReadPrefix())
+	d.SetMaxAlloc(maxTransactionElements)
+	r.Transactions = make([]types.Transaction, readPrefix(d, maxTransactionSetLen))
 	for i := range r.Transactions {
 		r.Transactions[i].DecodeFrom(d)
 	}
 	r.RenterKey.DecodeFrom(d)
-	r.FinalValidProofValues = make([]types.Currency, d.ReadPrefix())
+	r.FinalValidProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.FinalValidProofValues {
 		r.FinalValidProofValues[i].DecodeFrom(d)
 	}
-	r.FinalMissedProofValues = make([]types.Currency, d.ReadPrefix())
+	r.FinalMissedProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.FinalMissedProofValues {
 		r.FinalMissedProofValues[i].DecodeFrom(d)
 	}
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCRenewAndClearContractRequest) MaxLen() int {
+	return largeMaxLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCRenewAndClearContractSignatures) EncodeTo(e *types.Encoder) {
 	e.WritePrefix(len(r.ContractSignatures))
@@ -197,7 +310,7 @@ func (r *RPCRenewAndClearContractSignatures) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCRenewAndClearContractSignatures) DecodeFrom(d *types.Decoder) {
-	r.ContractSignatures = make([]types.TransactionSignature, d.ReadPrefix())
+	r.ContractSignatures = make([]types.TransactionSignature, readPrefix(d, maxSignatures))
 	for i := range r.ContractSignatures {
 		r.ContractSignatures[i].DecodeFrom(d)
 	}
@@ -205,6 +318,11 @@ func (r *RPCRenewAndClearContractSignatures) DecodeFrom(d *types.Decoder) {
 	copy(r.FinalRevisionSignature[:], d.ReadBytes())
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCRenewAndClearContractSignatures) MaxLen() int {
+	return defaultMaxLen
+}
+
 // RPCLock
 
 // EncodeTo implements ProtocolObject.
@@ -221,6 +339,11 @@ func (r *RPCLockRequest) DecodeFrom(d *types.Decoder) {
 	r.Timeout = d.ReadUint64()
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCLockRequest) MaxLen() int {
+	return 32 + 8 + 64 + 8
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCLockResponse) EncodeTo(e *types.Encoder) {
 	e.WriteBool(r.Acquired)
@@ -237,12 +360,17 @@ func (r *RPCLockResponse) DecodeFrom(d *types.Decoder) {
 	r.Acquired = d.ReadBool()
 	d.Read(r.NewChallenge[:])
 	r.Revision.DecodeFrom(d)
-	r.Signatures = make([]types.TransactionSignature, d.ReadPrefix())
+	r.Signatures = make([]types.TransactionSignature, readPrefix(d, maxSignatures))
 	for i := range r.Signatures {
 		r.Signatures[i].DecodeFrom(d)
 	}
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCLockResponse) MaxLen() int {
+	return defaultMaxLen
+}
+
 // RPCRead
 
 // EncodeTo implements ProtocolObject.
@@ -268,7 +396,7 @@ func (r *RPCReadRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCReadRequest) DecodeFrom(d *types.Decoder) {
-	r.Sections = make([]RPCReadRequestSection, d.ReadPrefix())
+	r.Sections = make([]RPCReadRequestSection, readPrefix(d, maxReadSections))
 	for i := range r.Sections {
 		d.Read(r.Sections[i].MerkleRoot[:])
 		r.Sections[i].Offset = d.ReadUint64()
@@ -276,17 +404,22 @@ func (r *RPCReadRequest) DecodeFrom(d *types.Decoder) {
 	}
 	r.MerkleProof = d.ReadBool()
 	r.RevisionNumber = d.ReadUint64()
-	r.ValidProofValues = make([]types.Currency, d.ReadPrefix())
+	r.ValidProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.ValidProofValues {
 		r.ValidProofValues[i].DecodeFrom(d)
 	}
-	r.MissedProofValues = make([]types.Currency, d.ReadPrefix())
+	r.MissedProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.MissedProofValues {
 		r.MissedProofValues[i].DecodeFrom(d)
 	}
 	copy(r.Signature[:], d.ReadBytes())
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCReadRequest) MaxLen() int {
+	return defaultMaxLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCReadResponse) EncodeTo(e *types.Encoder) {
 	e.WriteBytes(r.Signature[:])
@@ -313,12 +446,18 @@ func (r *RPCReadResponse) DecodeFrom(d *types.Decoder) {
 	r.Data = r.Data[:dataLen]
 	d.Read(r.Data)
 
-	r.MerkleProof = make([]types.Hash256, d.ReadPrefix())
+	r.MerkleProof = make([]types.Hash256, readPrefix(d, maxMerkleProofLen))
 	for i := range r.MerkleProof {
 		d.Read(r.MerkleProof[i][:])
 	}
 }
 
+// MaxLen implements ProtocolObject. Read responses are streamed, so callers
+// should pass a bound derived from the requested section lengths instead.
+func (r *RPCReadResponse) MaxLen() int {
+	return SectorSize + defaultMaxLen
+}
+
 // RPCSectorRoots
 
 // EncodeTo implements ProtocolObject.
@@ -342,17 +481,22 @@ func (r *RPCSectorRootsRequest) DecodeFrom(d *types.Decoder) {
 	r.RootOffset = d.ReadUint64()
 	r.NumRoots = d.ReadUint64()
 	r.RevisionNumber = d.ReadUint64()
-	r.ValidProofValues = make([]types.Currency, d.ReadPrefix())
+	r.ValidProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.ValidProofValues {
 		r.ValidProofValues[i].DecodeFrom(d)
 	}
-	r.MissedProofValues = make([]types.Currency, d.ReadPrefix())
+	r.MissedProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.MissedProofValues {
 		r.MissedProofValues[i].DecodeFrom(d)
 	}
 	copy(r.Signature[:], d.ReadBytes())
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCSectorRootsRequest) MaxLen() int {
+	return defaultMaxLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCSectorRootsResponse) EncodeTo(e *types.Encoder) {
 	e.WriteBytes(r.Signature[:])
@@ -369,16 +513,23 @@ func (r *RPCSectorRootsResponse) EncodeTo(e *types.Encoder) {
 // DecodeFrom implements ProtocolObject.
 func (r *RPCSectorRootsResponse) DecodeFrom(d *types.Decoder) {
 	copy(r.Signature[:], d.ReadBytes())
-	r.SectorRoots = make([]types.Hash256, d.ReadPrefix())
+	r.SectorRoots = make([]types.Hash256, readPrefix(d, maxSectorRoots))
 	for i := range r.SectorRoots {
 		d.Read(r.SectorRoots[i][:])
 	}
-	r.MerkleProof = make([]types.Hash256, d.ReadPrefix())
+	r.MerkleProof = make([]types.Hash256, readPrefix(d, maxMerkleProofLen))
 	for i := range r.MerkleProof {
 		d.Read(r.MerkleProof[i][:])
 	}
 }
 
+// MaxLen implements ProtocolObject. Callers that request more than a few
+// hundred roots should pass a bound derived from the number of roots instead.
+// No more than maxSectorRoots roots can be decoded from a single response.
+func (r *RPCSectorRootsResponse) MaxLen() int {
+	return defaultMaxLen
+}
+
 // RPCSettings
 
 // EncodeTo implements ProtocolObject.
@@ -391,6 +542,11 @@ func (r *RPCSettingsResponse) DecodeFrom(d *types.Decoder) {
 	r.Settings = d.ReadBytes()
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCSettingsResponse) MaxLen() int {
+	return defaultMaxLen
+}
+
 // RPCWrite
 
 // EncodeTo implements ProtocolObject.
@@ -416,7 +572,7 @@ func (r *RPCWriteRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCWriteRequest) DecodeFrom(d *types.Decoder) {
-	r.Actions = make([]RPCWriteAction, d.ReadPrefix())
+	r.Actions = make([]RPCWriteAction, readPrefix(d, maxWriteActions))
 	for i := range r.Actions {
 		d.Read(r.Actions[i].Type[:])
 		r.Actions[i].A = d.ReadUint64()
@@ -425,16 +581,25 @@ func (r *RPCWriteRequest) DecodeFrom(d *types.Decoder) {
 	}
 	r.MerkleProof = d.ReadBool()
 	r.RevisionNumber = d.ReadUint64()
-	r.ValidProofValues = make([]types.Currency, d.ReadPrefix())
+	r.ValidProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.ValidProofValues {
 		r.ValidProofValues[i].DecodeFrom(d)
 	}
-	r.MissedProofValues = make([]types.Currency, d.ReadPrefix())
+	r.MissedProofValues = make([]types.Currency, readPrefix(d, maxProofValues))
 	for i := range r.MissedProofValues {
 		r.MissedProofValues[i].DecodeFrom(d)
 	}
 }
 
+// MaxLen implements ProtocolObject. A Write request contains at most
+// maxWriteActions actions, which together carry at most maxWriteSectors
+// sectors of data.
+func (r *RPCWriteRequest) MaxLen() int {
+	const actionLen = 16 + 8 + 8 + 8                 // type, A, B, and data length prefix
+	const proofValuesLen = 8 + maxProofValues*(8+16) // length-prefixed currencies
+	return 8 + maxWriteActions*actionLen + maxWriteSectors*SectorSize + 1 + 8 + 2*proofValuesLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCWriteMerkleProof) EncodeTo(e *types.Encoder) {
 	e.WritePrefix(len(r.OldSubtreeHashes))
@@ -450,17 +615,22 @@ func (r *RPCWriteMerkleProof) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements ProtocolObject.
 func (r *RPCWriteMerkleProof) DecodeFrom(d *types.Decoder) {
-	r.OldSubtreeHashes = make([]types.Hash256, d.ReadPrefix())
+	r.OldSubtreeHashes = make([]types.Hash256, readPrefix(d, maxMerkleProofLen))
 	for i := range r.OldSubtreeHashes {
 		d.Read(r.OldSubtreeHashes[i][:])
 	}
-	r.OldLeafHashes = make([]types.Hash256, d.ReadPrefix())
+	r.OldLeafHashes = make([]types.Hash256, readPrefix(d, maxMerkleProofLen))
 	for i := range r.OldLeafHashes {
 		d.Read(r.OldLeafHashes[i][:])
 	}
 	d.Read(r.NewMerkleRoot[:])
 }
 
+// MaxLen implements ProtocolObject.
+func (r *RPCWriteMerkleProof) MaxLen() int {
+	return defaultMaxLen
+}
+
 // EncodeTo implements ProtocolObject.
 func (r *RPCWriteResponse) EncodeTo(e *types.Encoder) {
 	e.WriteBytes(r.Signature[:])
@@ -470,3 +640,8 @@ func (r *RPCWriteResponse) EncodeTo(e *types.Encoder) {
 func (r *RPCWriteResponse) DecodeFrom(d *types.Decoder) {
 	copy(r.Signature[:], d.ReadBytes())
 }
+
+// MaxLen implements ProtocolObject.
+func (r *RPCWriteResponse) MaxLen() int {
+	return 8 + 64
+}
@@ -26,20 +26,36 @@ import (
 // minMessageSize is the minimum size of an RPC message. If an encoded message
 // would be smaller than minMessageSize, the sender MAY pad it with random data.
 // This hinders traffic analysis by obscuring the true sizes of messages.
+//
+// By default, a Transport pads its messages to minMessageSize; this can be
+// changed with SetMinMessageSize. Messages of up to minMessageSize are always
+// accepted from the peer.
 const minMessageSize = 4096
 
 var (
 	// Handshake specifiers
 	loopEnter = types.NewSpecifier("LoopEnter")
 	loopExit  = types.NewSpecifier("LoopExit")
+	loopRekey = types.NewSpecifier("LoopRekey")
 
-	// RPC ciphers
-	cipherChaCha20Poly1305 = types.NewSpecifier("ChaCha20Poly1305")
-	cipherNoOverlap        = types.NewSpecifier("NoOverlap")
+	// RPC ciphers. A Specifier holds at most 16 bytes, so XChaCha20-Poly1305
+	// uses the shortened "XChaChaPoly1305" name, which fits.
+	CipherChaCha20Poly1305  = types.NewSpecifier("ChaCha20Poly1305")
+	CipherXChaCha20Poly1305 = types.NewSpecifier("XChaChaPoly1305")
+	cipherNoOverlap         = types.NewSpecifier("NoOverlap")
+
+	// DefaultCiphers are the ciphers proposed by NewRenterTransport, in order
+	// of preference.
+	DefaultCiphers = []types.Specifier{CipherChaCha20Poly1305, CipherXChaCha20Poly1305}
 
 	// ErrRenterClosed is returned by (*Transport).ReadID when the renter sends the
 	// Transport termination signal.
 	ErrRenterClosed = errors.New("renter has terminated Transport")
+
+	// ErrRPCLimitExceeded is returned when the peer sends more data within a
+	// single RPC than the limit set by (*Transport).SetRPCByteLimit. The
+	// Transport is closed when this occurs.
+	ErrRPCLimitExceeded = errors.New("RPC exceeded byte limit")
 )
 
 // wrapResponseErr formats RPC response errors nicely, wrapping them in either
@@ -62,6 +78,22 @@ func generateX25519KeyPair() (xsk []byte, xpk [32]byte) {
 	return
 }
 
+// newAEAD returns the AEAD identified by c, or false if c is not a supported
+// cipher. Every supported cipher must be a ChaCha20 variant, since RawResponse
+// decrypts messages with a raw ChaCha20 stream.
+func newAEAD(c types.Specifier, key []byte) (cipher.AEAD, bool) {
+	switch c {
+	case CipherChaCha20Poly1305:
+		aead, _ := chacha20poly1305.New(key) // no error possible
+		return aead, true
+	case CipherXChaCha20Poly1305:
+		aead, _ := chacha20poly1305.NewX(key) // no error possible
+		return aead, true
+	default:
+		return nil, false
+	}
+}
+
 func deriveSharedSecret(xsk []byte, xpk [32]byte) ([]byte, error) {
 	secret, err := curve25519.X25519(xsk, xpk[:])
 	if err != nil {
@@ -71,11 +103,55 @@ func deriveSharedSecret(xsk []byte, xpk [32]byte) ([]byte, error) {
 	return key[:], nil
 }
 
+// An ErrorCode identifies a well-known RPC error, allowing clients to handle
+// errors without inspecting their descriptions.
+type ErrorCode uint64
+
+// Well-known RPC error codes.
+const (
+	ErrCodeUnknown ErrorCode = iota
+	ErrCodeInsufficientFunds
+	ErrCodeContractNotFound
+	ErrCodeContractLocked
+	ErrCodeInvalidSignature
+	ErrCodeInvalidRevision
+	ErrCodeSectorNotFound
+)
+
+// Error implements the error interface.
+func (c ErrorCode) Error() string {
+	switch c {
+	case ErrCodeInsufficientFunds:
+		return "insufficient funds"
+	case ErrCodeContractNotFound:
+		return "contract not found"
+	case ErrCodeContractLocked:
+		return "contract is locked"
+	case ErrCodeInvalidSignature:
+		return "invalid signature"
+	case ErrCodeInvalidRevision:
+		return "invalid revision"
+	case ErrCodeSectorNotFound:
+		return "sector not found"
+	default:
+		return fmt.Sprintf("unknown error code %d", uint64(c))
+	}
+}
+
+// errorTypeCode is the RPCError Type used to transmit an ErrorCode. The code
+// is stored in Data, which keeps the encoding compatible with peers that do
+// not understand codes.
+var errorTypeCode = types.NewSpecifier("ErrorCode")
+
 // An RPCError may be sent instead of a response object to any RPC.
 type RPCError struct {
 	Type        types.Specifier
 	Data        []byte // structure depends on Type
 	Description string // human-readable error string
+
+	// Code, if non-zero, identifies the error. When sent, it replaces Type
+	// and Data.
+	Code ErrorCode
 }
 
 // Error implements the error interface.
@@ -83,8 +159,20 @@ func (e *RPCError) Error() string {
 	return e.Description
 }
 
-// Is reports whether this error matches target.
+// Is reports whether this error matches target. If both errors carry a code,
+// the codes are compared; otherwise, Is reports whether the description
+// contains target's error string.
 func (e *RPCError) Is(target error) bool {
+	var code ErrorCode
+	switch t := target.(type) {
+	case ErrorCode:
+		code = t
+	case *RPCError:
+		code = t.Code
+	}
+	if e.Code != ErrCodeUnknown && code != ErrCodeUnknown {
+		return e.Code == code
+	}
 	return strings.Contains(e.Description, target.Error())
 }
 
@@ -99,6 +187,7 @@ type rpcResponse struct {
 // version 2.
 type Transport struct {
 	conn      net.Conn
+	cipher    types.Specifier
 	aead      cipher.AEAD
 	key       []byte // for RawResponse
 	inbuf     bytes.Buffer
@@ -106,11 +195,15 @@ type Transport struct {
 	challenge [16]byte
 	isRenter  bool
 	hostKey   types.PublicKey
+	rpcLimit  uint64 // max bytes read per RPC
+	rpcRead   uint64
 
-	mu     sync.Mutex
-	r, w   uint64
-	err    error // set when Transport is prematurely closed
-	closed bool
+	mu      sync.Mutex
+	r, w    uint64
+	padded  uint64
+	padSize uint64
+	err     error // set when Transport is prematurely closed
+	closed  bool
 }
 
 func (t *Transport) setErr(err error) {
@@ -133,6 +226,45 @@ func (t *Transport) BytesRead() uint64 { return atomic.LoadUint64(&t.r) }
 // BytesWritten returns the number of bytes written to the underlying connection.
 func (t *Transport) BytesWritten() uint64 { return atomic.LoadUint64(&t.w) }
 
+// BytesPadded returns the number of padding bytes added to written messages.
+// These bytes are included in BytesWritten.
+func (t *Transport) BytesPadded() uint64 { return atomic.LoadUint64(&t.padded) }
+
+// SetMinMessageSize sets the size to which outgoing messages are padded. The
+// default, and maximum, is 4096 bytes; a size of 0 disables padding. Padding
+// obscures the true sizes of messages, so it should only be reduced on trusted
+// networks.
+func (t *Transport) SetMinMessageSize(n uint64) {
+	if n > minMessageSize {
+		n = minMessageSize
+	}
+	atomic.StoreUint64(&t.padSize, n)
+}
+
+// SetRPCByteLimit limits the total number of bytes that may be read within a
+// single RPC, across all of its messages. An RPC begins when the renter writes
+// a request or the host reads one. A limit of 0 disables the check.
+func (t *Transport) SetRPCByteLimit(n uint64) {
+	t.rpcLimit = n
+}
+
+// beginRPC resets the per-RPC read counter.
+func (t *Transport) beginRPC() {
+	t.rpcRead = 0
+}
+
+// spendRPCBytes adds n to the per-RPC read counter. If the limit is exceeded,
+// the Transport is closed.
+func (t *Transport) spendRPCBytes(n uint64) error {
+	t.rpcRead += n
+	if t.rpcLimit != 0 && t.rpcRead > t.rpcLimit {
+		err := fmt.Errorf("%w (%v > %v bytes)", ErrRPCLimitExceeded, t.rpcRead, t.rpcLimit)
+		t.setErr(err)
+		return err
+	}
+	return nil
+}
+
 // PrematureCloseErr returns the error that resulted in the Transport being closed
 // prematurely.
 func (t *Transport) PrematureCloseErr() error {
@@ -200,8 +332,9 @@ func (t *Transport) writeMessage(obj ProtocolObject) error {
 	nonce := make([]byte, 32)[:t.aead.NonceSize()] // avoid heap alloc
 	frand.Read(nonce)
 
+	padSize := int(atomic.LoadUint64(&t.padSize))
 	t.outbuf.Reset()
-	t.outbuf.Grow(minMessageSize)
+	t.outbuf.Grow(padSize)
 	e := types.NewEncoder(&t.outbuf)
 	e.WritePrefix(0) // placeholder
 	e.Write(nonce)
@@ -210,8 +343,9 @@ func (t *Transport) writeMessage(obj ProtocolObject) error {
 
 	// overwrite message length
 	msgSize := t.outbuf.Len() + t.aead.Overhead()
-	if msgSize < minMessageSize {
-		msgSize = minMessageSize
+	if msgSize < padSize {
+		atomic.AddUint64(&t.padded, uint64(padSize-msgSize))
+		msgSize = padSize
 	}
 	t.outbuf.Grow(t.aead.Overhead())
 	msg := t.outbuf.Bytes()[:msgSize]
@@ -228,10 +362,15 @@ func (t *Transport) writeMessage(obj ProtocolObject) error {
 	return err
 }
 
+// readMessage reads and decrypts a message into obj. If maxLen is 0, the bound
+// is derived from obj.MaxLen.
 func (t *Transport) readMessage(obj ProtocolObject, maxLen uint64) error {
 	if err := t.PrematureCloseErr(); err != nil {
 		return err
 	}
+	if maxLen == 0 {
+		maxLen = uint64(t.aead.NonceSize() + obj.MaxLen() + t.aead.Overhead())
+	}
 	if maxLen < minMessageSize {
 		maxLen = minMessageSize
 	}
@@ -243,6 +382,8 @@ func (t *Transport) readMessage(obj ProtocolObject, maxLen uint64) error {
 		return fmt.Errorf("message size (%v bytes) exceeds maxLen of %v bytes", msgSize, maxLen)
 	} else if msgSize < uint64(t.aead.NonceSize()+t.aead.Overhead()) {
 		return fmt.Errorf("message size (%v bytes) is too small (nonce + MAC is %v bytes)", msgSize, t.aead.NonceSize()+t.aead.Overhead())
+	} else if err := t.spendRPCBytes(8 + msgSize); err != nil {
+		return err
 	}
 	t.inbuf.Reset()
 	t.inbuf.Grow(int(msgSize))
@@ -261,6 +402,7 @@ func (t *Transport) readMessage(obj ProtocolObject, maxLen uint64) error {
 		return err
 	}
 	d = types.NewBufDecoder(plaintext)
+	d.SetMaxAlloc(SectorSize) // no RHP object contains more than a sector's worth of elements
 	obj.DecodeFrom(d)
 	return d.Err()
 }
@@ -268,6 +410,7 @@ func (t *Transport) readMessage(obj ProtocolObject, maxLen uint64) error {
 // WriteRequest sends an encrypted RPC request, comprising an RPC ID and a
 // request object.
 func (t *Transport) WriteRequest(rpcID types.Specifier, req ProtocolObject) error {
+	t.beginRPC()
 	if err := t.writeMessage(&rpcID); err != nil {
 		return fmt.Errorf("WriteRequestID: %w", err)
 	}
@@ -281,16 +424,82 @@ func (t *Transport) WriteRequest(rpcID types.Specifier, req ProtocolObject) erro
 
 // ReadID reads an RPC request ID. If the renter sends the Transport termination
 // signal, ReadID returns ErrRenterClosed.
+//
+// If the renter requests a rekey, ReadID completes the exchange and then reads
+// the next ID.
 func (t *Transport) ReadID() (rpcID types.Specifier, err error) {
 	defer wrapErr(&err, "ReadID")
-	err = t.readMessage(&rpcID, minMessageSize)
+	for {
+		// the ID (or rekey exchange) belongs to the new RPC, not the last one
+		t.beginRPC()
+		err = t.readMessage(&rpcID, minMessageSize)
+		if err != nil || rpcID != loopRekey {
+			break
+		} else if err = t.acceptRekey(); err != nil {
+			return
+		}
+	}
 	if rpcID == loopExit {
 		err = ErrRenterClosed
 	}
 	return
 }
 
-// ReadRequest reads an RPC request using the new loop protocol.
+// setKey replaces the Transport's cipher key.
+func (t *Transport) setKey(key []byte) {
+	aead, _ := newAEAD(t.cipher, key) // cipher was already negotiated
+	t.mu.Lock()
+	t.aead, t.key = aead, key
+	t.mu.Unlock()
+}
+
+// Rekey replaces the Transport's cipher key with one derived from a fresh
+// X25519 exchange with the host. The negotiated cipher is unchanged. Rekey is
+// only valid for renters, and must not be called while an RPC is in progress.
+func (t *Transport) Rekey() (err error) {
+	defer wrapErr(&err, "Rekey")
+	if !t.isRenter {
+		return errors.New("only the renter may initiate a rekey")
+	}
+	t.beginRPC()
+	xsk, xpk := generateX25519KeyPair()
+	if err := t.writeMessage(&loopRekey); err != nil {
+		return err
+	} else if err := t.writeMessage(&loopRekeyMessage{PublicKey: xpk}); err != nil {
+		return err
+	}
+	var resp loopRekeyMessage
+	if err := t.readMessage(&resp, minMessageSize); err != nil {
+		return err
+	}
+	key, err := deriveSharedSecret(xsk, resp.PublicKey)
+	if err != nil {
+		return err
+	}
+	t.setKey(key)
+	return nil
+}
+
+// acceptRekey conducts the host's half of the rekey exchange. The exchange is
+// authenticated by the current key, so no signature is necessary.
+func (t *Transport) acceptRekey() error {
+	var req loopRekeyMessage
+	if err := t.readMessage(&req, minMessageSize); err != nil {
+		return err
+	}
+	xsk, xpk := generateX25519KeyPair()
+	key, err := deriveSharedSecret(xsk, req.PublicKey)
+	if err != nil {
+		return err
+	} else if err := t.writeMessage(&loopRekeyMessage{PublicKey: xpk}); err != nil {
+		return err
+	}
+	t.setKey(key)
+	return nil
+}
+
+// ReadRequest reads an RPC request using the new loop protocol. The size of
+// the request is limited by maxLen, or by req.MaxLen if maxLen is 0.
 func (t *Transport) ReadRequest(req ProtocolObject, maxLen uint64) (err error) {
 	defer wrapErr(&err, "ReadRequest")
 	return t.readMessage(req, maxLen)
@@ -303,18 +512,22 @@ func (t *Transport) WriteResponse(resp ProtocolObject) (e error) {
 }
 
 // WriteResponseErr writes an error. If err is an *RPCError, it is sent
-// directly; otherwise, a generic RPCError is created from err's Error string.
+// directly; otherwise, a generic RPCError is created from err's Error string,
+// carrying the ErrorCode that err wraps, if any.
 func (t *Transport) WriteResponseErr(err error) (e error) {
 	defer wrapErr(&e, "WriteResponseErr")
 	re, ok := err.(*RPCError)
 	if err != nil && !ok {
 		re = &RPCError{Description: err.Error()}
+		errors.As(err, &re.Code)
 	}
 	return t.writeMessage(&rpcResponse{re, nil})
 }
 
 // ReadResponse reads an RPC response. If the response is an error, it is
-// returned directly.
+// returned directly. As with ReadRequest, the size of the response is limited
+// by maxLen, or by resp.MaxLen if maxLen is 0; streaming RPCs, such as Read,
+// should supply a bound appropriate for the request.
 func (t *Transport) ReadResponse(resp ProtocolObject, maxLen uint64) (err error) {
 	defer wrapErr(&err, "ReadResponse")
 	rr := rpcResponse{nil, resp}
@@ -331,9 +544,7 @@ func (t *Transport) Call(rpcID types.Specifier, req, resp ProtocolObject) error
 	if err := t.WriteRequest(rpcID, req); err != nil {
 		return err
 	}
-	// use a maxlen large enough for all RPCs except Read, Write, and
-	// SectorRoots (which don't use Call anyway)
-	err := t.ReadResponse(resp, 4096)
+	err := t.ReadResponse(resp, 0)
 	return wrapResponseErr(err, fmt.Sprintf("couldn't read %v response", rpcID), fmt.Sprintf("host rejected %v request", rpcID))
 }
 
@@ -374,7 +585,7 @@ func (rr *ResponseReader) VerifyTag() error {
 	}
 	// MAC is padded to 16 bytes, and covers the length of AD (0 in this case)
 	// and ciphertext
-	tail := make([]byte, 0, 32)[:32-(rr.clen%16)]
+	tail := make([]byte, 0, 32)[:16+(16-rr.clen%16)%16]
 	binary.LittleEndian.PutUint64(tail[len(tail)-8:], rr.clen)
 	rr.mac.Write(tail)
 	var ourTag [poly1305.TagSize]byte
@@ -395,16 +606,20 @@ func (t *Transport) RawResponse(maxLen uint64) (*ResponseReader, error) {
 	if maxLen < minMessageSize {
 		maxLen = minMessageSize
 	}
-	d := types.NewDecoder(io.LimitedReader{R: t.conn, N: int64(8 + chacha20.NonceSize)})
+	nonceSize := t.aead.NonceSize()
+	d := types.NewDecoder(io.LimitedReader{R: t.conn, N: int64(8 + nonceSize)})
 	msgSize := d.ReadUint64()
 	if msgSize > maxLen {
 		return nil, fmt.Errorf("message size (%v bytes) exceeds maxLen of %v bytes", msgSize, maxLen)
-	} else if msgSize < uint64(chacha20.NonceSize+poly1305.TagSize) {
-		return nil, fmt.Errorf("message size (%v bytes) is too small (nonce + MAC is %v bytes)", msgSize, chacha20.NonceSize+poly1305.TagSize)
+	} else if msgSize < uint64(nonceSize+poly1305.TagSize) {
+		return nil, fmt.Errorf("message size (%v bytes) is too small (nonce + MAC is %v bytes)", msgSize, nonceSize+poly1305.TagSize)
+	} else if err := t.spendRPCBytes(8 + msgSize); err != nil {
+		return nil, err
 	}
-	msgSize -= uint64(chacha20.NonceSize + poly1305.TagSize)
+	msgSize -= uint64(nonceSize + poly1305.TagSize)
 
-	nonce := make([]byte, 32)[:chacha20.NonceSize] // avoid heap allocation
+	// for a 24-byte nonce, this is XChaCha20
+	nonce := make([]byte, 32)[:nonceSize] // avoid heap allocation
 	d.Read(nonce)
 
 	// construct reader
@@ -438,6 +653,101 @@ func (t *Transport) RawResponse(maxLen uint64) (*ResponseReader, error) {
 	return rr, nil
 }
 
+// A RequestWriter encrypts and authenticates an RPC request message as it is
+// written, allowing large requests to be sent without buffering them.
+type RequestWriter struct {
+	t    *Transport
+	c    *chacha20.Cipher
+	mac  *poly1305.MAC
+	buf  []byte
+	rem  uint64
+	clen uint64
+}
+
+// Write implements io.Writer. The total number of bytes written must not
+// exceed the size passed to RawRequest.
+func (rw *RequestWriter) Write(p []byte) (int, error) {
+	if uint64(len(p)) > rw.rem {
+		return 0, fmt.Errorf("write of %v bytes exceeds remaining request size of %v bytes", len(p), rw.rem)
+	}
+	var n int
+	for len(p) > 0 {
+		chunk := rw.buf[:copy(rw.buf, p)]
+		rw.c.XORKeyStream(chunk, chunk)
+		rw.mac.Write(chunk)
+		m, err := rw.t.conn.Write(chunk)
+		atomic.AddUint64(&rw.t.w, uint64(m))
+		n += m
+		rw.rem -= uint64(m)
+		if err != nil {
+			rw.t.setErr(err)
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close writes the message's authentication tag, completing the request. It
+// returns an error if fewer bytes were written than the size passed to
+// RawRequest. Close does not close the Transport.
+func (rw *RequestWriter) Close() error {
+	if rw.rem != 0 {
+		err := fmt.Errorf("request closed with %v bytes unwritten", rw.rem)
+		rw.t.setErr(err) // the peer is still waiting for the rest of the message
+		return err
+	}
+	// MAC is padded to 16 bytes, and covers the length of AD (0 in this case)
+	// and ciphertext
+	tail := make([]byte, 0, 32)[:16+(16-rw.clen%16)%16]
+	binary.LittleEndian.PutUint64(tail[len(tail)-8:], rw.clen)
+	rw.mac.Write(tail)
+	var tag [poly1305.TagSize]byte
+	rw.mac.Sum(tag[:0])
+	n, err := rw.t.conn.Write(tag[:])
+	atomic.AddUint64(&rw.t.w, uint64(n))
+	rw.t.setErr(err)
+	return err
+}
+
+// RawRequest writes an RPC ID and returns a RequestWriter for the (encoded)
+// request object, which must be exactly size bytes long. The data is
+// encrypted and sent as it is written, so that large requests, such as Write
+// RPCs containing sector data, can be streamed. The caller must call Close on
+// the RequestWriter once all of the data has been written. Unlike
+// WriteRequest, the request is not padded to the minimum message size.
+func (t *Transport) RawRequest(rpcID types.Specifier, size uint64) (*RequestWriter, error) {
+	t.beginRPC()
+	if err := t.writeMessage(&rpcID); err != nil {
+		return nil, fmt.Errorf("WriteRequestID: %w", err)
+	}
+
+	// for a 24-byte nonce, this is XChaCha20
+	nonceSize := t.aead.NonceSize()
+	header := make([]byte, 8+nonceSize)
+	binary.LittleEndian.PutUint64(header, uint64(nonceSize)+size+poly1305.TagSize)
+	nonce := header[8:]
+	frand.Read(nonce)
+	c, _ := chacha20.NewUnauthenticatedCipher(t.key, nonce)
+	var polyKey [32]byte
+	c.XORKeyStream(polyKey[:], polyKey[:])
+	c.SetCounter(1)
+	n, err := t.conn.Write(header)
+	atomic.AddUint64(&t.w, uint64(n))
+	if err != nil {
+		t.setErr(err)
+		return nil, err
+	}
+	return &RequestWriter{
+		t:    t,
+		c:    c,
+		mac:  poly1305.New(&polyKey),
+		buf:  make([]byte, 64*1024),
+		rem:  size,
+		clen: size,
+	}, nil
+}
+
 // Close gracefully terminates the RPC loop and closes the connection.
 func (t *Transport) Close() (err error) {
 	defer wrapErr(&err, "Close")
@@ -483,21 +793,31 @@ func NewHostTransport(conn net.Conn, priv types.PrivateKey) (_ *Transport, err e
 		return nil, err
 	}
 
-	var supportsChaCha bool
+	xsk, xpk := generateX25519KeyPair()
+	cipherKey, err := deriveSharedSecret(xsk, req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// select the renter's most-preferred cipher that we support
+	var aead cipher.AEAD
+	rpcCipher := cipherNoOverlap
 	for _, c := range req.Ciphers {
-		if c == cipherChaCha20Poly1305 {
-			supportsChaCha = true
+		var ok bool
+		if aead, ok = newAEAD(c, cipherKey); ok {
+			rpcCipher = c
+			break
 		}
 	}
-	if !supportsChaCha {
+	if rpcCipher == cipherNoOverlap {
 		(&loopKeyExchangeResponse{Cipher: cipherNoOverlap}).EncodeTo(e)
+		e.Flush()
 		return nil, errors.New("no supported ciphers")
 	}
 
-	xsk, xpk := generateX25519KeyPair()
 	h := hashKeys(req.PublicKey, xpk)
 	resp := loopKeyExchangeResponse{
-		Cipher:    cipherChaCha20Poly1305,
+		Cipher:    rpcCipher,
 		PublicKey: xpk,
 		Signature: priv.SignHash(h),
 	}
@@ -506,15 +826,12 @@ func NewHostTransport(conn net.Conn, priv types.PrivateKey) (_ *Transport, err e
 		return nil, err
 	}
 
-	cipherKey, err := deriveSharedSecret(xsk, req.PublicKey)
-	if err != nil {
-		return nil, err
-	}
-	aead, _ := chacha20poly1305.New(cipherKey) // no error possible
 	t := &Transport{
 		conn:      conn,
+		cipher:    rpcCipher,
 		aead:      aead,
 		key:       cipherKey,
+		padSize:   minMessageSize,
 		challenge: frand.Entropy128(),
 		isRenter:  false,
 		hostKey:   priv.PublicKey(),
@@ -530,13 +847,24 @@ func NewHostTransport(conn net.Conn, priv types.PrivateKey) (_ *Transport, err e
 // handshake, returning a Transport that can be used to make RPC requests.
 func NewRenterTransport(conn net.Conn, pub types.PublicKey) (_ *Transport, err error) {
 	defer wrapErr(&err, "NewRenterTransport")
+	return newRenterTransport(conn, pub, DefaultCiphers)
+}
+
+// NewRenterTransportWithCiphers is like NewRenterTransport, but proposes the
+// specified ciphers, in order of preference, instead of DefaultCiphers.
+func NewRenterTransportWithCiphers(conn net.Conn, pub types.PublicKey, ciphers []types.Specifier) (_ *Transport, err error) {
+	defer wrapErr(&err, "NewRenterTransportWithCiphers")
+	return newRenterTransport(conn, pub, ciphers)
+}
+
+func newRenterTransport(conn net.Conn, pub types.PublicKey, ciphers []types.Specifier) (*Transport, error) {
 	e := types.NewEncoder(conn)
 	d := types.NewDecoder(io.LimitedReader{R: conn, N: 1024})
 
 	xsk, xpk := generateX25519KeyPair()
 	req := &loopKeyExchangeRequest{
 		PublicKey: xpk,
-		Ciphers:   []types.Specifier{cipherChaCha20Poly1305},
+		Ciphers:   ciphers,
 	}
 	req.EncodeTo(e)
 	if err := e.Flush(); err != nil {
@@ -547,14 +875,20 @@ func NewRenterTransport(conn net.Conn, pub types.PublicKey) (_ *Transport, err e
 	if err := d.Err(); err != nil {
 		return nil, fmt.Errorf("couldn't read host's handshake: %w", err)
 	}
-	// validate the signature before doing anything else
+	// the host does not sign a NoOverlap response; since it only aborts the
+	// handshake, it is safe to check for it before validating the signature
+	if resp.Cipher == cipherNoOverlap {
+		return nil, errors.New("host does not support any of our proposed ciphers")
+	}
 	h := hashKeys(req.PublicKey, resp.PublicKey)
 	if !pub.VerifyHash(h, resp.Signature) {
 		return nil, errors.New("host's handshake signature was invalid")
 	}
-	if resp.Cipher == cipherNoOverlap {
-		return nil, errors.New("host does not support any of our proposed ciphers")
-	} else if resp.Cipher != cipherChaCha20Poly1305 {
+	var proposed bool
+	for _, c := range ciphers {
+		proposed = proposed || c == resp.Cipher
+	}
+	if !proposed {
 		return nil, errors.New("host selected unsupported cipher")
 	}
 
@@ -562,11 +896,16 @@ func NewRenterTransport(conn net.Conn, pub types.PublicKey) (_ *Transport, err e
 	if err != nil {
 		return nil, err
 	}
-	aead, _ := chacha20poly1305.New(cipherKey) // no error possible
+	aead, ok := newAEAD(resp.Cipher, cipherKey)
+	if !ok {
+		return nil, errors.New("host selected unsupported cipher")
+	}
 	t := &Transport{
 		conn:     conn,
+		cipher:   resp.Cipher,
 		aead:     aead,
 		key:      cipherKey,
+		padSize:  minMessageSize,
 		isRenter: true,
 		hostKey:  pub,
 	}
@@ -589,4 +928,8 @@ type (
 		Signature types.Signature
 		Cipher    types.Specifier
 	}
+
+	loopRekeyMessage struct {
+		PublicKey [32]byte
+	}
 )
@@ -0,0 +1,583 @@
+package rhp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func newTestTransports(t *testing.T, ciphers []types.Specifier) (renter, host *Transport, renterErr, hostErr error) {
+	t.Helper()
+	hostKey := types.GeneratePrivateKey()
+	rc, hc := net.Pipe()
+	t.Cleanup(func() {
+		rc.Close()
+		hc.Close()
+	})
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		host, err = NewHostTransport(hc, hostKey)
+		errCh <- err
+	}()
+	renter, renterErr = NewRenterTransportWithCiphers(rc, hostKey.PublicKey(), ciphers)
+	if renterErr != nil {
+		rc.Close()
+	}
+	hostErr = <-errCh
+	return
+}
+
+func TestTransportCipherNegotiation(t *testing.T) {
+	// cipher specifiers must match their full protocol names
+	for spec, name := range map[types.Specifier]string{
+		CipherChaCha20Poly1305:  "ChaCha20Poly1305",
+		CipherXChaCha20Poly1305: "XChaChaPoly1305",
+	} {
+		if spec.String() != name {
+			t.Fatalf("expected %q, got %q", name, spec)
+		}
+	}
+
+	unknown := types.NewSpecifier("Unknown")
+	tests := []struct {
+		ciphers   []types.Specifier
+		exp       types.Specifier
+		nonceSize int
+	}{
+		{DefaultCiphers, CipherChaCha20Poly1305, 12},
+		{[]types.Specifier{CipherXChaCha20Poly1305, CipherChaCha20Poly1305}, CipherXChaCha20Poly1305, 24},
+		{[]types.Specifier{unknown, CipherXChaCha20Poly1305}, CipherXChaCha20Poly1305, 24},
+		{[]types.Specifier{unknown, types.NewSpecifier("XChaChaPoly1305")}, CipherXChaCha20Poly1305, 24},
+	}
+	for _, test := range tests {
+		renter, host, renterErr, hostErr := newTestTransports(t, test.ciphers)
+		if renterErr != nil {
+			t.Fatal(renterErr)
+		} else if hostErr != nil {
+			t.Fatal(hostErr)
+		} else if renter.aead.NonceSize() != test.nonceSize || host.aead.NonceSize() != test.nonceSize {
+			t.Fatalf("expected %v to be negotiated", test.exp)
+		}
+
+		// exchange a request and a raw response
+		id := types.NewSpecifier("Foo")
+		go func() {
+			renter.WriteRequest(id, nil)
+		}()
+		if readID, err := host.ReadID(); err != nil {
+			t.Fatal(err)
+		} else if readID != id {
+			t.Fatalf("expected %v, got %v", id, readID)
+		}
+		go func() {
+			host.WriteResponse(&id)
+		}()
+		rr, err := renter.RawResponse(4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp types.Specifier
+		if _, err := io.ReadFull(rr, resp[:]); err != nil {
+			t.Fatal(err)
+		} else if err := rr.VerifyTag(); err != nil {
+			t.Fatal(err)
+		} else if resp != id {
+			t.Fatalf("expected %v, got %v", id, resp)
+		}
+	}
+}
+
+func TestTransportCipherNoOverlap(t *testing.T) {
+	_, _, renterErr, hostErr := newTestTransports(t, []types.Specifier{types.NewSpecifier("Unknown")})
+	if renterErr == nil || !strings.Contains(renterErr.Error(), "does not support any of our proposed ciphers") {
+		t.Fatalf("expected no-overlap error, got %v", renterErr)
+	} else if hostErr == nil || !strings.Contains(hostErr.Error(), "no supported ciphers") {
+		t.Fatalf("expected no supported ciphers error, got %v", hostErr)
+	}
+}
+
+func TestTransportRekey(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+
+	exchange := func(id types.Specifier, rekey bool) {
+		t.Helper()
+		errCh := make(chan error, 1)
+		go func() {
+			if rekey {
+				if err := renter.Rekey(); err != nil {
+					errCh <- err
+					return
+				}
+			}
+			errCh <- renter.WriteRequest(id, &id)
+		}()
+		// the host handles the rekey while waiting for the next ID
+		var req types.Specifier
+		if readID, err := host.ReadID(); err != nil {
+			t.Fatal(err)
+		} else if readID != id {
+			t.Fatalf("expected %v, got %v", id, readID)
+		} else if err := host.ReadRequest(&req, minMessageSize); err != nil {
+			t.Fatal(err)
+		} else if req != id {
+			t.Fatalf("expected %v, got %v", id, req)
+		} else if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exchange(types.NewSpecifier("Before"), false)
+	oldKey := string(renter.key)
+	exchange(types.NewSpecifier("After"), true)
+	if string(renter.key) == oldKey {
+		t.Fatal("rekey did not change key")
+	} else if string(renter.key) != string(host.key) {
+		t.Fatal("renter and host keys differ after rekey")
+	}
+	exchange(types.NewSpecifier("AfterAgain"), false)
+
+	if err := host.Rekey(); err == nil {
+		t.Fatal("host should not be able to initiate a rekey")
+	}
+}
+
+func TestTransportPadding(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+
+	send := func() (written, padded uint64) {
+		t.Helper()
+		w, p := renter.BytesWritten(), renter.BytesPadded()
+		id := types.NewSpecifier("Foo")
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- renter.WriteRequest(id, nil)
+		}()
+		if readID, err := host.ReadID(); err != nil {
+			t.Fatal(err)
+		} else if readID != id {
+			t.Fatalf("expected %v, got %v", id, readID)
+		} else if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		return renter.BytesWritten() - w, renter.BytesPadded() - p
+	}
+
+	// by default, messages are padded to minMessageSize
+	if written, padded := send(); written != minMessageSize {
+		t.Fatalf("expected %v bytes written, got %v", minMessageSize, written)
+	} else if padded == 0 || padded >= written {
+		t.Fatalf("expected message to be padded, got %v padding bytes", padded)
+	}
+
+	// with padding disabled, only the message itself is written
+	renter.SetMinMessageSize(0)
+	if written, padded := send(); padded != 0 {
+		t.Fatalf("expected no padding, got %v bytes", padded)
+	} else if exp := uint64(8 + renter.aead.NonceSize() + 16 + renter.aead.Overhead()); written != exp {
+		t.Fatalf("expected %v bytes written, got %v", exp, written)
+	}
+}
+
+func TestTransportRPCByteLimit(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+	// allow two padded responses per RPC
+	renter.SetRPCByteLimit(2 * (8 + minMessageSize))
+
+	// simulate a host that streams responses indefinitely
+	id := types.NewSpecifier("Foo")
+	go func() {
+		if _, err := host.ReadID(); err != nil {
+			return
+		}
+		for host.WriteResponse(&id) == nil {
+		}
+	}()
+
+	if err := renter.WriteRequest(id, nil); err != nil {
+		t.Fatal(err)
+	}
+	var resp types.Specifier
+	for i := 0; i < 2; i++ {
+		if err := renter.ReadResponse(&resp, minMessageSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := renter.ReadResponse(&resp, minMessageSize); !errors.Is(err, ErrRPCLimitExceeded) {
+		t.Fatalf("expected %v, got %v", ErrRPCLimitExceeded, err)
+	} else if !renter.IsClosed() || !errors.Is(renter.PrematureCloseErr(), ErrRPCLimitExceeded) {
+		t.Fatal("transport should be closed after exceeding limit")
+	}
+}
+
+func TestTransportRPCByteLimitHost(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+	// allow a padded ID and request per RPC
+	host.SetRPCByteLimit(2 * (8 + minMessageSize))
+
+	// each RPC uses the host's full allowance; neither the next ID nor a
+	// rekey between RPCs should be charged to the previous RPC
+	id := types.NewSpecifier("Foo")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- func() error {
+			for i := 0; i < 3; i++ {
+				if err := renter.WriteRequest(id, &id); err != nil {
+					return err
+				} else if i == 1 {
+					if err := renter.Rekey(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}()
+	}()
+	for i := 0; i < 3; i++ {
+		var req types.Specifier
+		if _, err := host.ReadID(); err != nil {
+			t.Fatalf("rpc %v: %v", i, err)
+		} else if err := host.ReadRequest(&req, minMessageSize); err != nil {
+			t.Fatalf("rpc %v: %v", i, err)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// exceeding the allowance within an RPC should still fail
+	go func() {
+		renter.WriteRequest(id, &id)
+		renter.WriteRequest(id, nil)
+	}()
+	var req types.Specifier
+	if _, err := host.ReadID(); err != nil {
+		t.Fatal(err)
+	} else if err := host.ReadRequest(&req, minMessageSize); err != nil {
+		t.Fatal(err)
+	} else if err := host.ReadRequest(&req, minMessageSize); !errors.Is(err, ErrRPCLimitExceeded) {
+		t.Fatalf("expected %v, got %v", ErrRPCLimitExceeded, err)
+	}
+}
+
+type testObject struct {
+	data   []byte
+	maxLen int
+}
+
+func (o *testObject) EncodeTo(e *types.Encoder)   { e.WriteBytes(o.data) }
+func (o *testObject) DecodeFrom(d *types.Decoder) { o.data = d.ReadBytes() }
+func (o *testObject) MaxLen() int                 { return o.maxLen }
+
+func TestTransportMaxLen(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+
+	large := &testObject{data: make([]byte, 2*minMessageSize)}
+	go func() {
+		if _, err := host.ReadID(); err != nil {
+			return
+		}
+		for host.WriteResponse(large) == nil {
+		}
+	}()
+	if err := renter.WriteRequest(types.NewSpecifier("Foo"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// an explicit maxLen overrides the object's MaxLen
+	resp := &testObject{maxLen: 100}
+	if err := renter.ReadResponse(resp, 4*minMessageSize); err != nil {
+		t.Fatal(err)
+	} else if len(resp.data) != len(large.data) {
+		t.Fatalf("expected %v bytes, got %v", len(large.data), len(resp.data))
+	}
+
+	// otherwise, the object's MaxLen is enforced
+	if err := renter.ReadResponse(resp, 0); err == nil || !strings.Contains(err.Error(), "exceeds maxLen") {
+		t.Fatal("expected maxLen error, got", err)
+	}
+}
+
+func TestWriteRequestMaxLen(t *testing.T) {
+	// the largest permitted Write request must fit within MaxLen
+	req := &RPCWriteRequest{
+		Actions:           make([]RPCWriteAction, maxWriteActions),
+		MerkleProof:       true,
+		RevisionNumber:    1,
+		ValidProofValues:  make([]types.Currency, maxProofValues),
+		MissedProofValues: make([]types.Currency, maxProofValues),
+	}
+	for i := range req.Actions {
+		req.Actions[i].Type = RPCWriteActionAppend
+	}
+	for i := 0; i < maxWriteSectors; i++ {
+		req.Actions[i].Data = make([]byte, SectorSize)
+	}
+	for i := range req.ValidProofValues {
+		req.ValidProofValues[i] = types.MaxCurrency
+		req.MissedProofValues[i] = types.MaxCurrency
+	}
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	req.EncodeTo(e)
+	e.Flush()
+	if buf.Len() > req.MaxLen() {
+		t.Fatalf("encoded request (%v bytes) exceeds MaxLen (%v bytes)", buf.Len(), req.MaxLen())
+	}
+}
+
+// rawObject is a ProtocolObject with arbitrary encoded contents.
+type rawObject []byte
+
+func (o rawObject) EncodeTo(e *types.Encoder)   { e.Write(o) }
+func (o rawObject) DecodeFrom(d *types.Decoder) {}
+func (o rawObject) MaxLen() int                 { return len(o) }
+
+// craftPrefix returns an encoded length prefix of n, padded with enough zeros
+// that the prefix cannot be rejected for exceeding the remaining data.
+func craftPrefix(n int, pad int) rawObject {
+	buf := make([]byte, 8+pad)
+	binary.LittleEndian.PutUint64(buf, uint64(n))
+	return buf
+}
+
+func TestTransportCraftedPrefix(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+
+	// a Write request claiming millions of actions must be rejected before
+	// they are allocated
+	crafted := craftPrefix(maxWriteSectors*SectorSize/8, maxWriteSectors*SectorSize)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := host.ReadID()
+		if err == nil {
+			err = host.ReadRequest(new(RPCWriteRequest), 0)
+		}
+		errCh <- err
+	}()
+	if err := renter.WriteRequest(RPCWriteID, crafted); err != nil {
+		t.Fatal(err)
+	} else if err := <-errCh; err == nil || !strings.Contains(err.Error(), "too many elements") {
+		t.Fatal("expected element limit error, got", err)
+	}
+
+	// the same applies to every length-prefixed slice
+	for _, test := range []struct {
+		obj ProtocolObject
+		raw rawObject
+	}{
+		{new(RPCFormContractRequest), craftPrefix(largeMaxLen/8, largeMaxLen)},
+		{new(RPCFormContractAdditions), craftPrefix(largeMaxLen/8, largeMaxLen)},
+		{new(RPCFormContractSignatures), craftPrefix(defaultMaxLen/8, defaultMaxLen)},
+		{new(RPCReadRequest), craftPrefix(defaultMaxLen/8, defaultMaxLen)},
+		{new(RPCSectorRootsResponse), append(craftPrefix(64, 64), craftPrefix(maxSectorRoots+1, 32*(maxSectorRoots+1))...)},
+		{new(RPCWriteMerkleProof), craftPrefix(maxMerkleProofLen+1, 32*(maxMerkleProofLen+1))},
+		// a transaction containing an oversized slice
+		{new(RPCFormContractRequest), append(craftPrefix(1, 0), craftPrefix(maxTransactionElements+1, 32*(maxTransactionElements+1))...)},
+	} {
+		d := types.NewBufDecoder(test.raw)
+		test.obj.DecodeFrom(d)
+		if err := d.Err(); err == nil || !strings.Contains(err.Error(), "elem") {
+			t.Errorf("%T: expected element limit error, got %v", test.obj, err)
+		}
+	}
+}
+
+func TestRPCErrorCodes(t *testing.T) {
+	roundtrip := func(re *RPCError) error {
+		t.Helper()
+		var buf bytes.Buffer
+		e := types.NewEncoder(&buf)
+		(&rpcResponse{err: re}).EncodeTo(e)
+		e.Flush()
+		var resp rpcResponse
+		d := types.NewBufDecoder(buf.Bytes())
+		resp.DecodeFrom(d)
+		if err := d.Err(); err != nil {
+			t.Fatal(err)
+		} else if resp.err == nil {
+			t.Fatal("expected error response")
+		}
+		return fmt.Errorf("ReadResponse: %w", resp.err)
+	}
+
+	err := roundtrip(&RPCError{Code: ErrCodeContractNotFound, Description: "no such contract: foo"})
+	if !errors.Is(err, ErrCodeContractNotFound) {
+		t.Fatal("expected error to match its code")
+	} else if errors.Is(err, ErrCodeContractLocked) {
+		t.Fatal("error should not match a different code")
+	} else if !errors.Is(err, &RPCError{Code: ErrCodeContractNotFound}) {
+		t.Fatal("expected error to match an RPCError with the same code")
+	} else if err.Error() != "ReadResponse: no such contract: foo" {
+		t.Fatalf("description was not preserved: %q", err)
+	}
+
+	// errors without a code fall back to matching descriptions
+	err = roundtrip(&RPCError{Description: "host is out of storage"})
+	var re *RPCError
+	if !errors.As(err, &re) || re.Code != ErrCodeUnknown {
+		t.Fatal("expected error without code")
+	} else if !errors.Is(err, errors.New("out of storage")) {
+		t.Fatal("expected error to match description")
+	} else if errors.Is(err, ErrCodeContractNotFound) {
+		t.Fatal("error without code should not match unrelated code")
+	}
+
+	// codes wrapped by the host's errors are sent over the transport
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+	go host.WriteResponseErr(fmt.Errorf("renter has %v: %w", types.Siacoins(1), ErrCodeInsufficientFunds))
+	var resp types.Specifier
+	if err := renter.ReadResponse(&resp, minMessageSize); !errors.Is(err, ErrCodeInsufficientFunds) {
+		t.Fatalf("expected %v, got %v", ErrCodeInsufficientFunds, err)
+	}
+}
+
+func TestTransportRawRequest(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+
+	req := &RPCWriteRequest{
+		Actions: []RPCWriteAction{
+			{Type: RPCWriteActionAppend, Data: frand.Bytes(SectorSize)},
+			{Type: RPCWriteActionAppend, Data: frand.Bytes(SectorSize)},
+		},
+		RevisionNumber: 1,
+	}
+	type result struct {
+		req RPCWriteRequest
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		var r result
+		if _, r.err = host.ReadID(); r.err == nil {
+			r.err = host.ReadRequest(&r.req, 0)
+		}
+		resultCh <- r
+	}()
+
+	// stream the encoded request
+	rw, err := renter.RawRequest(RPCWriteID, uint64(types.EncodedLen(req)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := types.NewEncoder(rw)
+	req.EncodeTo(e)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	} else if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := <-resultCh
+	if r.err != nil {
+		t.Fatal(r.err)
+	} else if len(r.req.Actions) != len(req.Actions) || r.req.RevisionNumber != req.RevisionNumber {
+		t.Fatal("host received wrong request")
+	}
+	for i := range req.Actions {
+		if !bytes.Equal(r.req.Actions[i].Data, req.Actions[i].Data) {
+			t.Fatal("host received wrong sector data")
+		}
+	}
+
+	// writing more or less than the declared size should fail
+	go func() {
+		if _, err := host.ReadID(); err == nil {
+			host.ReadRequest(&testObject{maxLen: 100}, 0)
+		}
+	}()
+	rw, err = renter.RawRequest(RPCWriteID, 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := rw.Write(make([]byte, 11)); err == nil {
+		t.Fatal("expected oversized write to fail")
+	} else if _, err := rw.Write(make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	} else if err := rw.Close(); err == nil {
+		t.Fatal("expected short request to fail")
+	}
+}
+
+type emptyObject struct{}
+
+func (emptyObject) EncodeTo(e *types.Encoder)   {}
+func (emptyObject) DecodeFrom(d *types.Decoder) {}
+func (emptyObject) MaxLen() int                 { return 0 }
+
+func TestTransportEmptyObject(t *testing.T) {
+	renter, host, renterErr, hostErr := newTestTransports(t, DefaultCiphers)
+	if renterErr != nil {
+		t.Fatal(renterErr)
+	} else if hostErr != nil {
+		t.Fatal(hostErr)
+	}
+
+	// empty objects should round-trip both with and without padding
+	for _, padSize := range []uint64{minMessageSize, 0} {
+		renter.SetMinMessageSize(padSize)
+		host.SetMinMessageSize(padSize)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- func() error {
+				if _, err := host.ReadID(); err != nil {
+					return err
+				} else if err := host.ReadRequest(emptyObject{}, 0); err != nil {
+					return err
+				}
+				return host.WriteResponse(emptyObject{})
+			}()
+		}()
+		if err := renter.Call(types.NewSpecifier("Foo"), emptyObject{}, emptyObject{}); err != nil {
+			t.Fatal(err)
+		} else if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -117,12 +117,18 @@ func EncodedLen(v interface{}) int {
 	return buf.Len()
 }
 
+// ErrDecodeLimit is returned when a Decoder attempts to read past the limit of
+// its io.LimitedReader, as opposed to the underlying stream itself ending.
+var ErrDecodeLimit = errors.New("message truncated at decoder limit")
+
 // A Decoder reads values from an underlying stream. Callers MUST check
 // (*Decoder).Err before using any decoded values.
 type Decoder struct {
-	lr  io.LimitedReader
-	buf [64]byte
-	err error
+	lr       io.LimitedReader
+	limit    int64
+	buf      [64]byte
+	err      error
+	maxAlloc int
 }
 
 // SetErr sets the Decoder's error if it has not already been set. SetErr should
@@ -138,6 +144,12 @@ func (d *Decoder) SetErr(err error) {
 // Err returns the first error encountered during decoding.
 func (d *Decoder) Err() error { return d.err }
 
+// SetMaxAlloc limits the length prefixes accepted by ReadPrefix to n. Since
+// decoders typically allocate a slice as soon as its prefix is read, this
+// guards against peers that claim a huge number of elements in order to force
+// a large allocation. A limit of 0 disables the check.
+func (d *Decoder) SetMaxAlloc(n int) { d.maxAlloc = n }
+
 // Read implements the io.Reader interface. It always returns an error if fewer
 // than len(p) bytes were read.
 func (d *Decoder) Read(p []byte) (int, error) {
@@ -149,6 +161,9 @@ func (d *Decoder) Read(p []byte) (int, error) {
 		}
 		var read int
 		read, d.err = io.ReadFull(&d.lr, d.buf[:want])
+		if d.err != nil && d.lr.N == 0 && d.limit > 0 {
+			d.err = fmt.Errorf("%w (%v bytes)", ErrDecodeLimit, d.limit)
+		}
 		n += copy(p[n:], d.buf[:read])
 	}
 	return n, d.err
@@ -181,13 +196,16 @@ func (d *Decoder) ReadUint64() uint64 {
 }
 
 // ReadPrefix reads a length prefix from the underlying stream. If the length
-// exceeds the number of bytes remaining in the stream, ReadPrefix sets d.Err
-// and returns 0.
+// exceeds the number of bytes remaining in the stream, or the limit set by
+// SetMaxAlloc, ReadPrefix sets d.Err and returns 0.
 func (d *Decoder) ReadPrefix() int {
 	n := d.ReadUint64()
 	if n > uint64(d.lr.N) {
 		d.SetErr(fmt.Errorf("encoded object contains invalid length prefix (%v elems > %v bytes left in stream)", n, d.lr.N))
 		return 0
+	} else if d.maxAlloc > 0 && n > uint64(d.maxAlloc) {
+		d.SetErr(fmt.Errorf("encoded object contains invalid length prefix (%v elems > %v max)", n, d.maxAlloc))
+		return 0
 	}
 	return int(n)
 }
@@ -207,10 +225,12 @@ func (d *Decoder) ReadString() string {
 	return string(d.ReadBytes())
 }
 
-// NewDecoder returns a Decoder that wraps the provided stream.
+// NewDecoder returns a Decoder that wraps the provided stream. If decoding
+// exceeds the limit of lr, the Decoder's error will wrap ErrDecodeLimit.
 func NewDecoder(lr io.LimitedReader) *Decoder {
 	return &Decoder{
-		lr: lr,
+		lr:    lr,
+		limit: lr.N,
 	}
 }
 
@@ -221,10 +241,12 @@ type DecoderFrom interface {
 
 // NewBufDecoder returns a Decoder for the provided byte slice.
 func NewBufDecoder(buf []byte) *Decoder {
-	return NewDecoder(io.LimitedReader{
+	d := NewDecoder(io.LimitedReader{
 		R: bytes.NewReader(buf),
 		N: int64(len(buf)),
 	})
+	d.limit = 0 // the end of buf is the end of the stream, not a limit
+	return d
 }
 
 // implementations of EncoderTo and DecoderFrom for core types
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecoderMaxAlloc(t *testing.T) {
+	// craft a stream whose length prefix claims far more elements than the
+	// limit allows, but which is otherwise large enough to pass the
+	// bytes-remaining check
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WritePrefix(1000)
+	e.Write(make([]byte, 1000))
+	e.Flush()
+
+	d := NewBufDecoder(buf.Bytes())
+	d.SetMaxAlloc(100)
+	if n := d.ReadPrefix(); n != 0 {
+		t.Fatalf("expected ReadPrefix to return 0, got %v", n)
+	} else if d.Err() == nil {
+		t.Fatal("expected error for prefix exceeding max alloc")
+	}
+
+	// without a limit, the same prefix is accepted
+	d = NewBufDecoder(buf.Bytes())
+	if n := d.ReadPrefix(); n != 1000 || d.Err() != nil {
+		t.Fatalf("expected prefix of 1000, got %v (%v)", n, d.Err())
+	}
+
+	// ReadBytes must not allocate when the prefix is rejected
+	d = NewBufDecoder(buf.Bytes())
+	d.SetMaxAlloc(100)
+	if b := d.ReadBytes(); len(b) != 0 || d.Err() == nil {
+		t.Fatalf("expected empty slice and error, got %v bytes (%v)", len(b), d.Err())
+	}
+}
+
+func TestDecoderLimit(t *testing.T) {
+	// reading past the configured limit should report ErrDecodeLimit
+	d := NewDecoder(io.LimitedReader{R: bytes.NewReader(make([]byte, 100)), N: 10})
+	d.Read(make([]byte, 16))
+	if !errors.Is(d.Err(), ErrDecodeLimit) {
+		t.Fatalf("expected ErrDecodeLimit, got %v", d.Err())
+	}
+
+	// the underlying stream ending before the limit should not
+	d = NewDecoder(io.LimitedReader{R: bytes.NewReader(make([]byte, 5)), N: 100})
+	d.ReadUint64()
+	if errors.Is(d.Err(), ErrDecodeLimit) {
+		t.Fatal("did not expect ErrDecodeLimit when stream ended")
+	} else if !errors.Is(d.Err(), io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", d.Err())
+	}
+
+	// nor should reaching the end of a buffer
+	d = NewBufDecoder(make([]byte, 5))
+	d.ReadUint64()
+	if d.Err() == nil || errors.Is(d.Err(), ErrDecodeLimit) {
+		t.Fatalf("expected EOF error, got %v", d.Err())
+	}
+}
@@ -114,10 +114,15 @@ type Signature [64]byte
 // A Specifier is a fixed-size, 0-padded identifier.
 type Specifier [16]byte
 
-// NewSpecifier returns a specifier containing the provided name.
-func NewSpecifier(name string) (s Specifier) {
-	copy(s[:], name)
-	return
+// NewSpecifier returns a specifier containing the provided name. It panics if
+// name does not fit in a Specifier, since silently truncating it could cause
+// two distinct names to collide. Use ParseSpecifier for untrusted input.
+func NewSpecifier(name string) Specifier {
+	s, err := ParseSpecifier(name)
+	if err != nil {
+		panic(err)
+	}
+	return s
 }
 
 // An UnlockKey can provide one of the signatures required by a set of
@@ -605,6 +610,10 @@ func ParseChainIndex(s string) (ci ChainIndex, err error) {
 // String implements fmt.Stringer.
 func (s Specifier) String() string { return string(bytes.Trim(s[:], "\x00")) }
 
+// MaxLen returns the encoded length of a Specifier, allowing it to be sent as an
+// RPC object.
+func (s Specifier) MaxLen() int { return len(s) }
+
 // MarshalText implements encoding.TextMarshaler.
 func (s Specifier) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
 
@@ -617,10 +626,18 @@ func (s *Specifier) UnmarshalText(b []byte) error {
 	if len(str) > len(s) {
 		return fmt.Errorf("specifier %s too long", str)
 	}
+	*s = Specifier{}
 	copy(s[:], str)
 	return nil
 }
 
+// ParseSpecifier parses a Specifier from a string, padding it with zeros to
+// the fixed width. It returns an error if the string is too long.
+func ParseSpecifier(s string) (spec Specifier, err error) {
+	err = spec.UnmarshalText([]byte(s))
+	return
+}
+
 // MarshalText implements encoding.TextMarshaler.
 func (uk UnlockKey) MarshalText() ([]byte, error) {
 	return marshalHex(uk.Algorithm.String(), uk.Key[:])
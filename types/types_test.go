@@ -0,0 +1,41 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpecifier(t *testing.T) {
+	// a name of exactly the maximum length round-trips
+	maxName := strings.Repeat("a", len(Specifier{}))
+	if s := NewSpecifier(maxName); s.String() != maxName {
+		t.Fatalf("expected %q, got %q", maxName, s.String())
+	}
+	// shorter names are zero-padded
+	if s, err := ParseSpecifier("LoopEnter"); err != nil {
+		t.Fatal(err)
+	} else if s != (Specifier{'L', 'o', 'o', 'p', 'E', 'n', 't', 'e', 'r'}) || s.String() != "LoopEnter" {
+		t.Fatalf("unexpected specifier %q", s[:])
+	}
+
+	// over-length names are rejected rather than truncated
+	if _, err := ParseSpecifier(maxName + "b"); err == nil {
+		t.Fatal("expected error for over-length name")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected NewSpecifier to panic on over-length name")
+			}
+		}()
+		NewSpecifier(maxName + "b")
+	}()
+
+	// unmarshaling into a used Specifier should not leave stale bytes
+	s := NewSpecifier(maxName)
+	if err := s.UnmarshalText([]byte("foo")); err != nil {
+		t.Fatal(err)
+	} else if s != NewSpecifier("foo") {
+		t.Fatalf("expected %q, got %q", "foo", s[:])
+	}
+}
@@ -33,6 +33,12 @@ type ApplyUpdate struct {
 	Block types.Block
 }
 
+// ForEachElement calls fn for every element created, spent, or revised by the
+// block. See consensus.ApplyUpdate.ForEachElement.
+func (cau *ApplyUpdate) ForEachElement(fn func(consensus.ElementEvent)) {
+	cau.ApplyUpdate.ForEachElement(cau.Block, fn)
+}
+
 // A RevertUpdate reflects the changes to the blockchain resulting from the
 // removal of a block.
 type RevertUpdate struct {
@@ -1,8 +1,10 @@
 package chain_test
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"go.sia.tech/core/v2/chain"
 	"go.sia.tech/core/v2/consensus"
@@ -104,3 +106,37 @@ func TestManager(t *testing.T) {
 		t.Fatal("10 blocks should have been applied:", hs2.applyHistory)
 	}
 }
+
+func TestFutureBlock(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	cm := chain.NewManager(newTestStore(t, sim.Genesis), sim.State)
+	defer cm.Close()
+
+	mineAt := func(ts time.Time) types.Block {
+		b := types.Block{
+			Header: types.BlockHeader{
+				Height:       sim.State.Index.Height + 1,
+				ParentID:     sim.State.Index.ID,
+				Timestamp:    ts,
+				MinerAddress: types.VoidAddress,
+			},
+		}
+		b.Header.Commitment = sim.State.Commitment(b.Header.MinerAddress, b.Transactions)
+		chainutil.FindBlockNonce(sim.State, &b.Header, types.HashRequiringWork(sim.State.Difficulty))
+		return b
+	}
+
+	// a block beyond MaxFutureTimestamp is rejected, even though it is
+	// otherwise valid
+	future := mineAt(sim.State.MaxFutureTimestamp(time.Now()).Add(time.Hour))
+	if err := sim.State.ValidateBlock(future); err != nil {
+		t.Fatal(err)
+	} else if err := cm.AddTipBlock(future); !errors.Is(err, chain.ErrFutureBlock) {
+		t.Fatalf("expected ErrFutureBlock, got %v", err)
+	}
+
+	// a block within the bound is accepted
+	if err := cm.AddTipBlock(mineAt(time.Now())); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -16,7 +16,7 @@ func TestApplyRevertBlock(t *testing.T) {
 	sim := chainutil.NewChainSim()
 
 	s := sim.State
-	sau := consensus.GenesisUpdate(sim.Genesis.Block, s.Difficulty)
+	sau := consensus.GenesisUpdate(sim.Genesis.Block, s.Difficulty, consensus.MainnetParams())
 	var elems []types.StateElement
 	for _, sce := range sau.NewSiacoinElements {
 		elems = append(elems, sce.StateElement)
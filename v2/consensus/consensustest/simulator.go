@@ -0,0 +1,252 @@
+// Package consensustest provides an in-memory chain simulator for testing code
+// that depends on consensus state.
+package consensustest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.sia.tech/core/v2/consensus"
+	"go.sia.tech/core/v2/internal/chainutil"
+	"go.sia.tech/core/v2/types"
+)
+
+// A Simulator mines blocks on an in-memory chain. It tracks every unspent
+// siacoin element and unresolved file contract created on the chain, keeping
+// their Merkle proofs valid as new blocks are mined.
+type Simulator struct {
+	Genesis consensus.Checkpoint
+	Chain   []types.Block
+	State   consensus.State
+
+	privkey types.PrivateKey
+	sces    map[types.ElementID]types.SiacoinElement
+	fces    map[types.ElementID]types.FileContractElement
+}
+
+// Address returns the address that the Simulator uses to fund transactions.
+func (sim *Simulator) Address() types.Address {
+	return types.StandardAddress(sim.privkey.PublicKey())
+}
+
+// SiacoinElement returns the unspent siacoin element with the specified ID.
+// Its proof is valid for the current state.
+func (sim *Simulator) SiacoinElement(id types.ElementID) (types.SiacoinElement, bool) {
+	sce, ok := sim.sces[id]
+	sce.MerkleProof = append([]types.Hash256(nil), sce.MerkleProof...)
+	return sce, ok
+}
+
+// SiacoinElements returns the unspent siacoin elements sent to addr, ordered by
+// leaf index.
+func (sim *Simulator) SiacoinElements(addr types.Address) []types.SiacoinElement {
+	var sces []types.SiacoinElement
+	for id, sce := range sim.sces {
+		if sce.Address == addr {
+			sce, _ = sim.SiacoinElement(id)
+			sces = append(sces, sce)
+		}
+	}
+	sort.Slice(sces, func(i, j int) bool {
+		return sces[i].LeafIndex < sces[j].LeafIndex
+	})
+	return sces
+}
+
+// FileContractElement returns the unresolved file contract with the specified
+// ID, reflecting its latest revision. Its proof is valid for the current
+// state.
+func (sim *Simulator) FileContractElement(id types.ElementID) (types.FileContractElement, bool) {
+	fce, ok := sim.fces[id]
+	fce.MerkleProof = append([]types.Hash256(nil), fce.MerkleProof...)
+	return fce, ok
+}
+
+func (sim *Simulator) update(b types.Block, au consensus.ApplyUpdate) {
+	for _, sce := range au.SpentSiacoins {
+		delete(sim.sces, sce.ID)
+	}
+	for _, fce := range au.ResolvedFileContracts {
+		delete(sim.fces, fce.ID)
+	}
+	for _, fce := range au.RevisedFileContracts {
+		if tracked, ok := sim.fces[fce.ID]; ok {
+			tracked.FileContract = fce.FileContract
+			sim.fces[fce.ID] = tracked
+		}
+	}
+	for id, sce := range sim.sces {
+		au.UpdateElementProof(&sce.StateElement)
+		sim.sces[id] = sce
+	}
+	for id, fce := range sim.fces {
+		au.UpdateElementProof(&fce.StateElement)
+		sim.fces[id] = fce
+	}
+
+	// ephemeral outputs are created and spent within the same block, so they
+	// should not be tracked
+	ephemeral := make(map[types.ElementID]bool)
+	for _, txn := range b.Transactions {
+		for _, in := range txn.SiacoinInputs {
+			if in.Parent.LeafIndex == types.EphemeralLeafIndex {
+				ephemeral[in.Parent.ID] = true
+			}
+		}
+	}
+	for _, sce := range au.NewSiacoinElements {
+		if !ephemeral[sce.ID] {
+			sim.sces[sce.ID] = sce
+		}
+	}
+	for _, fce := range au.NewFileContracts {
+		sim.fces[fce.ID] = fce
+	}
+}
+
+// MineBlock mines a block containing the provided transactions and applies it
+// to the Simulator's state. It panics if the block is invalid.
+func (sim *Simulator) MineBlock(txns ...types.Transaction) types.Block {
+	parent := sim.Genesis.Block.Header
+	if len(sim.Chain) > 0 {
+		parent = sim.Chain[len(sim.Chain)-1].Header
+	}
+	b := types.Block{
+		Header: types.BlockHeader{
+			Height:       parent.Height + 1,
+			ParentID:     parent.ID(),
+			Timestamp:    parent.Timestamp.Add(time.Second),
+			MinerAddress: types.VoidAddress,
+		},
+		Transactions: txns,
+	}
+	b.Header.Commitment = sim.State.Commitment(b.Header.MinerAddress, b.Transactions)
+	chainutil.FindBlockNonce(sim.State, &b.Header, types.HashRequiringWork(sim.State.Difficulty))
+	if err := sim.State.ValidateBlock(b); err != nil {
+		panic(fmt.Sprintf("consensustest: mined invalid block: %v", err))
+	}
+
+	au := consensus.ApplyBlock(sim.State, b)
+	sim.State = au.State
+	sim.Chain = append(sim.Chain, b)
+	sim.update(b, au)
+	return b
+}
+
+// MineBlocks mines n empty blocks.
+func (sim *Simulator) MineBlocks(n int) []types.Block {
+	blocks := make([]types.Block, n)
+	for i := range blocks {
+		blocks[i] = sim.MineBlock()
+	}
+	return blocks
+}
+
+// FundAndSign adds siacoin inputs belonging to the Simulator to txn, sufficient
+// to cover amount plus the transaction's miner fee, along with a change output
+// if necessary; change that would be dust is added to the miner fee instead. It
+// then signs the inputs. It panics if the Simulator has insufficient funds.
+func (sim *Simulator) FundAndSign(txn *types.Transaction, amount types.Currency) {
+	amount = amount.Add(txn.MinerFee)
+	pubkey := sim.privkey.PublicKey()
+	blockHeight := sim.State.Index.Height + 1
+	var total types.Currency
+	for _, sce := range sim.SiacoinElements(sim.Address()) {
+		if total.Cmp(amount) >= 0 {
+			break
+		} else if sce.MaturityHeight > blockHeight {
+			continue
+		}
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			Parent:      sce,
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		})
+		total = total.Add(sce.Value)
+	}
+	if total.Cmp(amount) < 0 {
+		panic("consensustest: insufficient funds")
+	} else if total.Cmp(amount) > 0 {
+		change := types.SiacoinOutput{
+			Address: sim.Address(),
+			Value:   total.Sub(amount),
+		}
+		if sim.State.IsDust(change) {
+			txn.MinerFee = txn.MinerFee.Add(change.Value)
+		} else {
+			txn.SiacoinOutputs = append(txn.SiacoinOutputs, change)
+		}
+	}
+
+	sigHash := sim.State.InputSigHash(*txn)
+	for i := range txn.SiacoinInputs {
+		if txn.SiacoinInputs[i].Parent.Address == sim.Address() {
+			txn.SiacoinInputs[i].Signatures = []types.Signature{sim.privkey.SignHash(sigHash)}
+		}
+	}
+}
+
+// Fund mines a block containing a transaction that sends value to addr, and
+// returns the resulting siacoin element.
+func (sim *Simulator) Fund(addr types.Address, value types.Currency) types.SiacoinElement {
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: value}},
+	}
+	sim.FundAndSign(&txn, value)
+	sim.MineBlock(txn)
+	sce, _ := sim.SiacoinElement(txn.SiacoinOutputID(0))
+	return sce
+}
+
+// FormContract mines a block containing a transaction that forms fc, and returns
+// the resulting file contract element. The contract's public keys and
+// signatures are set using the provided renter and host keys, and its outputs
+// are funded by the Simulator.
+func (sim *Simulator) FormContract(fc types.FileContract, renterKey, hostKey types.PrivateKey) types.FileContractElement {
+	fc.RenterPublicKey = renterKey.PublicKey()
+	fc.HostPublicKey = hostKey.PublicKey()
+	sigHash := sim.State.ContractSigHash(fc)
+	fc.RenterSignature = renterKey.SignHash(sigHash)
+	fc.HostSignature = hostKey.SignHash(sigHash)
+
+	txn := types.Transaction{
+		FileContracts: []types.FileContract{fc},
+	}
+	sim.FundAndSign(&txn, fc.RenterOutput.Value.Add(fc.HostOutput.Value).Add(sim.State.FileContractTax(fc)))
+	sim.MineBlock(txn)
+	fce, _ := sim.FileContractElement(txn.FileContractID(0))
+	return fce
+}
+
+// New returns a Simulator whose genesis block sends a large number of siacoins
+// to the Simulator's own address.
+func New() *Simulator {
+	privkey := types.GeneratePrivateKey()
+	addr := types.StandardAddress(privkey.PublicKey())
+	gift := make([]types.SiacoinOutput, 10)
+	for i := range gift {
+		gift[i] = types.SiacoinOutput{
+			Address: addr,
+			Value:   types.Siacoins(1e6),
+		}
+	}
+	genesis := types.Block{
+		Header: types.BlockHeader{
+			Timestamp: time.Unix(734600000, 0).UTC(),
+		},
+		Transactions: []types.Transaction{{SiacoinOutputs: gift}},
+	}
+	au := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 4}}, consensus.MainnetParams())
+	sim := &Simulator{
+		Genesis: consensus.Checkpoint{
+			Block: genesis,
+			State: au.State,
+		},
+		State:   au.State,
+		privkey: privkey,
+		sces:    make(map[types.ElementID]types.SiacoinElement),
+		fces:    make(map[types.ElementID]types.FileContractElement),
+	}
+	sim.update(genesis, au)
+	return sim
+}
@@ -0,0 +1,70 @@
+package consensustest
+
+import (
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+)
+
+func TestSimulator(t *testing.T) {
+	sim := New()
+	key := types.GeneratePrivateKey()
+	addr := types.StandardAddress(key.PublicKey())
+
+	// fund an address, then let its proof go stale
+	sce := sim.Fund(addr, types.Siacoins(100))
+	if sce.Value != types.Siacoins(100) || sce.Address != addr {
+		t.Fatal("wrong funded element:", sce)
+	}
+	sim.MineBlocks(5)
+
+	// spend the funded output using the simulator's up-to-date proof
+	sce, ok := sim.SiacoinElement(sce.ID)
+	if !ok {
+		t.Fatal("funded element not tracked")
+	}
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sce,
+			SpendPolicy: types.PolicyPublicKey(key.PublicKey()),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sce.Value}},
+	}
+	txn.SiacoinInputs[0].Signatures = []types.Signature{key.SignHash(sim.State.InputSigHash(txn))}
+	sim.MineBlock(txn)
+	if _, ok := sim.SiacoinElement(sce.ID); ok {
+		t.Fatal("spent element is still tracked")
+	}
+
+	// form a contract, then revise it after several blocks
+	renterKey, hostKey := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	height := sim.State.Index.Height
+	fce := sim.FormContract(types.FileContract{
+		WindowStart:  height + 10,
+		WindowEnd:    height + 20,
+		RenterOutput: types.SiacoinOutput{Address: addr, Value: types.Siacoins(10)},
+		HostOutput:   types.SiacoinOutput{Address: addr, Value: types.Siacoins(5)},
+	}, renterKey, hostKey)
+	sim.MineBlocks(3)
+
+	fce, ok = sim.FileContractElement(fce.ID)
+	if !ok {
+		t.Fatal("formed contract not tracked")
+	}
+	rev := fce.FileContract
+	rev.RevisionNumber++
+	sigHash := sim.State.ContractSigHash(rev)
+	rev.RenterSignature = renterKey.SignHash(sigHash)
+	rev.HostSignature = hostKey.SignHash(sigHash)
+	sim.MineBlock(types.Transaction{
+		FileContractRevisions: []types.FileContractRevision{{
+			Parent:   fce,
+			Revision: rev,
+		}},
+	})
+	if fce, ok := sim.FileContractElement(fce.ID); !ok {
+		t.Fatal("revised contract not tracked")
+	} else if fce.RevisionNumber != rev.RevisionNumber {
+		t.Fatalf("expected revision number %v, got %v", rev.RevisionNumber, fce.RevisionNumber)
+	}
+}
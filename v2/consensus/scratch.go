@@ -20,7 +20,7 @@ type ScratchChain struct {
 // Headers must be appended before their transactions can be filled in with
 // AppendBlockTransactions.
 func (sc *ScratchChain) AppendHeader(h types.BlockHeader) error {
-	if err := sc.hs.validateHeader(h); err != nil {
+	if err := sc.hs.ValidateHeader(h); err != nil {
 		return err
 	}
 	applyHeader(&sc.hs, h)
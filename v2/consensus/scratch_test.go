@@ -19,15 +19,7 @@ func findBlockNonce(sc State, h *types.BlockHeader, target types.BlockID) {
 }
 
 func mineBlock(s State, parent types.Block, txns ...types.Transaction) types.Block {
-	b := types.Block{
-		Header: types.BlockHeader{
-			Height:    parent.Header.Height + 1,
-			ParentID:  parent.Header.ID(),
-			Timestamp: parent.Header.Timestamp.Add(time.Second),
-		},
-		Transactions: txns,
-	}
-	b.Header.Commitment = s.Commitment(b.Header.MinerAddress, b.Transactions)
+	b := AssembleBlock(s, types.Address{}, parent.Header.Timestamp.Add(time.Second), txns...)
 	findBlockNonce(s, &b.Header, types.HashRequiringWork(s.Difficulty))
 	return b
 }
@@ -51,7 +43,7 @@ func TestScratchChain(t *testing.T) {
 		{Value: types.Siacoins(12), Address: ourAddr},
 		{Value: types.Siacoins(13), Address: ourAddr},
 	}...)
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 
 	sc := NewScratchChain(sau.State)
 	if sc.Base() != sau.State.Index {
@@ -207,7 +199,7 @@ func TestScratchChain(t *testing.T) {
 
 func TestScratchChainDifficultyAdjustment(t *testing.T) {
 	b := genesisWithSiacoinOutputs()
-	s := GenesisUpdate(b, testingDifficulty).State
+	s := GenesisUpdate(b, testingDifficulty, MainnetParams()).State
 
 	// mine a block, triggering adjustment
 	sc := NewScratchChain(s)
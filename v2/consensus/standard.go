@@ -0,0 +1,58 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/v2/types"
+)
+
+const (
+	// MaxStandardArbitraryData is the maximum size, in bytes, of the
+	// ArbitraryData field of a standard transaction.
+	MaxStandardArbitraryData = 1024
+
+	// MaxStandardAttestations is the maximum number of attestations in a
+	// standard transaction.
+	MaxStandardAttestations = 4
+)
+
+// ErrNonStandard is returned when a transaction is valid, but does not meet
+// the relay policy enforced by IsStandard.
+var ErrNonStandard = errors.New("transaction is non-standard")
+
+// IsStandard checks whether txn is standard, i.e. whether nodes should relay
+// it. Standardness is a policy, not a consensus rule: a non-standard
+// transaction may still be valid, and may appear in a block. Accordingly,
+// IsStandard does not validate txn; use ValidateTransaction for that.
+//
+// A standard transaction has at most MaxStandardArbitraryData bytes of
+// arbitrary data and at most MaxStandardAttestations attestations, does not
+// spend inputs whose policy can be satisfied without any signatures (e.g.
+// types.AnyoneCanSpend), and does not create dust outputs.
+func (s State) IsStandard(txn types.Transaction) error {
+	if len(txn.ArbitraryData) > MaxStandardArbitraryData {
+		return fmt.Errorf("%w: arbitrary data (%v bytes) exceeds maximum (%v bytes)", ErrNonStandard, len(txn.ArbitraryData), MaxStandardArbitraryData)
+	} else if len(txn.Attestations) > MaxStandardAttestations {
+		return fmt.Errorf("%w: has %v attestations (maximum %v)", ErrNonStandard, len(txn.Attestations), MaxStandardAttestations)
+	}
+	anyoneCanSpend := func(p types.SpendPolicy) bool {
+		return s.ExplainSpendPolicy(p, types.Hash256{}, nil).Satisfied
+	}
+	for i, in := range txn.SiacoinInputs {
+		if anyoneCanSpend(in.SpendPolicy) {
+			return fmt.Errorf("%w: siacoin input %v has a policy that requires no signatures", ErrNonStandard, i)
+		}
+	}
+	for i, in := range txn.SiafundInputs {
+		if anyoneCanSpend(in.SpendPolicy) {
+			return fmt.Errorf("%w: siafund input %v has a policy that requires no signatures", ErrNonStandard, i)
+		}
+	}
+	for i, out := range txn.SiacoinOutputs {
+		if s.IsDust(out) {
+			return fmt.Errorf("%w: siacoin output %v is dust (%v)", ErrNonStandard, i, out.Value)
+		}
+	}
+	return nil
+}
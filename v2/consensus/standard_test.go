@@ -0,0 +1,84 @@
+package consensus
+
+import (
+	"errors"
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+)
+
+func TestIsStandard(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(100),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.StandardAddress(pubkey),
+			Value:   types.Siacoins(100),
+		}},
+		Attestations:  []types.Attestation{s.Attest(privkey, "foo", []byte("bar"))},
+		ArbitraryData: make([]byte, MaxStandardArbitraryData),
+	}
+	signAllInputs(&txn, s, privkey)
+	if err := s.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	} else if err := s.IsStandard(txn); err != nil {
+		t.Fatal("standard transaction rejected:", err)
+	}
+
+	tests := []struct {
+		desc   string
+		modify func(txn *types.Transaction)
+	}{
+		{
+			"oversized arbitrary data",
+			func(txn *types.Transaction) {
+				txn.ArbitraryData = make([]byte, MaxStandardArbitraryData+1)
+			},
+		},
+		{
+			"too many attestations",
+			func(txn *types.Transaction) {
+				for len(txn.Attestations) <= MaxStandardAttestations {
+					txn.Attestations = append(txn.Attestations, txn.Attestations[0])
+				}
+			},
+		},
+		{
+			"anyone-can-spend input",
+			func(txn *types.Transaction) {
+				txn.SiacoinInputs[0].SpendPolicy = types.AnyoneCanSpend()
+			},
+		},
+		{
+			"siafund input requiring no signatures",
+			func(txn *types.Transaction) {
+				txn.SiafundInputs = []types.SiafundInput{{
+					SpendPolicy: types.PolicyThreshold(0, []types.SpendPolicy{types.PolicyPublicKey(pubkey)}),
+				}}
+			},
+		},
+		{
+			"dust output",
+			func(txn *types.Transaction) {
+				txn.SiacoinOutputs[0].Value = MainnetParams().DustThreshold.Sub(types.NewCurrency64(1))
+			},
+		},
+	}
+	for _, test := range tests {
+		nonstandard := txn.DeepCopy()
+		test.modify(&nonstandard)
+		if err := s.IsStandard(nonstandard); !errors.Is(err, ErrNonStandard) {
+			t.Errorf("%v: expected ErrNonStandard, got %v", test.desc, err)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package consensus
 
 import (
+	"context"
 	"encoding/binary"
+	"fmt"
 	"math/bits"
 	"sync"
 	"time"
@@ -13,12 +15,71 @@ import (
 const (
 	blocksPerYear = 144 * 365
 
-	asicHardforkHeight       = 179000
-	foundationHardforkHeight = 300000
-
 	foundationSubsidyFrequency = blocksPerYear / 12
 )
 
+// NetworkParams contains the consensus parameters that distinguish one network
+// from another, e.g. mainnet from a testnet. The genesis timestamp is not a
+// parameter; it is always taken from the genesis block.
+type NetworkParams struct {
+	// InitialCoinbase is the block reward, in siacoins, for the block at
+	// height 0. The reward decreases by 1 SC per block until it reaches
+	// MinimumCoinbase.
+	InitialCoinbase uint32 `json:"initialCoinbase"`
+	MinimumCoinbase uint32 `json:"minimumCoinbase"`
+	// MaturityDelay is the number of blocks for which outputs linked to a
+	// particular block remain timelocked. See MaturityHeight.
+	MaturityDelay uint64 `json:"maturityDelay"`
+	// BlockInterval is the target wall clock time between consecutive blocks.
+	BlockInterval time.Duration `json:"blockInterval"`
+
+	ASICHardforkHeight       uint64 `json:"asicHardforkHeight"`
+	FoundationHardforkHeight uint64 `json:"foundationHardforkHeight"`
+
+	// DustThreshold is the minimum value of a siacoin output that is worth
+	// spending. It is not enforced by consensus; see State.IsDust.
+	DustThreshold types.Currency `json:"dustThreshold"`
+}
+
+// EncodeTo implements types.EncoderTo.
+func (p NetworkParams) EncodeTo(e *types.Encoder) {
+	e.WriteUint64(uint64(p.InitialCoinbase))
+	e.WriteUint64(uint64(p.MinimumCoinbase))
+	e.WriteUint64(p.MaturityDelay)
+	e.WriteUint64(uint64(p.BlockInterval))
+	e.WriteUint64(p.ASICHardforkHeight)
+	e.WriteUint64(p.FoundationHardforkHeight)
+	p.DustThreshold.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (p *NetworkParams) DecodeFrom(d *types.Decoder) {
+	p.InitialCoinbase = uint32(d.ReadUint64())
+	p.MinimumCoinbase = uint32(d.ReadUint64())
+	p.MaturityDelay = d.ReadUint64()
+	p.BlockInterval = time.Duration(d.ReadUint64())
+	p.ASICHardforkHeight = d.ReadUint64()
+	p.FoundationHardforkHeight = d.ReadUint64()
+	p.DustThreshold.DecodeFrom(d)
+}
+
+// MainnetParams returns the parameters of the Sia mainnet. Each call returns a
+// new copy, which the caller may modify without affecting any other State.
+func MainnetParams() *NetworkParams {
+	p := mainnetParams
+	return &p
+}
+
+var mainnetParams = NetworkParams{
+	InitialCoinbase:          300000,
+	MinimumCoinbase:          30000,
+	MaturityDelay:            144,
+	BlockInterval:            10 * time.Minute,
+	ASICHardforkHeight:       179000,
+	FoundationHardforkHeight: 300000,
+	DustThreshold:            types.Siacoins(1).Div64(1000),
+}
+
 // Pool for reducing heap allocations when hashing. This is only necessary
 // because blake2b.New256 returns a hash.Hash interface, which prevents the
 // compiler from doing escape analysis. Can be removed if we switch to an
@@ -37,9 +98,18 @@ type State struct {
 	OakWork          types.Work    `json:"oakWork"`
 	OakTime          time.Duration `json:"oakTime"`
 	GenesisTimestamp time.Time     `json:"genesisTimestamp"`
+	// GenesisID is the ID of the network's genesis block. It is included in
+	// every signature hash, so that signatures made on one network cannot be
+	// replayed on another.
+	GenesisID types.BlockID `json:"genesisID"`
 
 	SiafundPool       types.Currency `json:"siafundPool"`
 	FoundationAddress types.Address  `json:"foundationAddress"`
+
+	// Params are the parameters of the network that the State belongs to. If
+	// nil, the mainnet parameters are used. Params are encoded along with the
+	// rest of the State, so a decoded State retains the rules of its network.
+	Params *NetworkParams `json:"params"`
 }
 
 // EncodeTo implements types.EncoderTo.
@@ -55,8 +125,10 @@ func (s State) EncodeTo(e *types.Encoder) {
 	s.OakWork.EncodeTo(e)
 	e.WriteUint64(uint64(s.OakTime))
 	e.WriteTime(s.GenesisTimestamp)
+	s.GenesisID.EncodeTo(e)
 	s.SiafundPool.EncodeTo(e)
 	s.FoundationAddress.EncodeTo(e)
+	s.params().EncodeTo(e)
 }
 
 // DecodeFrom implements types.DecoderFrom.
@@ -72,8 +144,29 @@ func (s *State) DecodeFrom(d *types.Decoder) {
 	s.OakWork.DecodeFrom(d)
 	s.OakTime = time.Duration(d.ReadUint64())
 	s.GenesisTimestamp = d.ReadTime()
+	s.GenesisID.DecodeFrom(d)
 	s.SiafundPool.DecodeFrom(d)
 	s.FoundationAddress.DecodeFrom(d)
+	s.Params = new(NetworkParams)
+	s.Params.DecodeFrom(d)
+}
+
+// Copy returns a copy of s that may be used from another goroutine while s is
+// modified. State contains no slices or maps -- its accumulators store a fixed
+// array of tree roots -- so only Params needs to be copied explicitly.
+func (s State) Copy() State {
+	if s.Params != nil {
+		p := *s.Params
+		s.Params = &p
+	}
+	return s
+}
+
+func (s State) params() *NetworkParams {
+	if s.Params == nil {
+		return &mainnetParams
+	}
+	return s.Params
 }
 
 func (s State) numTimestamps() int {
@@ -85,18 +178,36 @@ func (s State) numTimestamps() int {
 
 // BlockInterval is the expected wall clock time between consecutive blocks.
 func (s State) BlockInterval() time.Duration {
-	return 10 * time.Minute
+	return s.params().BlockInterval
 }
 
 // BlockReward returns the reward for mining a child block.
 func (s State) BlockReward() types.Currency {
-	const initialCoinbase = 300000
-	const minimumCoinbase = 30000
+	p := s.params()
 	blockHeight := s.Index.Height + 1
-	if blockHeight < initialCoinbase-minimumCoinbase {
-		return types.Siacoins(uint32(initialCoinbase - blockHeight))
+	if p.InitialCoinbase > p.MinimumCoinbase && blockHeight < uint64(p.InitialCoinbase-p.MinimumCoinbase) {
+		return types.Siacoins(p.InitialCoinbase - uint32(blockHeight))
 	}
-	return types.Siacoins(minimumCoinbase)
+	return types.Siacoins(p.MinimumCoinbase)
+}
+
+// MinerPayout returns the value of the miner payout output of a child block
+// containing txns: the block reward plus the sum of the transactions' miner
+// fees. It panics if the sum overflows; ValidateBlock rejects such blocks.
+func (s State) MinerPayout(txns []types.Transaction) types.Currency {
+	payout := s.BlockReward()
+	for _, txn := range txns {
+		payout = payout.Add(txn.MinerFee)
+	}
+	return payout
+}
+
+// IsDust returns true if out is worth less than the network's dust threshold,
+// i.e. if it would likely cost more in fees to spend than it is worth. Wallets
+// should avoid creating dust outputs, e.g. by adding dusty change to the miner
+// fee instead.
+func (s State) IsDust(out types.SiacoinOutput) bool {
+	return out.Value.Cmp(s.params().DustThreshold) < 0
 }
 
 // MaturityHeight is the height at which various outputs created in the child
@@ -111,7 +222,7 @@ func (s State) BlockReward() types.Currency {
 // timelock does not completely eliminate this issue -- after all, reorgs can be
 // arbitrarily deep -- but it does make it highly unlikely to occur in practice.
 func (s State) MaturityHeight() uint64 {
-	return (s.Index.Height + 1) + 144
+	return (s.Index.Height + 1) + s.params().MaturityDelay
 }
 
 // SiafundCount is the number of siafunds in existence.
@@ -119,24 +230,33 @@ func (s State) SiafundCount() uint64 {
 	return 10000
 }
 
-// FoundationSubsidy returns the Foundation subsidy value for the child block.
-func (s State) FoundationSubsidy() types.Currency {
+// FoundationSubsidy returns the Foundation subsidy value for the block at the
+// given height. The subsidy is zero except on subsidy blocks: the hardfork
+// block, which pays out a year's worth of subsidy, and every
+// foundationSubsidyFrequency blocks thereafter, which each pay out a month's
+// worth.
+func (p *NetworkParams) FoundationSubsidy(height uint64) types.Currency {
 	foundationSubsidyPerBlock := types.Siacoins(30000)
 	initialfoundationSubsidy := foundationSubsidyPerBlock.Mul64(blocksPerYear)
 
-	blockHeight := s.Index.Height + 1
-	if blockHeight < foundationHardforkHeight || (blockHeight-foundationHardforkHeight)%foundationSubsidyFrequency != 0 {
+	hardforkHeight := p.FoundationHardforkHeight
+	if height < hardforkHeight || (height-hardforkHeight)%foundationSubsidyFrequency != 0 {
 		return types.ZeroCurrency
-	} else if blockHeight == foundationHardforkHeight {
+	} else if height == hardforkHeight {
 		return initialfoundationSubsidy
 	}
 	return foundationSubsidyPerBlock.Mul64(foundationSubsidyFrequency)
 }
 
+// FoundationSubsidy returns the Foundation subsidy value for the child block.
+func (s State) FoundationSubsidy() types.Currency {
+	return s.params().FoundationSubsidy(s.Index.Height + 1)
+}
+
 // NonceFactor is the factor by which all block nonces must be divisible.
 func (s State) NonceFactor() uint64 {
 	blockHeight := s.Index.Height + 1
-	if blockHeight < asicHardforkHeight {
+	if blockHeight < s.params().ASICHardforkHeight {
 		return 1
 	}
 	return 1009
@@ -147,6 +267,33 @@ func (s State) MaxBlockWeight() uint64 {
 	return 2_000_000
 }
 
+// ContainsUnspentSiacoinElement returns true if sce is an unspent siacoin
+// element in s.
+//
+// The state only stores the Merkle roots of its elements, so membership cannot
+// be determined from an ElementID alone: sce must carry a Merkle proof that is
+// valid for s. Callers should keep proofs current using the UpdateElementProof
+// method of each ApplyUpdate and RevertUpdate. Ephemeral elements are never
+// contained in the state.
+func (s State) ContainsUnspentSiacoinElement(sce types.SiacoinElement) bool {
+	return sce.LeafIndex != types.EphemeralLeafIndex && s.Elements.ContainsUnspentSiacoinElement(sce)
+}
+
+// ContainsUnspentSiafundElement returns true if sfe is an unspent siafund
+// element in s. As with ContainsUnspentSiacoinElement, sfe must carry a Merkle
+// proof that is valid for s.
+func (s State) ContainsUnspentSiafundElement(sfe types.SiafundElement) bool {
+	return sfe.LeafIndex != types.EphemeralLeafIndex && s.Elements.ContainsUnspentSiafundElement(sfe)
+}
+
+// ContainsUnresolvedFileContractElement returns true if fce is an unresolved
+// file contract in s. As with ContainsUnspentSiacoinElement, fce must carry a
+// Merkle proof that is valid for s. Note that a revised contract is only
+// contained in s with its latest revision.
+func (s State) ContainsUnresolvedFileContractElement(fce types.FileContractElement) bool {
+	return fce.LeafIndex != types.EphemeralLeafIndex && s.Elements.ContainsUnresolvedFileContractElement(fce)
+}
+
 // TransactionWeight computes the weight of a txn.
 func (s State) TransactionWeight(txn types.Transaction) uint64 {
 	storage := types.EncodedLen(txn)
@@ -173,6 +320,13 @@ func (s State) BlockWeight(txns []types.Transaction) uint64 {
 	return weight
 }
 
+// FeePerWeight returns the miner fee paid by txn per unit of weight, as
+// computed by TransactionWeight. Miners and txpools should prefer transactions
+// with a higher fee per weight.
+func (s State) FeePerWeight(txn types.Transaction) types.Currency {
+	return txn.MinerFee.Div64(s.TransactionWeight(txn))
+}
+
 // FileContractTax computes the tax levied on a given contract.
 func (s State) FileContractTax(fc types.FileContract) types.Currency {
 	sum := fc.RenterOutput.Value.Add(fc.HostOutput.Value)
@@ -183,6 +337,25 @@ func (s State) FileContractTax(fc types.FileContract) types.Currency {
 	return tax.Sub(types.NewCurrency64(r))
 }
 
+// ContractCost computes the siacoins that each party must contribute to form
+// fc. The host contributes the contract's collateral; the renter contributes
+// everything else, i.e. the renter payout, the remainder of the host payout,
+// and the tax. An error is returned if the collateral exceeds the host payout
+// or the cost overflows, since consensus would reject such a contract anyway.
+func (s State) ContractCost(fc types.FileContract) (renterFunding, hostFunding types.Currency, err error) {
+	if fc.TotalCollateral.Cmp(fc.HostOutput.Value) > 0 {
+		return types.ZeroCurrency, types.ZeroCurrency, fmt.Errorf("total collateral (%v SC) exceeds valid host value (%v SC)", fc.TotalCollateral, fc.HostOutput.Value)
+	}
+	payout, overflow := fc.RenterOutput.Value.AddWithOverflow(fc.HostOutput.Value)
+	if !overflow {
+		renterFunding, overflow = payout.Sub(fc.TotalCollateral).AddWithOverflow(s.FileContractTax(fc))
+	}
+	if overflow {
+		return types.ZeroCurrency, types.ZeroCurrency, ErrOverflow
+	}
+	return renterFunding, fc.TotalCollateral, nil
+}
+
 // StorageProofLeafIndex returns the leaf index used when computing or
 // validating a storage proof.
 func (s State) StorageProofLeafIndex(filesize uint64, windowStart types.ChainIndex, fcid types.ElementID) uint64 {
@@ -209,7 +382,10 @@ func (s State) StorageProofLeafIndex(filesize uint64, windowStart types.ChainInd
 	return r
 }
 
-// Commitment computes the commitment hash for a child block.
+// Commitment computes the commitment hash for a child block. Miners must set
+// the block header's Commitment field to this value. The commitment covers s,
+// the miner address, and the IDs of txns; since transaction IDs do not cover
+// signatures or Merkle proofs, neither does the commitment.
 func (s State) Commitment(minerAddr types.Address, txns []types.Transaction) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
@@ -236,14 +412,53 @@ func (s State) Commitment(minerAddr types.Address, txns []types.Transaction) typ
 	return h.Sum()
 }
 
+// AssembleBlock returns a child block of s containing txns, with its height,
+// parent ID, timestamp, miner address, and commitment set. The block's Nonce is
+// zero; the caller must find a nonce, divisible by s.NonceFactor(), such that
+// the block's ID meets the target s.Difficulty.
+func AssembleBlock(s State, minerAddr types.Address, timestamp time.Time, txns ...types.Transaction) types.Block {
+	return types.Block{
+		Header: types.BlockHeader{
+			Height:       s.Index.Height + 1,
+			ParentID:     s.Index.ID,
+			Timestamp:    timestamp,
+			MinerAddress: minerAddr,
+			Commitment:   s.Commitment(minerAddr, txns),
+		},
+		Transactions: txns,
+	}
+}
+
+// GrindNonce increments the Nonce of h, in multiples of s.NonceFactor(), until
+// h.ID() meets target, returning the mined header. If ctx is cancelled first,
+// it returns false.
+func GrindNonce(ctx context.Context, s State, h types.BlockHeader, target types.BlockID) (types.BlockHeader, bool) {
+	factor := s.NonceFactor()
+	h.Nonce -= h.Nonce % factor
+	for i := 0; !h.ID().MeetsTarget(target); i++ {
+		// checking the context is relatively expensive, so only do it
+		// periodically
+		if i%1024 == 0 && ctx.Err() != nil {
+			return h, false
+		}
+		h.Nonce += factor
+	}
+	return h, true
+}
+
 // InputSigHash returns the hash that must be signed for each transaction input.
+// It covers the genesis ID and every field of txn, except that inputs and
+// contract updates are identified only by their parent's ID (not the parent's
+// value or proof), input signatures are omitted, and storage proofs are
+// identified only by their window start.
 func (s State) InputSigHash(txn types.Transaction) types.Hash256 {
 	// NOTE: This currently covers exactly the same fields as txn.ID(), and for
-	// similar reasons.
+	// similar reasons, plus the genesis ID for replay protection.
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
 	h.E.WriteString("sia/sig/transactioninput")
+	s.GenesisID.EncodeTo(h.E)
 	h.E.WritePrefix(len(txn.SiacoinInputs))
 	for _, in := range txn.SiacoinInputs {
 		in.Parent.ID.EncodeTo(h.E)
@@ -276,6 +491,7 @@ func (s State) InputSigHash(txn types.Transaction) types.Hash256 {
 		fcr.StorageProof.WindowStart.EncodeTo(h.E)
 		fcr.Finalization.EncodeTo(h.E)
 	}
+	h.E.WritePrefix(len(txn.Attestations))
 	for _, a := range txn.Attestations {
 		a.EncodeTo(h.E)
 	}
@@ -285,12 +501,46 @@ func (s State) InputSigHash(txn types.Transaction) types.Hash256 {
 	return h.Sum()
 }
 
+// PartialSigHash returns the hash that must be signed for a transaction input
+// whose CoveredFields are cf. It covers the genesis ID and only the inputs
+// and outputs of txn specified by cf. As in InputSigHash, inputs are
+// identified by their parent's ID; siafund inputs additionally commit to their
+// claim address. It panics if cf contains an out-of-range index.
+func (s State) PartialSigHash(txn types.Transaction, cf types.CoveredFields) types.Hash256 {
+	h := hasherPool.Get().(*types.Hasher)
+	defer hasherPool.Put(h)
+	h.Reset()
+	h.E.WriteString("sia/sig/partialtransactioninput")
+	s.GenesisID.EncodeTo(h.E)
+	h.E.WritePrefix(len(cf.SiacoinInputs))
+	for _, i := range cf.SiacoinInputs {
+		txn.SiacoinInputs[i].Parent.ID.EncodeTo(h.E)
+	}
+	h.E.WritePrefix(len(cf.SiacoinOutputs))
+	for _, i := range cf.SiacoinOutputs {
+		txn.SiacoinOutputs[i].EncodeTo(h.E)
+	}
+	h.E.WritePrefix(len(cf.SiafundInputs))
+	for _, i := range cf.SiafundInputs {
+		txn.SiafundInputs[i].Parent.ID.EncodeTo(h.E)
+		txn.SiafundInputs[i].ClaimAddress.EncodeTo(h.E)
+	}
+	h.E.WritePrefix(len(cf.SiafundOutputs))
+	for _, i := range cf.SiafundOutputs {
+		txn.SiafundOutputs[i].EncodeTo(h.E)
+	}
+	return h.Sum()
+}
+
 // ContractSigHash returns the hash that must be signed for a file contract revision.
+// It covers the genesis ID and every field of fc except the renter and host
+// signatures.
 func (s State) ContractSigHash(fc types.FileContract) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
 	h.E.WriteString("sia/sig/filecontract")
+	s.GenesisID.EncodeTo(h.E)
 	h.E.WriteUint64(fc.Filesize)
 	fc.FileMerkleRoot.EncodeTo(h.E)
 	h.E.WriteUint64(fc.WindowStart)
@@ -298,6 +548,7 @@ func (s State) ContractSigHash(fc types.FileContract) types.Hash256 {
 	fc.RenterOutput.EncodeTo(h.E)
 	fc.HostOutput.EncodeTo(h.E)
 	fc.MissedHostValue.EncodeTo(h.E)
+	fc.TotalCollateral.EncodeTo(h.E)
 	fc.RenterPublicKey.EncodeTo(h.E)
 	fc.HostPublicKey.EncodeTo(h.E)
 	h.E.WriteUint64(fc.RevisionNumber)
@@ -305,11 +556,15 @@ func (s State) ContractSigHash(fc types.FileContract) types.Hash256 {
 }
 
 // RenewalSigHash returns the hash that must be signed for a file contract renewal.
+// It covers the genesis ID and every field of fcr except the renter and host
+// signatures; the final and initial revisions are covered in full, including
+// their own signatures.
 func (s State) RenewalSigHash(fcr types.FileContractRenewal) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
 	h.E.WriteString("sia/sig/filecontractrenewal")
+	s.GenesisID.EncodeTo(h.E)
 	fcr.FinalRevision.EncodeTo(h.E)
 	fcr.InitialRevision.EncodeTo(h.E)
 	fcr.RenterRollover.EncodeTo(h.E)
@@ -318,17 +573,110 @@ func (s State) RenewalSigHash(fcr types.FileContractRenewal) types.Hash256 {
 }
 
 // AttestationSigHash returns the hash that must be signed for an attestation.
+// It covers the genesis ID and every field of a except its signature.
 func (s State) AttestationSigHash(a types.Attestation) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
 	h.E.WriteString("sia/sig/attestation")
+	s.GenesisID.EncodeTo(h.E)
 	a.PublicKey.EncodeTo(h.E)
 	h.E.WriteString(a.Key)
 	h.E.WriteBytes(a.Value)
 	return h.Sum()
 }
 
+// Attest returns an attestation associating the specified key-value pair with
+// the public key of sk, signed by sk.
+func (s State) Attest(sk types.PrivateKey, key string, value []byte) types.Attestation {
+	a := types.Attestation{
+		PublicKey: sk.PublicKey(),
+		Key:       key,
+		Value:     value,
+	}
+	a.Signature = sk.SignHash(s.AttestationSigHash(a))
+	return a
+}
+
+// NewFinalization returns a resolution that finalizes fce in its current
+// state, setting its revision number to types.MaxRevisionNumber and signing it
+// with the renter and host keys. The resolution is only valid until the
+// contract's proof window ends.
+func (s State) NewFinalization(fce types.FileContractElement, renterKey, hostKey types.PrivateKey) types.FileContractResolution {
+	final := fce.FileContract
+	final.RevisionNumber = types.MaxRevisionNumber
+	sigHash := s.ContractSigHash(final)
+	final.RenterSignature = renterKey.SignHash(sigHash)
+	final.HostSignature = hostKey.SignHash(sigHash)
+	return types.FileContractResolution{
+		Parent:       fce,
+		Finalization: final,
+	}
+}
+
+// ResolutionOptions describes the ways in which a file contract may be revised
+// or resolved at a particular height.
+type ResolutionOptions struct {
+	// Revise is true if the contract may be revised, i.e. if its proof window
+	// has not begun and it has not been finalized.
+	Revise bool
+	// Renew and Finalize are true if the contract may be renewed or
+	// finalized, i.e. if its proof window has not ended and it has not been
+	// finalized.
+	Renew    bool
+	Finalize bool
+	// StorageProof is true if the contract is within its proof window.
+	StorageProof bool
+	// Missed is true if the contract may be resolved without a storage proof,
+	// renewal, or finalization. For an empty contract, this creates its valid
+	// outputs, and is allowed once its proof window has begun; otherwise, it
+	// creates its missed outputs, and is allowed after its proof window has
+	// ended.
+	Missed bool
+}
+
+// ResolutionOptions returns the ways in which fce may be revised or resolved
+// by a transaction in the child block.
+func (s State) ResolutionOptions(fce types.FileContractElement) ResolutionOptions {
+	fc := fce.FileContract
+	height := s.Index.Height
+	finalized := fc.IsFinalized()
+	inWindow := fc.WindowStart <= height && height <= fc.WindowEnd
+	missed := height > fc.WindowEnd
+	if fc.Filesize == 0 {
+		missed = height >= fc.WindowStart
+	}
+	return ResolutionOptions{
+		Revise:       height <= fc.WindowStart && !finalized,
+		Renew:        height <= fc.WindowEnd && !finalized,
+		Finalize:     height <= fc.WindowEnd && !finalized,
+		StorageProof: inWindow,
+		Missed:       missed,
+	}
+}
+
+// NewMissedResolution returns a resolution that resolves fce without a storage
+// proof, renewal, or finalization. For a non-empty contract, such a
+// resolution creates the missed outputs, and is only valid after the
+// contract's proof window has ended. For an empty contract, it creates the
+// valid outputs, and is valid as soon as the proof window has begun. An error
+// is returned if the resolution would not yet be valid.
+func (s State) NewMissedResolution(fce types.FileContractElement) (types.FileContractResolution, error) {
+	fc := fce.FileContract
+	if !s.ResolutionOptions(fce).Missed {
+		if fc.Filesize == 0 {
+			return types.FileContractResolution{}, fmt.Errorf("empty contract cannot be resolved until its proof window (%v - %v) begins", fc.WindowStart, fc.WindowEnd)
+		}
+		return types.FileContractResolution{}, fmt.Errorf("contract cannot be resolved as missed until its proof window (%v - %v) ends", fc.WindowStart, fc.WindowEnd)
+	}
+	return types.FileContractResolution{Parent: fce}, nil
+}
+
+// VerifyAttestation reports whether a is signed by its public key.
+func (s State) VerifyAttestation(a types.Attestation) bool {
+	return a.PublicKey.VerifyHash(s.AttestationSigHash(a), a.Signature)
+}
+
 // A Checkpoint pairs a block with its resulting chain state.
 type Checkpoint struct {
 	Block types.Block
@@ -0,0 +1,190 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func sigHashTestObjects() (types.FileContract, types.FileContractRenewal, types.Attestation, types.Transaction) {
+	fc := types.FileContract{
+		Filesize:        1,
+		FileMerkleRoot:  types.Hash256{2},
+		WindowStart:     3,
+		WindowEnd:       4,
+		RenterOutput:    types.SiacoinOutput{Address: types.Address{5}, Value: types.NewCurrency64(6)},
+		HostOutput:      types.SiacoinOutput{Address: types.Address{7}, Value: types.NewCurrency64(8)},
+		MissedHostValue: types.NewCurrency64(9),
+		TotalCollateral: types.NewCurrency64(10),
+		RenterPublicKey: types.PublicKey{11},
+		HostPublicKey:   types.PublicKey{12},
+		RevisionNumber:  13,
+		RenterSignature: types.Signature{14},
+		HostSignature:   types.Signature{15},
+	}
+	fcr := types.FileContractRenewal{
+		FinalRevision:   fc,
+		InitialRevision: fc,
+		RenterRollover:  types.NewCurrency64(16),
+		HostRollover:    types.NewCurrency64(17),
+		RenterSignature: types.Signature{18},
+		HostSignature:   types.Signature{19},
+	}
+	a := types.Attestation{
+		PublicKey: types.PublicKey{20},
+		Key:       "foo",
+		Value:     []byte("bar"),
+		Signature: types.Signature{21},
+	}
+	txn := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{Parent: types.SiacoinElement{StateElement: types.StateElement{ID: types.ElementID{Source: types.Hash256{22}}}}}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.Address{23}, Value: types.NewCurrency64(24)}},
+		FileContracts:  []types.FileContract{fc},
+		Attestations:   []types.Attestation{a},
+		ArbitraryData:  []byte("baz"),
+		MinerFee:       types.NewCurrency64(25),
+	}
+	return fc, fcr, a, txn
+}
+
+func TestSigHashes(t *testing.T) {
+	s := State{GenesisID: types.BlockID{26}}
+	fc, fcr, a, txn := sigHashTestObjects()
+
+	// golden values; if these change, the signature format has changed, and
+	// all existing signatures are invalidated
+	for _, test := range []struct {
+		desc string
+		hash types.Hash256
+		exp  string
+	}{
+		{"ContractSigHash", s.ContractSigHash(fc), "h:4ce8bbe2c8191d834ac6228641c5abd9d43e3ab75da5545980c1af179e6ec7d3"},
+		{"RenewalSigHash", s.RenewalSigHash(fcr), "h:fb2e817e7517cf8dea78548253d7f1856f240ad8edb5f8d7855958c251654884"},
+		{"AttestationSigHash", s.AttestationSigHash(a), "h:636d37f4c293ae334eb886144f1fc4e2688034e184bf3a10297488d143b503dd"},
+		{"InputSigHash", s.InputSigHash(txn), "h:b337346101504c75a66fcd2a396a55a268da421dc1f3fcc01907b1fe23c7eb00"},
+	} {
+		if test.hash.String() != test.exp {
+			t.Errorf("%v: expected %v, got %v", test.desc, test.exp, test.hash)
+		}
+	}
+
+	// pin the byte layout of the attestation and contract hashes
+	var buf []byte
+	writeBytes := func(b []byte) {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(b)))
+		buf = append(buf, b...)
+	}
+	writeCurrency := func(c types.Currency) {
+		buf = binary.LittleEndian.AppendUint64(buf, c.Lo)
+		buf = binary.LittleEndian.AppendUint64(buf, c.Hi)
+	}
+	writeBytes([]byte("sia/sig/attestation"))
+	buf = append(buf, s.GenesisID[:]...)
+	buf = append(buf, a.PublicKey[:]...)
+	writeBytes([]byte(a.Key))
+	writeBytes(a.Value)
+	if s.AttestationSigHash(a) != blake2b.Sum256(buf) {
+		t.Error("AttestationSigHash does not match expected layout")
+	}
+
+	buf = buf[:0]
+	writeBytes([]byte("sia/sig/filecontract"))
+	buf = append(buf, s.GenesisID[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, fc.Filesize)
+	buf = append(buf, fc.FileMerkleRoot[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, fc.WindowStart)
+	buf = binary.LittleEndian.AppendUint64(buf, fc.WindowEnd)
+	writeCurrency(fc.RenterOutput.Value)
+	buf = append(buf, fc.RenterOutput.Address[:]...)
+	writeCurrency(fc.HostOutput.Value)
+	buf = append(buf, fc.HostOutput.Address[:]...)
+	writeCurrency(fc.MissedHostValue)
+	writeCurrency(fc.TotalCollateral)
+	buf = append(buf, fc.RenterPublicKey[:]...)
+	buf = append(buf, fc.HostPublicKey[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, fc.RevisionNumber)
+	if s.ContractSigHash(fc) != blake2b.Sum256(buf) {
+		t.Error("ContractSigHash does not match expected layout")
+	}
+
+	// every field except the signatures should be covered
+	contractTests := []func(*types.FileContract){
+		func(fc *types.FileContract) { fc.Filesize++ },
+		func(fc *types.FileContract) { fc.FileMerkleRoot[0]++ },
+		func(fc *types.FileContract) { fc.WindowStart++ },
+		func(fc *types.FileContract) { fc.WindowEnd++ },
+		func(fc *types.FileContract) { fc.RenterOutput.Address[0]++ },
+		func(fc *types.FileContract) { fc.RenterOutput.Value.Lo++ },
+		func(fc *types.FileContract) { fc.HostOutput.Address[0]++ },
+		func(fc *types.FileContract) { fc.HostOutput.Value.Lo++ },
+		func(fc *types.FileContract) { fc.MissedHostValue.Lo++ },
+		func(fc *types.FileContract) { fc.TotalCollateral.Lo++ },
+		func(fc *types.FileContract) { fc.RenterPublicKey[0]++ },
+		func(fc *types.FileContract) { fc.HostPublicKey[0]++ },
+		func(fc *types.FileContract) { fc.RevisionNumber++ },
+	}
+	for i, fn := range contractTests {
+		mod := fc
+		fn(&mod)
+		if s.ContractSigHash(mod) == s.ContractSigHash(fc) {
+			t.Errorf("ContractSigHash does not cover field %v", i)
+		}
+	}
+	mod := fc
+	mod.RenterSignature, mod.HostSignature = types.Signature{}, types.Signature{}
+	if s.ContractSigHash(mod) != s.ContractSigHash(fc) {
+		t.Error("ContractSigHash should not cover signatures")
+	}
+	modA := a
+	modA.Signature = types.Signature{}
+	if s.AttestationSigHash(modA) != s.AttestationSigHash(a) {
+		t.Error("AttestationSigHash should not cover signature")
+	}
+
+	// a transaction whose attestations are reinterpreted as arbitrary data
+	// must not have the same hash
+	att := types.Attestation{PublicKey: types.PublicKey{118}, Key: "foo", Value: []byte("bar")}
+	var attBuf bytes.Buffer
+	e := types.NewEncoder(&attBuf)
+	att.EncodeTo(e)
+	e.WriteBytes(nil)
+	e.Flush()
+	withAtt := types.Transaction{Attestations: []types.Attestation{att}}
+	withData := types.Transaction{ArbitraryData: attBuf.Bytes()[8:]}
+	if s.InputSigHash(withAtt) == s.InputSigHash(withData) {
+		t.Error("InputSigHash should not be malleable between attestations and arbitrary data")
+	} else if withAtt.ID() == withData.ID() {
+		t.Error("transaction ID should not be malleable between attestations and arbitrary data")
+	}
+}
+
+func TestFeePerWeight(t *testing.T) {
+	s := GenesisUpdate(genesisWithSiacoinOutputs(), testingDifficulty, MainnetParams()).State
+
+	// a large transaction paying a high fee, and a small transaction paying a
+	// lower fee that is nonetheless higher relative to its weight
+	large := types.Transaction{
+		ArbitraryData: make([]byte, 10000),
+		MinerFee:      types.Siacoins(10),
+	}
+	small := types.Transaction{
+		ArbitraryData: make([]byte, 100),
+		MinerFee:      types.Siacoins(1),
+	}
+	if exp := large.MinerFee.Div64(s.TransactionWeight(large)); s.FeePerWeight(large) != exp {
+		t.Fatalf("expected fee per weight of %v, got %v", exp, s.FeePerWeight(large))
+	}
+
+	txns := []types.Transaction{large, small}
+	sort.Slice(txns, func(i, j int) bool {
+		return s.FeePerWeight(txns[i]).Cmp(s.FeePerWeight(txns[j])) > 0
+	})
+	if txns[0].MinerFee != small.MinerFee {
+		t.Fatal("expected transaction with higher fee per weight to sort first")
+	}
+}
@@ -66,6 +66,19 @@ func adjustDifficulty(s *State, h types.BlockHeader) types.Work {
 	return newDifficulty
 }
 
+// NextDifficulty returns the Difficulty of the State that results from
+// applying a child block with the specified timestamp. Blocks that arrive
+// faster than the target BlockInterval raise the difficulty, and blocks that
+// arrive slower lower it, by at most 0.4% per block.
+func (s State) NextDifficulty(timestamp time.Time) types.Work {
+	h := types.BlockHeader{
+		Height:    s.Index.Height + 1,
+		Timestamp: timestamp,
+	}
+	s.OakTime, s.OakWork = updateOakTotals(&s, h)
+	return adjustDifficulty(&s, h)
+}
+
 func applyHeader(s *State, h types.BlockHeader) {
 	if h.Height == 0 {
 		// special handling for GenesisUpdate
@@ -73,7 +86,9 @@ func applyHeader(s *State, h types.BlockHeader) {
 		s.Index = h.Index()
 		return
 	}
-	s.TotalWork = s.TotalWork.Add(s.Difficulty)
+	// NOTE: saturate rather than wrap; a wrapped total would cause a heavier
+	// chain to compare as lighter during fork choice
+	s.TotalWork = s.TotalWork.SaturatingAdd(s.Difficulty)
 	s.OakTime, s.OakWork = updateOakTotals(s, h)
 	s.Difficulty = adjustDifficulty(s, h)
 	if s.numTimestamps() < len(s.PrevTimestamps) {
@@ -134,7 +149,7 @@ func createdInBlock(s State, b types.Block) (sces []types.SiacoinElement, sfes [
 			ID: b.MinerOutputID(),
 		},
 		SiacoinOutput: types.SiacoinOutput{
-			Value:   s.BlockReward(),
+			Value:   s.MinerPayout(b.Transactions),
 			Address: b.Header.MinerAddress,
 		},
 		MaturityHeight: s.MaturityHeight(),
@@ -243,6 +258,10 @@ type ApplyUpdate struct {
 	NewSiacoinElements    []types.SiacoinElement
 	NewSiafundElements    []types.SiafundElement
 	NewFileContracts      []types.FileContractElement
+
+	// SiafundClaimOutputs contains the ID of the claim output created by each
+	// element of SpentSiafunds.
+	SiafundClaimOutputs []types.ElementID
 }
 
 // SiacoinElementWasSpent returns true if the given SiacoinElement was spent.
@@ -265,6 +284,21 @@ func (au *ApplyUpdate) SiafundElementWasSpent(sfe types.SiafundElement) bool {
 	return false
 }
 
+// SiafundClaimOutput returns the claim output created by spending the given
+// SiafundElement, if it was spent.
+func (au *ApplyUpdate) SiafundClaimOutput(sfe types.SiafundElement) (types.SiacoinElement, bool) {
+	for i := range au.SpentSiafunds {
+		if au.SpentSiafunds[i].LeafIndex == sfe.LeafIndex {
+			for _, sce := range au.NewSiacoinElements {
+				if sce.ID == au.SiafundClaimOutputs[i] {
+					return sce, true
+				}
+			}
+		}
+	}
+	return types.SiacoinElement{}, false
+}
+
 // FileContractElementWasResolved returns true if the given FileContractElement was resolved.
 func (au *ApplyUpdate) FileContractElementWasResolved(fce types.FileContractElement) bool {
 	for i := range au.ResolvedFileContracts {
@@ -275,6 +309,78 @@ func (au *ApplyUpdate) FileContractElementWasResolved(fce types.FileContractElem
 	return false
 }
 
+// An ElementKind identifies the type of a state element.
+type ElementKind uint8
+
+// ElementKinds.
+const (
+	ElementKindSiacoin ElementKind = iota + 1
+	ElementKindSiafund
+	ElementKindFileContract
+)
+
+// An ElementAction describes how a block affected an element.
+type ElementAction uint8
+
+// ElementActions.
+const (
+	ElementCreated ElementAction = iota + 1
+	ElementSpent                 // spent outputs and resolved contracts
+	ElementRevised               // revised contracts
+)
+
+// An ElementEvent describes an element affected by a block.
+type ElementEvent struct {
+	ID     types.ElementID
+	Kind   ElementKind
+	Action ElementAction
+	// Transaction is the index, within the block, of the transaction that
+	// affected the element, or -1 for outputs created by the block itself
+	// (the miner payout and Foundation subsidy).
+	Transaction int
+}
+
+// ForEachElement calls fn for every element created, spent, or revised by b,
+// which must be the block that au was produced from. Each element is visited
+// exactly once per action; an ephemeral output is thus visited twice, once
+// when it is created and once when it is spent. Spent and revised elements are
+// visited first, in transaction order, followed by created elements in the
+// order of NewSiacoinElements, NewSiafundElements, and NewFileContracts.
+func (au *ApplyUpdate) ForEachElement(b types.Block, fn func(ElementEvent)) {
+	txnIndex := make(map[types.Hash256]int, len(b.Transactions))
+	for i, txn := range b.Transactions {
+		txnIndex[types.Hash256(txn.ID())] = i
+		for _, in := range txn.SiacoinInputs {
+			fn(ElementEvent{in.Parent.ID, ElementKindSiacoin, ElementSpent, i})
+		}
+		for _, in := range txn.SiafundInputs {
+			fn(ElementEvent{in.Parent.ID, ElementKindSiafund, ElementSpent, i})
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			fn(ElementEvent{fcr.Parent.ID, ElementKindFileContract, ElementRevised, i})
+		}
+		for _, fcr := range txn.FileContractResolutions {
+			fn(ElementEvent{fcr.Parent.ID, ElementKindFileContract, ElementSpent, i})
+		}
+	}
+	created := func(id types.ElementID, kind ElementKind) {
+		i, ok := txnIndex[id.Source]
+		if !ok {
+			i = -1
+		}
+		fn(ElementEvent{id, kind, ElementCreated, i})
+	}
+	for _, sce := range au.NewSiacoinElements {
+		created(sce.ID, ElementKindSiacoin)
+	}
+	for _, sfe := range au.NewSiafundElements {
+		created(sfe.ID, ElementKindSiafund)
+	}
+	for _, fce := range au.NewFileContracts {
+		created(fce.ID, ElementKindFileContract)
+	}
+}
+
 // UpdateTransactionProofs updates the element proofs and window proofs of a
 // transaction.
 func (au *ApplyUpdate) UpdateTransactionProofs(txn *types.Transaction) {
@@ -316,6 +422,9 @@ func ApplyBlock(s State, b types.Block) (au ApplyUpdate) {
 				spent[in.Parent.ID] = true
 			}
 		}
+		for i := range txn.SiafundInputs {
+			au.SiafundClaimOutputs = append(au.SiafundClaimOutputs, txn.SiafundClaimOutputID(i))
+		}
 	}
 	for _, sce := range au.NewSiacoinElements {
 		created = append(created, merkle.SiacoinLeaf(sce, spent[sce.ID]))
@@ -358,11 +467,14 @@ func ApplyBlock(s State, b types.Block) (au ApplyUpdate) {
 	return
 }
 
-// GenesisUpdate returns the ApplyUpdate for the genesis block b.
-func GenesisUpdate(b types.Block, initialDifficulty types.Work) ApplyUpdate {
+// GenesisUpdate returns the ApplyUpdate for the genesis block b on the network
+// described by params. If params is nil, the mainnet parameters are used.
+func GenesisUpdate(b types.Block, initialDifficulty types.Work, params *NetworkParams) ApplyUpdate {
 	return ApplyBlock(State{
 		Difficulty:       initialDifficulty,
 		GenesisTimestamp: b.Header.Timestamp,
+		GenesisID:        b.ID(),
+		Params:           params,
 	}, b)
 }
 
@@ -1,8 +1,12 @@
 package consensus
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"math"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,7 +43,7 @@ func TestApplyBlock(t *testing.T) {
 		{Value: randAmount(), Address: randAddr()},
 		{Value: randAmount(), Address: randAddr()},
 	}...)
-	update1 := GenesisUpdate(b, testingDifficulty)
+	update1 := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	acc1 := update1.State.Elements
 	origOutputs := update1.NewSiacoinElements
 	if len(origOutputs) != len(b.Transactions[0].SiacoinOutputs)+1 {
@@ -212,7 +216,7 @@ func TestRevertBlock(t *testing.T) {
 		{Value: randAmount(), Address: randAddr()},
 		{Value: randAmount(), Address: randAddr()},
 	}...)
-	update1 := GenesisUpdate(b, testingDifficulty)
+	update1 := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	origOutputs := update1.NewSiacoinElements
 	if len(origOutputs) != len(b.Transactions[0].SiacoinOutputs)+1 {
 		t.Fatalf("expected %v new outputs, got %v", len(b.Transactions[0].SiacoinOutputs)+1, len(origOutputs))
@@ -277,7 +281,7 @@ func TestSiafunds(t *testing.T) {
 			Value:   100,
 		}}}},
 	}
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 
 	// send siafunds to a new address
 	claimPubkey, claimPrivkey := testingKeypair(1)
@@ -306,6 +310,28 @@ func TestSiafunds(t *testing.T) {
 		t.Fatal("expected one block reward and one claim output")
 	}
 
+	// every siafund input implicitly creates exactly one claim output, so the
+	// element IDs should match those computed from the transaction
+	if sau.NewSiacoinElements[1].ID != txn.SiafundClaimOutputID(0) {
+		t.Fatal("claim output has wrong ID")
+	} else if sau.NewSiafundElements[0].ID != txn.SiafundOutputID(0) {
+		t.Fatal("siafund output has wrong ID")
+	}
+
+	// the update should report the spent siafund element and its claim output
+	spent := txn.SiafundInputs[0].Parent
+	if len(sau.SpentSiafunds) != 1 || !sau.SiafundElementWasSpent(spent) {
+		t.Fatal("expected siafund element to be spent")
+	} else if len(sau.SiafundClaimOutputs) != 1 || sau.SiafundClaimOutputs[0] != txn.SiafundClaimOutputID(0) {
+		t.Fatal("expected claim output ID to be reported")
+	} else if claim, ok := sau.SiafundClaimOutput(spent); !ok || claim.ID != txn.SiafundClaimOutputID(0) {
+		t.Fatal("expected claim output for spent siafund element")
+	} else if claim.Address != txn.SiafundInputs[0].ClaimAddress || claim.Value != sau.NewSiacoinElements[1].Value {
+		t.Fatal("claim output has wrong address or value")
+	} else if _, ok := sau.SiafundClaimOutput(sau.NewSiafundElements[0]); ok {
+		t.Fatal("unspent siafund element should not have a claim output")
+	}
+
 	// attempt to spend the claim output before it matures
 	txn = types.Transaction{
 		SiacoinInputs: []types.SiacoinInput{{
@@ -334,6 +360,56 @@ func TestSiafunds(t *testing.T) {
 	}
 }
 
+func TestStateCopy(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(100),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   types.Siacoins(100),
+		}},
+	}
+	signAllInputs(&txn, s, privkey)
+
+	// validate txn against a copy while the original state is advanced; the
+	// copy should be unaffected (and the race detector should stay quiet)
+	c := s.Copy()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	errs := make(chan error, 1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := c.ValidateTransaction(txn); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		b = mineBlock(s, b)
+		s = ApplyBlock(s, b).State
+	}
+	s.Elements.Trees[0] = types.Hash256{}
+	s.PrevTimestamps[0] = time.Time{}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	} else if c.Index.Height != 0 || s.Index.Height != 10 {
+		t.Fatal("copy should be independent of original")
+	}
+}
+
 func TestFoundationSubsidy(t *testing.T) {
 	// mine genesis block with initial Foundation address
 	pubkey, privkey := testingKeypair(0)
@@ -342,15 +418,15 @@ func TestFoundationSubsidy(t *testing.T) {
 		Value:   types.NewCurrency64(100),
 	})
 	b.Transactions[0].NewFoundationAddress = types.StandardAddress(pubkey)
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	if sau.State.FoundationAddress != types.StandardAddress(pubkey) {
 		t.Fatal("Foundation address not updated")
 	}
 	initialOutput := sau.NewSiacoinElements[1]
 
 	// skip to Foundation hardfork height; we should receive the initial subsidy
-	b.Header.Height = foundationHardforkHeight - 1
-	sau.State.Index.Height = foundationHardforkHeight - 1
+	b.Header.Height = MainnetParams().FoundationHardforkHeight - 1
+	sau.State.Index.Height = MainnetParams().FoundationHardforkHeight - 1
 	for i := range sau.State.PrevTimestamps {
 		sau.State.PrevTimestamps[i] = b.Header.Timestamp
 	}
@@ -412,7 +488,7 @@ func TestFoundationSubsidy(t *testing.T) {
 
 	// skip to the next foundation subsidy height; the foundation address should
 	// receive a new subsidy.
-	sau.State.Index.Height = foundationHardforkHeight + foundationSubsidyFrequency - 1
+	sau.State.Index.Height = MainnetParams().FoundationHardforkHeight + foundationSubsidyFrequency - 1
 	b.Header.Height = sau.State.Index.Height
 	b = mineBlock(sau.State, b, txn)
 	if err := sau.State.ValidateBlock(b); err != nil {
@@ -436,6 +512,170 @@ func TestFoundationSubsidy(t *testing.T) {
 		t.Fatal("subsidy output not created")
 	} else if exp := types.Siacoins(30000).Mul64(foundationSubsidyFrequency); !subsidyOutput.Value.Equals(exp) {
 		t.Fatalf("expected subsidy to be %v SC, got %v SC", exp, subsidyOutput.Value)
+	} else if subsidyOutput.Address != newAddress {
+		t.Fatal("subsidy should be sent to the updated Foundation address")
+	}
+}
+
+func TestForEachElement(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	addr := types.StandardAddress(pubkey)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{Address: addr, Value: types.Siacoins(100)})
+	b.Transactions[0].SiafundOutputs = []types.SiafundOutput{{Address: addr, Value: 100}}
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	// txn0 spends the genesis siacoin output, creating two outputs
+	txn0 := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: addr, Value: types.Siacoins(60)},
+			{Address: addr, Value: types.Siacoins(40)},
+		},
+	}
+	signAllInputs(&txn0, s, privkey)
+	// txn1 spends the first output of txn0 ephemerally, forming a contract
+	fc := types.FileContract{
+		WindowStart:     5,
+		WindowEnd:       10,
+		RenterOutput:    types.SiacoinOutput{Address: addr, Value: types.Siacoins(50)},
+		HostOutput:      types.SiacoinOutput{Address: addr},
+		RenterPublicKey: pubkey,
+		HostPublicKey:   pubkey,
+	}
+	fc.RenterSignature = privkey.SignHash(s.ContractSigHash(fc))
+	fc.HostSignature = fc.RenterSignature
+	renterCost, _, err := s.ContractCost(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn1 := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent: types.SiacoinElement{
+				StateElement:  types.StateElement{ID: txn0.SiacoinOutputID(0), LeafIndex: types.EphemeralLeafIndex},
+				SiacoinOutput: txn0.SiacoinOutputs[0],
+			},
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(60).Sub(renterCost)}},
+		FileContracts:  []types.FileContract{fc},
+	}
+	signAllInputs(&txn1, s, privkey)
+	// txn2 spends the genesis siafund output, creating a claim output
+	txn2 := types.Transaction{
+		SiafundInputs: []types.SiafundInput{{
+			Parent:       sau.NewSiafundElements[0],
+			SpendPolicy:  types.PolicyPublicKey(pubkey),
+			ClaimAddress: addr,
+		}},
+		SiafundOutputs: []types.SiafundOutput{{Address: addr, Value: 100}},
+	}
+	signAllInputs(&txn2, s, privkey)
+
+	b = mineBlock(s, b, txn0, txn1, txn2)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	au := ApplyBlock(s, b)
+
+	type key struct {
+		id     types.ElementID
+		action ElementAction
+	}
+	exp := map[key]ElementEvent{}
+	add := func(id types.ElementID, kind ElementKind, action ElementAction, txn int) {
+		exp[key{id, action}] = ElementEvent{id, kind, action, txn}
+	}
+	add(b.MinerOutputID(), ElementKindSiacoin, ElementCreated, -1)
+	add(sau.NewSiacoinElements[1].ID, ElementKindSiacoin, ElementSpent, 0)
+	add(txn0.SiacoinOutputID(0), ElementKindSiacoin, ElementCreated, 0)
+	add(txn0.SiacoinOutputID(1), ElementKindSiacoin, ElementCreated, 0)
+	add(txn0.SiacoinOutputID(0), ElementKindSiacoin, ElementSpent, 1)
+	add(txn1.SiacoinOutputID(0), ElementKindSiacoin, ElementCreated, 1)
+	add(txn1.FileContractID(0), ElementKindFileContract, ElementCreated, 1)
+	add(sau.NewSiafundElements[0].ID, ElementKindSiafund, ElementSpent, 2)
+	add(txn2.SiafundClaimOutputID(0), ElementKindSiacoin, ElementCreated, 2)
+	add(txn2.SiafundOutputID(0), ElementKindSiafund, ElementCreated, 2)
+
+	seen := make(map[key]bool)
+	au.ForEachElement(b, func(e ElementEvent) {
+		k := key{e.ID, e.Action}
+		if seen[k] {
+			t.Errorf("element %v visited twice", e.ID)
+		} else if exp[k] != e {
+			t.Errorf("unexpected event %+v (expected %+v)", e, exp[k])
+		}
+		seen[k] = true
+	})
+	if len(seen) != len(exp) {
+		t.Errorf("expected %v events, got %v", len(exp), len(seen))
+	}
+}
+
+func TestContainsUnspentElement(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	addr := types.StandardAddress(pubkey)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{Address: addr, Value: types.Siacoins(100)})
+	b.Transactions[0].SiafundOutputs = []types.SiafundOutput{{Address: addr, Value: 100}}
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+	sce, sfe := sau.NewSiacoinElements[1], sau.NewSiafundElements[0]
+	if !s.ContainsUnspentSiacoinElement(sce) || !s.ContainsUnspentSiafundElement(sfe) {
+		t.Fatal("elements should be unspent")
+	}
+
+	// a tampered element should not be contained
+	tampered := sce
+	tampered.Value = types.Siacoins(1000)
+	if s.ContainsUnspentSiacoinElement(tampered) {
+		t.Fatal("tampered element should not be contained")
+	}
+
+	// mine a block that does not spend the elements; with updated proofs, they
+	// should still be unspent
+	b = mineBlock(s, b)
+	sau = ApplyBlock(s, b)
+	s = sau.State
+	if s.ContainsUnspentSiacoinElement(sce) {
+		t.Fatal("element with outdated proof should not be contained")
+	}
+	sau.UpdateElementProof(&sce.StateElement)
+	sau.UpdateElementProof(&sfe.StateElement)
+	if !s.ContainsUnspentSiacoinElement(sce) || !s.ContainsUnspentSiafundElement(sfe) {
+		t.Fatal("elements should be unspent")
+	}
+
+	// spend the elements
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sce,
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: sce.Value}},
+		SiafundInputs: []types.SiafundInput{{
+			Parent:       sfe,
+			SpendPolicy:  types.PolicyPublicKey(pubkey),
+			ClaimAddress: addr,
+		}},
+		SiafundOutputs: []types.SiafundOutput{{Address: addr, Value: sfe.Value}},
+	}
+	signAllInputs(&txn, s, privkey)
+	b = mineBlock(s, b, txn)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	sau = ApplyBlock(s, b)
+	s = sau.State
+	sau.UpdateElementProof(&sce.StateElement)
+	sau.UpdateElementProof(&sfe.StateElement)
+	if s.ContainsUnspentSiacoinElement(sce) || s.ContainsUnspentSiafundElement(sfe) {
+		t.Fatal("elements should be spent")
+	}
+	if sce := sau.NewSiacoinElements[1]; !s.ContainsUnspentSiacoinElement(sce) {
+		t.Fatal("new element should be unspent")
 	}
 }
 
@@ -443,7 +683,7 @@ func TestUpdateWindowProof(t *testing.T) {
 	for before := 0; before < 10; before++ {
 		for after := 0; after < 10; after++ {
 			b := genesisWithSiacoinOutputs()
-			sau := GenesisUpdate(b, testingDifficulty)
+			sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 			for i := 0; i < before; i++ {
 				b = mineBlock(sau.State, b)
 				sau = ApplyBlock(sau.State, b)
@@ -474,7 +714,7 @@ func TestFileContracts(t *testing.T) {
 		Address: types.StandardAddress(hostPubkey),
 		Value:   types.Siacoins(7),
 	})
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	renterOutput := sau.NewSiacoinElements[1]
 	hostOutput := sau.NewSiacoinElements[2]
 
@@ -646,7 +886,7 @@ func TestContractRenewal(t *testing.T) {
 		Address: types.StandardAddress(renterPubkey),
 		Value:   types.Siacoins(200),
 	})
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	renterOutput := sau.NewSiacoinElements[1]
 	hostOutput := sau.NewSiacoinElements[2]
 	renewOutput := sau.NewSiacoinElements[3]
@@ -862,7 +1102,7 @@ func TestContractFinalization(t *testing.T) {
 		Address: types.StandardAddress(hostPubkey),
 		Value:   types.Siacoins(7),
 	})
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	renterOutput := sau.NewSiacoinElements[1]
 	hostOutput := sau.NewSiacoinElements[2]
 
@@ -917,16 +1157,25 @@ func TestContractFinalization(t *testing.T) {
 	}
 
 	// finalize the contract
-	finalRev := fc.FileContract
-	finalRev.RevisionNumber = types.MaxRevisionNumber
-	contractHash = sau.State.ContractSigHash(finalRev)
-	finalRev.RenterSignature = renterPrivkey.SignHash(contractHash)
-	finalRev.HostSignature = hostPrivkey.SignHash(contractHash)
+	fcr := sau.State.NewFinalization(fc, renterPrivkey, hostPrivkey)
+	finalRev := fcr.Finalization
+	if finalRev.RevisionNumber != types.MaxRevisionNumber {
+		t.Fatal("finalization should set maximum revision number")
+	} else if fcr.Mode() != types.ResolutionFinalization {
+		t.Fatal("expected finalization resolution")
+	}
 	txn = types.Transaction{
-		FileContractResolutions: []types.FileContractResolution{{
-			Parent:       fc,
-			Finalization: finalRev,
-		}},
+		FileContractResolutions: []types.FileContractResolution{fcr},
+	}
+	if err := sau.State.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+	// signing with the wrong keys should produce an invalid finalization
+	badTxn := types.Transaction{
+		FileContractResolutions: []types.FileContractResolution{sau.State.NewFinalization(fc, hostPrivkey, renterPrivkey)},
+	}
+	if err := sau.State.ValidateTransaction(badTxn); err == nil {
+		t.Fatal("accepted finalization signed with wrong keys")
 	}
 
 	// after applying the transaction, the contract's outputs should be created immediately
@@ -946,6 +1195,213 @@ func TestContractFinalization(t *testing.T) {
 	}
 }
 
+func TestFinalizedContractRevision(t *testing.T) {
+	renterPubkey, renterPrivkey := testingKeypair(0)
+	hostPubkey, hostPrivkey := testingKeypair(1)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(renterPubkey),
+		Value:   types.Siacoins(100),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	signRevision := func(fc *types.FileContract) {
+		sigHash := s.ContractSigHash(*fc)
+		fc.RenterSignature = renterPrivkey.SignHash(sigHash)
+		fc.HostSignature = hostPrivkey.SignHash(sigHash)
+	}
+
+	// form a contract
+	fc := types.FileContract{
+		WindowStart: 5,
+		WindowEnd:   10,
+		RenterOutput: types.SiacoinOutput{
+			Address: types.StandardAddress(renterPubkey),
+			Value:   types.Siacoins(10),
+		},
+		RenterPublicKey: renterPubkey,
+		HostPublicKey:   hostPubkey,
+	}
+	signRevision(&fc)
+	cost, _, err := s.ContractCost(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(renterPubkey),
+		}},
+		FileContracts: []types.FileContract{fc},
+		MinerFee:      sau.NewSiacoinElements[1].Value.Sub(cost),
+	}
+	signAllInputs(&txn, s, renterPrivkey)
+	b = mineBlock(s, b, txn)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	sau = ApplyBlock(s, b)
+	s = sau.State
+	fce := sau.NewFileContracts[0]
+	if fce.IsFinalized() {
+		t.Fatal("new contract should not be finalized")
+	}
+
+	// revise the contract to the maximum revision number
+	rev := fce.FileContract
+	rev.RevisionNumber = types.MaxRevisionNumber
+	signRevision(&rev)
+	txn = types.Transaction{
+		FileContractRevisions: []types.FileContractRevision{{Parent: fce, Revision: rev}},
+	}
+	b = mineBlock(s, b, txn)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	sau = ApplyBlock(s, b)
+	s = sau.State
+	sau.UpdateElementProof(&fce.StateElement)
+	fce.FileContract = rev
+	if !fce.IsFinalized() {
+		t.Fatal("revised contract should be finalized")
+	} else if !s.ContainsUnresolvedFileContractElement(fce) {
+		t.Fatal("accumulator should contain finalized contract")
+	}
+
+	// further revisions should be rejected
+	rev.RenterOutput.Value, rev.HostOutput.Value = rev.HostOutput.Value, rev.RenterOutput.Value
+	signRevision(&rev)
+	txn = types.Transaction{
+		FileContractRevisions: []types.FileContractRevision{{Parent: fce, Revision: rev}},
+	}
+	if err := s.ValidateTransaction(txn); !errors.Is(err, ErrContractFinalized) {
+		t.Fatalf("expected ErrContractFinalized, got %v", err)
+	}
+}
+
+func TestNewMissedResolution(t *testing.T) {
+	renterPubkey, renterPrivkey := testingKeypair(0)
+	hostPubkey, hostPrivkey := testingKeypair(1)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(renterPubkey),
+		Value:   types.Siacoins(100),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	// form an empty contract and a non-empty contract
+	newContract := func(filesize uint64) types.FileContract {
+		fc := types.FileContract{
+			Filesize:    filesize,
+			WindowStart: 5,
+			WindowEnd:   10,
+			RenterOutput: types.SiacoinOutput{
+				Address: types.StandardAddress(renterPubkey),
+				Value:   types.Siacoins(10),
+			},
+			RenterPublicKey: renterPubkey,
+			HostPublicKey:   hostPubkey,
+		}
+		sigHash := s.ContractSigHash(fc)
+		fc.RenterSignature = renterPrivkey.SignHash(sigHash)
+		fc.HostSignature = hostPrivkey.SignHash(sigHash)
+		return fc
+	}
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(renterPubkey),
+		}},
+		FileContracts: []types.FileContract{newContract(0), newContract(64)},
+	}
+	txn.MinerFee = sau.NewSiacoinElements[1].Value
+	for _, fc := range txn.FileContracts {
+		cost, _, err := s.ContractCost(fc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txn.MinerFee = txn.MinerFee.Sub(cost)
+	}
+	signAllInputs(&txn, s, renterPrivkey)
+	b = mineBlock(s, b, txn)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	sau = ApplyBlock(s, b)
+	s = sau.State
+	emptyFCE, fce := sau.NewFileContracts[0], sau.NewFileContracts[1]
+
+	check := func(fce types.FileContractElement, height uint64, valid bool) {
+		t.Helper()
+		s := s
+		s.Index.Height = height
+		fcr, err := s.NewMissedResolution(fce)
+		if !valid {
+			if err == nil {
+				t.Fatalf("expected error resolving contract at height %v", height)
+			}
+			// a manually-constructed resolution should be rejected too
+			fcr = types.FileContractResolution{Parent: fce}
+			if err := s.ValidateTransaction(types.Transaction{FileContractResolutions: []types.FileContractResolution{fcr}}); err == nil {
+				t.Fatalf("consensus accepted resolution at height %v", height)
+			}
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		} else if fcr.Mode() != types.ResolutionMissed {
+			t.Fatal("expected missed resolution")
+		} else if err := s.ValidateTransaction(types.Transaction{FileContractResolutions: []types.FileContractResolution{fcr}}); err != nil {
+			t.Fatalf("consensus rejected resolution at height %v: %v", height, err)
+		}
+	}
+	// a non-empty contract can only be resolved after WindowEnd
+	check(fce, fce.WindowEnd-1, false)
+	check(fce, fce.WindowEnd, false)
+	check(fce, fce.WindowEnd+1, true)
+	// an empty contract can be resolved once the proof window begins
+	check(emptyFCE, emptyFCE.WindowStart-1, false)
+	check(emptyFCE, emptyFCE.WindowStart, true)
+	check(emptyFCE, emptyFCE.WindowEnd+1, true)
+}
+
+func TestResolutionOptions(t *testing.T) {
+	fce := types.FileContractElement{
+		FileContract: types.FileContract{
+			Filesize:    64,
+			WindowStart: 5,
+			WindowEnd:   10,
+		},
+	}
+	empty := fce
+	empty.Filesize = 0
+	finalized := fce
+	finalized.RevisionNumber = types.MaxRevisionNumber
+
+	tests := []struct {
+		desc   string
+		fce    types.FileContractElement
+		height uint64
+		exp    ResolutionOptions
+	}{
+		{"pre-window", fce, 4, ResolutionOptions{Revise: true, Renew: true, Finalize: true}},
+		{"window start", fce, 5, ResolutionOptions{Revise: true, Renew: true, Finalize: true, StorageProof: true}},
+		{"in window", fce, 7, ResolutionOptions{Renew: true, Finalize: true, StorageProof: true}},
+		{"window end", fce, 10, ResolutionOptions{Renew: true, Finalize: true, StorageProof: true}},
+		{"post-window", fce, 11, ResolutionOptions{Missed: true}},
+		{"empty pre-window", empty, 4, ResolutionOptions{Revise: true, Renew: true, Finalize: true}},
+		{"empty in window", empty, 7, ResolutionOptions{Renew: true, Finalize: true, StorageProof: true, Missed: true}},
+		{"empty post-window", empty, 11, ResolutionOptions{Missed: true}},
+		{"finalized pre-window", finalized, 4, ResolutionOptions{}},
+		{"finalized in window", finalized, 7, ResolutionOptions{StorageProof: true}},
+	}
+	for _, test := range tests {
+		s := State{Index: types.ChainIndex{Height: test.height}}
+		if got := s.ResolutionOptions(test.fce); got != test.exp {
+			t.Errorf("%v: expected %+v, got %+v", test.desc, test.exp, got)
+		}
+	}
+}
+
 func TestRevertFileContractRevision(t *testing.T) {
 	renterPubkey, renterPrivkey := testingKeypair(0)
 	hostPubkey, hostPrivkey := testingKeypair(1)
@@ -957,7 +1413,7 @@ func TestRevertFileContractRevision(t *testing.T) {
 		Value:   types.Siacoins(7),
 	})
 	parent := b
-	sau := GenesisUpdate(b, testingDifficulty)
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	renterOutput := sau.NewSiacoinElements[1]
 	hostOutput := sau.NewSiacoinElements[2]
 	prevState, s := sau.State, sau.State
@@ -1122,3 +1578,128 @@ func BenchmarkApplyBlock(b *testing.B) {
 		ApplyBlock(State{}, block)
 	}
 }
+
+func TestNetworkParams(t *testing.T) {
+	params := *MainnetParams()
+	params.InitialCoinbase = 100
+	params.MinimumCoinbase = 95
+	params.MaturityDelay = 3
+	params.BlockInterval = time.Minute
+
+	b := genesisWithSiacoinOutputs()
+	sau := GenesisUpdate(b, testingDifficulty, &params)
+	if sau.State.BlockInterval() != time.Minute {
+		t.Fatal("state does not use custom params")
+	}
+	for height := uint64(1); height <= 10; height++ {
+		s := sau.State
+		b = mineBlock(s, b)
+		if err := s.ValidateBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		sau = ApplyBlock(s, b)
+		if sau.State.Params != &params {
+			t.Fatal("params were not carried over to child state")
+		}
+
+		exp := types.Siacoins(95)
+		if height < 5 {
+			exp = types.Siacoins(100 - uint32(height))
+		}
+		if payout := sau.NewSiacoinElements[0]; payout.Value != exp {
+			t.Fatalf("expected block reward of %v at height %v, got %v", exp, height, payout.Value)
+		} else if payout.MaturityHeight != height+3 {
+			t.Fatalf("expected maturity height %v, got %v", height+3, payout.MaturityHeight)
+		}
+	}
+
+	// a nil Params should behave like mainnet
+	var s State
+	if s.BlockReward() != types.Siacoins(300000-1) || s.MaturityHeight() != 1+144 {
+		t.Fatal("zero State should use mainnet params")
+	}
+
+	// params should survive an encoding roundtrip
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	sau.State.EncodeTo(e)
+	e.Flush()
+	var decState State
+	d := types.NewBufDecoder(buf.Bytes())
+	decState.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if decState.Params == nil || *decState.Params != params {
+		t.Fatal("params did not survive encoding roundtrip")
+	} else if decState.BlockReward() != sau.State.BlockReward() {
+		t.Fatal("decoded state uses different params")
+	}
+
+	// likewise for JSON
+	js, err := json.Marshal(sau.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsState State
+	if err := json.Unmarshal(js, &jsState); err != nil {
+		t.Fatal(err)
+	} else if jsState.Params == nil || *jsState.Params != params {
+		t.Fatalf("params did not survive JSON roundtrip: %s", js)
+	}
+
+	// copies should not share params
+	c := sau.State.Copy()
+	c.Params.InitialCoinbase = 1
+	if sau.State.Params.InitialCoinbase != 100 {
+		t.Fatal("modifying copy affected original params")
+	}
+
+	// nor should the mainnet params
+	MainnetParams().InitialCoinbase = 1
+	if MainnetParams().InitialCoinbase != 300000 {
+		t.Fatal("modifying returned mainnet params affected the defaults")
+	}
+}
+
+func TestDifficultyAdjustment(t *testing.T) {
+	mineAt := func(s State, parent types.Block, interval time.Duration) types.Block {
+		b := types.Block{
+			Header: types.BlockHeader{
+				Height:    parent.Header.Height + 1,
+				ParentID:  parent.Header.ID(),
+				Timestamp: parent.Header.Timestamp.Add(interval),
+			},
+		}
+		b.Header.Commitment = s.Commitment(b.Header.MinerAddress, b.Transactions)
+		findBlockNonce(s, &b.Header, types.HashRequiringWork(s.Difficulty))
+		return b
+	}
+
+	for _, test := range []struct {
+		desc     string
+		interval time.Duration
+		cmp      int
+	}{
+		{"fast blocks", time.Second, 1},
+		{"slow blocks", 10 * MainnetParams().BlockInterval, -1},
+	} {
+		genesis := genesisWithSiacoinOutputs()
+		s := GenesisUpdate(genesis, testingDifficulty, MainnetParams()).State
+		initial := s.Difficulty
+		b := genesis
+		for i := 0; i < 20; i++ {
+			b = mineAt(s, b, test.interval)
+			if err := s.ValidateBlock(b); err != nil {
+				t.Fatal(err)
+			}
+			next := s.NextDifficulty(b.Header.Timestamp)
+			s = ApplyBlock(s, b).State
+			if s.Difficulty != next {
+				t.Fatalf("%v: NextDifficulty predicted %v, ApplyBlock produced %v", test.desc, next, s.Difficulty)
+			}
+		}
+		if s.Difficulty.Cmp(initial) != test.cmp {
+			t.Fatalf("%v: difficulty moved in the wrong direction (%v -> %v)", test.desc, initial, s.Difficulty)
+		}
+	}
+}
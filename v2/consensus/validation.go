@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"math/bits"
+	"runtime"
 	"sort"
+	"sync"
 	"time"
 
 	"go.sia.tech/core/v2/merkle"
@@ -19,9 +21,33 @@ var (
 	// ErrOverflow is returned when the sum of a transaction's inputs and/or
 	// outputs overflows the Currency representation.
 	ErrOverflow = errors.New("sum of currency values overflowed")
+
+	// ErrInvalidMinerPayout is returned when a block's miner payout output
+	// does not pay the block reward plus fees to the block's miner address.
+	ErrInvalidMinerPayout = errors.New("invalid miner payout")
+
+	// ErrInvalidFoundationSubsidy is returned when a block's Foundation
+	// subsidy output is missing, unexpected, or incorrectly sized.
+	ErrInvalidFoundationSubsidy = errors.New("invalid Foundation subsidy")
+
+	// ErrUnauthorizedFoundationUpdate is returned when a transaction sets a
+	// NewFoundationAddress without spending an input controlled by the current
+	// Foundation address.
+	ErrUnauthorizedFoundationUpdate = errors.New("transaction changes Foundation address, but does not spend an input controlled by current address")
+
+	// ErrContractFinalized is returned when a transaction revises a file
+	// contract that has already been finalized.
+	ErrContractFinalized = errors.New("contract has been finalized")
+
+	// ErrInsufficientContractFunding is returned when a transaction's siacoin
+	// inputs do not cover the cost of the file contracts it forms.
+	ErrInsufficientContractFunding = errors.New("insufficient contract funding")
 )
 
-func (s State) medianTimestamp() time.Time {
+// MedianTimestamp returns the median of the timestamps of the last (up to) 11
+// blocks, i.e. the "median time past." A child block's timestamp must not be
+// earlier than this value.
+func (s State) MedianTimestamp() time.Time {
 	prevCopy := s.PrevTimestamps
 	ts := prevCopy[:s.numTimestamps()]
 	sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
@@ -32,12 +58,16 @@ func (s State) medianTimestamp() time.Time {
 	return l.Add(r.Sub(l) / 2)
 }
 
-func (s State) validateHeader(h types.BlockHeader) error {
+// ValidateHeader validates h in the context of s. It checks the header's
+// height, parent ID, timestamp, nonce, and proof-of-work, but not its
+// commitment, which requires the full block. As with ValidateBlock, the
+// future timestamp bound is not checked; see MaxFutureTimestamp.
+func (s State) ValidateHeader(h types.BlockHeader) error {
 	if h.Height != s.Index.Height+1 {
 		return errors.New("wrong height")
 	} else if h.ParentID != s.Index.ID {
 		return errors.New("wrong parent ID")
-	} else if h.Timestamp.Before(s.medianTimestamp()) {
+	} else if h.Timestamp.Before(s.MedianTimestamp()) {
 		return errors.New("timestamp is too far in the past")
 	} else if h.Nonce%s.NonceFactor() != 0 {
 		return errors.New("nonce is not divisible by required factor")
@@ -129,7 +159,7 @@ func (s State) validateTimeLocks(txn types.Transaction) error {
 	return nil
 }
 
-func (s State) validateContract(fc types.FileContract) error {
+func (s State) validateContract(fc types.FileContract, verify sigVerifier) error {
 	switch {
 	case fc.WindowEnd <= s.Index.Height:
 		return fmt.Errorf("has proof window (%v-%v) that ends in the past", fc.WindowStart, fc.WindowEnd)
@@ -139,17 +169,21 @@ func (s State) validateContract(fc types.FileContract) error {
 		return fmt.Errorf("has missed host value (%v SC) exceeding valid host value (%v SC)", fc.MissedHostValue, fc.HostOutput.Value)
 	case fc.TotalCollateral.Cmp(fc.HostOutput.Value) > 0:
 		return fmt.Errorf("has total collateral (%v SC) exceeding valid host value (%v SC)", fc.TotalCollateral, fc.HostOutput.Value)
+	case fc.RenterPublicKey == (types.PublicKey{}):
+		return fmt.Errorf("has zero renter public key")
+	case fc.HostPublicKey == (types.PublicKey{}):
+		return fmt.Errorf("has zero host public key")
 	}
 	contractHash := s.ContractSigHash(fc)
-	if !fc.RenterPublicKey.VerifyHash(contractHash, fc.RenterSignature) {
+	if !verify(fc.RenterPublicKey, contractHash, fc.RenterSignature) {
 		return fmt.Errorf("has invalid renter signature")
-	} else if !fc.HostPublicKey.VerifyHash(contractHash, fc.HostSignature) {
+	} else if !verify(fc.HostPublicKey, contractHash, fc.HostSignature) {
 		return fmt.Errorf("has invalid host signature")
 	}
 	return nil
 }
 
-func (s State) validateRevision(cur, rev types.FileContract) error {
+func (s State) validateRevision(cur, rev types.FileContract, verify sigVerifier) error {
 	curOutputSum := cur.RenterOutput.Value.Add(cur.HostOutput.Value)
 	revOutputSum := rev.RenterOutput.Value.Add(rev.HostOutput.Value)
 	switch {
@@ -159,46 +193,60 @@ func (s State) validateRevision(cur, rev types.FileContract) error {
 		return fmt.Errorf("modifies output sum (%v SC -> %v SC)", curOutputSum, revOutputSum)
 	case rev.TotalCollateral != cur.TotalCollateral:
 		return fmt.Errorf("modifies total collateral")
+	case rev.RenterPublicKey != cur.RenterPublicKey:
+		return fmt.Errorf("modifies renter public key")
+	case rev.HostPublicKey != cur.HostPublicKey:
+		return fmt.Errorf("modifies host public key")
 	case rev.WindowEnd <= s.Index.Height:
 		return fmt.Errorf("has proof window (%v-%v) that ends in the past", rev.WindowStart, rev.WindowEnd)
 	case rev.WindowEnd <= rev.WindowStart:
 		return fmt.Errorf("has proof window (%v - %v) that ends before it begins", rev.WindowStart, rev.WindowEnd)
+	case rev.MissedHostValue.Cmp(rev.HostOutput.Value) > 0:
+		return fmt.Errorf("has missed host value (%v SC) exceeding valid host value (%v SC)", rev.MissedHostValue, rev.HostOutput.Value)
+	case rev.MissedHostValue.Cmp(cur.MissedHostValue) > 0 &&
+		(rev.HostOutput.Value.Cmp(cur.HostOutput.Value) < 0 ||
+			rev.MissedHostValue.Sub(cur.MissedHostValue).Cmp(rev.HostOutput.Value.Sub(cur.HostOutput.Value)) > 0):
+		// the missed host value may only increase alongside the valid host
+		// value, e.g. when the renter pays the host
+		return fmt.Errorf("increases missed host value (%v SC -> %v SC) by more than valid host value (%v SC -> %v SC)", cur.MissedHostValue, rev.MissedHostValue, cur.HostOutput.Value, rev.HostOutput.Value)
 	}
 
 	// verify signatures
 	//
 	// NOTE: very important that we verify with the *current* keys!
 	contractHash := s.ContractSigHash(rev)
-	if !cur.RenterPublicKey.VerifyHash(contractHash, rev.RenterSignature) {
+	if !verify(cur.RenterPublicKey, contractHash, rev.RenterSignature) {
 		return fmt.Errorf("has invalid renter signature")
-	} else if !cur.HostPublicKey.VerifyHash(contractHash, rev.HostSignature) {
+	} else if !verify(cur.HostPublicKey, contractHash, rev.HostSignature) {
 		return fmt.Errorf("has invalid host signature")
 	}
 	return nil
 }
 
-func (s State) validateFileContracts(txn types.Transaction) error {
+func (s State) validateFileContracts(txn types.Transaction, verify sigVerifier) error {
 	for i, fc := range txn.FileContracts {
-		if err := s.validateContract(fc); err != nil {
+		if err := s.validateContract(fc, verify); err != nil {
 			return fmt.Errorf("file contract %v %s", i, err)
 		}
 	}
 	return nil
 }
 
-func (s State) validateFileContractRevisions(txn types.Transaction) error {
+func (s State) validateFileContractRevisions(txn types.Transaction, verify sigVerifier) error {
 	for i, fcr := range txn.FileContractRevisions {
 		cur, rev := fcr.Parent.FileContract, fcr.Revision
 		if s.Index.Height > cur.WindowStart {
 			return fmt.Errorf("file contract revision %v cannot be applied to contract whose proof window (%v - %v) has already begun", i, cur.WindowStart, cur.WindowEnd)
-		} else if err := s.validateRevision(cur, rev); err != nil {
+		} else if cur.IsFinalized() {
+			return fmt.Errorf("file contract revision %v cannot be applied: %w", i, ErrContractFinalized)
+		} else if err := s.validateRevision(cur, rev, verify); err != nil {
 			return fmt.Errorf("file contract revision %v %s", i, err)
 		}
 	}
 	return nil
 }
 
-func (s State) validateFileContractResolutions(txn types.Transaction) error {
+func (s State) validateFileContractResolutions(txn types.Transaction, verify sigVerifier) error {
 	for i, fcr := range txn.FileContractResolutions {
 		// only one resolution type should be present
 		var typs int
@@ -223,11 +271,11 @@ func (s State) validateFileContractResolutions(txn types.Transaction) error {
 			old, renewed := fcr.Renewal.FinalRevision, fcr.Renewal.InitialRevision
 			if fc.WindowEnd < s.Index.Height {
 				return fmt.Errorf("file contract renewal %v cannot be applied to contract whose proof window (%v - %v) has expired", i, fc.WindowStart, fc.WindowEnd)
-			} else if old.RevisionNumber != types.MaxRevisionNumber {
+			} else if !old.IsFinalized() {
 				return fmt.Errorf("file contract renewal %v does not finalize old contract", i)
-			} else if err := s.validateRevision(fc, old); err != nil {
+			} else if err := s.validateRevision(fc, old, verify); err != nil {
 				return fmt.Errorf("file contract renewal %v has final revision that %s", i, err)
-			} else if err := s.validateContract(renewed); err != nil {
+			} else if err := s.validateContract(renewed, verify); err != nil {
 				return fmt.Errorf("file contract renewal %v has initial revision that %s", i, err)
 			}
 
@@ -243,9 +291,9 @@ func (s State) validateFileContractResolutions(txn types.Transaction) error {
 			}
 
 			renewalHash := s.RenewalSigHash(fcr.Renewal)
-			if !fc.RenterPublicKey.VerifyHash(renewalHash, fcr.Renewal.RenterSignature) {
+			if !verify(fc.RenterPublicKey, renewalHash, fcr.Renewal.RenterSignature) {
 				return fmt.Errorf("file contract renewal %v has invalid renter signature", i)
-			} else if !fc.HostPublicKey.VerifyHash(renewalHash, fcr.Renewal.HostSignature) {
+			} else if !verify(fc.HostPublicKey, renewalHash, fcr.Renewal.HostSignature) {
 				return fmt.Errorf("file contract renewal %v has invalid host signature", i)
 			}
 		} else if fcr.HasFinalization() {
@@ -254,9 +302,9 @@ func (s State) validateFileContractResolutions(txn types.Transaction) error {
 			// before WindowStart)
 			if fc.WindowEnd < s.Index.Height {
 				return fmt.Errorf("file contract finalization %v cannot be applied to contract whose proof window (%v - %v) has expired", i, fc.WindowStart, fc.WindowEnd)
-			} else if fcr.Finalization.RevisionNumber != types.MaxRevisionNumber {
+			} else if !fcr.Finalization.IsFinalized() {
 				return fmt.Errorf("file contract finalization %v does not set maximum revision number", i)
-			} else if err := s.validateRevision(fc, fcr.Finalization); err != nil {
+			} else if err := s.validateRevision(fc, fcr.Finalization, verify); err != nil {
 				return fmt.Errorf("file contract finalization %v %s", i, err)
 			}
 		} else if fcr.HasStorageProof() {
@@ -286,12 +334,12 @@ func (s State) validateFileContractResolutions(txn types.Transaction) error {
 	return nil
 }
 
-func (s State) validateAttestations(txn types.Transaction) error {
+func (s State) validateAttestations(txn types.Transaction, verify sigVerifier) error {
 	for i, a := range txn.Attestations {
 		switch {
 		case len(a.Key) == 0:
 			return fmt.Errorf("attestation %v has empty key", i)
-		case !a.PublicKey.VerifyHash(s.AttestationSigHash(a), a.Signature):
+		case !verify(a.PublicKey, s.AttestationSigHash(a), a.Signature):
 			return fmt.Errorf("attestation %v has invalid signature", i)
 		}
 	}
@@ -340,6 +388,39 @@ func (s State) outputsEqualInputs(txn types.Transaction) error {
 	return nil
 }
 
+// ValidateContractFunding checks that the siacoin inputs of txn are sufficient
+// to fund each of its new file contracts, i.e. the renter and host payouts
+// (which include the host's collateral) plus the contract tax. Unlike
+// ValidateTransaction, it does not check signatures, proofs, or whether the
+// transaction's inputs and outputs balance exactly, so it can be used to
+// sanity-check a formation transaction before it is fully assembled.
+func (s State) ValidateContractFunding(txn types.Transaction) error {
+	var inputSC, contractSC types.Currency
+	var overflow bool
+	for i, in := range txn.SiacoinInputs {
+		if inputSC, overflow = inputSC.AddWithOverflow(in.Parent.Value); overflow {
+			return fmt.Errorf("siacoin input %v overflows total input value", i)
+		}
+	}
+	for i, fc := range txn.FileContracts {
+		renterFunding, hostFunding, err := s.ContractCost(fc)
+		if err != nil {
+			return fmt.Errorf("file contract %v: %w", i, err)
+		}
+		cost, overflow := renterFunding.AddWithOverflow(hostFunding)
+		if !overflow {
+			contractSC, overflow = contractSC.AddWithOverflow(cost)
+		}
+		if overflow {
+			return fmt.Errorf("file contract %v overflows total contract cost", i)
+		}
+	}
+	if inputSC.Cmp(contractSC) < 0 {
+		return fmt.Errorf("%w: siacoin inputs (%v SC) do not cover contract payouts and tax (%v SC)", ErrInsufficientContractFunding, inputSC, contractSC)
+	}
+	return nil
+}
+
 func (s State) validateStateProofs(txn types.Transaction) error {
 	for i, in := range txn.SiacoinInputs {
 		switch {
@@ -395,83 +476,323 @@ func (s State) validateHistoryProofs(txn types.Transaction) error {
 	return nil
 }
 
+// validateFoundationUpdate checks that a change to the Foundation address is
+// authorized by the current Foundation policy. The address commits to that
+// policy (typically a primary multisig with a timelocked failsafe), so
+// spending any input controlled by the address proves that the policy was
+// satisfied; the policy itself is checked by validateSpendPolicies. Only
+// inputs signed over the whole transaction count: a partial signature does not
+// cover NewFoundationAddress, so anyone relaying the transaction could change
+// it.
 func (s State) validateFoundationUpdate(txn types.Transaction) error {
 	if txn.NewFoundationAddress == types.VoidAddress {
 		return nil
 	}
 	for _, in := range txn.SiacoinInputs {
-		if in.Parent.Address == s.FoundationAddress {
+		if in.Parent.Address == s.FoundationAddress && in.CoveredFields == nil {
 			return nil
 		}
 	}
-	return errors.New("transaction changes Foundation address, but does not spend an input controlled by current address")
+	return ErrUnauthorizedFoundationUpdate
 }
 
-func (s State) validateSpendPolicies(txn types.Transaction) error {
-	sigHash := s.InputSigHash(txn)
-	verifyPolicy := func(p types.SpendPolicy, sigs []types.Signature) error {
-		var verify func(types.SpendPolicy) error
-		verify = func(p types.SpendPolicy) error {
-			switch p := p.Type.(type) {
-			case types.PolicyTypeAbove:
-				if s.Index.Height > uint64(p) {
-					return nil
-				}
-				return fmt.Errorf("height not above %v", uint64(p))
-			case types.PolicyTypePublicKey:
-				for i := range sigs {
-					if types.PublicKey(p).VerifyHash(sigHash, sigs[i]) {
-						sigs = sigs[i+1:]
-						return nil
-					}
+// A PolicyResult describes whether a spend policy, or one of its clauses, was
+// satisfied, and why.
+type PolicyResult struct {
+	Policy    types.SpendPolicy
+	Satisfied bool
+	Reason    string
+	// Clauses contains the results of the policy's sub-policies, if any.
+	Clauses []PolicyResult
+}
+
+// Err returns nil if r was satisfied, and otherwise an error containing
+// r.Reason.
+func (r PolicyResult) Err() error {
+	if r.Satisfied {
+		return nil
+	}
+	return errors.New(r.Reason)
+}
+
+// ExplainSpendPolicy evaluates p against sigs, reporting which of its clauses
+// were satisfied. Its verdict is identical to the one used by consensus; it is
+// intended for debugging multisig and timelocked policies.
+//
+// Threshold clauses are evaluated in order, and evaluation stops once the
+// threshold is reached or can no longer be reached; remaining clauses are
+// reported as "not evaluated".
+func (s State) ExplainSpendPolicy(p types.SpendPolicy, sigHash types.Hash256, sigs []types.Signature) PolicyResult {
+	return s.explainSpendPolicy(p, sigHash, sigs, types.PublicKey.VerifyHash)
+}
+
+// satisfiesSpendPolicy reports whether p is satisfied by sigs. Its verdict is
+// identical to that of explainSpendPolicy, but it does not allocate an
+// explanation for every clause.
+func (s State) satisfiesSpendPolicy(p types.SpendPolicy, sigHash types.Hash256, sigs []types.Signature, verify sigVerifier) bool {
+	var satisfied func(types.SpendPolicy) bool
+	satisfied = func(p types.SpendPolicy) bool {
+		switch pt := p.Type.(type) {
+		case types.PolicyTypeAbove:
+			return s.Index.Height > uint64(pt)
+		case types.PolicyTypePublicKey:
+			if len(sigs) == 0 || !verify(types.PublicKey(pt), sigHash, sigs[0]) {
+				return false
+			}
+			sigs = sigs[1:]
+			return true
+		case types.PolicyTypeThreshold:
+			n := pt.N
+			for i, sub := range pt.Of {
+				if n == 0 || len(pt.Of[i:]) < int(n) {
+					break
+				} else if satisfied(sub) {
+					n--
 				}
-				return errors.New("no signatures matching pubkey")
-			case types.PolicyTypeThreshold:
-				for i := 0; i < len(p.Of) && p.N > 0 && len(p.Of[i:]) >= int(p.N); i++ {
-					if verify(p.Of[i]) == nil {
-						p.N--
-					}
+			}
+			return n == 0
+		case types.PolicyTypeUnlockConditions:
+			if !satisfied(types.PolicyAbove(pt.Timelock)) {
+				return false
+			}
+			n := pt.SignaturesRequired
+			for i, pk := range pt.PublicKeys {
+				if n == 0 || len(pt.PublicKeys[i:]) < int(n) {
+					break
+				} else if satisfied(types.PolicyPublicKey(pk)) {
+					n--
 				}
-				if p.N != 0 {
-					return errors.New("threshold not reached")
+			}
+			return n == 0
+		case types.PolicyTypeOpaque:
+			return false
+		default:
+			panic("invalid policy type") // developer error
+		}
+	}
+	return satisfied(p)
+}
+
+func (s State) explainSpendPolicy(p types.SpendPolicy, sigHash types.Hash256, sigs []types.Signature, verify sigVerifier) PolicyResult {
+	var explain func(types.SpendPolicy) PolicyResult
+	explain = func(p types.SpendPolicy) (r PolicyResult) {
+		r.Policy = p
+		switch pt := p.Type.(type) {
+		case types.PolicyTypeAbove:
+			r.Satisfied = s.Index.Height > uint64(pt)
+			if r.Satisfied {
+				r.Reason = fmt.Sprintf("height %v is above %v", s.Index.Height, uint64(pt))
+			} else {
+				r.Reason = fmt.Sprintf("timelock not yet expired (need height %v, at %v)", uint64(pt)+1, s.Index.Height)
+			}
+		case types.PolicyTypePublicKey:
+			// signatures must appear in the same order as their keys appear
+			// in the policy, so only the next signature needs to be checked
+			switch {
+			case len(sigs) == 0:
+				r.Reason = fmt.Sprintf("missing signature for %v", types.PublicKey(pt))
+			case !verify(types.PublicKey(pt), sigHash, sigs[0]):
+				r.Reason = fmt.Sprintf("next signature does not match %v", types.PublicKey(pt))
+			default:
+				sigs = sigs[1:]
+				r.Satisfied = true
+				r.Reason = fmt.Sprintf("signed by %v", types.PublicKey(pt))
+			}
+		case types.PolicyTypeThreshold:
+			noun := "signatures"
+			r.Clauses = make([]PolicyResult, len(pt.Of))
+			n := pt.N
+			for i, sub := range pt.Of {
+				if _, ok := sub.Type.(types.PolicyTypePublicKey); !ok {
+					noun = "clauses"
 				}
-				return nil
-			case types.PolicyTypeUnlockConditions:
-				if err := verify(types.PolicyAbove(p.Timelock)); err != nil {
-					return err
+				if n > 0 && len(pt.Of[i:]) >= int(n) {
+					r.Clauses[i] = explain(sub)
+					if r.Clauses[i].Satisfied {
+						n--
+					}
+				} else {
+					r.Clauses[i] = PolicyResult{Policy: sub, Reason: "not evaluated"}
 				}
-				n := p.SignaturesRequired
-				of := make([]types.SpendPolicy, len(p.PublicKeys))
-				for i, pk := range p.PublicKeys {
+			}
+			r.Satisfied = n == 0
+			r.Reason = fmt.Sprintf("satisfied %v of %v required %v", pt.N-n, pt.N, noun)
+		case types.PolicyTypeUnlockConditions:
+			r.Clauses = []PolicyResult{explain(types.PolicyAbove(pt.Timelock))}
+			if r.Clauses[0].Satisfied {
+				of := make([]types.SpendPolicy, len(pt.PublicKeys))
+				for i, pk := range pt.PublicKeys {
 					of[i] = types.PolicyPublicKey(pk)
 				}
-				return verify(types.PolicyThreshold(n, of))
+				r.Clauses = append(r.Clauses, explain(types.PolicyThreshold(pt.SignaturesRequired, of)))
 			}
+			last := r.Clauses[len(r.Clauses)-1]
+			r.Satisfied, r.Reason = last.Satisfied, last.Reason
+		case types.PolicyTypeOpaque:
+			r.Reason = "opaque policy cannot be satisfied"
+		default:
 			panic("invalid policy type") // developer error
 		}
-		return verify(p)
+		return
+	}
+	return explain(p)
+}
+
+func validateCoveredFields(txn types.Transaction, cf types.CoveredFields) error {
+	check := func(indices []uint64, n int, typ string) error {
+		for j, i := range indices {
+			if i >= uint64(n) {
+				return fmt.Errorf("covers non-existent %v %v", typ, i)
+			} else if j > 0 && i <= indices[j-1] {
+				return fmt.Errorf("has unsorted or duplicate %v indices", typ)
+			}
+		}
+		return nil
+	}
+	if err := check(cf.SiacoinInputs, len(txn.SiacoinInputs), "siacoin input"); err != nil {
+		return err
+	} else if err := check(cf.SiacoinOutputs, len(txn.SiacoinOutputs), "siacoin output"); err != nil {
+		return err
+	} else if err := check(cf.SiafundInputs, len(txn.SiafundInputs), "siafund input"); err != nil {
+		return err
+	} else if err := check(cf.SiafundOutputs, len(txn.SiafundOutputs), "siafund output"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func containsIndex(indices []uint64, i int) bool {
+	for _, j := range indices {
+		if j == uint64(i) {
+			return true
+		}
 	}
+	return false
+}
 
+func (s State) validateSpendPolicies(txn types.Transaction, verify sigVerifier) error {
+	sigHash := s.InputSigHash(txn)
 	for i, in := range txn.SiacoinInputs {
+		inputHash := sigHash
+		if in.CoveredFields != nil {
+			if err := validateCoveredFields(txn, *in.CoveredFields); err != nil {
+				return fmt.Errorf("siacoin input %v %s", i, err)
+			} else if !containsIndex(in.CoveredFields.SiacoinInputs, i) {
+				return fmt.Errorf("siacoin input %v does not cover itself", i)
+			}
+			inputHash = s.PartialSigHash(txn, *in.CoveredFields)
+		}
 		if in.SpendPolicy.Address() != in.Parent.Address {
 			return fmt.Errorf("siacoin input %v claims incorrect policy for parent address", i)
-		} else if err := verifyPolicy(in.SpendPolicy, in.Signatures); err != nil {
-			return fmt.Errorf("siacoin input %v failed to satisfy spend policy: %w", i, err)
+		} else if !s.satisfiesSpendPolicy(in.SpendPolicy, inputHash, in.Signatures, verify) {
+			r := s.explainSpendPolicy(in.SpendPolicy, inputHash, in.Signatures, verify)
+			return fmt.Errorf("siacoin input %v failed to satisfy spend policy: %w", i, r.Err())
 		}
 	}
 	for i, in := range txn.SiafundInputs {
+		inputHash := sigHash
+		if in.CoveredFields != nil {
+			if err := validateCoveredFields(txn, *in.CoveredFields); err != nil {
+				return fmt.Errorf("siafund input %v %s", i, err)
+			} else if !containsIndex(in.CoveredFields.SiafundInputs, i) {
+				return fmt.Errorf("siafund input %v does not cover itself", i)
+			}
+			inputHash = s.PartialSigHash(txn, *in.CoveredFields)
+		}
 		if in.SpendPolicy.Address() != in.Parent.Address {
 			return fmt.Errorf("siafund input %v claims incorrect policy for parent address", i)
-		} else if err := verifyPolicy(in.SpendPolicy, in.Signatures); err != nil {
-			return fmt.Errorf("siafund input %v failed to satisfy spend policy: %w", i, err)
+		} else if !s.satisfiesSpendPolicy(in.SpendPolicy, inputHash, in.Signatures, verify) {
+			r := s.explainSpendPolicy(in.SpendPolicy, inputHash, in.Signatures, verify)
+			return fmt.Errorf("siafund input %v failed to satisfy spend policy: %w", i, r.Err())
 		}
 	}
 	return nil
 }
 
+// A sigVerifier reports whether sig is a valid signature of hash by pk.
+type sigVerifier func(pk types.PublicKey, hash types.Hash256, sig types.Signature) bool
+
+type sigEntry struct {
+	pk   types.PublicKey
+	hash types.Hash256
+	sig  types.Signature
+}
+
+// A sigBatch defers signature verification so that many signatures can be
+// verified at once. Its add method is a sigVerifier that optimistically reports
+// every signature as valid; verify then checks all of the added signatures in
+// parallel, skipping duplicates.
+type sigBatch struct {
+	sigs []sigEntry
+	seen map[sigEntry]struct{}
+}
+
+func (b *sigBatch) add(pk types.PublicKey, hash types.Hash256, sig types.Signature) bool {
+	e := sigEntry{pk, hash, sig}
+	if _, ok := b.seen[e]; !ok {
+		b.seen[e] = struct{}{}
+		b.sigs = append(b.sigs, e)
+	}
+	return true
+}
+
+// truncate discards the signatures added since the batch contained n
+// signatures.
+func (b *sigBatch) truncate(n int) {
+	for _, e := range b.sigs[n:] {
+		delete(b.seen, e)
+	}
+	b.sigs = b.sigs[:n]
+}
+
+func (b *sigBatch) verify() bool {
+	verifyAll := func(sigs []sigEntry) bool {
+		for _, e := range sigs {
+			if !e.pk.VerifyHash(e.hash, e.sig) {
+				return false
+			}
+		}
+		return true
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers == 1 || len(b.sigs) < 2*workers {
+		return verifyAll(b.sigs)
+	}
+	var wg sync.WaitGroup
+	valid := make([]bool, workers)
+	chunk := (len(b.sigs) + workers - 1) / workers
+	for i := range valid {
+		start, end := i*chunk, (i+1)*chunk
+		if end > len(b.sigs) {
+			end = len(b.sigs)
+		}
+		wg.Add(1)
+		go func(i int, sigs []sigEntry) {
+			defer wg.Done()
+			valid[i] = verifyAll(sigs)
+		}(i, b.sigs[start:end])
+	}
+	wg.Wait()
+	for _, ok := range valid {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // ValidateTransaction partially validates txn for inclusion in a child block.
-// It does not validate ephemeral outputs.
+// It does not validate ephemeral outputs; use ValidateTransactionSet for that.
+//
+// Note that a transaction can never spend one of its own outputs: an output's
+// ID is derived from the ID of its transaction, which in turn covers the IDs of
+// every input's parent. Ephemeral inputs must therefore always refer to outputs
+// of earlier transactions in the same set.
 func (s State) ValidateTransaction(txn types.Transaction) error {
+	return s.validateTransaction(txn, types.PublicKey.VerifyHash)
+}
+
+func (s State) validateTransaction(txn types.Transaction, verify sigVerifier) error {
 	// check proofs first; that way, subsequent checks can assume that all
 	// parent StateElements are valid
 	if err := s.validateStateProofs(txn); err != nil {
@@ -488,15 +809,15 @@ func (s State) ValidateTransaction(txn types.Transaction) error {
 		return err
 	} else if err := s.validateFoundationUpdate(txn); err != nil {
 		return err
-	} else if err := s.validateFileContracts(txn); err != nil {
+	} else if err := s.validateFileContracts(txn, verify); err != nil {
 		return err
-	} else if err := s.validateFileContractRevisions(txn); err != nil {
+	} else if err := s.validateFileContractRevisions(txn, verify); err != nil {
 		return err
-	} else if err := s.validateFileContractResolutions(txn); err != nil {
+	} else if err := s.validateFileContractResolutions(txn, verify); err != nil {
 		return err
-	} else if err := s.validateAttestations(txn); err != nil {
+	} else if err := s.validateAttestations(txn, verify); err != nil {
 		return err
-	} else if err := s.validateSpendPolicies(txn); err != nil {
+	} else if err := s.validateSpendPolicies(txn, verify); err != nil {
 		return err
 	}
 	return nil
@@ -585,6 +906,17 @@ func (s State) noDoubleContractUpdates(txns []types.Transaction) error {
 }
 
 // ValidateTransactionSet validates txns within the context of s.
+//
+// The cost of validating a large set is dominated by signature verification.
+// Rather than verifying each signature as it is encountered,
+// ValidateTransactionSet optimistically assumes that every signature is valid,
+// then verifies them all at the end, skipping duplicates and spreading the work
+// across GOMAXPROCS goroutines. The total work remains O(n) in the number of
+// signatures, but the wall-clock time is O(n/GOMAXPROCS). Transactions with
+// threshold policies that do not require every clause are validated
+// sequentially; see batchable. If any signature is invalid, the transactions
+// are validated individually to identify the first invalid one, so the error
+// is the same as that of the sequential path.
 func (s State) ValidateTransactionSet(txns []types.Transaction) error {
 	if s.BlockWeight(txns) > s.MaxBlockWeight() {
 		return ErrOverweight
@@ -595,6 +927,72 @@ func (s State) ValidateTransactionSet(txns []types.Transaction) error {
 	} else if err := s.noDoubleContractUpdates(txns); err != nil {
 		return err
 	}
+	batch := &sigBatch{seen: make(map[sigEntry]struct{})}
+	for i, txn := range txns {
+		n := len(batch.sigs)
+		verify := batch.add
+		if !batchable(txn) {
+			verify = types.PublicKey.VerifyHash
+		}
+		if err := s.validateTransaction(txn, verify); err != nil {
+			// a signature error found by the sequential path takes precedence
+			// over any other error, so discard the transaction's signatures
+			// and check it precisely
+			batch.truncate(n)
+			if err := s.ValidateTransaction(txn); err != nil {
+				if err := s.verifySigBatch(txns[:i], batch); err != nil {
+					return err
+				}
+				return fmt.Errorf("transaction %v is invalid: %w", i, err)
+			}
+		}
+	}
+	return s.verifySigBatch(txns, batch)
+}
+
+// batchable reports whether the signatures of txn can be verified by a
+// sigBatch. A threshold policy that can be satisfied without all of its
+// clauses matches each signature to the first key that it is valid for;
+// optimistically treating every signature as valid would instead match it to
+// the first remaining key, so such policies must be evaluated sequentially.
+func batchable(txn types.Transaction) bool {
+	var exact func(types.SpendPolicy) bool
+	exact = func(p types.SpendPolicy) bool {
+		switch pt := p.Type.(type) {
+		case types.PolicyTypeThreshold:
+			if int(pt.N) < len(pt.Of) {
+				return false
+			}
+			for _, sub := range pt.Of {
+				if !exact(sub) {
+					return false
+				}
+			}
+		case types.PolicyTypeUnlockConditions:
+			return int(pt.SignaturesRequired) >= len(pt.PublicKeys)
+		}
+		return true
+	}
+	for _, in := range txn.SiacoinInputs {
+		if !exact(in.SpendPolicy) {
+			return false
+		}
+	}
+	for _, in := range txn.SiafundInputs {
+		if !exact(in.SpendPolicy) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifySigBatch verifies the signatures that were added to batch while
+// validating txns. If any is invalid, it returns the error of the first invalid
+// transaction.
+func (s State) verifySigBatch(txns []types.Transaction, batch *sigBatch) error {
+	if batch.verify() {
+		return nil
+	}
 	for i, txn := range txns {
 		if err := s.ValidateTransaction(txn); err != nil {
 			return fmt.Errorf("transaction %v is invalid: %w", i, err)
@@ -603,6 +1001,157 @@ func (s State) ValidateTransactionSet(txns []types.Transaction) error {
 	return nil
 }
 
+// ValidateTransactionSetPartial validates each transaction in txns within the
+// context of s, rejecting invalid transactions rather than the whole set. It
+// returns the indices of the rejected transactions, along with the reason each
+// was rejected; the remaining transactions, in order, form a valid transaction
+// set.
+//
+// A transaction is rejected if it is invalid on its own, if it conflicts with
+// an earlier accepted transaction, if it spends an ephemeral output not
+// created by an earlier accepted transaction, or if accepting it would exceed
+// the maximum block weight. In particular, a transaction spending an ephemeral
+// output of a rejected transaction is also rejected.
+func (s State) ValidateTransactionSetPartial(txns []types.Transaction) (rejected []int, errs []error) {
+	var weight uint64
+	available := make(map[types.ElementID]types.SiacoinOutput)
+	spent := make(map[types.ElementID]int)
+	updated := make(map[types.ElementID]int)
+
+	check := func(txn types.Transaction) error {
+		if w := s.TransactionWeight(txn); weight+w > s.MaxBlockWeight() {
+			return ErrOverweight
+		}
+		// check for conflicts with the accepted transactions, and within txn
+		// itself
+		txnSpent := make(map[types.ElementID]bool)
+		for _, in := range txn.SiacoinInputs {
+			if prev, ok := spent[in.Parent.ID]; ok {
+				return fmt.Errorf("transaction double-spends siacoin output %v (previously spent in transaction %v)", in.Parent.ID, prev)
+			} else if txnSpent[in.Parent.ID] {
+				return fmt.Errorf("transaction double-spends siacoin output %v", in.Parent.ID)
+			}
+			txnSpent[in.Parent.ID] = true
+			if in.Parent.LeafIndex == types.EphemeralLeafIndex {
+				if out, ok := available[in.Parent.ID]; !ok {
+					return fmt.Errorf("transaction claims non-existent ephemeral output %v", in.Parent.ID)
+				} else if in.Parent.Value != out.Value {
+					return fmt.Errorf("transaction claims wrong value for ephemeral output %v", in.Parent.ID)
+				} else if in.Parent.Address != out.Address {
+					return fmt.Errorf("transaction claims wrong address for ephemeral output %v", in.Parent.ID)
+				}
+			}
+		}
+		for _, in := range txn.SiafundInputs {
+			if prev, ok := spent[in.Parent.ID]; ok {
+				return fmt.Errorf("transaction double-spends siafund output %v (previously spent in transaction %v)", in.Parent.ID, prev)
+			} else if txnSpent[in.Parent.ID] {
+				return fmt.Errorf("transaction double-spends siafund output %v", in.Parent.ID)
+			}
+			txnSpent[in.Parent.ID] = true
+		}
+		txnUpdated := make(map[types.ElementID]bool)
+		for _, id := range contractUpdates(txn) {
+			if prev, ok := updated[id]; ok {
+				return fmt.Errorf("transaction updates contract %v multiple times (previously updated in transaction %v)", id, prev)
+			} else if txnUpdated[id] {
+				return fmt.Errorf("transaction updates contract %v multiple times", id)
+			}
+			txnUpdated[id] = true
+		}
+		return s.ValidateTransaction(txn)
+	}
+
+	for i, txn := range txns {
+		if err := check(txn); err != nil {
+			rejected = append(rejected, i)
+			errs = append(errs, fmt.Errorf("transaction %v is invalid: %w", i, err))
+			continue
+		}
+		// accept the transaction
+		weight += s.TransactionWeight(txn)
+		for _, in := range txn.SiacoinInputs {
+			spent[in.Parent.ID] = i
+			delete(available, in.Parent.ID)
+		}
+		for _, in := range txn.SiafundInputs {
+			spent[in.Parent.ID] = i
+		}
+		for _, id := range contractUpdates(txn) {
+			updated[id] = i
+		}
+		for j, out := range txn.SiacoinOutputs {
+			available[txn.SiacoinOutputID(j)] = out
+		}
+	}
+	return
+}
+
+// contractUpdates returns the IDs of the contracts revised or resolved by txn.
+func contractUpdates(txn types.Transaction) []types.ElementID {
+	ids := make([]types.ElementID, 0, len(txn.FileContractRevisions)+len(txn.FileContractResolutions))
+	for _, fcr := range txn.FileContractRevisions {
+		ids = append(ids, fcr.Parent.ID)
+	}
+	for _, fcr := range txn.FileContractResolutions {
+		ids = append(ids, fcr.Parent.ID)
+	}
+	return ids
+}
+
+// ValidateBlockPayouts validates an externally supplied set of elements
+// claiming to be the block-linked outputs created by b, such as the
+// NewSiacoinElements of an ApplyUpdate received from a peer or an indexer.
+// ValidateBlock does not call it: blocks do not carry their payouts, and
+// ApplyBlock derives them from s, so only element sets obtained elsewhere need
+// checking.
+//
+// The miner payout must pay exactly MinerPayout to the block's miner address,
+// and the Foundation subsidy must be present, with the value of
+// FoundationSubsidy, if and only if b is a subsidy block, and must be sent to
+// the Foundation address as of s; a NewFoundationAddress set by a transaction
+// in b only takes effect for subsequent subsidies. Outputs in sces that are not
+// linked to b are ignored.
+func (s State) ValidateBlockPayouts(b types.Block, sces []types.SiacoinElement) error {
+	var minerPayout, subsidy *types.SiacoinElement
+	for i := range sces {
+		switch sces[i].ID {
+		case b.MinerOutputID():
+			minerPayout = &sces[i]
+		case b.FoundationOutputID():
+			subsidy = &sces[i]
+		}
+	}
+
+	if minerPayout == nil {
+		return fmt.Errorf("%w: missing miner payout output", ErrInvalidMinerPayout)
+	} else if exp := s.MinerPayout(b.Transactions); minerPayout.Value != exp {
+		return fmt.Errorf("%w: payout has value %v, expected %v", ErrInvalidMinerPayout, minerPayout.Value, exp)
+	} else if minerPayout.Address != b.Header.MinerAddress {
+		return fmt.Errorf("%w: payout is not sent to the miner address", ErrInvalidMinerPayout)
+	} else if minerPayout.MaturityHeight != s.MaturityHeight() {
+		return fmt.Errorf("%w: payout has maturity height %v, expected %v", ErrInvalidMinerPayout, minerPayout.MaturityHeight, s.MaturityHeight())
+	}
+
+	exp := s.FoundationSubsidy()
+	if exp.IsZero() {
+		if subsidy != nil {
+			return fmt.Errorf("%w: subsidy output created on non-subsidy block", ErrInvalidFoundationSubsidy)
+		}
+		return nil
+	}
+	if subsidy == nil {
+		return fmt.Errorf("%w: missing subsidy output", ErrInvalidFoundationSubsidy)
+	} else if subsidy.Value != exp {
+		return fmt.Errorf("%w: subsidy has value %v, expected %v", ErrInvalidFoundationSubsidy, subsidy.Value, exp)
+	} else if subsidy.Address != s.FoundationAddress {
+		return fmt.Errorf("%w: subsidy is not sent to the Foundation address", ErrInvalidFoundationSubsidy)
+	} else if subsidy.MaturityHeight != s.MaturityHeight() {
+		return fmt.Errorf("%w: subsidy has maturity height %v, expected %v", ErrInvalidFoundationSubsidy, subsidy.MaturityHeight, s.MaturityHeight())
+	}
+	return nil
+}
+
 // ValidateBlock validates b in the context of s.
 //
 // This function does not check whether the header's timestamp is too far in the
@@ -610,17 +1159,26 @@ func (s State) ValidateTransactionSet(txns []types.Transaction) error {
 // e.g. in p2p networking code; see MaxFutureTimestamp.
 func (s State) ValidateBlock(b types.Block) error {
 	h := b.Header
-	if err := s.validateHeader(h); err != nil {
+	if err := s.ValidateHeader(h); err != nil {
 		return err
 	} else if s.Commitment(h.MinerAddress, b.Transactions) != h.Commitment {
 		return errors.New("commitment hash does not match header")
 	} else if err := s.ValidateTransactionSet(b.Transactions); err != nil {
 		return err
 	}
+	payout := s.BlockReward()
+	for _, txn := range b.Transactions {
+		var overflow bool
+		if payout, overflow = payout.AddWithOverflow(txn.MinerFee); overflow {
+			return fmt.Errorf("%w: miner payout overflows", ErrInvalidMinerPayout)
+		}
+	}
 	return nil
 }
 
-// MaxFutureTimestamp returns the maximum allowed timestamp for a block.
+// MaxFutureTimestamp returns the maximum allowed timestamp for a block received
+// at currentTime. Together with MedianTimestamp, it bounds the acceptable
+// timestamps of a child block.
 func (s State) MaxFutureTimestamp(currentTime time.Time) time.Time {
 	return currentTime.Add(2 * time.Hour)
 }
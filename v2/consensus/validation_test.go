@@ -1,7 +1,11 @@
 package consensus
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"math"
 	"reflect"
 	"strings"
@@ -67,12 +71,245 @@ func TestBlockRewardValue(t *testing.T) {
 	}
 }
 
+func TestFoundationSubsidyValue(t *testing.T) {
+	hardfork := MainnetParams().FoundationHardforkHeight
+	tests := []struct {
+		height uint64
+		exp    types.Currency
+	}{
+		{0, types.ZeroCurrency},
+		{hardfork - 1, types.ZeroCurrency},
+		{hardfork, types.Siacoins(30000).Mul64(blocksPerYear)},
+		{hardfork + 1, types.ZeroCurrency},
+		{hardfork + foundationSubsidyFrequency - 1, types.ZeroCurrency},
+		{hardfork + foundationSubsidyFrequency, types.Siacoins(30000).Mul64(foundationSubsidyFrequency)},
+		{hardfork + 7*foundationSubsidyFrequency, types.Siacoins(30000).Mul64(foundationSubsidyFrequency)},
+		{hardfork + 7*foundationSubsidyFrequency + 1, types.ZeroCurrency},
+	}
+	for _, test := range tests {
+		if got := MainnetParams().FoundationSubsidy(test.height); got != test.exp {
+			t.Errorf("height %v: expected %v, got %v", test.height, test.exp, got)
+		}
+		s := State{Index: types.ChainIndex{Height: test.height - 1}}
+		if got := s.FoundationSubsidy(); test.height > 0 && got != test.exp {
+			t.Errorf("height %v: expected child subsidy %v, got %v", test.height, test.exp, got)
+		}
+	}
+}
+
+func TestIsDust(t *testing.T) {
+	var s State
+	threshold := MainnetParams().DustThreshold
+	tests := []struct {
+		value types.Currency
+		dust  bool
+	}{
+		{types.ZeroCurrency, true},
+		{threshold.Sub(types.NewCurrency64(1)), true},
+		{threshold, false},
+		{threshold.Add(types.NewCurrency64(1)), false},
+		{types.Siacoins(1), false},
+	}
+	for _, test := range tests {
+		if s.IsDust(types.SiacoinOutput{Value: test.value}) != test.dust {
+			t.Errorf("expected IsDust(%v) to be %v", test.value, test.dust)
+		}
+	}
+
+	// a zero threshold disables the check
+	params := *MainnetParams()
+	params.DustThreshold = types.ZeroCurrency
+	s.Params = &params
+	if s.IsDust(types.SiacoinOutput{Value: types.NewCurrency64(1)}) {
+		t.Error("nothing should be dust with a zero threshold")
+	}
+}
+
+func TestMinerPayoutMaturity(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	ourAddr := types.StandardAddress(pubkey)
+	b := genesisWithSiacoinOutputs()
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	// mine a block that pays out to our address
+	b = types.Block{
+		Header: types.BlockHeader{
+			Height:       b.Header.Height + 1,
+			ParentID:     b.ID(),
+			Timestamp:    b.Header.Timestamp.Add(time.Second),
+			MinerAddress: ourAddr,
+		},
+	}
+	b.Header.Commitment = s.Commitment(b.Header.MinerAddress, b.Transactions)
+	findBlockNonce(s, &b.Header, types.HashRequiringWork(s.Difficulty))
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	sau = ApplyBlock(s, b)
+	s = sau.State
+	payout := sau.NewSiacoinElements[0]
+	if payout.Address != ourAddr {
+		t.Fatal("first new element should be the miner payout")
+	} else if payout.MaturityHeight == 0 {
+		t.Fatal("miner payout should have a maturity height")
+	}
+
+	spend := func() types.Transaction {
+		txn := types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				Parent:      payout,
+				SpendPolicy: types.PolicyPublicKey(pubkey),
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{
+				Address: types.VoidAddress,
+				Value:   payout.Value,
+			}},
+		}
+		signAllInputs(&txn, s, privkey)
+		return txn
+	}
+
+	// spending the payout immediately should fail
+	if err := s.ValidateTransaction(spend()); err == nil || !strings.Contains(err.Error(), "does not mature") {
+		t.Fatal("expected maturity error when spending immature payout, got", err)
+	}
+
+	// mine until the payout is spendable in the next block
+	for s.Index.Height+1 < payout.MaturityHeight {
+		if err := s.ValidateTransaction(spend()); err == nil {
+			t.Fatalf("accepted immature payout at height %v (matures at %v)", s.Index.Height+1, payout.MaturityHeight)
+		}
+		b = mineBlock(s, b)
+		sau = ApplyBlock(s, b)
+		s = sau.State
+		sau.UpdateElementProof(&payout.StateElement)
+	}
+	if err := s.ValidateTransaction(spend()); err != nil {
+		t.Fatal("rejected matured payout:", err)
+	}
+}
+
+func TestValidateBlockPayouts(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(100),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	// mine a block containing a transaction with a miner fee; the miner payout
+	// should include the fee
+	fee := types.Siacoins(10)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.StandardAddress(pubkey),
+			Value:   types.Siacoins(90),
+		}},
+		MinerFee: fee,
+	}
+	signAllInputs(&txn, s, privkey)
+	b = mineBlock(s, b, txn)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	sces := ApplyBlock(s, b).NewSiacoinElements
+	if exp := s.BlockReward().Add(fee); s.MinerPayout(b.Transactions) != exp {
+		t.Fatalf("expected miner payout of %v, got %v", exp, s.MinerPayout(b.Transactions))
+	} else if sces[0].Value != exp {
+		t.Fatalf("expected payout element to have value %v, got %v", exp, sces[0].Value)
+	} else if err := s.ValidateBlockPayouts(b, sces); err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper with the payouts, as a dishonest source of elements might
+	tamper := func(fn func([]types.SiacoinElement) []types.SiacoinElement) []types.SiacoinElement {
+		return fn(append([]types.SiacoinElement(nil), sces...))
+	}
+	minerTests := []struct {
+		desc string
+		sces []types.SiacoinElement
+	}{
+		{"over-claimed reward", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[0].Value = sces[0].Value.Add(types.NewCurrency64(1))
+			return sces
+		})},
+		{"omitted fees", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[0].Value = s.BlockReward()
+			return sces
+		})},
+		{"wrong address", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[0].Address = types.StandardAddress(pubkey)
+			return sces
+		})},
+		{"immediate maturity", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[0].MaturityHeight = 0
+			return sces
+		})},
+		{"missing payout", sces[1:]},
+		{"payout for another block", ApplyBlock(s, mineBlock(s, b)).NewSiacoinElements},
+	}
+	for _, test := range minerTests {
+		if err := s.ValidateBlockPayouts(b, test.sces); !errors.Is(err, ErrInvalidMinerPayout) {
+			t.Errorf("%v: expected ErrInvalidMinerPayout, got %v", test.desc, err)
+		}
+	}
+
+	// a subsidy output is not allowed on a non-subsidy block
+	subsidy := types.SiacoinElement{
+		StateElement:   types.StateElement{ID: b.FoundationOutputID()},
+		SiacoinOutput:  types.SiacoinOutput{Value: types.Siacoins(1), Address: s.FoundationAddress},
+		MaturityHeight: s.MaturityHeight(),
+	}
+	if err := s.ValidateBlockPayouts(b, append(sces, subsidy)); !errors.Is(err, ErrInvalidFoundationSubsidy) {
+		t.Fatal("expected ErrInvalidFoundationSubsidy, got", err)
+	}
+
+	// on a subsidy block, the subsidy must be present and correctly sized
+	s.Index.Height = MainnetParams().FoundationHardforkHeight - 1
+	b = mineBlock(s, b)
+	sces = ApplyBlock(s, b).NewSiacoinElements
+	if err := s.ValidateBlockPayouts(b, sces); err != nil {
+		t.Fatal(err)
+	} else if sces[1].ID != b.FoundationOutputID() || sces[1].Value != s.FoundationSubsidy() {
+		t.Fatal("expected Foundation subsidy output")
+	}
+	subsidyTests := []struct {
+		desc string
+		sces []types.SiacoinElement
+	}{
+		{"over-sized subsidy", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[1].Value = sces[1].Value.Add(types.NewCurrency64(1))
+			return sces
+		})},
+		{"under-sized subsidy", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[1].Value = sces[1].Value.Sub(types.NewCurrency64(1))
+			return sces
+		})},
+		{"wrong address", tamper(func(sces []types.SiacoinElement) []types.SiacoinElement {
+			sces[1].Address = types.Address{1, 2, 3}
+			return sces
+		})},
+		{"missing subsidy", sces[:1]},
+	}
+	for _, test := range subsidyTests {
+		if err := s.ValidateBlockPayouts(b, test.sces); !errors.Is(err, ErrInvalidFoundationSubsidy) {
+			t.Errorf("%v: expected ErrInvalidFoundationSubsidy, got %v", test.desc, err)
+		}
+	}
+}
+
 func TestEphemeralOutputs(t *testing.T) {
 	pubkey, privkey := testingKeypair(0)
 	sau := GenesisUpdate(genesisWithSiacoinOutputs(types.SiacoinOutput{
 		Address: types.StandardAddress(pubkey),
 		Value:   types.Siacoins(1),
-	}), testingDifficulty)
+	}), testingDifficulty, MainnetParams())
 
 	// create an ephemeral output
 	parentTxn := types.Transaction{
@@ -144,6 +381,33 @@ func TestEphemeralOutputs(t *testing.T) {
 	if err := sau.State.ValidateTransactionSet([]types.Transaction{parentTxn, invalidTxn}); err == nil {
 		t.Fatal("transaction claims wrong address for ephemeral output")
 	}
+
+	// a transaction cannot spend its own outputs, since the output IDs depend
+	// on the IDs of the transaction's inputs
+	selfTxn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{
+			{
+				Parent:      sau.NewSiacoinElements[1],
+				SpendPolicy: types.PolicyPublicKey(pubkey),
+			},
+			{
+				Parent:      ephemeralOutput,
+				SpendPolicy: types.PolicyPublicKey(pubkey),
+			},
+		},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.StandardAddress(pubkey), Value: types.Siacoins(1)},
+			{Address: types.StandardAddress(pubkey), Value: types.Siacoins(1)},
+		},
+	}
+	selfTxn.SiacoinInputs[1].Parent.ID = selfTxn.SiacoinOutputID(0)
+	if selfTxn.SiacoinOutputID(0) == selfTxn.SiacoinInputs[1].Parent.ID {
+		t.Fatal("output ID should change when input IDs change")
+	}
+	signAllInputs(&selfTxn, sau.State, privkey)
+	if err := sau.State.ValidateTransactionSet([]types.Transaction{selfTxn}); err == nil || !strings.Contains(err.Error(), "non-existent ephemeral output") {
+		t.Fatal("transaction spending its own output should be rejected:", err)
+	}
 }
 
 func TestValidateTransaction(t *testing.T) {
@@ -237,7 +501,7 @@ func TestValidateTransaction(t *testing.T) {
 			},
 		}},
 	}
-	sau := GenesisUpdate(genesisBlock, testingDifficulty)
+	sau := GenesisUpdate(genesisBlock, testingDifficulty, MainnetParams())
 	spentSC := sau.NewSiacoinElements[1]
 	unspentSC := sau.NewSiacoinElements[2]
 	overflowSC := sau.NewSiacoinElements[3]
@@ -369,6 +633,8 @@ func TestValidateTransaction(t *testing.T) {
 				RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(77)},
 				HostOutput:      types.SiacoinOutput{Value: types.Siacoins(0)},
 				TotalCollateral: types.ZeroCurrency,
+				RenterPublicKey: renterPubkey,
+				HostPublicKey:   hostPubkey,
 				RevisionNumber:  1,
 			},
 		}},
@@ -398,6 +664,44 @@ func TestValidateTransaction(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// the transaction, including its signatures and proofs, should survive an
+	// encoding roundtrip losslessly
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	txn.EncodeTo(e)
+	e.Flush()
+	var decTxn types.Transaction
+	d := types.NewBufDecoder(buf.Bytes())
+	decTxn.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var rebuf bytes.Buffer
+	e = types.NewEncoder(&rebuf)
+	decTxn.EncodeTo(e)
+	e.Flush()
+	if !bytes.Equal(buf.Bytes(), rebuf.Bytes()) {
+		t.Fatal("transaction encoding did not survive roundtrip")
+	} else if decTxn.ID() != txn.ID() {
+		t.Fatalf("transaction ID changed after roundtrip: expected %v, got %v", txn.ID(), decTxn.ID())
+	} else if err := s.ValidateTransaction(decTxn); err != nil {
+		t.Fatal("decoded transaction is no longer valid:", err)
+	}
+
+	// likewise for JSON
+	js, err := json.Marshal(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsTxn types.Transaction
+	if err := json.Unmarshal(js, &jsTxn); err != nil {
+		t.Fatal(err)
+	} else if !jsTxn.StrictEqual(&txn) {
+		t.Fatalf("transaction did not survive JSON roundtrip: %s", js)
+	} else if err := s.ValidateTransaction(jsTxn); err != nil {
+		t.Fatal("JSON-decoded transaction is no longer valid:", err)
+	}
+
 	// corrupt the transaction in various ways to trigger validation errors
 	tests := []struct {
 		desc    string
@@ -421,6 +725,12 @@ func TestValidateTransaction(t *testing.T) {
 				txn.SiacoinInputs[0].SpendPolicy = types.AnyoneCanSpend()
 			},
 		},
+		{
+			"siacoin input with opaque spend policy",
+			func(txn *types.Transaction) {
+				txn.SiacoinInputs[0].SpendPolicy = types.PolicyOpaque(txn.SiacoinInputs[0].Parent.Address)
+			},
+		},
 		{
 			"siafund input address does not match spend policy",
 			func(txn *types.Transaction) {
@@ -569,6 +879,28 @@ func TestValidateTransaction(t *testing.T) {
 				txn.FileContracts[0].HostSignature[0] ^= 1
 			},
 		},
+		{
+			"file contract with zero renter public key",
+			func(txn *types.Transaction) {
+				txn.FileContracts[0].RenterPublicKey = types.PublicKey{}
+			},
+		},
+		{
+			"file contract with zero host public key",
+			func(txn *types.Transaction) {
+				txn.FileContracts[0].HostPublicKey = types.PublicKey{}
+			},
+		},
+		{
+			"file contract with missed host value exceeding valid host value",
+			func(txn *types.Transaction) {
+				fc := &txn.FileContracts[0]
+				fc.MissedHostValue = fc.HostOutput.Value.Add(types.Siacoins(1))
+				contractHash := s.ContractSigHash(*fc)
+				fc.RenterSignature = renterPrivkey.SignHash(contractHash)
+				fc.HostSignature = hostPrivkey.SignHash(contractHash)
+			},
+		},
 		{
 			"file contract whose window ends before it begins",
 			func(txn *types.Transaction) {
@@ -607,6 +939,48 @@ func TestValidateTransaction(t *testing.T) {
 				rev.RenterOutput.Value = rev.RenterOutput.Value.Mul64(2)
 			},
 		},
+		{
+			"file contract revision with missed host value exceeding valid host value",
+			func(txn *types.Transaction) {
+				rev := &txn.FileContractRevisions[0].Revision
+				rev.MissedHostValue = rev.HostOutput.Value.Add(types.Siacoins(1))
+				contractHash := s.ContractSigHash(*rev)
+				rev.RenterSignature = renterPrivkey.SignHash(contractHash)
+				rev.HostSignature = hostPrivkey.SignHash(contractHash)
+			},
+		},
+		{
+			"file contract revision that increases missed host value without increasing valid host value",
+			func(txn *types.Transaction) {
+				rev := &txn.FileContractRevisions[0].Revision
+				rev.RenterOutput.Value = rev.RenterOutput.Value.Sub(types.Siacoins(1))
+				rev.HostOutput.Value = rev.HostOutput.Value.Add(types.Siacoins(1))
+				rev.MissedHostValue = types.Siacoins(1)
+				contractHash := s.ContractSigHash(*rev)
+				rev.RenterSignature = renterPrivkey.SignHash(contractHash)
+				rev.HostSignature = hostPrivkey.SignHash(contractHash)
+			},
+		},
+		{
+			"file contract revision that modifies renter public key",
+			func(txn *types.Transaction) {
+				rev := &txn.FileContractRevisions[0].Revision
+				rev.RenterPublicKey = hostPubkey
+				contractHash := s.ContractSigHash(*rev)
+				rev.RenterSignature = renterPrivkey.SignHash(contractHash)
+				rev.HostSignature = hostPrivkey.SignHash(contractHash)
+			},
+		},
+		{
+			"file contract revision that modifies host public key",
+			func(txn *types.Transaction) {
+				rev := &txn.FileContractRevisions[0].Revision
+				rev.HostPublicKey = renterPubkey
+				contractHash := s.ContractSigHash(*rev)
+				rev.RenterSignature = renterPrivkey.SignHash(contractHash)
+				rev.HostSignature = hostPrivkey.SignHash(contractHash)
+			},
+		},
 		{
 			"file contract revision whose window ends before it begins",
 			func(txn *types.Transaction) {
@@ -689,6 +1063,72 @@ func TestValidateTransaction(t *testing.T) {
 	}
 }
 
+func TestRevisionImmutableFields(t *testing.T) {
+	renterPubkey, renterPrivkey := testingKeypair(0)
+	hostPubkey, hostPrivkey := testingKeypair(1)
+	b := genesisWithSiacoinOutputs()
+	b.Transactions[0].FileContracts = []types.FileContract{{
+		WindowStart: 5,
+		WindowEnd:   10,
+		RenterOutput: types.SiacoinOutput{
+			Address: types.StandardAddress(renterPubkey),
+			Value:   types.Siacoins(58),
+		},
+		HostOutput: types.SiacoinOutput{
+			Address: types.StandardAddress(hostPubkey),
+			Value:   types.Siacoins(19),
+		},
+		TotalCollateral: types.Siacoins(10),
+		RenterPublicKey: renterPubkey,
+		HostPublicKey:   hostPubkey,
+	}}
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+	fce := sau.NewFileContracts[0]
+
+	tests := []struct {
+		desc   string
+		modify func(rev *types.FileContract)
+		errMsg string
+	}{
+		{
+			"renter public key",
+			func(rev *types.FileContract) { rev.RenterPublicKey = hostPubkey },
+			"modifies renter public key",
+		},
+		{
+			"host public key",
+			func(rev *types.FileContract) { rev.HostPublicKey = renterPubkey },
+			"modifies host public key",
+		},
+		{
+			"total payout",
+			func(rev *types.FileContract) { rev.HostOutput.Value = rev.HostOutput.Value.Add(types.Siacoins(1)) },
+			"modifies output sum",
+		},
+		{
+			"total collateral",
+			func(rev *types.FileContract) { rev.TotalCollateral = rev.TotalCollateral.Add(types.Siacoins(1)) },
+			"modifies total collateral",
+		},
+	}
+	for _, test := range tests {
+		rev := fce.FileContract
+		rev.RevisionNumber++
+		test.modify(&rev)
+		// sign with the original keys, so that only the field check can fail
+		sigHash := s.ContractSigHash(rev)
+		rev.RenterSignature = renterPrivkey.SignHash(sigHash)
+		rev.HostSignature = hostPrivkey.SignHash(sigHash)
+		txn := types.Transaction{
+			FileContractRevisions: []types.FileContractRevision{{Parent: fce, Revision: rev}},
+		}
+		if err := s.ValidateTransaction(txn); err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%v: expected %q error, got %v", test.desc, test.errMsg, err)
+		}
+	}
+}
+
 func TestValidateSpendPolicy(t *testing.T) {
 	// create a State with a height above 0
 	s := State{
@@ -704,12 +1144,51 @@ func TestValidateSpendPolicy(t *testing.T) {
 		return pubkey
 	}
 
+	fiveOfEight := func() types.SpendPolicy {
+		of := make([]types.SpendPolicy, 8)
+		for i := range of {
+			of[i] = types.PolicyPublicKey(pubkey(uint64(i)))
+		}
+		return types.PolicyThreshold(5, of)
+	}
+	signWith := func(sigHash types.Hash256, seeds ...uint64) []types.Signature {
+		sigs := make([]types.Signature, len(seeds))
+		for i, seed := range seeds {
+			sigs[i] = privkey(seed).SignHash(sigHash)
+		}
+		return sigs
+	}
+
 	tests := []struct {
 		desc    string
 		policy  types.SpendPolicy
 		sign    func(sigHash types.Hash256) []types.Signature
 		wantErr bool
 	}{
+		{
+			desc:   "5-of-8 threshold signed by a subset of keys",
+			policy: fiveOfEight(),
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return signWith(sigHash, 1, 2, 4, 6, 7)
+			},
+			wantErr: false,
+		},
+		{
+			desc:   "5-of-8 threshold with signatures out of order",
+			policy: fiveOfEight(),
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return signWith(sigHash, 1, 4, 2, 6, 7)
+			},
+			wantErr: true,
+		},
+		{
+			desc:   "5-of-8 threshold signed by too few keys",
+			policy: fiveOfEight(),
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return signWith(sigHash, 0, 3, 5, 7)
+			},
+			wantErr: true,
+		},
 		{
 			desc: "not enough signatures",
 			policy: types.PolicyThreshold(
@@ -922,64 +1401,337 @@ func TestValidateSpendPolicy(t *testing.T) {
 		}
 		sigHash := s.InputSigHash(txn)
 		txn.SiacoinInputs[0].Signatures = tt.sign(sigHash)
-		if err := s.validateSpendPolicies(txn); (err != nil) != tt.wantErr {
+		if err := s.validateSpendPolicies(txn, types.PublicKey.VerifyHash); (err != nil) != tt.wantErr {
 			t.Fatalf("case %q failed: %v", tt.desc, err)
 		}
 	}
 }
 
-func TestValidateTransactionSet(t *testing.T) {
-	pubkey, privkey := testingKeypair(0)
-	genesisBlock := genesisWithSiacoinOutputs(types.SiacoinOutput{
-		Address: types.StandardAddress(pubkey),
-		Value:   types.Siacoins(1),
+func TestFoundationAddressUpdate(t *testing.T) {
+	pubkey := func(seed uint64) types.PublicKey { pk, _ := testingKeypair(seed); return pk }
+	privkey := func(seed uint64) types.PrivateKey { _, sk := testingKeypair(seed); return sk }
+
+	// the Foundation is controlled by a 2-of-3 primary policy, with a 1-of-1
+	// failsafe that activates above height 1000
+	foundationPolicy := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyThreshold(2, []types.SpendPolicy{
+			types.PolicyPublicKey(pubkey(0)),
+			types.PolicyPublicKey(pubkey(1)),
+			types.PolicyPublicKey(pubkey(2)),
+		}),
+		types.PolicyThreshold(2, []types.SpendPolicy{
+			types.PolicyPublicKey(pubkey(3)),
+			types.PolicyAbove(1000),
+		}),
 	})
-	// also add some SF
-	genesisBlock.Transactions[0].SiafundOutputs = []types.SiafundOutput{{
-		Address: types.StandardAddress(pubkey),
-		Value:   100,
-	}}
-	sau := GenesisUpdate(genesisBlock, testingDifficulty)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: foundationPolicy.Address(),
+		Value:   types.Siacoins(100),
+	}, types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey(4)),
+		Value:   types.Siacoins(100),
+	})
+	b.Transactions[0].NewFoundationAddress = foundationPolicy.Address()
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
 	s := sau.State
-
-	txn := types.Transaction{
-		SiacoinInputs: []types.SiacoinInput{{
-			Parent:      sau.NewSiacoinElements[1],
-			SpendPolicy: types.PolicyPublicKey(pubkey),
-		}},
-		SiacoinOutputs: []types.SiacoinOutput{{
-			Address: types.StandardAddress(pubkey),
-			Value:   sau.NewSiacoinElements[1].Value,
-		}},
-		SiafundInputs: []types.SiafundInput{{
-			Parent:      sau.NewSiafundElements[0],
-			SpendPolicy: types.PolicyPublicKey(pubkey),
-		}},
-		SiafundOutputs: []types.SiafundOutput{{
-			Address: types.StandardAddress(pubkey),
-			Value:   sau.NewSiafundElements[0].Value,
-		}},
+	if s.FoundationAddress != foundationPolicy.Address() {
+		t.Fatal("Foundation address not set")
 	}
-	signAllInputs(&txn, s, privkey)
+	foundationOutput, otherOutput := sau.NewSiacoinElements[1], sau.NewSiacoinElements[2]
 
-	if err := sau.State.ValidateTransactionSet([]types.Transaction{txn, txn}); err == nil {
-		t.Fatal("accepted transaction set with repeated txn")
+	newAddress := types.StandardAddress(pubkey(5))
+	updateTxn := func(s State, signers ...uint64) types.Transaction {
+		txn := types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				Parent:      foundationOutput,
+				SpendPolicy: foundationPolicy,
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{
+				Address: newAddress,
+				Value:   foundationOutput.Value,
+			}},
+			NewFoundationAddress: newAddress,
+		}
+		sigHash := s.InputSigHash(txn)
+		for _, i := range signers {
+			txn.SiacoinInputs[0].Signatures = append(txn.SiacoinInputs[0].Signatures, privkey(i).SignHash(sigHash))
+		}
+		return txn
 	}
 
-	doubleSpendSCTxn := types.Transaction{
+	// an update signed by the primary policy should succeed
+	if err := s.ValidateTransaction(updateTxn(s, 0, 2)); err != nil {
+		t.Fatal("rejected update authorized by primary policy:", err)
+	}
+	// an update signed by the failsafe key should fail until the failsafe
+	// activates
+	if err := s.ValidateTransaction(updateTxn(s, 3)); err == nil {
+		t.Fatal("accepted update authorized by inactive failsafe policy")
+	}
+	failsafe := s
+	failsafe.Index.Height = 1001
+	if err := failsafe.ValidateTransaction(updateTxn(failsafe, 3)); err != nil {
+		t.Fatal("rejected update authorized by active failsafe policy:", err)
+	}
+	// an update without enough primary signatures should fail
+	if err := s.ValidateTransaction(updateTxn(s, 1)); err == nil {
+		t.Fatal("accepted update without enough primary signatures")
+	}
+	// an update that does not spend a Foundation input should fail
+	txn := types.Transaction{
 		SiacoinInputs: []types.SiacoinInput{{
-			Parent:      sau.NewSiacoinElements[1],
-			SpendPolicy: types.PolicyPublicKey(pubkey),
+			Parent:      otherOutput,
+			SpendPolicy: types.PolicyPublicKey(pubkey(4)),
 		}},
 		SiacoinOutputs: []types.SiacoinOutput{{
-			Address: types.StandardAddress(pubkey),
-			Value:   sau.NewSiacoinElements[1].Value,
+			Address: newAddress,
+			Value:   otherOutput.Value,
 		}},
+		NewFoundationAddress: newAddress,
+	}
+	signAllInputs(&txn, s, privkey(4))
+	if err := s.ValidateTransaction(txn); !errors.Is(err, ErrUnauthorizedFoundationUpdate) {
+		t.Fatal("expected ErrUnauthorizedFoundationUpdate, got", err)
 	}
-	signAllInputs(&doubleSpendSCTxn, s, privkey)
 
-	if err := sau.State.ValidateTransactionSet([]types.Transaction{txn, doubleSpendSCTxn}); err == nil {
-		t.Fatal("accepted transaction set with double spent siacoin output")
+	// a partial signature does not cover NewFoundationAddress, so it cannot
+	// authorize an update; otherwise, a relayer could redirect the subsidy
+	txn = updateTxn(s)
+	txn.SiacoinInputs[0].CoveredFields = &types.CoveredFields{SiacoinInputs: []uint64{0}, SiacoinOutputs: []uint64{0}}
+	partialHash := s.PartialSigHash(txn, *txn.SiacoinInputs[0].CoveredFields)
+	txn.SiacoinInputs[0].Signatures = []types.Signature{privkey(0).SignHash(partialHash), privkey(2).SignHash(partialHash)}
+	txn.NewFoundationAddress = types.StandardAddress(pubkey(6))
+	if err := s.ValidateTransaction(txn); !errors.Is(err, ErrUnauthorizedFoundationUpdate) {
+		t.Fatal("expected ErrUnauthorizedFoundationUpdate for partially-signed update, got", err)
+	}
+
+	// applying the authorized update should change the Foundation address
+	txn = updateTxn(s, 0, 1)
+	b = mineBlock(s, b, txn)
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if s = ApplyBlock(s, b).State; s.FoundationAddress != newAddress {
+		t.Fatal("Foundation address not updated")
+	}
+}
+
+func TestPartialSignatures(t *testing.T) {
+	alicePub, alicePriv := testingKeypair(0)
+	bobPub, bobPriv := testingKeypair(1)
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(alicePub),
+		Value:   types.Siacoins(10),
+	}, types.SiacoinOutput{
+		Address: types.StandardAddress(bobPub),
+		Value:   types.Siacoins(20),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	// alice and bob each contribute an input and an output, and each signs
+	// only their own contribution
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{
+			{
+				Parent:        sau.NewSiacoinElements[1],
+				SpendPolicy:   types.PolicyPublicKey(alicePub),
+				CoveredFields: &types.CoveredFields{SiacoinInputs: []uint64{0}, SiacoinOutputs: []uint64{0}},
+			},
+			{
+				Parent:        sau.NewSiacoinElements[2],
+				SpendPolicy:   types.PolicyPublicKey(bobPub),
+				CoveredFields: &types.CoveredFields{SiacoinInputs: []uint64{1}, SiacoinOutputs: []uint64{1}},
+			},
+		},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.StandardAddress(alicePub), Value: types.Siacoins(9)},
+			{Address: types.StandardAddress(bobPub), Value: types.Siacoins(19)},
+		},
+		MinerFee: types.Siacoins(2),
+	}
+	sign := func(txn *types.Transaction) {
+		for i, priv := range []types.PrivateKey{alicePriv, bobPriv} {
+			in := &txn.SiacoinInputs[i]
+			in.Signatures = []types.Signature{priv.SignHash(s.PartialSigHash(*txn, *in.CoveredFields))}
+		}
+	}
+	sign(&txn)
+	if err := s.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob's signature should not depend on alice's contribution
+	aliceSig := txn.SiacoinInputs[0].Signatures[0]
+	bobSig := txn.SiacoinInputs[1].Signatures[0]
+	modified := txn.DeepCopy()
+	modified.SiacoinOutputs[0].Value = types.Siacoins(8)
+	modified.MinerFee = types.Siacoins(3)
+	if err := s.ValidateTransaction(modified); err == nil {
+		t.Fatal("accepted transaction after modifying alice's output")
+	}
+	sign(&modified)
+	if modified.SiacoinInputs[1].Signatures[0] != bobSig {
+		t.Fatal("bob's signature should not cover alice's output")
+	} else if modified.SiacoinInputs[0].Signatures[0] == aliceSig {
+		t.Fatal("alice's signature should cover alice's output")
+	} else if err := s.ValidateTransaction(modified); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid covered fields should be rejected
+	for _, test := range []struct {
+		desc string
+		cf   types.CoveredFields
+	}{
+		{"input that does not cover itself", types.CoveredFields{SiacoinInputs: []uint64{1}}},
+		{"out-of-range index", types.CoveredFields{SiacoinInputs: []uint64{0}, SiacoinOutputs: []uint64{2}}},
+		{"duplicate index", types.CoveredFields{SiacoinInputs: []uint64{0, 0}}},
+	} {
+		invalid := txn.DeepCopy()
+		invalid.SiacoinInputs[0].CoveredFields = &test.cf
+		if err := s.ValidateTransaction(invalid); err == nil {
+			t.Errorf("accepted input with %v", test.desc)
+		}
+	}
+}
+
+func TestExplainSpendPolicy(t *testing.T) {
+	s := State{
+		Index: types.ChainIndex{Height: 100},
+	}
+	var sigHash types.Hash256
+	pks := make([]types.PublicKey, 3)
+	sigs := make([]types.Signature, 3)
+	for i := range pks {
+		pk, sk := testingKeypair(uint64(i))
+		pks[i], sigs[i] = pk, sk.SignHash(sigHash)
+	}
+
+	// 2 of 3 signatures for a 3-of-3 multisig
+	multisig := types.SpendPolicy{Type: types.PolicyTypeUnlockConditions{
+		PublicKeys:         pks,
+		SignaturesRequired: 3,
+	}}
+	r := s.ExplainSpendPolicy(multisig, sigHash, sigs[:2])
+	if r.Satisfied || r.Err() == nil {
+		t.Fatal("expected policy to be unsatisfied")
+	} else if r.Reason != "satisfied 2 of 3 required signatures" {
+		t.Fatalf("unexpected reason %q", r.Reason)
+	} else if len(r.Clauses) != 2 || len(r.Clauses[1].Clauses) != 3 {
+		t.Fatalf("unexpected clauses: %+v", r.Clauses)
+	} else if c := r.Clauses[1].Clauses; !c[0].Satisfied || !c[1].Satisfied || c[2].Satisfied {
+		t.Fatalf("wrong keys satisfied: %+v", c)
+	} else if !strings.HasPrefix(c[2].Reason, "missing signature") {
+		t.Fatalf("unexpected reason %q", c[2].Reason)
+	}
+
+	// an unexpired timelock
+	r = s.ExplainSpendPolicy(types.PolicyAbove(150), sigHash, nil)
+	if r.Satisfied {
+		t.Fatal("expected policy to be unsatisfied")
+	} else if r.Reason != "timelock not yet expired (need height 151, at 100)" {
+		t.Fatalf("unexpected reason %q", r.Reason)
+	}
+
+	// a 1-of-2 threshold stops evaluating once it is satisfied
+	r = s.ExplainSpendPolicy(types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyPublicKey(pks[0]),
+		types.PolicyAbove(50),
+	}), sigHash, sigs[:1])
+	if !r.Satisfied || r.Err() != nil {
+		t.Fatal("expected policy to be satisfied:", r.Reason)
+	} else if r.Reason != "satisfied 1 of 1 required clauses" {
+		t.Fatalf("unexpected reason %q", r.Reason)
+	} else if r.Clauses[1].Reason != "not evaluated" {
+		t.Fatalf("expected second clause to be skipped, got %q", r.Clauses[1].Reason)
+	}
+
+	// the verdict should match the one used during validation
+	policies := []types.SpendPolicy{
+		multisig,
+		types.PolicyAbove(50),
+		types.PolicyAbove(150),
+		types.PolicyThreshold(2, []types.SpendPolicy{
+			types.PolicyPublicKey(pks[0]),
+			types.PolicyPublicKey(pks[1]),
+			types.PolicyPublicKey(pks[2]),
+		}),
+		types.PolicyThreshold(1, []types.SpendPolicy{
+			types.PolicyThreshold(2, []types.SpendPolicy{
+				types.PolicyPublicKey(pks[1]),
+				types.PolicyAbove(150),
+			}),
+			types.PolicyPublicKey(pks[2]),
+		}),
+		{Type: types.PolicyTypeUnlockConditions{Timelock: 150, PublicKeys: pks}},
+		{Type: types.PolicyTypeUnlockConditions{PublicKeys: pks, SignaturesRequired: 2}},
+		types.PolicyOpaque(types.AnyoneCanSpend().Address()),
+		types.AnyoneCanSpend(),
+	}
+	sigSets := [][]types.Signature{nil, sigs[:1], sigs[1:], sigs[2:], {sigs[0], sigs[2]}, sigs}
+	for i, p := range policies {
+		for j, sigs := range sigSets {
+			exp := s.ExplainSpendPolicy(p, sigHash, sigs).Satisfied
+			if got := s.satisfiesSpendPolicy(p, sigHash, sigs, types.PublicKey.VerifyHash); got != exp {
+				t.Errorf("policy %v, signatures %v: expected %v, got %v", i, j, exp, got)
+			}
+		}
+	}
+}
+
+func TestValidateTransactionSet(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	genesisBlock := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(1),
+	})
+	// also add some SF
+	genesisBlock.Transactions[0].SiafundOutputs = []types.SiafundOutput{{
+		Address: types.StandardAddress(pubkey),
+		Value:   100,
+	}}
+	sau := GenesisUpdate(genesisBlock, testingDifficulty, MainnetParams())
+	s := sau.State
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.StandardAddress(pubkey),
+			Value:   sau.NewSiacoinElements[1].Value,
+		}},
+		SiafundInputs: []types.SiafundInput{{
+			Parent:      sau.NewSiafundElements[0],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiafundOutputs: []types.SiafundOutput{{
+			Address: types.StandardAddress(pubkey),
+			Value:   sau.NewSiafundElements[0].Value,
+		}},
+	}
+	signAllInputs(&txn, s, privkey)
+
+	if err := sau.State.ValidateTransactionSet([]types.Transaction{txn, txn}); err == nil {
+		t.Fatal("accepted transaction set with repeated txn")
+	}
+
+	doubleSpendSCTxn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.StandardAddress(pubkey),
+			Value:   sau.NewSiacoinElements[1].Value,
+		}},
+	}
+	signAllInputs(&doubleSpendSCTxn, s, privkey)
+
+	if err := sau.State.ValidateTransactionSet([]types.Transaction{txn, doubleSpendSCTxn}); err == nil {
+		t.Fatal("accepted transaction set with double spent siacoin output")
 	}
 
 	doubleSpendSFTxn := types.Transaction{
@@ -1009,6 +1761,284 @@ func TestValidateTransactionSet(t *testing.T) {
 	}
 }
 
+// spendGenesisOutputs returns a state containing n outputs controlled by
+// pubkey, along with a signed transaction spending each one.
+func spendGenesisOutputs(n int, pubkey types.PublicKey, privkey types.PrivateKey) (State, []types.Transaction) {
+	scos := make([]types.SiacoinOutput, n)
+	for i := range scos {
+		scos[i] = types.SiacoinOutput{Address: types.StandardAddress(pubkey), Value: types.Siacoins(1)}
+	}
+	sau := GenesisUpdate(genesisWithSiacoinOutputs(scos...), testingDifficulty, MainnetParams())
+	txns := make([]types.Transaction, n)
+	for i := range txns {
+		sce := sau.NewSiacoinElements[i+1]
+		txns[i] = types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				Parent:      sce,
+				SpendPolicy: types.PolicyPublicKey(pubkey),
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{
+				Address: types.VoidAddress,
+				Value:   sce.Value,
+			}},
+		}
+		signAllInputs(&txns[i], sau.State, privkey)
+	}
+	return sau.State, txns
+}
+
+func TestSignatureReplayProtection(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+
+	// create states on mainnet and a testnet that differ only in their
+	// genesis block, e.g. because the testnet's genesis has another timestamp
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(100),
+	})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	if sau.State.GenesisID != b.ID() {
+		t.Fatal("genesis state has wrong genesis ID")
+	}
+	b.Header.Timestamp = b.Header.Timestamp.Add(time.Second)
+	mainnetState, testnetState := sau.State, sau.State
+	testnetState.GenesisID = b.ID()
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   types.Siacoins(100),
+		}},
+		Attestations: []types.Attestation{testnetState.Attest(privkey, "foo", []byte("bar"))},
+	}
+	signAllInputs(&txn, testnetState, privkey)
+	if err := testnetState.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	} else if err := mainnetState.ValidateTransaction(txn); err == nil {
+		t.Fatal("testnet transaction should be invalid on mainnet")
+	} else if mainnetState.VerifyAttestation(txn.Attestations[0]) {
+		t.Fatal("testnet attestation should be invalid on mainnet")
+	}
+
+	fc := types.FileContract{WindowStart: 5, WindowEnd: 10}
+	if mainnetState.ContractSigHash(fc) == testnetState.ContractSigHash(fc) {
+		t.Fatal("contract signature hashes should differ between networks")
+	}
+	fcr := types.FileContractRenewal{FinalRevision: fc, InitialRevision: fc}
+	if mainnetState.RenewalSigHash(fcr) == testnetState.RenewalSigHash(fcr) {
+		t.Fatal("renewal signature hashes should differ between networks")
+	}
+}
+
+func TestValidateTransactionSetBatch(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	s, txns := spendGenesisOutputs(10, pubkey, privkey)
+	if err := s.ValidateTransactionSet(txns); err != nil {
+		t.Fatal(err)
+	}
+
+	// an invalid signature anywhere in the set should be reported for the
+	// correct transaction, even if a later transaction is invalid for some
+	// other reason
+	txns[3].SiacoinInputs[0].Signatures[0][0] ^= 1
+	if err := s.ValidateTransactionSet(txns); err == nil || !strings.Contains(err.Error(), "transaction 3 is invalid") {
+		t.Fatal("expected transaction 3 to be invalid, got", err)
+	}
+	txns[7].SiacoinOutputs[0].Value = types.Siacoins(2)
+	if err := s.ValidateTransactionSet(txns); err == nil || !strings.Contains(err.Error(), "transaction 3 is invalid") {
+		t.Fatal("expected transaction 3 to be invalid, got", err)
+	}
+	txns[3].SiacoinInputs[0].Signatures[0][0] ^= 1
+	if err := s.ValidateTransactionSet(txns); err == nil || !strings.Contains(err.Error(), "transaction 7 is invalid") {
+		t.Fatal("expected transaction 7 to be invalid, got", err)
+	}
+
+	// a policy that is only satisfied by skipping past a non-matching key
+	// should still be accepted
+	pk1, sk1 := testingKeypair(1)
+	policy := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyThreshold(2, []types.SpendPolicy{
+			types.PolicyPublicKey(pubkey),
+			types.PolicyPublicKey(pubkey),
+		}),
+		types.PolicyPublicKey(pk1),
+	})
+	b := genesisWithSiacoinOutputs(types.SiacoinOutput{Address: policy.Address(), Value: types.Siacoins(1)})
+	sau := GenesisUpdate(b, testingDifficulty, MainnetParams())
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: policy,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   sau.NewSiacoinElements[1].Value,
+		}},
+	}
+	signAllInputs(&txn, sau.State, sk1)
+	if err := sau.State.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	} else if err := sau.State.ValidateTransactionSet([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an n-of-m policy signed by its last keys must not be batched, since the
+	// optimistic verifier would credit each signature to the wrong key
+	var sks []types.PrivateKey
+	var of []types.SpendPolicy
+	for i := uint64(0); i < 4; i++ {
+		pk, sk := testingKeypair(10 + i)
+		sks, of = append(sks, sk), append(of, types.PolicyPublicKey(pk))
+	}
+	policy = types.PolicyThreshold(2, of)
+	b = genesisWithSiacoinOutputs(types.SiacoinOutput{Address: policy.Address(), Value: types.Siacoins(1)})
+	sau = GenesisUpdate(b, testingDifficulty, MainnetParams())
+	txn = types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: policy,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   sau.NewSiacoinElements[1].Value,
+		}},
+	}
+	sigHash := sau.State.InputSigHash(txn)
+	txn.SiacoinInputs[0].Signatures = []types.Signature{sks[2].SignHash(sigHash), sks[3].SignHash(sigHash)}
+	if batchable(txn) {
+		t.Fatal("n-of-m policy should not be batchable")
+	} else if err := sau.State.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	} else if err := sau.State.ValidateTransactionSet([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	// the same policy with every key required can be batched
+	txn.SiacoinInputs[0].SpendPolicy = types.PolicyThreshold(4, of)
+	if !batchable(txn) {
+		t.Fatal("m-of-m policy should be batchable")
+	}
+	// signing with a key outside the policy should still be rejected
+	_, outsider := testingKeypair(20)
+	txn.SiacoinInputs[0].SpendPolicy = policy
+	txn.SiacoinInputs[0].Signatures[1] = outsider.SignHash(sigHash)
+	if err := sau.State.ValidateTransactionSet([]types.Transaction{txn}); err == nil || !strings.Contains(err.Error(), "transaction 0 is invalid") {
+		t.Fatal("expected transaction 0 to be invalid, got", err)
+	}
+}
+
+func BenchmarkValidateTransactionSet(b *testing.B) {
+	pubkey, privkey := testingKeypair(0)
+	s, txns := spendGenesisOutputs(2000, pubkey, privkey)
+	if err := s.ValidateTransactionSet(txns); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("set", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := s.ValidateTransactionSet(txns); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("individual", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, txn := range txns {
+				if err := s.ValidateTransaction(txn); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestValidateTransactionSetPartial(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	sau := GenesisUpdate(genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(1),
+	}, types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(2),
+	}), testingDifficulty, MainnetParams())
+	s := sau.State
+
+	// spend sce, creating an ephemeral output, and return a child transaction
+	// spending that output
+	spend := func(sce types.SiacoinElement, addr types.Address) (parent, child types.Transaction) {
+		parent = types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				Parent:      sce,
+				SpendPolicy: types.PolicyPublicKey(pubkey),
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{
+				Address: addr,
+				Value:   sce.Value,
+			}},
+		}
+		signAllInputs(&parent, s, privkey)
+		child = types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				Parent: types.SiacoinElement{
+					StateElement: types.StateElement{
+						ID:        parent.SiacoinOutputID(0),
+						LeafIndex: types.EphemeralLeafIndex,
+					},
+					SiacoinOutput: parent.SiacoinOutputs[0],
+				},
+				SpendPolicy: types.PolicyPublicKey(pubkey),
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{
+				Address: types.VoidAddress,
+				Value:   sce.Value,
+			}},
+		}
+		signAllInputs(&child, s, privkey)
+		return
+	}
+
+	parentA, childA := spend(sau.NewSiacoinElements[1], types.StandardAddress(pubkey))
+	doubleSpendA, doubleSpendChildA := spend(sau.NewSiacoinElements[1], types.VoidAddress)
+	parentB, childB := spend(sau.NewSiacoinElements[2], types.StandardAddress(pubkey))
+	parentB.SiacoinInputs[0].Signatures[0][0] ^= 1 // invalidate signature
+
+	txns := []types.Transaction{
+		parentA,           // 0: valid
+		doubleSpendA,      // 1: double-spends parentA's input
+		childA,            // 2: valid; spends parentA's ephemeral output
+		doubleSpendChildA, // 3: spends the output of a rejected parent
+		parentB,           // 4: invalid signature
+		childB,            // 5: spends the output of a rejected parent
+		childA,            // 6: duplicate of an accepted transaction
+	}
+	rejected, errs := s.ValidateTransactionSetPartial(txns)
+	if !reflect.DeepEqual(rejected, []int{1, 3, 4, 5, 6}) {
+		t.Fatalf("expected transactions [1 3 4 5 6] to be rejected, got %v", rejected)
+	} else if len(errs) != len(rejected) {
+		t.Fatalf("expected %v errors, got %v", len(rejected), len(errs))
+	}
+	for i, substr := range []string{"double-spends", "non-existent ephemeral output", "signature", "non-existent ephemeral output", "double-spends"} {
+		if !strings.Contains(errs[i].Error(), substr) {
+			t.Errorf("expected error for transaction %v to contain %q, got %q", rejected[i], substr, errs[i])
+		}
+	}
+
+	// the accepted transactions should form a valid set
+	if err := s.ValidateTransactionSet([]types.Transaction{parentA, childA}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fully valid set should not reject anything
+	if rejected, errs := s.ValidateTransactionSetPartial([]types.Transaction{parentA, childA}); len(rejected) != 0 || len(errs) != 0 {
+		t.Fatalf("expected no rejections, got %v (%v)", rejected, errs)
+	}
+}
+
 func TestValidateBlock(t *testing.T) {
 	pubkey, privkey := testingKeypair(0)
 	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
@@ -1018,7 +2048,7 @@ func TestValidateBlock(t *testing.T) {
 		Address: types.StandardAddress(pubkey),
 		Value:   types.Siacoins(1),
 	})
-	sau := GenesisUpdate(genesis, testingDifficulty)
+	sau := GenesisUpdate(genesis, testingDifficulty, MainnetParams())
 	s := sau.State
 
 	// Mine a block with a few transactions. We are not testing transaction
@@ -1093,6 +2123,363 @@ func TestValidateBlock(t *testing.T) {
 	}
 }
 
+func TestMedianTimestamp(t *testing.T) {
+	b := genesisWithSiacoinOutputs()
+	s := GenesisUpdate(b, testingDifficulty, MainnetParams()).State
+	if !s.MedianTimestamp().Equal(b.Header.Timestamp) {
+		t.Fatal("median of genesis state should be the genesis timestamp")
+	}
+
+	// mine 5 blocks at 1-second intervals; the median of the 6 timestamps is
+	// halfway between the 3rd and 4th
+	for i := 0; i < 5; i++ {
+		b = mineBlock(s, b)
+		s = ApplyBlock(s, b).State
+	}
+	median := s.PrevTimestamps[0].Add(2500 * time.Millisecond)
+	if !s.MedianTimestamp().Equal(median) {
+		t.Fatalf("expected median %v, got %v", median, s.MedianTimestamp())
+	}
+
+	// once the window is full, only the last 11 timestamps count
+	for i := 0; i < 20; i++ {
+		b = mineBlock(s, b)
+		s = ApplyBlock(s, b).State
+	}
+	if median := b.Header.Timestamp.Add(-5 * time.Second); !s.MedianTimestamp().Equal(median) {
+		t.Fatalf("expected median %v, got %v", median, s.MedianTimestamp())
+	}
+
+	// a block timestamped exactly at the median is valid; one nanosecond
+	// earlier is not
+	child := mineBlock(s, b)
+	withTimestamp := func(ts time.Time) types.Block {
+		c := child
+		c.Header.Timestamp = ts
+		findBlockNonce(s, &c.Header, types.HashRequiringWork(s.Difficulty))
+		return c
+	}
+	if err := s.ValidateBlock(withTimestamp(s.MedianTimestamp())); err != nil {
+		t.Fatal("block at median timestamp should be valid:", err)
+	} else if err := s.ValidateBlock(withTimestamp(s.MedianTimestamp().Add(-1))); err == nil {
+		t.Fatal("block before median timestamp should be invalid")
+	}
+}
+
+func TestAssembleBlock(t *testing.T) {
+	genesis := genesisWithSiacoinOutputs()
+	s := GenesisUpdate(genesis, testingDifficulty, MainnetParams()).State
+	minerAddr := types.Address{1}
+	timestamp := genesis.Header.Timestamp.Add(time.Minute)
+
+	b := AssembleBlock(s, minerAddr, timestamp)
+	switch {
+	case b.Header.Height != 1:
+		t.Fatal("wrong height:", b.Header.Height)
+	case b.Header.ParentID != genesis.ID():
+		t.Fatal("wrong parent ID")
+	case !b.Header.Timestamp.Equal(timestamp):
+		t.Fatal("wrong timestamp")
+	case b.Header.MinerAddress != minerAddr:
+		t.Fatal("wrong miner address")
+	case b.Header.Nonce != 0:
+		t.Fatal("nonce should be left to the caller")
+	}
+
+	// once a nonce is found, the block is valid and pays the miner
+	findBlockNonce(s, &b.Header, types.HashRequiringWork(s.Difficulty))
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	} else if sau := ApplyBlock(s, b); sau.NewSiacoinElements[0].Address != minerAddr {
+		t.Fatal("miner payout sent to wrong address")
+	}
+}
+
+func TestGrindNonce(t *testing.T) {
+	genesis := genesisWithSiacoinOutputs()
+	s := GenesisUpdate(genesis, testingDifficulty, MainnetParams()).State
+	b := AssembleBlock(s, types.VoidAddress, genesis.Header.Timestamp.Add(time.Second))
+
+	// an easy target should be met quickly
+	h, ok := GrindNonce(context.Background(), s, b.Header, types.HashRequiringWork(s.Difficulty))
+	if !ok {
+		t.Fatal("failed to find nonce")
+	}
+	b.Header = h
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// nonces must respect the nonce factor
+	s.Index.Height = MainnetParams().ASICHardforkHeight
+	h, ok = GrindNonce(context.Background(), s, types.BlockHeader{Nonce: 1}, types.HashRequiringWork(testingDifficulty))
+	if !ok {
+		t.Fatal("failed to find nonce")
+	} else if h.Nonce%s.NonceFactor() != 0 {
+		t.Fatalf("nonce %v is not divisible by %v", h.Nonce, s.NonceFactor())
+	}
+
+	// an impossible target should run until cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := GrindNonce(ctx, s, b.Header, types.BlockID{}); ok {
+		t.Fatal("should not have met impossible target")
+	}
+}
+
+func TestCommitment(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(1),
+	})
+	sau := GenesisUpdate(genesis, testingDifficulty, MainnetParams())
+	s := sau.State
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		MinerFee: types.Siacoins(1),
+	}
+	signAllInputs(&txn, s, privkey)
+
+	// assemble a block by hand using the exported commitment
+	minerAddr := types.StandardAddress(pubkey)
+	b := types.Block{
+		Header: types.BlockHeader{
+			Height:       1,
+			ParentID:     genesis.ID(),
+			Timestamp:    genesis.Header.Timestamp.Add(time.Second),
+			MinerAddress: minerAddr,
+			Commitment:   s.Commitment(minerAddr, []types.Transaction{txn}),
+		},
+		Transactions: []types.Transaction{txn},
+	}
+	findBlockNonce(s, &b.Header, types.HashRequiringWork(s.Difficulty))
+	if err := s.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// changing any input should change the commitment
+	commitment := b.Header.Commitment
+	otherState := s
+	otherState.SiafundPool = types.Siacoins(1)
+	otherTxn := txn
+	otherTxn.MinerFee = types.Siacoins(2)
+	for desc, c := range map[string]types.Hash256{
+		"miner address": s.Commitment(types.VoidAddress, b.Transactions),
+		"transactions":  s.Commitment(minerAddr, []types.Transaction{otherTxn}),
+		"no txns":       s.Commitment(minerAddr, nil),
+		"state":         otherState.Commitment(minerAddr, b.Transactions),
+	} {
+		if c == commitment {
+			t.Errorf("changing %v did not change commitment", desc)
+		}
+	}
+
+	// signatures are not committed to
+	resigned := txn.DeepCopy()
+	resigned.SiacoinInputs[0].Signatures[0][0] ^= 1
+	if s.Commitment(minerAddr, []types.Transaction{resigned}) != commitment {
+		t.Error("changing a signature should not change commitment")
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	genesis := genesisWithSiacoinOutputs()
+	s := GenesisUpdate(genesis, testingDifficulty, MainnetParams()).State
+	b := mineBlock(s, genesis)
+	if err := s.ValidateHeader(b.Header); err != nil {
+		t.Fatal(err)
+	}
+
+	// the commitment is not checked, so a header can be validated without
+	// its transactions
+	h := b.Header
+	h.Commitment[0] ^= 1
+	findBlockNonce(s, &h, types.HashRequiringWork(s.Difficulty))
+	if err := s.ValidateHeader(h); err != nil {
+		t.Fatal("header with different commitment should be valid:", err)
+	} else if err := s.ValidateBlock(types.Block{Header: h}); err == nil {
+		t.Fatal("block with wrong commitment should be invalid")
+	}
+
+	// a header that does not meet the target is rejected
+	hard := s
+	hard.Difficulty = types.WorkRequiredForHash(b.ID()).Add(types.Work{NumHashes: [32]byte{31: 1}})
+	if err := hard.ValidateHeader(b.Header); err == nil || err.Error() != "insufficient work" {
+		t.Fatalf("expected insufficient work error, got %v", err)
+	}
+}
+
+func TestContractCost(t *testing.T) {
+	s := GenesisUpdate(genesisWithSiacoinOutputs(), testingDifficulty, MainnetParams()).State
+	fc := types.FileContract{
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(5)},
+		HostOutput:      types.SiacoinOutput{Value: types.Siacoins(10)},
+		TotalCollateral: types.Siacoins(7),
+	}
+	tax := s.FileContractTax(fc)
+	renterFunding, hostFunding, err := s.ContractCost(fc)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := types.Siacoins(8).Add(tax); renterFunding != exp {
+		t.Fatalf("expected renter funding of %v, got %v", exp, renterFunding)
+	} else if hostFunding != types.Siacoins(7) {
+		t.Fatalf("expected host funding of %v, got %v", types.Siacoins(7), hostFunding)
+	}
+
+	// the combined funding should match the payouts plus tax, regardless of
+	// collateral
+	fc.TotalCollateral = types.ZeroCurrency
+	renterFunding, hostFunding, err = s.ContractCost(fc)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := types.Siacoins(15).Add(tax); renterFunding.Add(hostFunding) != exp {
+		t.Fatalf("expected total funding of %v, got %v", exp, renterFunding.Add(hostFunding))
+	} else if !hostFunding.IsZero() {
+		t.Fatalf("expected zero host funding, got %v", hostFunding)
+	}
+
+	// collateral exceeding the payouts should be rejected rather than
+	// underflowing
+	fc.TotalCollateral = types.Siacoins(20)
+	if _, _, err := s.ContractCost(fc); err == nil {
+		t.Fatal("expected error for collateral exceeding payouts")
+	}
+	// as should collateral exceeding the host payout alone, which consensus
+	// would reject
+	fc.TotalCollateral = types.Siacoins(11)
+	if _, _, err := s.ContractCost(fc); err == nil {
+		t.Fatal("expected error for collateral exceeding host payout")
+	}
+	// and payouts that overflow
+	fc.TotalCollateral = types.ZeroCurrency
+	fc.RenterOutput.Value = types.NewCurrency(math.MaxUint64, math.MaxUint64)
+	if _, _, err := s.ContractCost(fc); !errors.Is(err, ErrOverflow) {
+		t.Fatal("expected ErrOverflow, got", err)
+	}
+}
+
+func TestValidateContractFunding(t *testing.T) {
+	renterPub, renterPriv := testingKeypair(0)
+	hostPub, hostPriv := testingKeypair(1)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(renterPub),
+		Value:   types.Siacoins(100),
+	}, types.SiacoinOutput{
+		Address: types.StandardAddress(hostPub),
+		Value:   types.Siacoins(7),
+	})
+	sau := GenesisUpdate(genesis, testingDifficulty, MainnetParams())
+	s := sau.State
+	renterOutput, hostOutput := sau.NewSiacoinElements[1], sau.NewSiacoinElements[2]
+
+	fc := types.FileContract{
+		WindowStart: 20,
+		WindowEnd:   30,
+		RenterOutput: types.SiacoinOutput{
+			Address: types.StandardAddress(renterPub),
+			Value:   types.Siacoins(90),
+		},
+		HostOutput: types.SiacoinOutput{
+			Address: types.StandardAddress(hostPub),
+			Value:   types.Siacoins(10),
+		},
+		TotalCollateral: types.Siacoins(5),
+		RenterPublicKey: renterPub,
+		HostPublicKey:   hostPub,
+	}
+	sigHash := s.ContractSigHash(fc)
+	fc.RenterSignature = renterPriv.SignHash(sigHash)
+	fc.HostSignature = hostPriv.SignHash(sigHash)
+	sign := func(txn *types.Transaction) {
+		sigHash := s.InputSigHash(*txn)
+		for i := range txn.SiacoinInputs {
+			priv := renterPriv
+			if txn.SiacoinInputs[i].Parent.Address == types.StandardAddress(hostPub) {
+				priv = hostPriv
+			}
+			txn.SiacoinInputs[i].Signatures = []types.Signature{priv.SignHash(sigHash)}
+		}
+	}
+
+	// the renter's output alone cannot cover the payouts plus tax
+	underfunded := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{
+			{Parent: renterOutput, SpendPolicy: types.PolicyPublicKey(renterPub)},
+		},
+		FileContracts: []types.FileContract{fc},
+	}
+	sign(&underfunded)
+	if err := s.ValidateContractFunding(underfunded); !errors.Is(err, ErrInsufficientContractFunding) {
+		t.Fatalf("expected ErrInsufficientContractFunding, got %v", err)
+	} else if err := s.ValidateTransaction(underfunded); err == nil {
+		t.Fatal("consensus accepted underfunded formation transaction")
+	}
+
+	// adding the host's collateral should suffice
+	renterFunding, hostFunding, err := s.ContractCost(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funded := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{
+			{Parent: renterOutput, SpendPolicy: types.PolicyPublicKey(renterPub)},
+			{Parent: hostOutput, SpendPolicy: types.PolicyPublicKey(hostPub)},
+		},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.StandardAddress(renterPub), Value: renterOutput.Value.Sub(renterFunding)},
+			{Address: types.StandardAddress(hostPub), Value: hostOutput.Value.Sub(hostFunding)},
+		},
+		FileContracts: []types.FileContract{fc},
+	}
+	sign(&funded)
+	if err := s.ValidateContractFunding(funded); err != nil {
+		t.Fatal(err)
+	} else if err := s.ValidateTransaction(funded); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	s := GenesisUpdate(genesisWithSiacoinOutputs(), testingDifficulty, MainnetParams()).State
+	_, privkey := testingKeypair(0)
+
+	a := s.Attest(privkey, "foo", []byte("bar"))
+	if a.PublicKey != privkey.PublicKey() || a.Key != "foo" || string(a.Value) != "bar" {
+		t.Fatal("attestation has wrong fields:", a)
+	} else if !s.VerifyAttestation(a) {
+		t.Fatal("attestation should be valid")
+	}
+
+	// the signature should survive an encoding roundtrip
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	a.EncodeTo(e)
+	e.Flush()
+	var decoded types.Attestation
+	decoded.DecodeFrom(types.NewBufDecoder(buf.Bytes()))
+	if !s.VerifyAttestation(decoded) {
+		t.Fatal("decoded attestation should be valid")
+	}
+
+	// modifying any signed field should invalidate the signature
+	for _, modify := range []func(a *types.Attestation){
+		func(a *types.Attestation) { a.Key = "baz" },
+		func(a *types.Attestation) { a.Value = []byte("baz") },
+		func(a *types.Attestation) { a.PublicKey[0] ^= 1 },
+		func(a *types.Attestation) { a.Signature[0] ^= 1 },
+	} {
+		modified := s.Attest(privkey, "foo", []byte("bar"))
+		modify(&modified)
+		if s.VerifyAttestation(modified) {
+			t.Fatal("modified attestation should be invalid")
+		}
+	}
+}
+
 func TestNoDoubleContractUpdates(t *testing.T) {
 	renterPub, renterPriv := testingKeypair(0)
 	hostPub, hostPriv := testingKeypair(1)
@@ -1105,7 +2492,7 @@ func TestNoDoubleContractUpdates(t *testing.T) {
 		Address: hostAddr,
 		Value:   types.Siacoins(100),
 	})
-	sau := GenesisUpdate(genesis, testingDifficulty)
+	sau := GenesisUpdate(genesis, testingDifficulty, MainnetParams())
 	s := sau.State
 
 	signRevision := func(fc *types.FileContract) {
@@ -1,6 +1,6 @@
 module go.sia.tech/core/v2
 
-go 1.17
+go 1.18
 
 require (
 	github.com/hdevalence/ed25519consensus v0.1.0
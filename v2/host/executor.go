@@ -100,13 +100,17 @@ func (pe *ProgramExecutor) executeAppendSector(root types.Hash256, sector *[rhp.
 		return nil, fmt.Errorf("failed to add sector: %w", err)
 	}
 
+	var proof []types.Hash256
+	if requiresProof {
+		proof = rhp.BuildAppendProof(pe.newRoots)
+	}
+
 	// update the program's state
 	pe.newRoots = append(pe.newRoots, root)
 	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
 	pe.newFileSize += rhp.SectorSize
 	pe.gainedSectors[root]++
-	// TODO: calculate optional proof.
-	return nil, nil
+	return proof, nil
 }
 
 // executeUpdateSector updates an existing sector.
@@ -118,8 +122,23 @@ func (pe *ProgramExecutor) executeUpdateSector(offset uint64, data []byte, requi
 	index := offset / rhp.SectorSize
 	if index >= uint64(len(pe.newRoots)) {
 		return nil, fmt.Errorf("offset out of range: %d", index)
+	} else if offset%rhp.SectorSize+uint64(len(data)) > rhp.SectorSize {
+		return nil, errors.New("update exceeds sector bounds")
 	}
 	existingRoot := pe.newRoots[index]
+
+	// the proof must be built from the sector's contents prior to the update.
+	var proof []types.Hash256
+	if requiresProof {
+		if offset%rhp.LeafSize != 0 || uint64(len(data))%rhp.LeafSize != 0 || len(data) == 0 {
+			return nil, errors.New("update proofs require updates aligned to leaf size")
+		}
+		var sector [rhp.SectorSize]byte
+		if _, err := pe.sectors.Read(existingRoot, bytes.NewBuffer(sector[:0]), 0, rhp.SectorSize); err != nil {
+			return nil, fmt.Errorf("failed to read sector: %w", err)
+		}
+		proof = rhp.BuildUpdateProof(pe.newRoots, offset, uint64(len(data)), func(uint64) *[rhp.SectorSize]byte { return &sector })
+	}
 	offset %= rhp.SectorSize
 
 	// update the sector in the sector store.
@@ -132,8 +151,7 @@ func (pe *ProgramExecutor) executeUpdateSector(offset uint64, data []byte, requi
 	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
 	pe.gainedSectors[updatedRoot]++
 	pe.removedSectors[existingRoot]++
-	// TODO: calculate optional proof.
-	return nil, nil
+	return proof, nil
 }
 
 // executeDropSectors drops the last n sectors from the executor's sector roots.
@@ -169,15 +187,20 @@ func (pe *ProgramExecutor) executeSwapSectors(indexA, indexB uint64, requiresPro
 		return nil, fmt.Errorf("sector 2 index out of range %v", indexB)
 	}
 
+	// the proof does not depend on the swapped roots, so it can be built
+	// before or after the swap.
+	var proof []types.Hash256
+	if requiresProof {
+		proof = rhp.BuildSwapProof(pe.newRoots, indexA, indexB)
+	}
+
 	// swap the sector roots.
 	pe.newRoots[indexA], pe.newRoots[indexB] = pe.newRoots[indexB], pe.newRoots[indexA]
 	// update the program's contract state
 	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
 	pe.newRoots[indexA].EncodeTo(pe.encoder)
-	pe.newRoots[indexA].EncodeTo(pe.encoder)
-
-	// TODO: calculate optional proof.
-	return nil, nil
+	pe.newRoots[indexB].EncodeTo(pe.encoder)
+	return proof, nil
 }
 
 // executeReadSector reads a sector from the host. Returning the bytes read, an
@@ -219,10 +242,7 @@ func (pe *ProgramExecutor) executeSectorRoots() error {
 	}
 
 	// write the sector roots to the encoder.
-	pe.encoder.WritePrefix(len(pe.newRoots))
-	for _, root := range pe.newRoots {
-		root.EncodeTo(pe.encoder)
-	}
+	(&rhp.SectorRootsOutput{SectorRoots: pe.newRoots}).EncodeTo(pe.encoder)
 	return nil
 }
 
@@ -218,7 +218,7 @@ func NewChainSim() *ChainSim {
 		},
 		Transactions: genesisTxns,
 	}
-	sau := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 4}})
+	sau := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 4}}, consensus.MainnetParams())
 	var outputs []types.SiacoinElement
 	for _, out := range sau.NewSiacoinElements {
 		if out.Address == types.StandardAddress(pubkey) {
@@ -2,6 +2,7 @@ package merkle
 
 import (
 	"math/bits"
+	"unsafe"
 
 	"go.sia.tech/core/v2/internal/blake2b"
 	"go.sia.tech/core/v2/types"
@@ -11,6 +12,12 @@ import (
 const leafHashPrefix = 0x00
 const nodeHashPrefix = 0x01
 
+// SectorSize is the size of one sector of file contract data, in bytes.
+const SectorSize = 1 << 22 // 4 MiB
+
+// leafSize is the size of one leaf of file contract data, in bytes.
+const leafSize = len(types.StorageProof{}.Leaf)
+
 // mergeHeight returns the height at which the proof paths of x and y merge.
 func mergeHeight(x, y uint64) int { return bits.Len64(x ^ y) }
 
@@ -42,7 +49,6 @@ func ProofRoot(leafHash types.Hash256, leafIndex uint64, proof []types.Hash256)
 // StorageProofLeafHash computes the leaf hash of file contract data. If
 // len(leaf) < 64, it will be extended with zeros.
 func StorageProofLeafHash(leaf []byte) types.Hash256 {
-	const leafSize = len(types.StorageProof{}.Leaf)
 	buf := make([]byte, 1+leafSize)
 	buf[0] = leafHashPrefix
 	copy(buf[1:], leaf)
@@ -54,3 +60,45 @@ func StorageProofLeafHash(leaf []byte) types.Hash256 {
 func StorageProofRoot(sp types.StorageProof, leafIndex uint64) types.Hash256 {
 	return ProofRoot(StorageProofLeafHash(sp.Leaf[:]), leafIndex, sp.Proof)
 }
+
+// SectorRoot computes the Merkle root of a sector, whose leaves are hashed with
+// StorageProofLeafHash. Since a sector's tree is perfect, it is a subtree of
+// the tree whose root is a contract's FileMerkleRoot. SectorRoot panics if
+// len(sector) != SectorSize.
+func SectorRoot(sector []byte) types.Hash256 {
+	if len(sector) != SectorSize {
+		panic("SectorRoot: sector has wrong length")
+	}
+	// hash the sector in fixed-size chunks so that no allocation is needed;
+	// both the leaves per chunk and the chunks per sector are powers of two, so
+	// every level of the tree can be hashed four nodes at a time
+	const chunkLeaves = 4096
+	var nodes [chunkLeaves][32]byte
+	var roots [SectorSize / leafSize / chunkLeaves][32]byte
+	for i := range roots {
+		chunk := sector[i*chunkLeaves*leafSize:][:chunkLeaves*leafSize]
+		for j := 0; j < chunkLeaves; j += 4 {
+			blake2b.SumLeaves((*[4][32]byte)(nodes[j:]), (*[4][64]byte)(unsafe.Pointer(&chunk[j*leafSize])))
+		}
+		roots[i] = perfectRoot(nodes[:])
+	}
+	return perfectRoot(roots[:])
+}
+
+// perfectRoot computes the root of the perfect tree whose leaf hashes are
+// nodes, overwriting nodes in the process. len(nodes) must be a power of two.
+func perfectRoot(nodes [][32]byte) types.Hash256 {
+	for len(nodes) >= 8 {
+		for i := 0; i < len(nodes)/8; i++ {
+			blake2b.SumNodes((*[4][32]byte)(nodes[i*4:]), (*[8][32]byte)(nodes[i*8:]))
+		}
+		nodes = nodes[:len(nodes)/2]
+	}
+	for len(nodes) > 1 {
+		for i := 0; i < len(nodes)/2; i++ {
+			nodes[i] = blake2b.SumPair(nodes[2*i], nodes[2*i+1])
+		}
+		nodes = nodes[:len(nodes)/2]
+	}
+	return nodes[0]
+}
@@ -0,0 +1,62 @@
+package merkle
+
+import (
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+
+	"lukechampine.com/frand"
+)
+
+// incrementalSectorRoot computes a sector root by inserting leaves one at a
+// time into a stack of subtree roots.
+func incrementalSectorRoot(sector []byte) types.Hash256 {
+	var stack []types.Hash256
+	for i := 0; i < len(sector)/leafSize; i++ {
+		h := StorageProofLeafHash(sector[i*leafSize:][:leafSize])
+		for j := i; j&1 == 1; j >>= 1 {
+			h = NodeHash(stack[len(stack)-1], h)
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, h)
+	}
+	return stack[0]
+}
+
+func TestSectorRoot(t *testing.T) {
+	// known roots, shared with the renter-host protocol
+	sector := make([]byte, SectorSize)
+	if SectorRoot(sector).String() != "h:50ed59cecd5ed3ca9e65cec0797202091dbba45272dafa3faa4e27064eedd52c" {
+		t.Error("wrong Merkle root for empty sector")
+	}
+	sector[SectorSize-1] = 1
+	if SectorRoot(sector).String() != "h:d0ab6691d76750618452e920386e5f6f98fdd1219a70a06f06ef622ac6c6373c" {
+		t.Error("wrong Merkle root for sector[SectorSize-1] = 1")
+	}
+
+	frand.Read(sector)
+	root := SectorRoot(sector)
+	if root != incrementalSectorRoot(sector) {
+		t.Fatal("SectorRoot does not match incremental leaf hashing")
+	}
+
+	// a storage proof for any leaf should lead to the same root
+	leafIndex := frand.Intn(SectorSize / leafSize)
+	var sp types.StorageProof
+	copy(sp.Leaf[:], sector[leafIndex*leafSize:])
+	for height := 0; 1<<height < SectorSize/leafSize; height++ {
+		sibling := (leafIndex >> height) ^ 1
+		start, end := sibling<<height*leafSize, (sibling+1)<<height*leafSize
+		sp.Proof = append(sp.Proof, incrementalSectorRoot(sector[start:end]))
+	}
+	if StorageProofRoot(sp, uint64(leafIndex)) != root {
+		t.Fatal("storage proof does not match sector root")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for short sector")
+		}
+	}()
+	SectorRoot(sector[1:])
+}
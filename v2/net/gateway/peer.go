@@ -1,9 +1,12 @@
 package gateway
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"go.sia.tech/core/v2/net/rpc"
 	"go.sia.tech/core/v2/types"
@@ -12,7 +15,36 @@ import (
 	"lukechampine.com/frand"
 )
 
-const protocolVersion = 2
+// protocolVersion is the version of the gateway protocol that we speak, and
+// minProtocolVersion is the oldest version we will accept from a peer.
+const (
+	protocolVersion    = 3
+	minProtocolVersion = 3
+)
+
+// Default resource limits for a Session.
+const (
+	DefaultMaxStreams     = 64
+	DefaultMaxMessageSize = 100e6 // large enough for RPCBlocksResponse
+)
+
+// Errors returned when the gateway handshake fails.
+var (
+	// ErrIncompatibleVersion is returned when the peer's protocol version is
+	// too old.
+	ErrIncompatibleVersion = errors.New("peer has incompatible protocol version")
+
+	// ErrWrongNetwork is returned when the peer has a different genesis block,
+	// i.e. it is on a different network. Unlike most handshake failures, this
+	// is permanent, so there is no point in reconnecting to the peer.
+	ErrWrongNetwork = errors.New("peer has different genesis block")
+)
+
+// Errors returned when a peer exceeds a Session's resource limits.
+var (
+	ErrTooManyStreams  = errors.New("peer exceeded maximum number of concurrent streams")
+	ErrMessageTooLarge = errors.New("peer exceeded maximum message size")
+)
 
 // A UniqueID is a randomly-generated nonce that helps prevent self-connections
 // and double-connections.
@@ -24,44 +56,232 @@ func GenerateUniqueID() (id UniqueID) {
 	return
 }
 
-type rpcHeader struct {
-	GenesisID types.BlockID
-	UniqueID  [8]byte
+// Capabilities is a bitfield of optional protocol features.
+type Capabilities uint64
+
+// Has reports whether c includes all of the features in x.
+func (c Capabilities) Has(x Capabilities) bool {
+	return c&x == x
+}
+
+// A Header contains the information exchanged during the gateway handshake.
+type Header struct {
+	GenesisID    types.BlockID
+	UniqueID     UniqueID
+	Capabilities Capabilities
 }
 
-func validateHeader(ours, theirs rpcHeader) error {
+func validateHeader(ours, theirs Header) error {
 	if theirs.GenesisID != ours.GenesisID {
-		return errors.New("peer has different genesis block")
+		return ErrWrongNetwork
 	} else if theirs.UniqueID == ours.UniqueID {
 		return errors.New("peer has same unique ID as us")
 	}
 	return nil
 }
 
-func (h *rpcHeader) EncodeTo(e *types.Encoder) {
+func validateVersion(theirs uint8) error {
+	if theirs < minProtocolVersion {
+		return fmt.Errorf("%w (ours = %v, theirs = %v)", ErrIncompatibleVersion, protocolVersion, theirs)
+	}
+	return nil
+}
+
+func negotiatedVersion(theirs uint8) uint8 {
+	if theirs < protocolVersion {
+		return theirs
+	}
+	return protocolVersion
+}
+
+// EncodeTo implements rpc.Object.
+func (h *Header) EncodeTo(e *types.Encoder) {
 	h.GenesisID.EncodeTo(e)
 	e.Write(h.UniqueID[:])
+	e.WriteUint64(uint64(h.Capabilities))
 }
 
-func (h *rpcHeader) DecodeFrom(d *types.Decoder) {
+// DecodeFrom implements rpc.Object.
+func (h *Header) DecodeFrom(d *types.Decoder) {
 	h.GenesisID.DecodeFrom(d)
 	d.Read(h.UniqueID[:])
+	h.Capabilities = Capabilities(d.ReadUint64())
 }
 
-func (h *rpcHeader) MaxLen() int {
+// MaxLen implements rpc.Object.
+func (h *Header) MaxLen() int {
 	return 1024 // arbitrary
 }
 
+// interruptOnCancel sets an immediate deadline on c if ctx is cancelled,
+// interrupting any pending I/O. The returned function must be called once the
+// I/O is complete; it reports whether c was interrupted.
+func interruptOnCancel(ctx context.Context, c interface{ SetDeadline(time.Time) error }) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
 // A Session is an ongoing exchange of RPCs via the gateway protocol.
+//
+// The underlying mux keeps idle sessions alive by itself: whenever no frames
+// have been sent for 75% of the negotiated connection timeout, it sends an
+// empty keepalive frame. Likewise, frames are written to the connection as
+// soon as they are buffered, so there is no need to flush a Session.
 type Session struct {
 	*mux.Mux
 	RemoteAddr string
 	RemoteID   UniqueID
+
+	// Version is the negotiated protocol version, i.e. the lower of ours and
+	// the peer's. Capabilities are the features supported by both peers.
+	Version      uint8
+	Capabilities Capabilities
+
+	// MaxStreams is the maximum number of concurrent streams the peer may
+	// open, and MaxMessageSize is the maximum number of bytes that may be
+	// read from any one stream. If zero, DefaultMaxStreams and
+	// DefaultMaxMessageSize are used. They should be set before the Session
+	// is used.
+	MaxStreams     int
+	MaxMessageSize int64
+
+	mu      sync.Mutex
+	streams int                   // currently-open accepted streams
+	pending []chan acceptedStream // accepts abandoned by AcceptStreamContext
+}
+
+// An acceptedStream is the outcome of accepting a stream.
+type acceptedStream struct {
+	stream *Stream
+	err    error
+}
+
+// A Stream is a single RPC exchange within a Session. It implements net.Conn;
+// in particular, SetDeadline, SetReadDeadline, and SetWriteDeadline may be used
+// to bound individual reads and writes. Deadlines only affect future calls, not
+// pending ones.
+type Stream struct {
+	*mux.Stream
+	remaining int64
+	closeOnce sync.Once
+	onClose   func()
+}
+
+var _ net.Conn = (*Stream)(nil)
+
+// Read implements io.Reader. It returns ErrMessageTooLarge if the peer sends
+// more than the Session's MaxMessageSize.
+func (s *Stream) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, ErrMessageTooLarge
+	} else if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.Stream.Read(p)
+	s.remaining -= int64(n)
+	return n, err
+}
+
+// Close closes the stream in both directions: subsequent reads and writes on
+// either side of the stream fail. Streams do not support half-closing, so an
+// RPC that streams multiple responses must delimit them itself rather than
+// relying on the peer closing its side of the stream.
+func (s *Stream) Close() error {
+	if s.onClose != nil {
+		s.closeOnce.Do(s.onClose)
+	}
+	return s.Stream.Close()
+}
+
+func (s *Session) maxMessageSize() int64 {
+	if s.MaxMessageSize == 0 {
+		return DefaultMaxMessageSize
+	}
+	return s.MaxMessageSize
+}
+
+func (s *Session) maxStreams() int {
+	if s.MaxStreams == 0 {
+		return DefaultMaxStreams
+	}
+	return s.MaxStreams
+}
+
+// DialStream opens a new stream.
+func (s *Session) DialStream() *Stream {
+	return &Stream{
+		Stream:    s.Mux.DialStream(),
+		remaining: s.maxMessageSize(),
+	}
+}
+
+// AcceptStream accepts a stream opened by the peer. If accepting the stream
+// would exceed the Session's MaxStreams, the stream is closed and
+// ErrTooManyStreams is returned; the caller may continue accepting streams.
+func (s *Session) AcceptStream() (*Stream, error) {
+	if ch := s.takePending(); ch != nil {
+		r := <-ch
+		return r.stream, r.err
+	}
+	return s.acceptStream()
+}
+
+// takePending returns the channel of the oldest accept abandoned by
+// AcceptStreamContext, if any. The stream it receives belongs to the next
+// caller, rather than being discarded.
+func (s *Session) takePending() chan acceptedStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	ch := s.pending[0]
+	s.pending = s.pending[1:]
+	return ch
+}
+
+func (s *Session) acceptStream() (*Stream, error) {
+	ms, err := s.Mux.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams >= s.maxStreams() {
+		ms.Close()
+		return nil, ErrTooManyStreams
+	}
+	s.streams++
+	return &Stream{
+		Stream:    ms,
+		remaining: s.maxMessageSize(),
+		onClose: func() {
+			s.mu.Lock()
+			s.streams--
+			s.mu.Unlock()
+		},
+	}, nil
 }
 
 // DialSession initiates the gateway handshake with a peer, establishing a
 // Session.
-func DialSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Session, err error) {
+func DialSession(conn net.Conn, ourHeader Header) (_ *Session, err error) {
 	m, err := mux.DialAnonymous(conn)
 	if err != nil {
 		return nil, err
@@ -80,13 +300,12 @@ func DialSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Sessi
 		return nil, fmt.Errorf("could not write our version: %w", err)
 	} else if _, err := s.Read(buf[:]); err != nil {
 		return nil, fmt.Errorf("could not read peer version: %w", err)
-	} else if version := buf[0]; version != protocolVersion {
-		return nil, fmt.Errorf("incompatible versions (ours = %v, theirs = %v)", protocolVersion, version)
+	} else if err := validateVersion(buf[0]); err != nil {
+		return nil, err
 	}
 
 	// exchange headers
-	ourHeader := rpcHeader{genesisID, uid}
-	var peerHeader rpcHeader
+	var peerHeader Header
 	if err := rpc.WriteObject(s, &ourHeader); err != nil {
 		return nil, fmt.Errorf("could not write our header: %w", err)
 	} else if err := rpc.ReadObject(s, &peerHeader); err != nil {
@@ -99,12 +318,43 @@ func DialSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Sessi
 		Mux:        m,
 		RemoteAddr: conn.RemoteAddr().String(),
 		RemoteID:   peerHeader.UniqueID,
+
+		Version:      negotiatedVersion(buf[0]),
+		Capabilities: ourHeader.Capabilities & peerHeader.Capabilities,
 	}, nil
 }
 
+// DialSessionContext is like DialSession, but aborts the handshake if ctx is
+// cancelled.
+func DialSessionContext(ctx context.Context, conn net.Conn, ourHeader Header) (*Session, error) {
+	stop := interruptOnCancel(ctx, conn)
+	sess, err := DialSession(conn, ourHeader)
+	if stop() {
+		if err == nil {
+			sess.Close()
+		}
+		return nil, ctx.Err()
+	}
+	return sess, err
+}
+
+// awaitPeerClose waits (briefly) for the peer to close s. The acceptor writes
+// its half of the handshake last, and closing the mux can discard frames that
+// have not yet reached the peer; waiting ensures that the peer receives our
+// response and can detect the failure itself.
+func awaitPeerClose(s *mux.Stream) {
+	s.SetReadDeadline(time.Now().Add(time.Second))
+	var buf [1]byte
+	for {
+		if _, err := s.Read(buf[:]); err != nil {
+			return
+		}
+	}
+}
+
 // AcceptSession reciprocates the gateway handshake with a peer, establishing a
 // Session.
-func AcceptSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Session, err error) {
+func AcceptSession(conn net.Conn, ourHeader Header) (_ *Session, err error) {
 	m, err := mux.AcceptAnonymous(conn)
 	if err != nil {
 		return nil, err
@@ -126,18 +376,19 @@ func AcceptSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Ses
 		return nil, fmt.Errorf("could not read peer version: %w", err)
 	} else if _, err := s.Write([]byte{protocolVersion}); err != nil {
 		return nil, fmt.Errorf("could not write our version: %w", err)
-	} else if version := buf[0]; version != protocolVersion {
-		return nil, fmt.Errorf("incompatible versions (ours = %v, theirs = %v)", protocolVersion, version)
+	} else if err := validateVersion(buf[0]); err != nil {
+		awaitPeerClose(s)
+		return nil, err
 	}
 
 	// exchange headers
-	ourHeader := rpcHeader{genesisID, uid}
-	var peerHeader rpcHeader
+	var peerHeader Header
 	if err := rpc.ReadObject(s, &peerHeader); err != nil {
 		return nil, fmt.Errorf("could not read peer's header: %w", err)
 	} else if err := rpc.WriteObject(s, &ourHeader); err != nil {
 		return nil, fmt.Errorf("could not write our header: %w", err)
 	} else if err := validateHeader(ourHeader, peerHeader); err != nil {
+		awaitPeerClose(s)
 		return nil, fmt.Errorf("unacceptable header: %w", err)
 	}
 
@@ -145,5 +396,82 @@ func AcceptSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Ses
 		Mux:        m,
 		RemoteAddr: conn.RemoteAddr().String(),
 		RemoteID:   peerHeader.UniqueID,
+
+		Version:      negotiatedVersion(buf[0]),
+		Capabilities: ourHeader.Capabilities & peerHeader.Capabilities,
 	}, nil
 }
+
+// AcceptSessionContext is like AcceptSession, but aborts the handshake if ctx
+// is cancelled.
+func AcceptSessionContext(ctx context.Context, conn net.Conn, ourHeader Header) (*Session, error) {
+	stop := interruptOnCancel(ctx, conn)
+	sess, err := AcceptSession(conn, ourHeader)
+	if stop() {
+		if err == nil {
+			sess.Close()
+		}
+		return nil, ctx.Err()
+	}
+	return sess, err
+}
+
+// withContext applies ctx's deadline to stream and closes the stream if ctx is
+// cancelled. The goroutine watching ctx exits when the stream is closed.
+func withContext(ctx context.Context, stream *Stream) *Stream {
+	if d, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(d)
+	}
+	if ctx.Done() != nil {
+		closed := make(chan struct{})
+		onClose := stream.onClose
+		stream.onClose = func() {
+			if onClose != nil {
+				onClose()
+			}
+			close(closed)
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+				stream.Close()
+			case <-closed:
+			}
+		}()
+	}
+	return stream
+}
+
+// DialStreamContext opens a new stream. Reads and writes on the stream fail
+// once ctx's deadline passes, and the stream is closed if ctx is cancelled.
+func (s *Session) DialStreamContext(ctx context.Context) *Stream {
+	return withContext(ctx, s.DialStream())
+}
+
+// AcceptStreamContext is like AcceptStream, but returns early if ctx is
+// cancelled. As with DialStreamContext, ctx also governs the returned stream.
+// If ctx is cancelled before a stream arrives, the next stream is returned by
+// a subsequent call to AcceptStream or AcceptStreamContext.
+func (s *Session) AcceptStreamContext(ctx context.Context) (*Stream, error) {
+	ch := s.takePending()
+	if ch == nil {
+		ch = make(chan acceptedStream, 1)
+		go func() {
+			stream, err := s.acceptStream()
+			ch <- acceptedStream{stream, err}
+		}()
+	}
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return withContext(ctx, r.stream), nil
+	case <-ctx.Done():
+		// hand the in-progress accept to the next caller
+		s.mu.Lock()
+		s.pending = append(s.pending, ch)
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
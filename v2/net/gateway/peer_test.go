@@ -1,12 +1,17 @@
 package gateway
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
+	"runtime"
 	"testing"
+	"time"
 
 	"go.sia.tech/core/v2/net/rpc"
 	"go.sia.tech/core/v2/types"
+	"go.sia.tech/mux"
 )
 
 type objString string
@@ -33,7 +38,7 @@ func TestHandshake(t *testing.T) {
 				return err
 			}
 			defer conn.Close()
-			sess, err := AcceptSession(conn, genesisID, UniqueID{0})
+			sess, err := AcceptSession(conn, Header{GenesisID: genesisID, UniqueID: UniqueID{0}})
 			if err != nil {
 				return err
 			}
@@ -67,7 +72,7 @@ func TestHandshake(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer conn.Close()
-	sess, err := DialSession(conn, genesisID, UniqueID{1})
+	sess, err := DialSession(conn, Header{GenesisID: genesisID, UniqueID: UniqueID{1}})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -88,3 +93,331 @@ func TestHandshake(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHandshakeContext(t *testing.T) {
+	genesisID := (&types.Block{}).ID()
+
+	// a peer that accepts connections, but never completes the handshake
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := DialSessionContext(ctx, conn, Header{GenesisID: genesisID, UniqueID: UniqueID{1}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected DeadlineExceeded, got", err)
+	} else if time.Since(start) > 5*time.Second {
+		t.Fatal("handshake was not aborted promptly")
+	}
+
+	// same for the accepting side: a peer that connects, but says nothing
+	conn2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := AcceptSessionContext(ctx, conn2, Header{GenesisID: genesisID, UniqueID: UniqueID{1}}); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected Canceled, got", err)
+	}
+}
+
+func TestAcceptStreamContext(t *testing.T) {
+	dialSess, sess := newTestSessions(t)
+
+	// the peer never opens a stream
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := sess.AcceptStreamContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected DeadlineExceeded, got", err)
+	}
+
+	// a stream should be unusable once its context expires (the stream must
+	// be written to before it can be read)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stream := dialSess.DialStreamContext(ctx)
+	defer stream.Close()
+	if _, err := stream.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected read on expired stream to fail")
+	}
+
+	// closing a stream should stop the goroutine watching its context
+	before := runtime.NumGoroutine()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		s := dialSess.DialStreamContext(ctx)
+		s.Write([]byte{1})
+		s.Close()
+	}
+	for start := time.Now(); runtime.NumGoroutine() > before; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("leaked %v goroutines", runtime.NumGoroutine()-before)
+		}
+	}
+}
+
+func TestAcceptStreamContextCancelled(t *testing.T) {
+	dialSess, sess := newTestSessions(t)
+
+	for _, accept := range []func() (*Stream, error){
+		sess.AcceptStream,
+		func() (*Stream, error) { return sess.AcceptStreamContext(context.Background()) },
+	} {
+		// give up on an accept before the peer opens a stream
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if _, err := sess.AcceptStreamContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected DeadlineExceeded, got", err)
+		}
+		cancel()
+
+		// the next stream should still be delivered
+		ds := dialSess.DialStream()
+		if _, err := ds.Write([]byte{1}); err != nil {
+			t.Fatal(err)
+		}
+		// ensure the abandoned accept receives the stream first
+		time.Sleep(50 * time.Millisecond)
+		stream, err := accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(stream, buf); err != nil {
+			t.Fatal(err)
+		} else if buf[0] != 1 {
+			t.Fatal("read wrong data from accepted stream")
+		}
+		stream.Close()
+		ds.Close()
+	}
+}
+
+func TestStreamDeadline(t *testing.T) {
+	dialer, acceptor := newTestSessions(t)
+
+	s := dialer.DialStream()
+	defer s.Close()
+	if _, err := s.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := acceptor.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if _, err := io.ReadFull(s2, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dialer sends nothing more, so the read should time out
+	s2.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	var ne net.Error
+	if _, err := s2.Read(make([]byte, 1)); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatal("expected timeout error, got", err)
+	} else if time.Since(start) > 5*time.Second {
+		t.Fatal("read deadline did not fire promptly")
+	}
+
+	// after clearing the deadline, the stream should still be usable
+	s2.SetReadDeadline(time.Time{})
+	if _, err := s.Write([]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(s2, buf); err != nil {
+		t.Fatal(err)
+	} else if buf[0] != 2 {
+		t.Fatal("read wrong data from stream")
+	}
+}
+
+func handshake(dialHeader, acceptHeader Header) (dialer, acceptor *Session, dialErr, acceptErr error) {
+	c1, c2 := net.Pipe()
+	type result struct {
+		sess *Session
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		sess, err := AcceptSession(c2, acceptHeader)
+		if err != nil {
+			c2.Close()
+		}
+		ch <- result{sess, err}
+	}()
+	dialer, dialErr = DialSession(c1, dialHeader)
+	if dialErr != nil {
+		c1.Close()
+	}
+	r := <-ch
+	return dialer, r.sess, dialErr, r.err
+}
+
+func newTestSessions(t *testing.T) (dialer, acceptor *Session) {
+	t.Helper()
+	genesisID := (&types.Block{}).ID()
+	dialer, acceptor, dialErr, acceptErr := handshake(
+		Header{GenesisID: genesisID, UniqueID: UniqueID{1}},
+		Header{GenesisID: genesisID, UniqueID: UniqueID{0}},
+	)
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	} else if acceptErr != nil {
+		t.Fatal(acceptErr)
+	}
+	// close the sessions if the test runs too long, so that a blocked call
+	// fails the test instead of hanging until the global test timeout
+	timer := time.AfterFunc(10*time.Second, func() { dialer.Close(); acceptor.Close() })
+	t.Cleanup(func() { timer.Stop(); dialer.Close(); acceptor.Close() })
+	return dialer, acceptor
+}
+
+func TestHandshakeNegotiation(t *testing.T) {
+	genesisID := (&types.Block{}).ID()
+
+	// matching versions; capabilities should be intersected
+	dialer, acceptor, dialErr, acceptErr := handshake(
+		Header{GenesisID: genesisID, UniqueID: UniqueID{1}, Capabilities: 0b011},
+		Header{GenesisID: genesisID, UniqueID: UniqueID{0}, Capabilities: 0b110},
+	)
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	} else if acceptErr != nil {
+		t.Fatal(acceptErr)
+	}
+	defer dialer.Close()
+	defer acceptor.Close()
+	for _, sess := range []*Session{dialer, acceptor} {
+		if sess.Version != protocolVersion {
+			t.Errorf("expected version %v, got %v", protocolVersion, sess.Version)
+		} else if sess.Capabilities != 0b010 {
+			t.Errorf("expected capabilities %b, got %b", 0b010, sess.Capabilities)
+		} else if !sess.Capabilities.Has(0b010) || sess.Capabilities.Has(0b001) {
+			t.Error("Has returned wrong result")
+		}
+	}
+
+	// a peer speaking an old version should be rejected
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		m, err := mux.DialAnonymous(c1)
+		if err != nil {
+			return
+		}
+		defer m.Close()
+		s := m.DialStream()
+		defer s.Close()
+		s.Write([]byte{minProtocolVersion - 1})
+		s.Read(make([]byte, 1))
+	}()
+	if _, err := AcceptSession(c2, Header{GenesisID: genesisID}); !errors.Is(err, ErrIncompatibleVersion) {
+		t.Fatal("expected ErrIncompatibleVersion, got", err)
+	}
+}
+
+func TestHandshakeWrongNetwork(t *testing.T) {
+	_, _, dialErr, acceptErr := handshake(
+		Header{GenesisID: types.BlockID{0}, UniqueID: UniqueID{1}},
+		Header{GenesisID: types.BlockID{1}, UniqueID: UniqueID{0}},
+	)
+	for _, err := range []error{dialErr, acceptErr} {
+		if !errors.Is(err, ErrWrongNetwork) || errors.Is(err, ErrIncompatibleVersion) {
+			t.Error("expected ErrWrongNetwork, got", err)
+		}
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	dialer, acceptor := newTestSessions(t)
+	acceptor.MaxStreams = 2
+	acceptor.MaxMessageSize = 10
+
+	// open one more stream than the limit. The mux delivers each frame
+	// synchronously, so every stream must be accepted and read before the
+	// next one can arrive.
+	buf := make([]byte, 1)
+	var dialed, accepted []*Stream
+	for i := 0; i < 3; i++ {
+		s := dialer.DialStream()
+		defer s.Close()
+		if _, err := s.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		dialed = append(dialed, s)
+		s2, err := acceptor.AcceptStream()
+		if i == 2 {
+			if !errors.Is(err, ErrTooManyStreams) {
+				t.Fatal("expected ErrTooManyStreams, got", err)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		defer s2.Close()
+		if _, err := io.ReadFull(s2, buf); err != nil {
+			t.Fatal(err)
+		} else if buf[0] != byte(i) {
+			t.Fatal("read wrong data from stream", i)
+		}
+		accepted = append(accepted, s2)
+	}
+
+	// existing streams should keep working
+	for i, s := range accepted {
+		buf[0] = byte(i)
+		if _, err := s.Write(buf); err != nil {
+			t.Fatal(err)
+		} else if _, err := io.ReadFull(dialed[i], buf); err != nil {
+			t.Fatal(err)
+		} else if buf[0] != byte(i) {
+			t.Fatal("read wrong data from stream", i)
+		}
+	}
+
+	// after closing a stream, a new one can be accepted
+	accepted[0].Close()
+	s := dialer.DialStream()
+	defer s.Close()
+	if _, err := s.Write(make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := acceptor.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+
+	// reading more than MaxMessageSize should fail
+	if _, err := io.ReadFull(s2, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	} else if _, err := s2.Read(buf); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatal("expected ErrMessageTooLarge, got", err)
+	}
+}
@@ -0,0 +1,43 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/v2/net/rpc"
+
+	"lukechampine.com/frand"
+)
+
+// RequestPeers uses the Peers RPC to request a list of peer addresses known to
+// the remote peer.
+func RequestPeers(stream io.ReadWriter) ([]string, error) {
+	var resp RPCPeersResponse
+	if err := rpc.WriteRequest(stream, RPCPeersID, &RPCPeersRequest{}); err != nil {
+		return nil, err
+	} else if err := rpc.ReadResponse(stream, &resp); err != nil {
+		return nil, fmt.Errorf("couldn't read peers response: %w", err)
+	}
+	return resp, nil
+}
+
+// ServePeers handles a Peers RPC, responding with a random sample of at most
+// MaxRPCPeersLen of the supplied peer addresses. The RPC ID must already have
+// been read from the stream.
+func ServePeers(stream io.ReadWriter, peers []string) error {
+	var req RPCPeersRequest
+	if err := rpc.ReadRequest(stream, &req); err != nil {
+		return fmt.Errorf("couldn't read peers request: %w", err)
+	}
+	resp := make(RPCPeersResponse, 0, len(peers))
+	for _, p := range peers {
+		if len(p) <= maxDomainLen {
+			resp = append(resp, p)
+		}
+	}
+	frand.Shuffle(len(resp), func(i, j int) { resp[i], resp[j] = resp[j], resp[i] })
+	if len(resp) > MaxRPCPeersLen {
+		resp = resp[:MaxRPCPeersLen]
+	}
+	return rpc.WriteResponse(stream, &resp)
+}
@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/v2/net/rpc"
+)
+
+func TestSharePeers(t *testing.T) {
+	dialer, acceptor := newTestSessions(t)
+
+	requestPeers := func(peers []string) ([]string, error) {
+		var m rpc.ServeMux
+		m.Handle(RPCPeersID, func(stream io.ReadWriter) error {
+			return ServePeers(stream, peers)
+		})
+		serveErr := make(chan error, 1)
+		go func() {
+			stream, err := acceptor.AcceptStream()
+			if err != nil {
+				serveErr <- err
+				return
+			}
+			defer stream.Close()
+			serveErr <- m.Serve(stream)
+		}()
+		stream := dialer.DialStream()
+		defer stream.Close()
+		resp, err := RequestPeers(stream)
+		if err := <-serveErr; err != nil {
+			t.Fatal(err)
+		}
+		return resp, err
+	}
+
+	// a small list should be returned in full (though perhaps reordered), minus
+	// any invalid addresses
+	peers := []string{"foo.bar:9981", "1.2.3.4:9981", "[::1]:9981", strings.Repeat("a", maxDomainLen+1)}
+	resp, err := requestPeers(peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(resp)
+	exp := append([]string(nil), peers[:3]...)
+	sort.Strings(exp)
+	if fmt.Sprint(resp) != fmt.Sprint(exp) {
+		t.Fatalf("expected %v, got %v", exp, resp)
+	}
+
+	// a large list should be capped
+	peers = peers[:0]
+	for i := 0; i < MaxRPCPeersLen*2; i++ {
+		peers = append(peers, fmt.Sprintf("1.2.3.%v:9981", i))
+	}
+	if resp, err := requestPeers(peers); err != nil {
+		t.Fatal(err)
+	} else if len(resp) != MaxRPCPeersLen {
+		t.Fatalf("expected %v peers, got %v", MaxRPCPeersLen, len(resp))
+	}
+}
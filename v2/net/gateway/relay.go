@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/v2/net/rpc"
+	"go.sia.tech/core/v2/types"
+)
+
+// RelayBlock sends b to the peer via the RelayBlock RPC. Relay RPCs have no
+// response.
+func RelayBlock(stream io.Writer, b types.Block) error {
+	return rpc.WriteRequest(stream, RPCRelayBlockID, &RPCRelayBlockRequest{Block: b})
+}
+
+// ReceiveBlock reads a block relayed via the RelayBlock RPC. The RPC ID must
+// already have been read from the stream.
+func ReceiveBlock(stream io.Reader) (types.Block, error) {
+	var req RPCRelayBlockRequest
+	if err := rpc.ReadRequest(stream, &req); err != nil {
+		return types.Block{}, fmt.Errorf("couldn't read relayed block: %w", err)
+	}
+	return req.Block, nil
+}
+
+// RelayTransaction sends txn to the peer via the RelayTxn RPC, along with any
+// unconfirmed transactions it depends on. Relay RPCs have no response.
+func RelayTransaction(stream io.Writer, txn types.Transaction, dependsOn []types.Transaction) error {
+	return rpc.WriteRequest(stream, RPCRelayTxnID, &RPCRelayTxnRequest{
+		Transaction: txn,
+		DependsOn:   dependsOn,
+	})
+}
+
+// ReceiveTransaction reads a transaction, and the transactions it depends on,
+// relayed via the RelayTxn RPC. The RPC ID must already have been read from the
+// stream.
+func ReceiveTransaction(stream io.Reader) (txn types.Transaction, dependsOn []types.Transaction, err error) {
+	var req RPCRelayTxnRequest
+	if err := rpc.ReadRequest(stream, &req); err != nil {
+		return types.Transaction{}, nil, fmt.Errorf("couldn't read relayed transaction: %w", err)
+	}
+	return req.Transaction, req.DependsOn, nil
+}
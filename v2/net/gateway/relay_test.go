@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/v2/consensus/consensustest"
+	"go.sia.tech/core/v2/net/rpc"
+	"go.sia.tech/core/v2/types"
+)
+
+func TestRelay(t *testing.T) {
+	dialer, acceptor := newTestSessions(t)
+
+	var relayedBlock types.Block
+	var relayedTxn types.Transaction
+	var relayedDeps []types.Transaction
+	var m rpc.ServeMux
+	m.Handle(RPCRelayBlockID, func(stream io.ReadWriter) (err error) {
+		relayedBlock, err = ReceiveBlock(stream)
+		return
+	})
+	m.Handle(RPCRelayTxnID, func(stream io.ReadWriter) (err error) {
+		relayedTxn, relayedDeps, err = ReceiveTransaction(stream)
+		return
+	})
+	relay := func(fn func(io.Writer) error) {
+		t.Helper()
+		serveErr := make(chan error, 1)
+		go func() {
+			stream, err := acceptor.AcceptStream()
+			if err != nil {
+				serveErr <- err
+				return
+			}
+			defer stream.Close()
+			serveErr <- m.Serve(stream)
+		}()
+		stream := dialer.DialStream()
+		defer stream.Close()
+		if err := fn(stream); err != nil {
+			t.Fatal(err)
+		} else if err := <-serveErr; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// relay a block containing a transaction
+	sim := consensustest.New()
+	parent := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: sim.Address(), Value: types.Siacoins(10)}},
+		MinerFee:       types.Siacoins(1),
+	}
+	sim.FundAndSign(&parent, types.Siacoins(10))
+	b := sim.MineBlock(parent)
+	relay(func(w io.Writer) error { return RelayBlock(w, b) })
+	if !reflect.DeepEqual(relayedBlock, b) {
+		t.Fatalf("relayed block does not match: expected %v, got %v", b, relayedBlock)
+	} else if relayedBlock.ID() != b.ID() {
+		t.Fatal("relayed block has different ID")
+	}
+
+	// relay a transaction along with its dependencies
+	child := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+	}
+	sim.FundAndSign(&child, types.Siacoins(1))
+	relay(func(w io.Writer) error { return RelayTransaction(w, child, []types.Transaction{parent}) })
+	if !reflect.DeepEqual(relayedTxn, child) {
+		t.Fatalf("relayed transaction does not match: expected %v, got %v", child, relayedTxn)
+	} else if !reflect.DeepEqual(relayedDeps, []types.Transaction{parent}) {
+		t.Fatalf("relayed dependencies do not match: expected %v, got %v", []types.Transaction{parent}, relayedDeps)
+	}
+}
@@ -15,6 +15,20 @@ const largeMaxLen = 1e6
 // MaxRPCPeersLen is the maximum number of peers that RPCPeers can return.
 const MaxRPCPeersLen = 100
 
+// encoded sizes of fixed-size slice elements, used to bound slice lengths
+var (
+	chainIndexLen  = types.EncodedLen(types.ChainIndex{})
+	blockHeaderLen = types.EncodedLen(types.BlockHeader{})
+)
+
+const maxDomainLen = 256 // See https://www.freesoft.org/CIE/RFC/1035/9.htm
+
+// maxRelayLen is the maximum encoded size of a relayed block or transaction
+// set. A transaction's weight is never less than its encoded size, so no valid
+// block (or set of transactions that fits in one) can exceed MaxBlockWeight,
+// plus some room for the block header.
+var maxRelayLen = int((consensus.State{}).MaxBlockWeight()) + 1024
+
 // RPC IDs
 var (
 	RPCPeersID      = rpc.NewSpecifier("Peers")
@@ -104,18 +118,12 @@ func (RPCPeersRequest) MaxLen() int { return 0 }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCHeadersRequest) EncodeTo(e *types.Encoder) {
-	e.WritePrefix(len(r.History))
-	for i := range r.History {
-		r.History[i].EncodeTo(e)
-	}
+	types.EncodeSlice(e, r.History)
 }
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCHeadersRequest) DecodeFrom(d *types.Decoder) {
-	r.History = make([]types.ChainIndex, d.ReadPrefix())
-	for i := range r.History {
-		r.History[i].DecodeFrom(d)
-	}
+	types.DecodeSlice(d, &r.History, defaultMaxLen/chainIndexLen)
 }
 
 // MaxLen implements rpc.Object.
@@ -123,18 +131,12 @@ func (RPCHeadersRequest) MaxLen() int { return defaultMaxLen }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCHeadersResponse) EncodeTo(e *types.Encoder) {
-	e.WritePrefix(len(r.Headers))
-	for i := range r.Headers {
-		r.Headers[i].EncodeTo(e)
-	}
+	types.EncodeSlice(e, r.Headers)
 }
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCHeadersResponse) DecodeFrom(d *types.Decoder) {
-	r.Headers = make([]types.BlockHeader, d.ReadPrefix())
-	for i := range r.Headers {
-		r.Headers[i].DecodeFrom(d)
-	}
+	types.DecodeSlice(d, &r.Headers, largeMaxLen/blockHeaderLen)
 }
 
 // MaxLen implements rpc.Object.
@@ -153,7 +155,12 @@ func (r *RPCPeersResponse) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCPeersResponse) DecodeFrom(d *types.Decoder) {
-	*r = make([]string, d.ReadPrefix())
+	n := d.ReadPrefix()
+	if n > MaxRPCPeersLen {
+		d.SetErr(fmt.Errorf("too many peers (%v > %v)", n, MaxRPCPeersLen))
+		return
+	}
+	*r = make([]string, n)
 	for i := range *r {
 		(*r)[i] = d.ReadString()
 	}
@@ -161,24 +168,17 @@ func (r *RPCPeersResponse) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (RPCPeersResponse) MaxLen() int {
-	const maxDomainLen = 256 // See https://www.freesoft.org/CIE/RFC/1035/9.htm
 	return 8 + MaxRPCPeersLen*maxDomainLen
 }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCBlocksRequest) EncodeTo(e *types.Encoder) {
-	e.WritePrefix(len(r.Blocks))
-	for i := range r.Blocks {
-		r.Blocks[i].EncodeTo(e)
-	}
+	types.EncodeSlice(e, r.Blocks)
 }
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCBlocksRequest) DecodeFrom(d *types.Decoder) {
-	r.Blocks = make([]types.ChainIndex, d.ReadPrefix())
-	for i := range r.Blocks {
-		r.Blocks[i].DecodeFrom(d)
-	}
+	types.DecodeSlice(d, &r.Blocks, defaultMaxLen/chainIndexLen)
 }
 
 // MaxLen implements rpc.Object.
@@ -244,7 +244,7 @@ func (r *RPCRelayBlockRequest) DecodeFrom(d *types.Decoder) {
 }
 
 // MaxLen implements rpc.Object.
-func (RPCRelayBlockRequest) MaxLen() int { return defaultMaxLen }
+func (RPCRelayBlockRequest) MaxLen() int { return maxRelayLen }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCRelayTxnRequest) EncodeTo(e *types.Encoder) {
@@ -265,4 +265,4 @@ func (r *RPCRelayTxnRequest) DecodeFrom(d *types.Decoder) {
 }
 
 // MaxLen implements rpc.Object.
-func (RPCRelayTxnRequest) MaxLen() int { return defaultMaxLen }
+func (RPCRelayTxnRequest) MaxLen() int { return maxRelayLen }
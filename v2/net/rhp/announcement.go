@@ -0,0 +1,49 @@
+package rhp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/v2/consensus"
+	"go.sia.tech/core/v2/types"
+)
+
+// AnnouncementKey is the attestation key used by hosts to announce their
+// network address.
+const AnnouncementKey = "HostAnnouncement"
+
+// maxNetAddressLen is the maximum length of an announced network address,
+// based on RFC 1035.
+const maxNetAddressLen = 256
+
+// AnnounceHost returns an attestation, signed by sk, announcing that the host
+// is reachable at netAddress.
+func AnnounceHost(cs consensus.State, sk types.PrivateKey, netAddress string) types.Attestation {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	e.WriteString(netAddress)
+	e.Flush()
+	return cs.Attest(sk, AnnouncementKey, buf.Bytes())
+}
+
+// ParseHostAnnouncement returns the network address announced by a. The host's
+// public key is a.PublicKey. ParseHostAnnouncement does not verify the
+// attestation's signature; use (consensus.State).VerifyAttestation for that.
+func ParseHostAnnouncement(a types.Attestation) (netAddress string, err error) {
+	if a.Key != AnnouncementKey {
+		return "", fmt.Errorf("attestation has key %q, not %q", a.Key, AnnouncementKey)
+	}
+	d := types.NewBufDecoder(a.Value)
+	netAddress = d.ReadString()
+	if err := d.Err(); err != nil {
+		return "", fmt.Errorf("failed to decode announcement: %w", err)
+	} else if 8+len(netAddress) != len(a.Value) {
+		return "", errors.New("announcement contains trailing bytes")
+	} else if len(netAddress) == 0 {
+		return "", errors.New("announcement contains empty net address")
+	} else if len(netAddress) > maxNetAddressLen {
+		return "", fmt.Errorf("announced net address exceeds maximum length (%v > %v)", len(netAddress), maxNetAddressLen)
+	}
+	return netAddress, nil
+}
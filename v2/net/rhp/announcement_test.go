@@ -0,0 +1,50 @@
+package rhp
+
+import (
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/v2/consensus/consensustest"
+	"go.sia.tech/core/v2/types"
+)
+
+func TestHostAnnouncement(t *testing.T) {
+	sim := consensustest.New()
+	_, hostKey := testingKeypair(0)
+
+	a := AnnounceHost(sim.State, hostKey, "foo.bar:9982")
+	if !sim.State.VerifyAttestation(a) {
+		t.Fatal("announcement has invalid signature")
+	}
+	// the announcement should be accepted by consensus
+	sim.MineBlock(types.Transaction{Attestations: []types.Attestation{a}})
+
+	netAddress, err := ParseHostAnnouncement(a)
+	if err != nil {
+		t.Fatal(err)
+	} else if netAddress != "foo.bar:9982" {
+		t.Fatalf("expected net address %q, got %q", "foo.bar:9982", netAddress)
+	} else if a.PublicKey != hostKey.PublicKey() {
+		t.Fatal("announcement has wrong public key")
+	}
+
+	// tampering with the announcement should invalidate its signature
+	tampered := a
+	tampered.Value = AnnounceHost(sim.State, hostKey, "evil.bar:9982").Value
+	if sim.State.VerifyAttestation(tampered) {
+		t.Fatal("tampered announcement should have invalid signature")
+	}
+
+	// malformed announcements should be rejected
+	for _, a := range []types.Attestation{
+		{Key: "foo", Value: a.Value},
+		{Key: AnnouncementKey, Value: a.Value[:len(a.Value)-1]},
+		{Key: AnnouncementKey, Value: append(append([]byte(nil), a.Value...), 0)},
+		AnnounceHost(sim.State, hostKey, ""),
+		AnnounceHost(sim.State, hostKey, strings.Repeat("a", maxNetAddressLen+1)),
+	} {
+		if _, err := ParseHostAnnouncement(a); err == nil {
+			t.Errorf("expected error parsing announcement %q", a.Value)
+		}
+	}
+}
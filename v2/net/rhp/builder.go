@@ -89,7 +89,7 @@ func (pb *ProgramBuilder) AddHasSectorInstruction(root types.Hash256) {
 
 // AddReadSectorInstruction adds a read sector instruction to the program.
 func (pb *ProgramBuilder) AddReadSectorInstruction(root types.Hash256, offset uint64, length uint64, proof bool) error {
-	if offset+length > SectorSize {
+	if length > SectorSize || offset > SectorSize-length {
 		return errors.New("read offset + length exceeds sector size")
 	}
 
@@ -151,12 +151,12 @@ func (pb *ProgramBuilder) AddRevisionInstruction() {
 func (pb *ProgramBuilder) AddSwapSectorInstruction(i, j uint64, proof bool) {
 	instr := &InstrSwapSector{
 		RootAOffset:   pb.offset,
-		RootBOffset:   pb.offset + 32,
+		RootBOffset:   pb.offset + 8,
 		ProofRequired: proof,
 	}
 	pb.encoder.WriteUint64(i)
 	pb.encoder.WriteUint64(j)
-	pb.offset += 64
+	pb.offset += 16
 	pb.appendInstruction(instr)
 	pb.addUsage(SwapSectorCost(pb.settings))
 }
@@ -2,6 +2,8 @@ package rhp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
 	"reflect"
 	"testing"
@@ -57,6 +59,115 @@ func TestAppendProgram(t *testing.T) {
 	}
 }
 
+func TestProgramCost(t *testing.T) {
+	var sector [SectorSize]byte
+	root := SectorRoot(&sector)
+
+	buf := bytes.NewBuffer(nil)
+	builder := NewProgramBuilder(testSettings, buf, 10)
+	builder.AddAppendSectorInstruction(&sector, true)
+	if err := builder.AddReadSectorInstruction(root, 64, 128, true); err != nil {
+		t.Fatal(err)
+	}
+	instructions, _, requiresFinalization, err := builder.Program()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(instructions) != 2 {
+		t.Fatal("wrong number of instructions")
+	} else if !requiresFinalization {
+		t.Fatal("program should require finalization")
+	}
+
+	// compute the cost manually
+	dataLen := uint64(SectorSize + 32 + 8 + 8)
+	if uint64(buf.Len()) != dataLen {
+		t.Fatalf("expected %v bytes of program data, got %v", dataLen, buf.Len())
+	}
+	exp := ExecutionCost(testSettings, dataLen, 2, true).
+		Add(AppendSectorCost(testSettings, 10)).
+		Add(ReadCost(testSettings, 128))
+	if got := builder.Cost(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected cost %v, got %v", exp, got)
+	}
+}
+
+func TestProgramCostMixed(t *testing.T) {
+	var sector [SectorSize]byte
+	frand.Read(sector[:])
+	root := SectorRoot(&sector)
+	value := randomRegistryValue(types.GeneratePrivateKey())
+
+	buf := bytes.NewBuffer(nil)
+	builder := NewProgramBuilder(testSettings, buf, 10)
+	builder.AddAppendSectorInstruction(&sector, true)
+	if err := builder.AddUpdateSectorInstruction(64, make([]byte, 256), true); err != nil {
+		t.Fatal(err)
+	}
+	builder.AddHasSectorInstruction(root)
+	if err := builder.AddReadSectorInstruction(root, 0, 4096, true); err != nil {
+		t.Fatal(err)
+	}
+	builder.AddReadOffsetInstruction(128, 512, true)
+	builder.AddSwapSectorInstruction(0, 1, true)
+	builder.AddDropSectorsInstruction(2, true)
+	builder.AddSectorRootsInstruction(7)
+	builder.AddRevisionInstruction()
+	builder.AddUpdateRegistryInstruction(value)
+	builder.AddReadRegistryInstruction(value.PublicKey, value.Tweak)
+	instructions, _, _, err := builder.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := ProgramCost(testSettings, instructions, buf.Bytes(), 10, 7)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := builder.Cost(); !reflect.DeepEqual(usage, exp) {
+		t.Fatalf("expected cost %v, got %v", exp, usage)
+	}
+
+	// truncated program data should be rejected
+	if _, err := ProgramCost(testSettings, instructions, buf.Bytes()[:buf.Len()-1], 10, 7); err == nil {
+		t.Fatal("expected error for truncated program data")
+	}
+}
+
+func TestProgramCostOverflow(t *testing.T) {
+	// an out-of-range read length must not panic when priced
+	settings := testSettings
+	settings.ProgReadCost = types.Siacoins(1)
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data[8:], ^uint64(0))
+	instrs := []Instruction{&InstrReadOffset{DataOffset: 0, LengthOffset: 8}}
+	if _, err := ProgramCost(settings, instrs, data, 10, 0); err == nil {
+		t.Fatal("expected error for maximum-length read")
+	} else if _, err := ProgramRefund(settings, instrs, data, 10, 0, 0); err == nil {
+		t.Fatal("expected error for maximum-length read")
+	}
+
+	// nor should valid arguments combined with extreme prices
+	huge := types.NewCurrency(0, 1<<62)
+	for _, test := range []struct {
+		desc   string
+		modify func(*HostSettings)
+		instr  Instruction
+		arg    uint64
+	}{
+		{"read", func(s *HostSettings) { s.ProgReadCost = huge }, &InstrReadOffset{DataOffset: 0, LengthOffset: 8}, SectorSize},
+		{"drop", func(s *HostSettings) { s.InstrDropSectorsUnitCost = huge }, &InstrDropSectors{SectorCountOffset: 8}, MaxDropSectors},
+		{"sum", func(s *HostSettings) { s.InstrReadBaseCost = types.NewCurrency(0, 1<<63) }, &InstrReadOffset{DataOffset: 0, LengthOffset: 8}, 1},
+	} {
+		settings := testSettings
+		test.modify(&settings)
+		data := make([]byte, 16)
+		binary.LittleEndian.PutUint64(data[8:], test.arg)
+		instrs := []Instruction{test.instr, test.instr}
+		if _, err := ProgramCost(settings, instrs, data, 10, 0); err == nil {
+			t.Errorf("expected overflowing %v cost to be rejected", test.desc)
+		}
+	}
+}
+
 func TestUpdateProgram(t *testing.T) {
 	offset := frand.Uint64n(SectorSize - 128)
 	data := make([]byte, 128)
@@ -230,6 +341,33 @@ func TestRegistryProgram(t *testing.T) {
 	}
 }
 
+func TestProofRequiredInstructions(t *testing.T) {
+	var sector [SectorSize]byte
+	frand.Read(sector[:128])
+	root := SectorRoot(&sector)
+
+	buf := bytes.NewBuffer(nil)
+	builder := NewProgramBuilder(testSettings, buf, 10)
+	builder.AddAppendSectorInstruction(&sector, true)
+	builder.AddHasSectorInstruction(root)
+	if err := builder.AddReadSectorInstruction(root, 0, 64, false); err != nil {
+		t.Fatal(err)
+	} else if err := builder.AddReadSectorInstruction(root, 64, 64, true); err != nil {
+		t.Fatal(err)
+	}
+	builder.AddRevisionInstruction()
+	builder.AddSwapSectorInstruction(0, 1, true)
+
+	instructions, _, _, err := builder.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indices := ProofRequiredInstructions(instructions)
+	if exp := []int{0, 3, 5}; !reflect.DeepEqual(indices, exp) {
+		t.Fatalf("expected proof indices %v, got %v", exp, indices)
+	}
+}
+
 func BenchmarkProgramBuilder(b *testing.B) {
 	var sector [SectorSize]byte
 	frand.Read(sector[:128])
@@ -244,3 +382,267 @@ func BenchmarkProgramBuilder(b *testing.B) {
 		builder.AddAppendSectorInstruction(&sector, true)
 	}
 }
+
+func TestValidateProgramResources(t *testing.T) {
+	var sector [SectorSize]byte
+	builder := NewProgramBuilder(testSettings, bytes.NewBuffer(nil), 10)
+	builder.AddAppendSectorInstruction(&sector, false)
+	single := builder.Cost()
+	builder.AddAppendSectorInstruction(&sector, false)
+	usage := builder.Cost()
+
+	// each instruction should contribute to the program's total usage
+	if usage.Memory <= single.Memory {
+		t.Fatalf("expected memory usage to increase, got %v and %v", single.Memory, usage.Memory)
+	}
+
+	tests := []struct {
+		limits HostResourceLimits
+		valid  bool
+	}{
+		{HostResourceLimits{}, true},
+		{HostResourceLimits{MaxMemory: usage.Memory, MaxTime: usage.Time}, true},
+		{HostResourceLimits{MaxMemory: single.Memory}, false},
+		{HostResourceLimits{MaxTime: usage.Time - 1}, false},
+	}
+	for _, test := range tests {
+		err := ValidateProgramResources(usage, test.limits)
+		if test.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", test.limits, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", test.limits)
+		}
+	}
+}
+
+func TestCostFunctionFields(t *testing.T) {
+	// each cost function should depend on the settings fields it purports to
+	// charge for
+	tests := []struct {
+		field string
+		cost  func(HostSettings) ResourceUsage
+	}{
+		{"ProgInitBaseCost", func(s HostSettings) ResourceUsage { return ExecutionCost(s, 64, 1, false) }},
+		{"ProgMemoryTimeCost", func(s HostSettings) ResourceUsage { return ExecutionCost(s, 64, 1, true) }},
+		{"InstrAppendSectorBaseCost", func(s HostSettings) ResourceUsage { return AppendSectorCost(s, 10) }},
+		{"ProgWriteCost", func(s HostSettings) ResourceUsage { return AppendSectorCost(s, 10) }},
+		{"StoragePrice", func(s HostSettings) ResourceUsage { return AppendSectorCost(s, 10) }},
+		{"Collateral", func(s HostSettings) ResourceUsage { return AppendSectorCost(s, 10) }},
+		{"ProgMemoryTimeCost", func(s HostSettings) ResourceUsage { return AppendSectorCost(s, 10) }},
+		{"InstrUpdateSectorBaseCost", func(s HostSettings) ResourceUsage { return UpdateSectorCost(s, 64) }},
+		{"InstrDropSectorsBaseCost", func(s HostSettings) ResourceUsage { return DropSectorsCost(s, 2) }},
+		{"InstrDropSectorsUnitCost", func(s HostSettings) ResourceUsage { return DropSectorsCost(s, 2) }},
+		{"InstrHasSectorBaseCost", HasSectorCost},
+		{"InstrReadBaseCost", func(s HostSettings) ResourceUsage { return ReadCost(s, 64) }},
+		{"ProgReadCost", func(s HostSettings) ResourceUsage { return ReadCost(s, 64) }},
+		{"InstrRevisionBaseCost", RevisionCost},
+		{"InstrSectorRootsBaseCost", func(s HostSettings) ResourceUsage { return SectorRootsCost(s, 4) }},
+		{"InstrSwapSectorBaseCost", SwapSectorCost},
+		{"InstrUpdateRegistryBaseCost", UpdateRegistryCost},
+		{"InstrReadRegistryBaseCost", ReadRegistryCost},
+	}
+	for _, test := range tests {
+		var settings HostSettings
+		zero := test.cost(settings)
+		f := reflect.ValueOf(&settings).Elem().FieldByName(test.field)
+		if !f.IsValid() {
+			t.Fatalf("HostSettings has no field %v", test.field)
+		}
+		f.Set(reflect.ValueOf(types.Siacoins(1)))
+		if reflect.DeepEqual(test.cost(settings), zero) {
+			t.Errorf("cost is unaffected by %v", test.field)
+		}
+	}
+}
+
+func TestValidateProgram(t *testing.T) {
+	var sector [SectorSize]byte
+	buf := bytes.NewBuffer(nil)
+	builder := NewProgramBuilder(testSettings, buf, 10)
+	builder.AddAppendSectorInstruction(&sector, true)
+	builder.AddHasSectorInstruction(types.Hash256{})
+	if err := builder.AddReadSectorInstruction(types.Hash256{}, 0, 64, true); err != nil {
+		t.Fatal(err)
+	}
+	builder.AddReadOffsetInstruction(0, 64, true)
+	builder.AddSwapSectorInstruction(0, 1, true)
+	builder.AddDropSectorsInstruction(1, true)
+	builder.AddUpdateRegistryInstruction(RegistryValue{Data: []byte("foo")})
+	builder.AddReadRegistryInstruction(types.PublicKey{}, types.Hash256{})
+	builder.AddRevisionInstruction()
+	builder.AddSectorRootsInstruction(1)
+	instrs, _, _, err := builder.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	dataLen := uint64(len(data))
+
+	// programs produced by the builder should be valid
+	if err := ValidateProgram(instrs, data, true); err != nil {
+		t.Fatal(err)
+	}
+	// ...but not without a contract
+	if err := ValidateProgram(instrs, data, false); err == nil {
+		t.Fatal("expected contract-requiring program to be rejected without a contract")
+	}
+	// a read-only program is fine without a contract
+	if err := ValidateProgram(instrs[1:2], data, false); err != nil {
+		t.Fatal(err)
+	}
+	// truncated data should be rejected
+	if err := ValidateProgram(instrs, data[:dataLen-1], true); err == nil {
+		t.Fatal("expected program with truncated data to be rejected")
+	}
+
+	// out-of-bounds offsets should be rejected
+	for _, instr := range []Instruction{
+		&InstrAppendSector{SectorDataOffset: dataLen - SectorSize + 1},
+		&InstrUpdateSector{Offset: 0, Length: 10, DataOffset: dataLen - 5},
+		&InstrUpdateSector{Offset: SectorSize - 5, Length: 10, DataOffset: 0},
+		&InstrDropSectors{SectorCountOffset: dataLen},
+		&InstrHasSector{SectorRootOffset: dataLen - 31},
+		&InstrReadOffset{DataOffset: 0, LengthOffset: dataLen - 7},
+		&InstrReadSector{RootOffset: 0, SectorOffset: 32, LengthOffset: 1 << 63},
+		&InstrSwapSector{RootAOffset: ^uint64(0), RootBOffset: 0},
+		&InstrReadRegistry{PublicKeyOffset: 0, TweakOffset: dataLen},
+		&InstrUpdateRegistry{EntryOffset: dataLen - minRegistryValueLen + 1},
+	} {
+		if err := ValidateProgram([]Instruction{instr}, data, true); err == nil {
+			t.Errorf("expected %T with out-of-bounds offsets to be rejected", instr)
+		}
+	}
+
+	// out-of-range argument values should be rejected
+	for _, test := range []struct {
+		desc  string
+		instr Instruction
+		args  []uint64
+	}{
+		{"zero-length offset read", &InstrReadOffset{DataOffset: 0, LengthOffset: 8}, []uint64{0, 0}},
+		{"oversized offset read", &InstrReadOffset{DataOffset: 0, LengthOffset: 8}, []uint64{0, SectorSize + 1}},
+		{"maximum-length offset read", &InstrReadOffset{DataOffset: 0, LengthOffset: 8}, []uint64{0, ^uint64(0)}},
+		{"offset read across sectors", &InstrReadOffset{DataOffset: 0, LengthOffset: 8}, []uint64{2*SectorSize - 64, 128}},
+		{"zero-length sector read", &InstrReadSector{RootOffset: 16, SectorOffset: 0, LengthOffset: 8}, []uint64{0, 0}},
+		{"oversized sector read", &InstrReadSector{RootOffset: 16, SectorOffset: 0, LengthOffset: 8}, []uint64{0, SectorSize + 1}},
+		{"sector read past end", &InstrReadSector{RootOffset: 16, SectorOffset: 0, LengthOffset: 8}, []uint64{SectorSize - 64, 128}},
+		{"sector read with overflowing offset", &InstrReadSector{RootOffset: 16, SectorOffset: 0, LengthOffset: 8}, []uint64{^uint64(0) - 63, 128}},
+		{"excessive sector drop", &InstrDropSectors{SectorCountOffset: 0}, []uint64{MaxDropSectors + 1}},
+	} {
+		data := make([]byte, 48)
+		for i, v := range test.args {
+			binary.LittleEndian.PutUint64(data[i*8:], v)
+		}
+		if err := ValidateProgram([]Instruction{test.instr}, data, true); err == nil {
+			t.Errorf("expected %v to be rejected", test.desc)
+		}
+	}
+}
+
+func TestResourceUsageSub(t *testing.T) {
+	a := AppendSectorCost(testSettings, 10)
+	b := ReadCost(testSettings, 4096)
+	if got := a.Add(b).Sub(b); !reflect.DeepEqual(got, a) {
+		t.Fatalf("expected %v, got %v", a, got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected underflow to panic")
+		}
+	}()
+	b.Sub(a)
+}
+
+func TestProgramRefund(t *testing.T) {
+	var sector [SectorSize]byte
+	root := SectorRoot(&sector)
+
+	buf := bytes.NewBuffer(nil)
+	builder := NewProgramBuilder(testSettings, buf, 10)
+	builder.AddAppendSectorInstruction(&sector, true)
+	builder.AddAppendSectorInstruction(&sector, true)
+	if err := builder.AddReadSectorInstruction(root, 0, 4096, true); err != nil {
+		t.Fatal(err)
+	}
+	instructions, _, _, err := builder.Program()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err := ProgramCost(testSettings, instructions, buf.Bytes(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataLen := uint64(buf.Len())
+	init := ExecutionCost(testSettings, dataLen, 3, false)
+	appendCost := AppendSectorCost(testSettings, 10)
+
+	// if nothing completed, everything but the initialization cost is refunded
+	if refund, err := ProgramRefund(testSettings, instructions, buf.Bytes(), 10, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if exp := total.Sub(init); !reflect.DeepEqual(refund, exp) {
+		t.Fatalf("expected refund %v, got %v", exp, refund)
+	}
+
+	// after the first append, its storage is refunded, but not its base cost
+	refund, err := ProgramRefund(testSettings, instructions, buf.Bytes(), 10, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := total.Sub(init).Sub(appendCost)
+	exp.StorageCost = exp.StorageCost.Add(appendCost.StorageCost)
+	exp.AdditionalCollateral = exp.AdditionalCollateral.Add(appendCost.AdditionalCollateral)
+	if !reflect.DeepEqual(refund, exp) {
+		t.Fatalf("expected refund %v, got %v", exp, refund)
+	} else if !refund.StorageCost.Equals(total.StorageCost) {
+		t.Fatalf("expected all storage (%v) to be refunded, got %v", total.StorageCost, refund.StorageCost)
+	}
+
+	// even if every instruction completed, a failed program is not finalized
+	refund, err = ProgramRefund(testSettings, instructions, buf.Bytes(), 10, 0, len(instructions))
+	if err != nil {
+		t.Fatal(err)
+	} else if !refund.BaseCost.Equals(finalizationCost(testSettings).BaseCost) {
+		t.Fatalf("expected finalization cost %v to be refunded, got %v", finalizationCost(testSettings).BaseCost, refund.BaseCost)
+	} else if !refund.StorageCost.Equals(total.StorageCost) {
+		t.Fatalf("expected all storage (%v) to be refunded, got %v", total.StorageCost, refund.StorageCost)
+	}
+
+	if _, err := ProgramRefund(testSettings, instructions, buf.Bytes(), 10, 0, len(instructions)+1); err == nil {
+		t.Fatal("expected error for out-of-range completed count")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	usage := AppendSectorCost(testSettings, 10)
+	cost := usage.BaseCost.Add(usage.StorageCost)
+
+	// the budget covers exactly three appends
+	b := NewBudget(cost.Mul64(3))
+	for i := 0; i < 3; i++ {
+		if err := b.Spend(usage); err != nil {
+			t.Fatal(err)
+		} else if exp := cost.Mul64(uint64(2 - i)); !b.Remaining().Equals(exp) {
+			t.Fatalf("expected %v remaining, got %v", exp, b.Remaining())
+		}
+	}
+	if err := b.Spend(usage); !errors.Is(err, ErrInsufficientBudget) {
+		t.Fatal("expected ErrInsufficientBudget, got", err)
+	} else if !b.Remaining().IsZero() {
+		t.Fatal("failed spend should not modify budget")
+	}
+
+	// a failed spend leaves the budget intact for cheaper instructions
+	b = NewBudget(cost.Sub(types.NewCurrency64(1)))
+	if err := b.Spend(usage); !errors.Is(err, ErrInsufficientBudget) {
+		t.Fatal("expected ErrInsufficientBudget, got", err)
+	} else if !b.Remaining().Equals(cost.Sub(types.NewCurrency64(1))) {
+		t.Fatal("failed spend should not modify budget")
+	} else if err := b.Spend(HasSectorCost(testSettings)); err != nil {
+		t.Fatal(err)
+	}
+
+	// collateral is not paid from the budget
+	if err := NewBudget(types.ZeroCurrency).Spend(ResourceUsage{AdditionalCollateral: types.Siacoins(1)}); err != nil {
+		t.Fatal(err)
+	}
+}
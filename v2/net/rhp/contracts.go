@@ -63,6 +63,29 @@ func FinalizeProgramRevision(fc types.FileContract, burn types.Currency) (types.
 	return fc, nil
 }
 
+// PrepareContractRenewal returns a renewal that finalizes fc and replaces it
+// with renewed, rolling over the specified amounts from the old contract's
+// outputs into the new contract. The final revision's revision number is set
+// to the maximum and the initial revision's to zero. Both revisions, as well as
+// the renewal itself, must still be signed by the renter and host.
+func PrepareContractRenewal(fc, renewed types.FileContract, renterRollover, hostRollover types.Currency) (types.FileContractRenewal, error) {
+	if fc.RenterOutput.Value.Cmp(renterRollover) < 0 {
+		return types.FileContractRenewal{}, errors.New("renter rollover exceeds renter output")
+	} else if fc.HostOutput.Value.Cmp(hostRollover) < 0 {
+		return types.FileContractRenewal{}, errors.New("host rollover exceeds host output")
+	}
+	fc.RevisionNumber = types.MaxRevisionNumber
+	fc.RenterSignature, fc.HostSignature = types.Signature{}, types.Signature{}
+	renewed.RevisionNumber = 0
+	renewed.RenterSignature, renewed.HostSignature = types.Signature{}, types.Signature{}
+	return types.FileContractRenewal{
+		FinalRevision:   fc,
+		InitialRevision: renewed,
+		RenterRollover:  renterRollover,
+		HostRollover:    hostRollover,
+	}, nil
+}
+
 // ValidateContractSignatures validates a contract's renter and host signatures.
 func ValidateContractSignatures(cs consensus.State, fc types.FileContract) (err error) {
 	hash := cs.ContractSigHash(fc)
@@ -160,7 +183,7 @@ func ValidateContractFinalization(current, final types.FileContract) error {
 		return errors.New("renter public key must not change")
 	case current.HostPublicKey != final.HostPublicKey:
 		return errors.New("host public key must not change")
-	case final.RevisionNumber != types.MaxRevisionNumber:
+	case !final.IsFinalized():
 		return errors.New("revision number must be max value")
 	}
 	return nil
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"go.sia.tech/core/v2/consensus"
+	"go.sia.tech/core/v2/consensus/consensustest"
 	"go.sia.tech/core/v2/types"
 )
 
@@ -395,6 +396,116 @@ func TestValidateContractRenewalFinalization(t *testing.T) {
 	}
 }
 
+func TestPrepareContractRenewal(t *testing.T) {
+	sim := consensustest.New()
+	renterPubkey, renterPrivkey := testingKeypair(0)
+	hostPubkey, hostPrivkey := testingKeypair(1)
+	height := sim.State.Index.Height
+	fce := sim.FormContract(types.FileContract{
+		WindowStart:     height + 10,
+		WindowEnd:       height + 20,
+		RenterOutput:    types.SiacoinOutput{Address: types.StandardAddress(renterPubkey), Value: types.Siacoins(50)},
+		HostOutput:      types.SiacoinOutput{Address: types.StandardAddress(hostPubkey), Value: types.Siacoins(20)},
+		MissedHostValue: types.Siacoins(20),
+		TotalCollateral: types.Siacoins(10),
+	}, renterPrivkey, hostPrivkey)
+
+	renewed := fce.FileContract
+	renewed.WindowStart += 10
+	renewed.WindowEnd += 10
+	renewed.RenterOutput.Value = types.Siacoins(60)
+	renewed.HostOutput.Value = types.Siacoins(30)
+	renewed.MissedHostValue = types.Siacoins(30)
+	renewed.TotalCollateral = types.Siacoins(15)
+	if _, err := PrepareContractRenewal(fce.FileContract, renewed, types.Siacoins(51), types.ZeroCurrency); err == nil {
+		t.Fatal("expected error when renter rollover exceeds renter output")
+	} else if _, err := PrepareContractRenewal(fce.FileContract, renewed, types.ZeroCurrency, types.Siacoins(21)); err == nil {
+		t.Fatal("expected error when host rollover exceeds host output")
+	}
+	renewal, err := PrepareContractRenewal(fce.FileContract, renewed, types.Siacoins(40), types.Siacoins(10))
+	if err != nil {
+		t.Fatal(err)
+	} else if renewal.FinalRevision.RevisionNumber != types.MaxRevisionNumber {
+		t.Fatal("final revision should have maximum revision number")
+	} else if renewal.InitialRevision.RevisionNumber != 0 {
+		t.Fatal("initial revision should have revision number 0")
+	}
+
+	sign := func(renewal *types.FileContractRenewal) {
+		for _, fc := range []*types.FileContract{&renewal.FinalRevision, &renewal.InitialRevision} {
+			sigHash := sim.State.ContractSigHash(*fc)
+			fc.RenterSignature = renterPrivkey.SignHash(sigHash)
+			fc.HostSignature = hostPrivkey.SignHash(sigHash)
+		}
+		sigHash := sim.State.RenewalSigHash(*renewal)
+		renewal.RenterSignature = renterPrivkey.SignHash(sigHash)
+		renewal.HostSignature = hostPrivkey.SignHash(sigHash)
+	}
+	renewalTxn := func(renewal types.FileContractRenewal) types.Transaction {
+		txn := types.Transaction{
+			FileContractResolutions: []types.FileContractResolution{{
+				Parent:  fce,
+				Renewal: renewal,
+			}},
+		}
+		rollover := renewal.RenterRollover.Add(renewal.HostRollover)
+		cost := renewal.InitialRevision.RenterOutput.Value.Add(renewal.InitialRevision.HostOutput.Value).Add(sim.State.FileContractTax(renewal.InitialRevision))
+		sim.FundAndSign(&txn, cost.Sub(rollover))
+		return txn
+	}
+
+	sign(&renewal)
+	if err := sim.State.ValidateTransaction(renewalTxn(renewal)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc   string
+		resign bool
+		tamper func(*types.FileContractRenewal)
+	}{
+		{"modified renter rollover", false, func(r *types.FileContractRenewal) {
+			r.RenterRollover = r.RenterRollover.Sub(types.Siacoins(1))
+		}},
+		{"modified initial revision", false, func(r *types.FileContractRenewal) {
+			r.InitialRevision.WindowEnd++
+		}},
+		{"non-final final revision", true, func(r *types.FileContractRenewal) {
+			r.FinalRevision.RevisionNumber = fce.RevisionNumber + 1
+		}},
+		{"initial revision with invalid proof window", true, func(r *types.FileContractRenewal) {
+			r.InitialRevision.WindowEnd = r.InitialRevision.WindowStart
+		}},
+		{"final revision that modifies output sum", true, func(r *types.FileContractRenewal) {
+			r.FinalRevision.RenterOutput.Value = r.FinalRevision.RenterOutput.Value.Add(types.Siacoins(1))
+		}},
+		{"rollover exceeding old outputs", true, func(r *types.FileContractRenewal) {
+			r.HostRollover = r.FinalRevision.HostOutput.Value.Add(types.Siacoins(1))
+		}},
+	}
+	for _, test := range tests {
+		tampered := renewal
+		test.tamper(&tampered)
+		if test.resign {
+			sign(&tampered)
+		}
+		if err := sim.State.ValidateTransaction(renewalTxn(tampered)); err == nil {
+			t.Fatalf("accepted renewal with %v", test.desc)
+		}
+	}
+
+	// mine the valid renewal and check that the new contract was created
+	txn := renewalTxn(renewal)
+	sim.MineBlock(txn)
+	if _, ok := sim.FileContractElement(fce.ID); ok {
+		t.Fatal("old contract should be resolved")
+	} else if fc, ok := sim.FileContractElement(txn.FileContractID(0)); !ok {
+		t.Fatal("renewed contract should exist")
+	} else if fc.WindowEnd != renewed.WindowEnd {
+		t.Fatal("renewed contract has wrong window end")
+	}
+}
+
 func TestValidateContractRevision(t *testing.T) {
 	currentHeight := uint64(5)
 	settings := HostSettings{
@@ -1,6 +1,11 @@
 package rhp
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
 	"go.sia.tech/core/v2/net/rpc"
 	"go.sia.tech/core/v2/types"
 )
@@ -85,6 +90,45 @@ func InstructionRequiresFinalization(i Instruction) bool {
 	panic("unahndled instruction")
 }
 
+// InstructionRequiresProof returns true if the host will return a proof for
+// the instruction's output.
+func InstructionRequiresProof(i Instruction) bool {
+	switch i := i.(type) {
+	case *InstrAppendSector:
+		return i.ProofRequired
+	case *InstrUpdateSector:
+		return i.ProofRequired
+	case *InstrDropSectors:
+		return i.ProofRequired
+	case *InstrReadOffset:
+		return i.ProofRequired
+	case *InstrReadSector:
+		return i.ProofRequired
+	case *InstrSwapSector:
+		return i.ProofRequired
+	case *InstrContractRevision,
+		*InstrSectorRoots,
+		*InstrHasSector,
+		*InstrReadRegistry,
+		*InstrUpdateRegistry:
+		return false
+	}
+	panic("unahndled instruction")
+}
+
+// ProofRequiredInstructions returns the indices of the instructions that will
+// return a proof. Renters can use this to determine how many proofs to expect
+// when parsing the host's output.
+func ProofRequiredInstructions(instrs []Instruction) []int {
+	var indices []int
+	for i, instr := range instrs {
+		if InstructionRequiresProof(instr) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
 // InstrAppendSector uploads and appends a new sector to a contract
 type InstrAppendSector struct {
 	SectorDataOffset uint64
@@ -226,7 +270,9 @@ func (i *InstrHasSector) DecodeFrom(d *types.Decoder) {
 	i.SectorRootOffset = d.ReadUint64()
 }
 
-// InstrReadOffset reads len bytes from the contract at the given offset.
+// InstrReadOffset reads len bytes from the contract at the given offset. The
+// range must lie within a single sector, and its proof is relative to that
+// sector's root, as with InstrReadSector.
 type InstrReadOffset struct {
 	DataOffset    uint64
 	LengthOffset  uint64
@@ -360,6 +406,160 @@ func (i *InstrUpdateRegistry) DecodeFrom(d *types.Decoder) {
 	i.EntryOffset = d.ReadUint64()
 }
 
+// VerifyAppendSectorOutput verifies the proof returned by the host for an
+// InstrAppendSector with ProofRequired set. numSectors and oldRoot describe the
+// contract prior to the append, and sectorRoot is the root of the appended
+// sector; the proof must show that resp.NewMerkleRoot is the contract's new
+// root.
+func VerifyAppendSectorOutput(resp *RPCExecuteInstrResponse, numSectors uint64, oldRoot, sectorRoot types.Hash256) bool {
+	return VerifyAppendProof(numSectors, resp.Proof, sectorRoot, oldRoot, resp.NewMerkleRoot)
+}
+
+// VerifySwapSectorOutput verifies the proof returned by the host for an
+// InstrSwapSector with ProofRequired set. numSectors and oldRoot describe the
+// contract prior to the swap, and rootI and rootJ are the roots of the sectors
+// at indices i and j prior to the swap.
+func VerifySwapSectorOutput(resp *RPCExecuteInstrResponse, numSectors, i, j uint64, rootI, rootJ, oldRoot types.Hash256) bool {
+	return VerifySwapProof(numSectors, i, j, resp.Proof, rootI, rootJ, oldRoot, resp.NewMerkleRoot)
+}
+
+// VerifyUpdateSectorOutput verifies the proof returned by the host for an
+// InstrUpdateSector with ProofRequired set. numSectors and oldRoot describe the
+// contract prior to the update, and data must have been written at offset
+// within the contract. Proofs can only be verified for updates aligned to
+// LeafSize.
+func VerifyUpdateSectorOutput(resp *RPCExecuteInstrResponse, numSectors, offset uint64, data []byte, oldRoot types.Hash256) bool {
+	return VerifyUpdateProof(numSectors, offset, data, resp.Proof, oldRoot, resp.NewMerkleRoot)
+}
+
+// VerifyReadSectorOutput verifies the data and proof returned by the host for
+// an InstrReadSector or InstrReadOffset with ProofRequired set. data must have
+// been read from offset within the sector with root sectorRoot; for
+// InstrReadOffset, this is the contract offset modulo SectorSize. Proofs can
+// only be verified for reads aligned to LeafSize.
+func VerifyReadSectorOutput(resp *RPCExecuteInstrResponse, data []byte, sectorRoot types.Hash256, offset uint64) bool {
+	length := uint64(len(data))
+	if offset%LeafSize != 0 || length%LeafSize != 0 || length == 0 || offset > SectorSize || length > SectorSize-offset {
+		return false
+	}
+	rpv := NewRangeProofVerifier(offset/LeafSize, (offset+length)/LeafSize)
+	if _, err := rpv.ReadFrom(bytes.NewReader(data)); err != nil {
+		return false
+	}
+	return rpv.Verify(resp.Proof, sectorRoot)
+}
+
+// SectorRootsOutput is the output of an InstrSectorRoots instruction.
+type SectorRootsOutput struct {
+	SectorRoots []types.Hash256
+}
+
+// EncodeTo implements types.EncoderTo.
+func (o *SectorRootsOutput) EncodeTo(e *types.Encoder) {
+	writeMerkleProof(e, o.SectorRoots)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (o *SectorRootsOutput) DecodeFrom(d *types.Decoder) {
+	o.SectorRoots = readMerkleProof(d)
+}
+
+// Verify checks that the sector roots returned by the host match the contract:
+// their Merkle root must equal fc.FileMerkleRoot, and there must be one root for
+// each sector in fc.Filesize.
+func (o *SectorRootsOutput) Verify(fc types.FileContract) error {
+	if n := uint64(len(o.SectorRoots)); n*SectorSize != fc.Filesize {
+		return fmt.Errorf("host returned %v sector roots, but contract contains %v bytes", n, fc.Filesize)
+	} else if root := MetaRoot(o.SectorRoots); root != fc.FileMerkleRoot {
+		return fmt.Errorf("sector roots have Merkle root %v, but contract has %v", root, fc.FileMerkleRoot)
+	}
+	return nil
+}
+
+// minRegistryValueLen is the encoded length of a RegistryValue with no data.
+const minRegistryValueLen = 32 + 8 + 8 + 1 + 32 + 64
+
+// MaxDropSectors is the maximum number of sectors that a single
+// InstrDropSectors may remove.
+const MaxDropSectors = 1 << 24
+
+// ValidateProgram checks that each instruction's arguments lie within the
+// program data, that the values they reference are in range, and that
+// instructions requiring a contract are only used if hasContract is true. Read
+// lengths must be non-zero and no larger than SectorSize, reads must not
+// extend past the end of a sector, and at most MaxDropSectors sectors may be
+// dropped at once. Hosts should call it before executing a program.
+func ValidateProgram(instrs []Instruction, data []byte, hasContract bool) error {
+	type arg struct {
+		name   string
+		offset uint64
+		length uint64
+	}
+	dataLen := uint64(len(data))
+	readUint64 := func(offset uint64) uint64 {
+		return binary.LittleEndian.Uint64(data[offset:])
+	}
+	for i, instr := range instrs {
+		if !hasContract && InstructionRequiresContract(instr) {
+			return fmt.Errorf("instruction %v (%T) requires a contract", i, instr)
+		}
+		var args []arg
+		switch instr := instr.(type) {
+		case *InstrAppendSector:
+			args = []arg{{"sector data", instr.SectorDataOffset, SectorSize}}
+		case *InstrUpdateSector:
+			if instr.Offset > SectorSize || instr.Length > SectorSize-instr.Offset {
+				return fmt.Errorf("instruction %v (%T) updates beyond end of sector", i, instr)
+			}
+			args = []arg{{"update data", instr.DataOffset, instr.Length}}
+		case *InstrDropSectors:
+			args = []arg{{"sector count", instr.SectorCountOffset, 8}}
+		case *InstrHasSector:
+			args = []arg{{"sector root", instr.SectorRootOffset, 32}}
+		case *InstrReadOffset:
+			args = []arg{{"offset", instr.DataOffset, 8}, {"length", instr.LengthOffset, 8}}
+		case *InstrReadSector:
+			args = []arg{{"sector root", instr.RootOffset, 32}, {"offset", instr.SectorOffset, 8}, {"length", instr.LengthOffset, 8}}
+		case *InstrSwapSector:
+			args = []arg{{"sector A", instr.RootAOffset, 8}, {"sector B", instr.RootBOffset, 8}}
+		case *InstrReadRegistry:
+			args = []arg{{"public key", instr.PublicKeyOffset, 32}, {"tweak", instr.TweakOffset, 32}}
+		case *InstrUpdateRegistry:
+			args = []arg{{"registry entry", instr.EntryOffset, minRegistryValueLen}}
+		case *InstrContractRevision, *InstrSectorRoots:
+		default:
+			return fmt.Errorf("instruction %v has unknown type %T", i, instr)
+		}
+		for _, a := range args {
+			if a.offset > dataLen || a.length > dataLen-a.offset {
+				return fmt.Errorf("instruction %v (%T) %v [%v, %v) exceeds program data length %v", i, instr, a.name, a.offset, a.offset+a.length, dataLen)
+			}
+		}
+
+		switch instr := instr.(type) {
+		case *InstrDropSectors:
+			if n := readUint64(instr.SectorCountOffset); n > MaxDropSectors {
+				return fmt.Errorf("instruction %v (%T) drops %v sectors, exceeding limit of %v", i, instr, n, uint64(MaxDropSectors))
+			}
+		case *InstrReadOffset:
+			offset, length := readUint64(instr.DataOffset), readUint64(instr.LengthOffset)
+			if length == 0 || length > SectorSize {
+				return fmt.Errorf("instruction %v (%T) has invalid read length %v", i, instr, length)
+			} else if offset%SectorSize > SectorSize-length {
+				return fmt.Errorf("instruction %v (%T) reads across a sector boundary", i, instr)
+			}
+		case *InstrReadSector:
+			offset, length := readUint64(instr.SectorOffset), readUint64(instr.LengthOffset)
+			if length == 0 || length > SectorSize {
+				return fmt.Errorf("instruction %v (%T) has invalid read length %v", i, instr, length)
+			} else if offset > SectorSize-length {
+				return fmt.Errorf("instruction %v (%T) reads beyond end of sector", i, instr)
+			}
+		}
+	}
+	return nil
+}
+
 // ResourceUsage is the associated costs of executing an instruction set or
 // individual instruction.
 type ResourceUsage struct {
@@ -383,11 +583,80 @@ func (r ResourceUsage) Add(b ResourceUsage) (c ResourceUsage) {
 	c.StorageCost = r.StorageCost.Add(b.StorageCost)
 	c.AdditionalCollateral = r.AdditionalCollateral.Add(b.AdditionalCollateral)
 
-	c.Memory += b.Memory
-	c.Time += b.Time
+	c.Memory = r.Memory + b.Memory
+	c.Time = r.Time + b.Time
+	return c
+}
+
+// Sub returns the difference of r and b. It panics if any field of b exceeds
+// the corresponding field of r.
+func (r ResourceUsage) Sub(b ResourceUsage) (c ResourceUsage) {
+	if r.Memory < b.Memory || r.Time < b.Time {
+		panic("underflow")
+	}
+	c.BaseCost = r.BaseCost.Sub(b.BaseCost)
+	c.StorageCost = r.StorageCost.Sub(b.StorageCost)
+	c.AdditionalCollateral = r.AdditionalCollateral.Sub(b.AdditionalCollateral)
+
+	c.Memory = r.Memory - b.Memory
+	c.Time = r.Time - b.Time
 	return c
 }
 
+// ErrInsufficientBudget is returned by (*Budget).Spend when the cost of an
+// instruction exceeds the remaining budget.
+var ErrInsufficientBudget = errors.New("insufficient budget")
+
+// A Budget tracks the funds remaining to a program as it executes. Only the
+// BaseCost and StorageCost of a ResourceUsage are paid by the renter, so only
+// those are deducted; AdditionalCollateral is provided by the host.
+type Budget struct {
+	remaining types.Currency
+}
+
+// Remaining returns the unspent portion of the budget.
+func (b *Budget) Remaining() types.Currency {
+	return b.remaining
+}
+
+// Spend deducts the cost of usage from the budget. If the cost exceeds the
+// remaining budget, ErrInsufficientBudget is returned and the budget is left
+// unchanged.
+func (b *Budget) Spend(usage ResourceUsage) error {
+	cost := usage.BaseCost.Add(usage.StorageCost)
+	if cost.Cmp(b.remaining) > 0 {
+		return fmt.Errorf("%w: cost %v exceeds remaining %v", ErrInsufficientBudget, cost, b.remaining)
+	}
+	b.remaining = b.remaining.Sub(cost)
+	return nil
+}
+
+// NewBudget returns a Budget containing the supplied funds.
+func NewBudget(funds types.Currency) *Budget {
+	return &Budget{remaining: funds}
+}
+
+// HostResourceLimits are the maximum resources a host is willing to allocate
+// to a single program. Unlike HostSettings, they are local to the host and are
+// not advertised to renters. A zero value indicates no limit.
+type HostResourceLimits struct {
+	MaxMemory uint64
+	MaxTime   uint64
+}
+
+// ValidateProgramResources returns an error if usage exceeds any of the
+// specified limits. Hosts should call it with the total usage of a program
+// before executing it.
+func ValidateProgramResources(usage ResourceUsage, limits HostResourceLimits) error {
+	switch {
+	case limits.MaxMemory != 0 && usage.Memory > limits.MaxMemory:
+		return fmt.Errorf("program requires %v bytes of memory, exceeding limit of %v", usage.Memory, limits.MaxMemory)
+	case limits.MaxTime != 0 && usage.Time > limits.MaxTime:
+		return fmt.Errorf("program requires %v units of time, exceeding limit of %v", usage.Time, limits.MaxTime)
+	}
+	return nil
+}
+
 // resourceCost returns the cost of a program with the given data and time
 func resourceCost(settings HostSettings, memory, time uint64) types.Currency {
 	return settings.ProgMemoryTimeCost.Mul64(memory * time)
@@ -515,3 +784,124 @@ func ReadRegistryCost(settings HostSettings) (costs ResourceUsage) {
 	costs.StorageCost = settings.StoragePrice.Mul64(256 * 10 * blocksPerYear)
 	return
 }
+
+// linearCostOverflows reports whether base + n*unit overflows.
+func linearCostOverflows(base, unit types.Currency, n uint64) bool {
+	c, overflow := unit.Mul64WithOverflow(n)
+	if !overflow {
+		_, overflow = c.AddWithOverflow(base)
+	}
+	return overflow
+}
+
+// instructionCost returns the cost of executing a single instruction. The
+// instruction's arguments must already have been validated against data. Since
+// read lengths and sector counts are supplied by the renter, an error is
+// returned if pricing them would overflow.
+func instructionCost(settings HostSettings, instr Instruction, data []byte, duration, sectors uint64) (ResourceUsage, error) {
+	readUint64 := func(offset uint64) uint64 {
+		return binary.LittleEndian.Uint64(data[offset:])
+	}
+	readCost := func(length uint64) (ResourceUsage, error) {
+		if linearCostOverflows(settings.InstrReadBaseCost, settings.ProgReadCost, length) {
+			return ResourceUsage{}, fmt.Errorf("cost of reading %v bytes overflows", length)
+		}
+		return ReadCost(settings, length), nil
+	}
+	switch instr := instr.(type) {
+	case *InstrAppendSector:
+		return AppendSectorCost(settings, duration), nil
+	case *InstrUpdateSector:
+		return UpdateSectorCost(settings, instr.Length), nil
+	case *InstrDropSectors:
+		n := readUint64(instr.SectorCountOffset)
+		if linearCostOverflows(settings.InstrDropSectorsBaseCost, settings.InstrDropSectorsUnitCost, n) {
+			return ResourceUsage{}, fmt.Errorf("cost of dropping %v sectors overflows", n)
+		}
+		return DropSectorsCost(settings, n), nil
+	case *InstrHasSector:
+		return HasSectorCost(settings), nil
+	case *InstrReadOffset:
+		return readCost(readUint64(instr.LengthOffset))
+	case *InstrReadSector:
+		return readCost(readUint64(instr.LengthOffset))
+	case *InstrContractRevision:
+		return RevisionCost(settings), nil
+	case *InstrSectorRoots:
+		return SectorRootsCost(settings, sectors), nil
+	case *InstrSwapSector:
+		return SwapSectorCost(settings), nil
+	case *InstrUpdateRegistry:
+		return UpdateRegistryCost(settings), nil
+	case *InstrReadRegistry:
+		return ReadRegistryCost(settings), nil
+	}
+	return ResourceUsage{}, nil
+}
+
+// addUsage returns the sum of a and b, or an error if any cost overflows.
+func addUsage(a, b ResourceUsage) (ResourceUsage, error) {
+	var c ResourceUsage
+	var o1, o2, o3 bool
+	c.BaseCost, o1 = a.BaseCost.AddWithOverflow(b.BaseCost)
+	c.StorageCost, o2 = a.StorageCost.AddWithOverflow(b.StorageCost)
+	c.AdditionalCollateral, o3 = a.AdditionalCollateral.AddWithOverflow(b.AdditionalCollateral)
+	if o1 || o2 || o3 {
+		return ResourceUsage{}, errors.New("program cost overflows")
+	}
+	c.Memory = a.Memory + b.Memory
+	c.Time = a.Time + b.Time
+	return c, nil
+}
+
+// ProgramCost returns the cost of executing the given program, excluding
+// bandwidth usage. Instruction arguments such as read lengths and sector
+// counts are read from the program data. Since the number of sectors in the
+// contract is not part of the program, it must be supplied by the caller to
+// price InstrSectorRoots. An error is returned if the program is invalid or
+// its cost overflows.
+func ProgramCost(settings HostSettings, instrs []Instruction, data []byte, duration, sectors uint64) (ResourceUsage, error) {
+	if err := ValidateProgram(instrs, data, true); err != nil {
+		return ResourceUsage{}, err
+	}
+	var usage ResourceUsage
+	var requiresFinalization bool
+	for i, instr := range instrs {
+		requiresFinalization = requiresFinalization || InstructionRequiresFinalization(instr)
+		cost, err := instructionCost(settings, instr, data, duration, sectors)
+		if err != nil {
+			return ResourceUsage{}, fmt.Errorf("instruction %v (%T): %w", i, instr, err)
+		} else if usage, err = addUsage(usage, cost); err != nil {
+			return ResourceUsage{}, err
+		}
+	}
+	return addUsage(ExecutionCost(settings, uint64(len(data)), uint64(len(instrs)), requiresFinalization), usage)
+}
+
+// ProgramRefund returns the portion of a program's cost, as computed by
+// ProgramCost, that the host must refund if the program fails after the first
+// completed instructions have executed. The program's initialization cost and
+// the BaseCost of each completed instruction are retained by the host.
+// Everything else is refunded: the full cost of the unexecuted instructions,
+// the StorageCost of the completed instructions, and the finalization cost,
+// since a failed program is never finalized. For the same reason, the
+// refund's AdditionalCollateral is the program's entire collateral, which the
+// host is no longer obligated to add.
+func ProgramRefund(settings HostSettings, instrs []Instruction, data []byte, duration, sectors uint64, completed int) (ResourceUsage, error) {
+	if completed < 0 || completed > len(instrs) {
+		return ResourceUsage{}, fmt.Errorf("completed instruction count (%v) out of range", completed)
+	}
+	total, err := ProgramCost(settings, instrs, data, duration, sectors)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	charged := initCost(settings, uint64(len(data)), uint64(len(instrs)))
+	for _, instr := range instrs[:completed] {
+		// ProgramCost has already priced every instruction successfully
+		cost, _ := instructionCost(settings, instr, data, duration, sectors)
+		cost.StorageCost = types.ZeroCurrency
+		cost.AdditionalCollateral = types.ZeroCurrency
+		charged = charged.Add(cost)
+	}
+	return total.Sub(charged), nil
+}
@@ -3,12 +3,14 @@ package rhp
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"math/bits"
 	"unsafe"
 
 	"go.sia.tech/core/v2/internal/blake2b"
+	"go.sia.tech/core/v2/merkle"
 	"go.sia.tech/core/v2/types"
 )
 
@@ -17,7 +19,7 @@ import (
 
 const (
 	// SectorSize is the size of one sector in bytes.
-	SectorSize = 1 << 22 // 4 MiB
+	SectorSize = merkle.SectorSize
 
 	// LeafSize is the size of one leaf in bytes.
 	LeafSize = 64
@@ -171,11 +173,10 @@ func (sa *sectorAccumulator) root() types.Hash256 {
 	return root
 }
 
-// SectorRoot computes the Merkle root of a sector.
+// SectorRoot computes the Merkle root of a sector. It is equivalent to
+// merkle.SectorRoot.
 func SectorRoot(sector *[SectorSize]byte) types.Hash256 {
-	var sa sectorAccumulator
-	sa.appendLeaves(sector[:])
-	return sa.root()
+	return merkle.SectorRoot(sector[:])
 }
 
 // ReaderRoot returns the Merkle root of the supplied stream, which must contain
@@ -210,6 +211,16 @@ func ReadSector(r io.Reader) (types.Hash256, *[SectorSize]byte, error) {
 	return root, &sector, err
 }
 
+// ValidateSectorLength returns an error if data is not exactly one sector
+// long. Both renters and hosts should check sector data before computing its
+// root or storing it.
+func ValidateSectorLength(data []byte) error {
+	if len(data) != SectorSize {
+		return fmt.Errorf("sector data must be exactly %v bytes, got %v", SectorSize, len(data))
+	}
+	return nil
+}
+
 // MetaRoot calculates the root of a set of existing Merkle roots.
 func MetaRoot(roots []types.Hash256) types.Hash256 {
 	// sectorAccumulator is only designed to store one sector's worth of leaves,
@@ -316,6 +327,21 @@ func BuildProof(sector *[SectorSize]byte, start, end uint64, precalc func(i, j u
 	return proof
 }
 
+// SectorReadProof returns the Merkle root and proof for a read covering an
+// entire sector. Since the verifier possesses every leaf of the sector, the
+// proof is always empty, and verification reduces to comparing the root of the
+// downloaded data against the expected root; see VerifySectorReadProof. Partial
+// reads require a range proof, constructed with BuildProof and verified with a
+// RangeProofVerifier.
+func SectorReadProof(sector *[SectorSize]byte) (types.Hash256, []types.Hash256) {
+	return SectorRoot(sector), nil
+}
+
+// VerifySectorReadProof verifies a proof produced by SectorReadProof.
+func VerifySectorReadProof(sector *[SectorSize]byte, proof []types.Hash256, root types.Hash256) bool {
+	return len(proof) == 0 && SectorRoot(sector) == root
+}
+
 // BuildSectorRangeProof constructs a proof for the sector range [start, end).
 func BuildSectorRangeProof(sectorRoots []types.Hash256, start, end uint64) []types.Hash256 {
 	numLeaves := uint64(len(sectorRoots))
@@ -395,8 +421,314 @@ func NewRangeProofVerifier(start, end uint64) *RangeProofVerifier {
 	}
 }
 
+// LeafRange returns the range of leaves [start, end) that must be read in
+// order to cover length bytes of contract data beginning at offset. Proofs
+// operate on whole leaves, so a read of an arbitrary byte range must first be
+// expanded to leaf boundaries.
+func LeafRange(offset, length uint64) (start, end uint64) {
+	return offset / LeafSize, (offset + length + LeafSize - 1) / LeafSize
+}
+
+// splitSubtree returns the index at which the subtree [i, j) of a contract's
+// Merkle tree divides into its left and right children. As in MetaRoot, the
+// left child is the largest perfect subtree smaller than [i, j).
+func splitSubtree(i, j uint64) uint64 {
+	return i + 1<<(bits.Len64(j-i-1)-1)
+}
+
+// BuildContractRangeProof constructs a proof for the leaf range [start, end)
+// within a contract whose sectors have the supplied roots. Unlike BuildProof,
+// the range may span any number of sectors. Subtrees covering whole sectors are
+// computed from sectorRoots; subtrees within a sector are computed from the
+// sector's data, which is supplied by the sector function. Only the sectors
+// containing the first and last leaves of the range are requested.
+func BuildContractRangeProof(sectorRoots []types.Hash256, start, end uint64, sector func(i uint64) *[SectorSize]byte) []types.Hash256 {
+	numLeaves := uint64(len(sectorRoots)) * LeavesPerSector
+	if end > numLeaves || start > end || start == end {
+		panic("BuildContractRangeProof: illegal proof range")
+	}
+
+	var s sectorAccumulator
+	subtreeRoot := func(i, j uint64) types.Hash256 {
+		if j-i >= LeavesPerSector {
+			return MetaRoot(sectorRoots[i/LeavesPerSector : j/LeavesPerSector])
+		}
+		off := i % LeavesPerSector
+		s.reset()
+		s.appendLeaves(sector(i / LeavesPerSector)[off*LeafSize : (off+j-i)*LeafSize])
+		return s.root()
+	}
+
+	// same approach as BuildProof, except that the tree need not be perfect
+	proof := make([]types.Hash256, 0, RangeProofSize(numLeaves, start, end))
+	var rec func(uint64, uint64)
+	rec = func(i, j uint64) {
+		if i >= start && j <= end {
+			// this subtree contains only data leaves; skip it
+		} else if j <= start || i >= end {
+			proof = append(proof, subtreeRoot(i, j))
+		} else {
+			mid := splitSubtree(i, j)
+			rec(i, mid)
+			rec(mid, j)
+		}
+	}
+	rec(0, numLeaves)
+	return proof
+}
+
+// contractRangeRoot computes the root of a contract containing numLeaves
+// leaves, using the hashes in proof for every subtree outside the leaf range
+// [start, end) and rangeRoot for every subtree inside it. It mirrors the
+// enumeration performed by BuildContractRangeProof, and reports false if proof
+// does not contain exactly the expected number of hashes.
+func contractRangeRoot(proof []types.Hash256, start, end, numLeaves uint64, rangeRoot func(i, j uint64) types.Hash256) (types.Hash256, bool) {
+	var rec func(uint64, uint64) (types.Hash256, bool)
+	rec = func(i, j uint64) (types.Hash256, bool) {
+		if i >= start && j <= end {
+			return rangeRoot(i, j), true
+		} else if j <= start || i >= end {
+			if len(proof) == 0 {
+				return types.Hash256{}, false
+			}
+			h := proof[0]
+			proof = proof[1:]
+			return h, true
+		}
+		mid := splitSubtree(i, j)
+		left, ok := rec(i, mid)
+		if !ok {
+			return types.Hash256{}, false
+		}
+		right, ok := rec(mid, j)
+		if !ok {
+			return types.Hash256{}, false
+		}
+		return blake2b.SumPair(left, right), true
+	}
+	h, ok := rec(0, numLeaves)
+	return h, ok && len(proof) == 0
+}
+
+// VerifyContractRangeProof verifies a proof produced by
+// BuildContractRangeProof. data must contain the leaves [start, end) of a
+// contract containing numSectors sectors.
+func VerifyContractRangeProof(data []byte, proof []types.Hash256, start, end, numSectors uint64, root types.Hash256) bool {
+	numLeaves := numSectors * LeavesPerSector
+	if end > numLeaves || start >= end || uint64(len(data)) != (end-start)*LeafSize {
+		return false
+	}
+
+	var s sectorAccumulator
+	dataRoot := func(i, j uint64) types.Hash256 {
+		leaves := data[(i-start)*LeafSize : (j-start)*LeafSize]
+		if j-i > LeavesPerSector {
+			roots := make([]types.Hash256, 0, len(leaves)/SectorSize)
+			for len(leaves) > 0 {
+				roots = append(roots, SectorRoot((*[SectorSize]byte)(leaves)))
+				leaves = leaves[SectorSize:]
+			}
+			return MetaRoot(roots)
+		}
+		s.reset()
+		s.appendLeaves(leaves)
+		return s.root()
+	}
+	h, ok := contractRangeRoot(proof, start, end, numLeaves, dataRoot)
+	return ok && h == root
+}
+
+// BuildDiffProof constructs a proof for modifying the sectors at the supplied
+// indices, which must be sorted and distinct. The proof consists of the roots
+// of each subtree of the contract's Merkle tree that contains none of the
+// modified sectors, from left to right. Since those subtrees are unaffected by
+// the modification, the proof can be combined with either the old or the new
+// roots of the modified sectors to compute the contract's Merkle root before
+// or after the modification.
+func BuildDiffProof(sectorRoots []types.Hash256, indices []uint64) []types.Hash256 {
+	numSectors := uint64(len(sectorRoots))
+	for k, i := range indices {
+		if i >= numSectors || (k > 0 && i <= indices[k-1]) {
+			panic("BuildDiffProof: illegal sector indices")
+		}
+	}
+
+	var proof []types.Hash256
+	var rec func(uint64, uint64, []uint64)
+	rec = func(i, j uint64, indices []uint64) {
+		if len(indices) == 0 {
+			proof = append(proof, MetaRoot(sectorRoots[i:j]))
+		} else if j-i > 1 {
+			mid := splitSubtree(i, j)
+			k := 0
+			for k < len(indices) && indices[k] < mid {
+				k++
+			}
+			rec(i, mid, indices[:k])
+			rec(mid, j, indices[k:])
+		}
+	}
+	if numSectors > 0 {
+		rec(0, numSectors, indices)
+	}
+	return proof
+}
+
+// diffProofRoot computes the root of a contract containing numSectors sectors,
+// using leaves as the roots of the sectors at indices and proof for the roots
+// of every other subtree.
+func diffProofRoot(proof []types.Hash256, numSectors uint64, indices []uint64, leaves []types.Hash256) (types.Hash256, bool) {
+	var rec func(uint64, uint64, []uint64, []types.Hash256) (types.Hash256, bool)
+	rec = func(i, j uint64, indices []uint64, leaves []types.Hash256) (types.Hash256, bool) {
+		if len(indices) == 0 {
+			if len(proof) == 0 {
+				return types.Hash256{}, false
+			}
+			h := proof[0]
+			proof = proof[1:]
+			return h, true
+		} else if j-i == 1 {
+			return leaves[0], true
+		}
+		mid := splitSubtree(i, j)
+		k := 0
+		for k < len(indices) && indices[k] < mid {
+			k++
+		}
+		left, ok := rec(i, mid, indices[:k], leaves[:k])
+		if !ok {
+			return types.Hash256{}, false
+		}
+		right, ok := rec(mid, j, indices[k:], leaves[k:])
+		if !ok {
+			return types.Hash256{}, false
+		}
+		return blake2b.SumPair(left, right), true
+	}
+	if numSectors == 0 {
+		return types.Hash256{}, len(proof) == 0
+	}
+	h, ok := rec(0, numSectors, indices, leaves)
+	return h, ok && len(proof) == 0
+}
+
+// VerifyDiffProof verifies a proof produced by BuildDiffProof. oldLeaves and
+// newLeaves contain the roots of the sectors at indices before and after the
+// modification, respectively.
+func VerifyDiffProof(numSectors uint64, indices []uint64, proof []types.Hash256, oldLeaves, newLeaves []types.Hash256, oldRoot, newRoot types.Hash256) bool {
+	if len(oldLeaves) != len(indices) || len(newLeaves) != len(indices) {
+		return false
+	}
+	for k, i := range indices {
+		if i >= numSectors || (k > 0 && i <= indices[k-1]) {
+			return false
+		}
+	}
+	if h, ok := diffProofRoot(proof, numSectors, indices, oldLeaves); !ok || h != oldRoot {
+		return false
+	}
+	h, ok := diffProofRoot(proof, numSectors, indices, newLeaves)
+	return ok && h == newRoot
+}
+
+// BuildSwapProof constructs a proof that swapping the sectors at indices i and
+// j of a contract containing sectorRoots results in a particular Merkle root.
+func BuildSwapProof(sectorRoots []types.Hash256, i, j uint64) []types.Hash256 {
+	if i > j {
+		i, j = j, i
+	} else if i == j {
+		return BuildDiffProof(sectorRoots, []uint64{i})
+	}
+	return BuildDiffProof(sectorRoots, []uint64{i, j})
+}
+
+// VerifySwapProof verifies a proof produced by BuildSwapProof. rootI and rootJ
+// are the roots of the sectors at indices i and j prior to the swap.
+func VerifySwapProof(numSectors, i, j uint64, proof []types.Hash256, rootI, rootJ, oldRoot, newRoot types.Hash256) bool {
+	if i > j {
+		i, j = j, i
+		rootI, rootJ = rootJ, rootI
+	} else if i == j {
+		return rootI == rootJ && VerifyDiffProof(numSectors, []uint64{i}, proof, []types.Hash256{rootI}, []types.Hash256{rootI}, oldRoot, newRoot)
+	}
+	return VerifyDiffProof(numSectors, []uint64{i, j}, proof, []types.Hash256{rootI, rootJ}, []types.Hash256{rootJ, rootI}, oldRoot, newRoot)
+}
+
+// BuildUpdateProof constructs a proof that overwriting length bytes at offset
+// within the contract containing sectorRoots results in a particular Merkle
+// root. The update must be aligned to LeafSize and may span multiple sectors.
+// The sector function supplies the contents of the sectors prior to the update.
+//
+// The proof begins with the hashes of each leaf being overwritten, followed by
+// a proof for the leaf range, as constructed by BuildContractRangeProof.
+func BuildUpdateProof(sectorRoots []types.Hash256, offset, length uint64, sector func(i uint64) *[SectorSize]byte) []types.Hash256 {
+	if offset%LeafSize != 0 || length%LeafSize != 0 {
+		panic("BuildUpdateProof: update is not aligned to LeafSize")
+	}
+	start, end := offset/LeafSize, (offset+length)/LeafSize
+	rangeProof := BuildContractRangeProof(sectorRoots, start, end, sector)
+
+	proof := make([]types.Hash256, 0, int(end-start)+len(rangeProof))
+	for i := start; i < end; i++ {
+		off := (i % LeavesPerSector) * LeafSize
+		proof = append(proof, blake2b.SumLeaf((*[LeafSize]byte)(sector(i / LeavesPerSector)[off:])))
+	}
+	return append(proof, rangeProof...)
+}
+
+// VerifyUpdateProof verifies a proof produced by BuildUpdateProof. data is the
+// data written at offset within a contract containing numSectors sectors.
+func VerifyUpdateProof(numSectors, offset uint64, data []byte, proof []types.Hash256, oldRoot, newRoot types.Hash256) bool {
+	length := uint64(len(data))
+	numLeaves := numSectors * LeavesPerSector
+	if offset%LeafSize != 0 || length%LeafSize != 0 || length == 0 || offset/LeafSize > numLeaves || length/LeafSize > numLeaves-offset/LeafSize {
+		return false
+	}
+	start, end := offset/LeafSize, (offset+length)/LeafSize
+	if uint64(len(proof)) < end-start {
+		return false
+	}
+	oldLeaves, rangeProof := proof[:end-start], proof[end-start:]
+	newLeaves := make([]types.Hash256, 0, end-start)
+	for i := 0; i < len(data); i += LeafSize {
+		newLeaves = append(newLeaves, blake2b.SumLeaf((*[LeafSize]byte)(data[i:])))
+	}
+
+	leavesRoot := func(leaves []types.Hash256) func(i, j uint64) types.Hash256 {
+		return func(i, j uint64) types.Hash256 {
+			return MetaRoot(leaves[i-start : j-start])
+		}
+	}
+	if h, ok := contractRangeRoot(rangeProof, start, end, numLeaves, leavesRoot(oldLeaves)); !ok || h != oldRoot {
+		return false
+	}
+	h, ok := contractRangeRoot(rangeProof, start, end, numLeaves, leavesRoot(newLeaves))
+	return ok && h == newRoot
+}
+
+// BuildAppendProof constructs a proof that appending a sector to a contract
+// containing sectorRoots results in a particular Merkle root. The proof
+// consists of the roots of each perfect subtree of the contract's current
+// Merkle tree, from smallest to largest.
+func BuildAppendProof(sectorRoots []types.Hash256) []types.Hash256 {
+	var acc proofAccumulator
+	for _, root := range sectorRoots {
+		acc.insertNode(root, 0)
+	}
+	proof := make([]types.Hash256, 0, bits.OnesCount64(acc.numLeaves))
+	for i := range acc.trees {
+		if acc.hasNodeAtHeight(i) {
+			proof = append(proof, acc.trees[i])
+		}
+	}
+	return proof
+}
+
 // VerifyAppendProof verifies a proof produced by BuildAppendProof.
 func VerifyAppendProof(numLeaves uint64, treeHashes []types.Hash256, sectorRoot, oldRoot, newRoot types.Hash256) bool {
+	if len(treeHashes) != bits.OnesCount64(numLeaves) {
+		return false
+	}
 	acc := proofAccumulator{numLeaves: numLeaves}
 	for i := range acc.trees {
 		if acc.hasNodeAtHeight(i) && len(treeHashes) > 0 {
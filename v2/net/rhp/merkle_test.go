@@ -293,6 +293,37 @@ func TestBuildProof(t *testing.T) {
 	}
 }
 
+func TestSectorReadProof(t *testing.T) {
+	var sector [SectorSize]byte
+	frand.Read(sector[:])
+
+	root, proof := SectorReadProof(&sector)
+	if root != SectorRoot(&sector) {
+		t.Fatal("SectorReadProof returned wrong root")
+	} else if !reflect.DeepEqual(append([]types.Hash256{}, proof...), BuildProof(&sector, 0, LeavesPerSector, nil)) {
+		t.Fatal("SectorReadProof disagrees with BuildProof for the entire sector")
+	} else if !VerifySectorReadProof(&sector, proof, root) {
+		t.Fatal("failed to verify valid full-sector proof")
+	}
+
+	// the proof should also be accepted by a RangeProofVerifier
+	rpv := NewRangeProofVerifier(0, LeavesPerSector)
+	if _, err := rpv.ReadFrom(bytes.NewReader(sector[:])); err != nil {
+		t.Fatal(err)
+	} else if !rpv.Verify(proof, root) {
+		t.Fatal("RangeProofVerifier rejected full-sector proof")
+	}
+
+	// corrupted data or a non-empty proof should be rejected
+	if VerifySectorReadProof(&sector, []types.Hash256{{}}, root) {
+		t.Fatal("verified full-sector proof with extra hashes")
+	}
+	sector[0] ^= 1
+	if VerifySectorReadProof(&sector, proof, root) {
+		t.Fatal("verified full-sector proof for corrupted sector")
+	}
+}
+
 func TestBuildSectorRangeProof(t *testing.T) {
 	// test some known proofs
 	sectorRoots := make([]types.Hash256, 16)
@@ -342,6 +373,196 @@ func TestBuildSectorRangeProof(t *testing.T) {
 	}
 }
 
+func TestContractRangeProof(t *testing.T) {
+	sectors := make([][SectorSize]byte, 3)
+	sectorRoots := make([]types.Hash256, len(sectors))
+	for i := range sectors {
+		frand.Read(sectors[i][:])
+		sectorRoots[i] = SectorRoot(&sectors[i])
+	}
+	contract := make([]byte, 0, len(sectors)*SectorSize)
+	for i := range sectors {
+		contract = append(contract, sectors[i][:]...)
+	}
+	root := MetaRoot(sectorRoots)
+
+	// only the sectors at either end of the range should be needed
+	var fetched map[uint64]bool
+	sector := func(i uint64) *[SectorSize]byte {
+		fetched[i] = true
+		return &sectors[i]
+	}
+
+	const lps = LeavesPerSector
+	for _, r := range []struct {
+		desc       string
+		start, end uint64
+	}{
+		{"first leaf", 0, 1},
+		{"last leaf", 3*lps - 1, 3 * lps},
+		{"within sector", lps + 10, lps + 20},
+		{"first sector", 0, lps},
+		{"middle sector", lps, 2 * lps},
+		{"last two sectors", lps, 3 * lps},
+		{"entire contract", 0, 3 * lps},
+		{"straddling one boundary", lps - 3, lps + 5},
+		{"straddling two boundaries", lps - 1, 2*lps + 1},
+		{"from boundary into sector", 2 * lps, 2*lps + 7},
+	} {
+		fetched = make(map[uint64]bool)
+		proof := BuildContractRangeProof(sectorRoots, r.start, r.end, sector)
+		for i := range fetched {
+			if i != r.start/lps && i != (r.end-1)/lps {
+				t.Errorf("%v: fetched unnecessary sector %v", r.desc, i)
+			}
+		}
+		if uint64(len(proof)) != RangeProofSize(3*lps, r.start, r.end) {
+			t.Errorf("%v: expected proof size %v, got %v", r.desc, RangeProofSize(3*lps, r.start, r.end), len(proof))
+		}
+		data := contract[r.start*LeafSize : r.end*LeafSize]
+		if !VerifyContractRangeProof(data, proof, r.start, r.end, 3, root) {
+			t.Errorf("%v: failed to verify proof", r.desc)
+			continue
+		}
+
+		// tampering with the data or proof should cause verification to fail
+		bad := append([]byte(nil), data...)
+		bad[frand.Intn(len(bad))] ^= 1
+		if VerifyContractRangeProof(bad, proof, r.start, r.end, 3, root) {
+			t.Errorf("%v: verified proof with tampered data", r.desc)
+		}
+		if len(proof) > 0 {
+			badProof := append([]types.Hash256(nil), proof...)
+			badProof[frand.Intn(len(badProof))][0] ^= 1
+			if VerifyContractRangeProof(data, badProof, r.start, r.end, 3, root) {
+				t.Errorf("%v: verified tampered proof", r.desc)
+			}
+			if VerifyContractRangeProof(data, proof[1:], r.start, r.end, 3, root) {
+				t.Errorf("%v: verified truncated proof", r.desc)
+			}
+		}
+		if VerifyContractRangeProof(data, append(proof, types.Hash256{}), r.start, r.end, 3, root) {
+			t.Errorf("%v: verified proof with extra hash", r.desc)
+		}
+	}
+
+	// within a single sector, the proof should match BuildProof
+	single := BuildContractRangeProof(sectorRoots[:1], 100, 200, sector)
+	if !reflect.DeepEqual(single, BuildProof(&sectors[0], 100, 200, nil)) {
+		t.Error("contract proof for a single sector does not match BuildProof")
+	}
+
+	// a byte range should be expanded to leaf boundaries
+	if start, end := LeafRange(LeafSize*lps-1, 2); start != lps-1 || end != lps+1 {
+		t.Errorf("expected leaf range [%v, %v), got [%v, %v)", lps-1, lps+1, start, end)
+	}
+	if start, end := LeafRange(LeafSize, LeafSize); start != 1 || end != 2 {
+		t.Errorf("expected leaf range [1, 2), got [%v, %v)", start, end)
+	}
+}
+
+func TestMutationProofs(t *testing.T) {
+	sectorRoots := make([]types.Hash256, 7)
+	for i := range sectorRoots {
+		sectorRoots[i] = frand.Entropy256()
+	}
+	oldRoot := MetaRoot(sectorRoots)
+	tamper := func(proof []types.Hash256) []types.Hash256 {
+		bad := append([]types.Hash256(nil), proof...)
+		bad[frand.Intn(len(bad))][0] ^= 1
+		return bad
+	}
+
+	// append
+	newSector := frand.Entropy256()
+	proof := BuildAppendProof(sectorRoots)
+	newRoot := MetaRoot(append(append([]types.Hash256(nil), sectorRoots...), newSector))
+	if !VerifyAppendProof(7, proof, newSector, oldRoot, newRoot) {
+		t.Error("failed to verify append proof")
+	} else if VerifyAppendProof(7, tamper(proof), newSector, oldRoot, newRoot) {
+		t.Error("verified tampered append proof")
+	} else if VerifyAppendProof(7, proof, frand.Entropy256(), oldRoot, newRoot) {
+		t.Error("verified append proof for wrong sector")
+	}
+
+	// swap
+	for _, p := range [][2]uint64{{0, 6}, {6, 0}, {2, 3}, {1, 5}, {4, 4}} {
+		i, j := p[0], p[1]
+		swapped := append([]types.Hash256(nil), sectorRoots...)
+		swapped[i], swapped[j] = swapped[j], swapped[i]
+		newRoot := MetaRoot(swapped)
+		proof := BuildSwapProof(sectorRoots, i, j)
+		if !VerifySwapProof(7, i, j, proof, sectorRoots[i], sectorRoots[j], oldRoot, newRoot) {
+			t.Errorf("failed to verify swap proof for (%v, %v)", i, j)
+		} else if VerifySwapProof(7, i, j, tamper(proof), sectorRoots[i], sectorRoots[j], oldRoot, newRoot) {
+			t.Errorf("verified tampered swap proof for (%v, %v)", i, j)
+		} else if VerifySwapProof(7, i, j, proof[1:], sectorRoots[i], sectorRoots[j], oldRoot, newRoot) {
+			t.Errorf("verified truncated swap proof for (%v, %v)", i, j)
+		} else if i != j && VerifySwapProof(7, i, j, proof, sectorRoots[i], sectorRoots[j], oldRoot, oldRoot) {
+			t.Errorf("verified swap proof for (%v, %v) with unchanged root", i, j)
+		}
+	}
+
+	// modifying several sectors at once
+	indices := []uint64{0, 3, 4}
+	modified := append([]types.Hash256(nil), sectorRoots...)
+	var oldLeaves, newLeaves []types.Hash256
+	for _, i := range indices {
+		oldLeaves = append(oldLeaves, modified[i])
+		modified[i] = frand.Entropy256()
+		newLeaves = append(newLeaves, modified[i])
+	}
+	proof = BuildDiffProof(sectorRoots, indices)
+	if !VerifyDiffProof(7, indices, proof, oldLeaves, newLeaves, oldRoot, MetaRoot(modified)) {
+		t.Error("failed to verify diff proof")
+	} else if VerifyDiffProof(7, indices, tamper(proof), oldLeaves, newLeaves, oldRoot, MetaRoot(modified)) {
+		t.Error("verified tampered diff proof")
+	} else if VerifyDiffProof(7, []uint64{0, 3, 5}, proof, oldLeaves, newLeaves, oldRoot, MetaRoot(modified)) {
+		t.Error("verified diff proof for wrong indices")
+	}
+
+	// update
+	sectors := make([][SectorSize]byte, 2)
+	sectorRoots = make([]types.Hash256, len(sectors))
+	for i := range sectors {
+		frand.Read(sectors[i][:])
+		sectorRoots[i] = SectorRoot(&sectors[i])
+	}
+	oldRoot = MetaRoot(sectorRoots)
+	sector := func(i uint64) *[SectorSize]byte { return &sectors[i] }
+	for _, u := range []struct {
+		desc           string
+		offset, length uint64
+	}{
+		{"single leaf", 10 * LeafSize, LeafSize},
+		{"within sector", SectorSize + 100*LeafSize, 50 * LeafSize},
+		{"entire sector", SectorSize, SectorSize},
+		{"straddling sectors", SectorSize - 3*LeafSize, 5 * LeafSize},
+	} {
+		proof := BuildUpdateProof(sectorRoots, u.offset, u.length, sector)
+		data := frand.Bytes(int(u.length))
+		contract := append(append([]byte(nil), sectors[0][:]...), sectors[1][:]...)
+		copy(contract[u.offset:], data)
+		newRoot := MetaRoot([]types.Hash256{
+			SectorRoot((*[SectorSize]byte)(contract[:SectorSize])),
+			SectorRoot((*[SectorSize]byte)(contract[SectorSize:])),
+		})
+
+		if !VerifyUpdateProof(2, u.offset, data, proof, oldRoot, newRoot) {
+			t.Errorf("%v: failed to verify update proof", u.desc)
+			continue
+		} else if VerifyUpdateProof(2, u.offset, data, tamper(proof), oldRoot, newRoot) {
+			t.Errorf("%v: verified tampered update proof", u.desc)
+		} else if VerifyUpdateProof(2, u.offset+LeafSize, data, proof, oldRoot, newRoot) {
+			t.Errorf("%v: verified update proof at wrong offset", u.desc)
+		}
+		data[0] ^= 1
+		if VerifyUpdateProof(2, u.offset, data, proof, oldRoot, newRoot) {
+			t.Errorf("%v: verified update proof with wrong data", u.desc)
+		}
+	}
+}
+
 func TestReadSector(t *testing.T) {
 	var expected [SectorSize]byte
 	frand.Read(expected[:256])
@@ -366,6 +587,17 @@ func TestReadSector(t *testing.T) {
 	}
 }
 
+func TestValidateSectorLength(t *testing.T) {
+	for _, n := range []int{0, LeafSize, SectorSize - 1, SectorSize + 1, 2 * SectorSize} {
+		if err := ValidateSectorLength(make([]byte, n)); err == nil {
+			t.Errorf("expected error for %v-byte sector", n)
+		}
+	}
+	if err := ValidateSectorLength(make([]byte, SectorSize)); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func BenchmarkReadSector(b *testing.B) {
 	buf := bytes.NewBuffer(nil)
 	buf.Grow(SectorSize)
@@ -385,3 +617,119 @@ func BenchmarkReadSector(b *testing.B) {
 		}
 	}
 }
+
+func TestVerifyInstructionOutputs(t *testing.T) {
+	// append a sector to a contract
+	roots := make([]types.Hash256, 13)
+	for i := range roots {
+		roots[i] = frand.Entropy256()
+	}
+	var sector [SectorSize]byte
+	frand.Read(sector[:])
+	sectorRoot := SectorRoot(&sector)
+	oldRoot := MetaRoot(roots)
+	resp := &RPCExecuteInstrResponse{
+		NewMerkleRoot: MetaRoot(append(roots, sectorRoot)),
+		Proof:         BuildAppendProof(roots),
+	}
+	if !VerifyAppendSectorOutput(resp, uint64(len(roots)), oldRoot, sectorRoot) {
+		t.Fatal("valid append proof was rejected")
+	}
+	for _, tamper := range []func(*RPCExecuteInstrResponse){
+		func(r *RPCExecuteInstrResponse) { r.NewMerkleRoot[0] ^= 1 },
+		func(r *RPCExecuteInstrResponse) { r.Proof[0][0] ^= 1 },
+		func(r *RPCExecuteInstrResponse) { r.Proof = r.Proof[1:] },
+		func(r *RPCExecuteInstrResponse) { r.Proof = append(r.Proof, types.Hash256{}) },
+	} {
+		tampered := *resp
+		tampered.Proof = append([]types.Hash256(nil), resp.Proof...)
+		tamper(&tampered)
+		if VerifyAppendSectorOutput(&tampered, uint64(len(roots)), oldRoot, sectorRoot) {
+			t.Error("tampered append proof was accepted")
+		}
+	}
+	if VerifyAppendSectorOutput(resp, uint64(len(roots)), oldRoot, frand.Entropy256()) {
+		t.Error("append proof for wrong sector was accepted")
+	}
+
+	// read part of the sector
+	offset, length := uint64(LeafSize*100), uint64(LeafSize*37)
+	data := sector[offset:][:length]
+	resp = &RPCExecuteInstrResponse{
+		Proof: BuildProof(&sector, offset/LeafSize, (offset+length)/LeafSize, nil),
+	}
+	if !VerifyReadSectorOutput(resp, data, sectorRoot, offset) {
+		t.Fatal("valid read proof was rejected")
+	}
+	tamperedData := append([]byte(nil), data...)
+	tamperedData[0] ^= 1
+	if VerifyReadSectorOutput(resp, tamperedData, sectorRoot, offset) {
+		t.Error("read proof with tampered data was accepted")
+	}
+	tampered := *resp
+	tampered.Proof = append([]types.Hash256(nil), resp.Proof...)
+	tampered.Proof[len(tampered.Proof)-1][0] ^= 1
+	if VerifyReadSectorOutput(&tampered, data, sectorRoot, offset) {
+		t.Error("tampered read proof was accepted")
+	}
+	if VerifyReadSectorOutput(resp, data, sectorRoot, offset+LeafSize) {
+		t.Error("read proof for wrong offset was accepted")
+	}
+	if VerifyReadSectorOutput(resp, data[1:], sectorRoot, offset+1) {
+		t.Error("unaligned read proof was accepted")
+	}
+
+	// a full-sector read requires no proof
+	if !VerifyReadSectorOutput(&RPCExecuteInstrResponse{}, sector[:], sectorRoot, 0) {
+		t.Error("valid full-sector read was rejected")
+	}
+}
+
+func TestVerifySectorRootsOutput(t *testing.T) {
+	roots := make([]types.Hash256, 7)
+	for i := range roots {
+		roots[i] = frand.Entropy256()
+	}
+	fc := types.FileContract{
+		Filesize:       uint64(len(roots)) * SectorSize,
+		FileMerkleRoot: MetaRoot(roots),
+	}
+
+	// roundtrip the output as the host would send it
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	(&SectorRootsOutput{SectorRoots: roots}).EncodeTo(e)
+	e.Flush()
+	var out SectorRootsOutput
+	d := types.NewBufDecoder(buf.Bytes())
+	out.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(out.SectorRoots, roots) {
+		t.Fatal("sector roots did not survive roundtrip")
+	} else if err := out.Verify(fc); err != nil {
+		t.Fatal(err)
+	}
+
+	// a tampered root should be detected
+	tampered := SectorRootsOutput{SectorRoots: append([]types.Hash256(nil), roots...)}
+	tampered.SectorRoots[3][0] ^= 1
+	if err := tampered.Verify(fc); err == nil {
+		t.Fatal("tampered sector root was accepted")
+	}
+
+	// missing or reordered roots should be detected
+	if err := (&SectorRootsOutput{SectorRoots: roots[:len(roots)-1]}).Verify(fc); err == nil {
+		t.Fatal("truncated sector roots were accepted")
+	}
+	reordered := SectorRootsOutput{SectorRoots: append([]types.Hash256(nil), roots...)}
+	reordered.SectorRoots[0], reordered.SectorRoots[1] = reordered.SectorRoots[1], reordered.SectorRoots[0]
+	if err := reordered.Verify(fc); err == nil {
+		t.Fatal("reordered sector roots were accepted")
+	}
+
+	// an empty contract has no roots
+	if err := (&SectorRootsOutput{}).Verify(types.FileContract{}); err != nil {
+		t.Fatal(err)
+	}
+}
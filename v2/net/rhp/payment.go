@@ -0,0 +1,136 @@
+package rhp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/v2/consensus"
+	"go.sia.tech/core/v2/net/rpc"
+	"go.sia.tech/core/v2/types"
+
+	"lukechampine.com/frand"
+)
+
+var (
+	// ErrWithdrawalExpired is returned when a withdrawal message's expiration
+	// height has passed.
+	ErrWithdrawalExpired = errors.New("withdrawal request expired")
+
+	// ErrInvalidWithdrawalSignature is returned when a withdrawal message is
+	// not signed by the account's key.
+	ErrInvalidWithdrawalSignature = errors.New("invalid withdrawal signature")
+)
+
+// A PaymentMethod pays the host for an RPC, either by revising a contract or
+// by withdrawing from an ephemeral account.
+type PaymentMethod interface {
+	rpc.Object
+	// PaymentType returns the specifier identifying the payment method.
+	PaymentType() rpc.Specifier
+}
+
+// PaymentType implements PaymentMethod.
+func (*PayByContractRequest) PaymentType() rpc.Specifier { return PayByContract }
+
+// PaymentType implements PaymentMethod.
+func (*PayByEphemeralAccountRequest) PaymentType() rpc.Specifier { return PayByEphemeralAccount }
+
+// WritePayment writes a payment, prefixed by its type, to w.
+func WritePayment(w io.Writer, pm PaymentMethod) error {
+	return rpc.WriteRequest(w, pm.PaymentType(), pm)
+}
+
+// ReadPayment reads a payment written by WritePayment from r.
+func ReadPayment(r io.Reader) (PaymentMethod, error) {
+	id, err := rpc.ReadID(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment type: %w", err)
+	}
+	var pm PaymentMethod
+	switch id {
+	case PayByContract:
+		pm = new(PayByContractRequest)
+	case PayByEphemeralAccount:
+		pm = new(PayByEphemeralAccountRequest)
+	default:
+		return nil, fmt.Errorf("unknown payment type %q", id)
+	}
+	if err := rpc.ReadRequest(r, pm); err != nil {
+		return nil, fmt.Errorf("failed to read payment: %w", err)
+	}
+	return pm, nil
+}
+
+// PayWithContract returns a payment transferring amount from the renter to
+// the host via a revision of c, along with the revision itself. The revision
+// is signed by renterKey; the host's signature must be added once it accepts
+// the payment.
+func PayWithContract(cs consensus.State, c Contract, amount types.Currency, refundAccount types.PublicKey, renterKey types.PrivateKey) (*PayByContractRequest, types.FileContract, error) {
+	rev, err := PaymentRevision(c.Revision, amount)
+	if err != nil {
+		return nil, types.FileContract{}, err
+	}
+	rev.HostSignature = types.Signature{}
+	rev.RenterSignature = renterKey.SignHash(cs.ContractSigHash(rev))
+	return &PayByContractRequest{
+		ContractID:        c.ID,
+		RefundAccount:     refundAccount,
+		Signature:         rev.RenterSignature,
+		NewRevisionNumber: rev.RevisionNumber,
+		NewOutputs: ContractOutputs{
+			RenterValue:     rev.RenterOutput.Value,
+			HostValue:       rev.HostOutput.Value,
+			MissedHostValue: rev.MissedHostValue,
+		},
+	}, rev, nil
+}
+
+// PayWithAccount returns a payment withdrawing amount from the ephemeral
+// account controlled by accountKey. The withdrawal is only valid until the
+// expiration height.
+func PayWithAccount(accountKey types.PrivateKey, amount types.Currency, expiration uint64) *PayByEphemeralAccountRequest {
+	req := &PayByEphemeralAccountRequest{
+		Message: WithdrawalMessage{
+			AccountID: accountKey.PublicKey(),
+			Expiry:    expiration,
+			Amount:    amount,
+		},
+	}
+	frand.Read(req.Message.Nonce[:])
+	req.Signature = accountKey.SignHash(req.Message.SigHash())
+	return req
+}
+
+// VerifyContractPayment verifies a contract payment against the current
+// revision of the contract. It returns the renter-signed payment revision and
+// the amount paid. The host's signature is not added.
+func VerifyContractPayment(cs consensus.State, current types.FileContract, req *PayByContractRequest) (types.FileContract, types.Currency, error) {
+	if req.NewOutputs.RenterValue.Cmp(current.RenterOutput.Value) > 0 {
+		return types.FileContract{}, types.ZeroCurrency, errors.New("payment revision increases renter output")
+	}
+	amount := current.RenterOutput.Value.Sub(req.NewOutputs.RenterValue)
+
+	rev := current
+	rev.RevisionNumber = req.NewRevisionNumber
+	req.NewOutputs.Apply(&rev)
+	rev.RenterSignature, rev.HostSignature = req.Signature, types.Signature{}
+	if err := ValidatePaymentRevision(current, rev, amount); err != nil {
+		return types.FileContract{}, types.ZeroCurrency, fmt.Errorf("invalid payment revision: %w", err)
+	} else if !rev.RenterPublicKey.VerifyHash(cs.ContractSigHash(rev), rev.RenterSignature) {
+		return types.FileContract{}, types.ZeroCurrency, ErrInvalidRenterSignature
+	}
+	return rev, amount, nil
+}
+
+// VerifyAccountPayment verifies that an account payment is signed by the
+// account's key and has not expired. The host should use the withdrawal
+// message's SigHash to reject duplicate withdrawals.
+func VerifyAccountPayment(req *PayByEphemeralAccountRequest, currentHeight uint64) error {
+	if req.Message.Expiry <= currentHeight {
+		return ErrWithdrawalExpired
+	} else if !req.Message.AccountID.VerifyHash(req.Message.SigHash(), req.Signature) {
+		return ErrInvalidWithdrawalSignature
+	}
+	return nil
+}
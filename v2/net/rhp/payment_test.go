@@ -0,0 +1,136 @@
+package rhp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/v2/consensus"
+	"go.sia.tech/core/v2/types"
+)
+
+func TestContractPayment(t *testing.T) {
+	var cs consensus.State
+	renterPubkey, renterPrivkey := testingKeypair(0)
+	hostPubkey, _ := testingKeypair(1)
+	_, accountKey := testingKeypair(2)
+
+	c := Contract{
+		ID: types.ElementID{Index: 1},
+		Revision: types.FileContract{
+			RenterOutput:    outputValue(types.Siacoins(10)),
+			HostOutput:      outputValue(types.Siacoins(20)),
+			MissedHostValue: types.Siacoins(30),
+			RevisionNumber:  5,
+			RenterPublicKey: renterPubkey,
+			HostPublicKey:   hostPubkey,
+		},
+	}
+	amount := types.Siacoins(3)
+	req, rev, err := PayWithContract(cs, c, amount, accountKey.PublicKey(), renterPrivkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// send the payment over the wire
+	var buf bytes.Buffer
+	if err := WritePayment(&buf, req); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := ReadPayment(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, ok := pm.(*PayByContractRequest)
+	if !ok {
+		t.Fatalf("expected %T, got %T", req, pm)
+	} else if !reflect.DeepEqual(received, req) {
+		t.Fatal("payment did not survive roundtrip")
+	}
+
+	verified, paid, err := VerifyContractPayment(cs, c.Revision, received)
+	if err != nil {
+		t.Fatal(err)
+	} else if paid != amount {
+		t.Fatalf("expected payment of %v, got %v", amount, paid)
+	} else if !reflect.DeepEqual(verified, rev) {
+		t.Fatal("verified revision does not match renter's revision")
+	}
+
+	// an invalid signature should be rejected
+	bad := *received
+	bad.Signature[0] ^= 1
+	if _, _, err := VerifyContractPayment(cs, c.Revision, &bad); err != ErrInvalidRenterSignature {
+		t.Fatalf("expected %v, got %v", ErrInvalidRenterSignature, err)
+	}
+
+	// a revision that does not pay the host should be rejected
+	bad = *received
+	bad.NewOutputs.HostValue = c.Revision.HostOutput.Value
+	if _, _, err := VerifyContractPayment(cs, c.Revision, &bad); err == nil {
+		t.Fatal("expected error for revision that does not pay host")
+	}
+
+	// a stale revision number should be rejected
+	bad = *received
+	bad.NewRevisionNumber = c.Revision.RevisionNumber
+	if _, _, err := VerifyContractPayment(cs, c.Revision, &bad); err == nil {
+		t.Fatal("expected error for stale revision number")
+	}
+
+	// paying more than the renter has should fail
+	if _, _, err := PayWithContract(cs, c, types.Siacoins(11), accountKey.PublicKey(), renterPrivkey); err == nil {
+		t.Fatal("expected insufficient funds error")
+	}
+}
+
+func TestAccountPayment(t *testing.T) {
+	_, accountKey := testingKeypair(0)
+	_, otherKey := testingKeypair(1)
+
+	amount := types.Siacoins(1)
+	req := PayWithAccount(accountKey, amount, 100)
+	if req.Message.AccountID != accountKey.PublicKey() || req.Message.Amount != amount {
+		t.Fatal("withdrawal message has wrong account or amount")
+	}
+
+	var buf bytes.Buffer
+	if err := WritePayment(&buf, req); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := ReadPayment(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, ok := pm.(*PayByEphemeralAccountRequest)
+	if !ok {
+		t.Fatalf("expected %T, got %T", req, pm)
+	} else if !reflect.DeepEqual(received, req) {
+		t.Fatal("payment did not survive roundtrip")
+	}
+
+	if err := VerifyAccountPayment(received, 99); err != nil {
+		t.Fatal(err)
+	} else if err := VerifyAccountPayment(received, 100); err != ErrWithdrawalExpired {
+		t.Fatalf("expected %v, got %v", ErrWithdrawalExpired, err)
+	}
+
+	// tampering with the message should invalidate the signature
+	bad := *received
+	bad.Message.Amount = types.Siacoins(2)
+	if err := VerifyAccountPayment(&bad, 0); err != ErrInvalidWithdrawalSignature {
+		t.Fatalf("expected %v, got %v", ErrInvalidWithdrawalSignature, err)
+	}
+
+	// a withdrawal signed by a different key should be rejected
+	bad = *received
+	bad.Signature = otherKey.SignHash(bad.Message.SigHash())
+	if err := VerifyAccountPayment(&bad, 0); err != ErrInvalidWithdrawalSignature {
+		t.Fatalf("expected %v, got %v", ErrInvalidWithdrawalSignature, err)
+	}
+
+	// two withdrawals of the same amount should have distinct nonces
+	if PayWithAccount(accountKey, amount, 100).Message.SigHash() == req.Message.SigHash() {
+		t.Fatal("duplicate withdrawal message")
+	}
+}
@@ -0,0 +1,181 @@
+package rhp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/v2/types"
+)
+
+// sectorReaderChunkSize is the maximum amount of data fetched by a
+// SectorReader in a single program.
+const sectorReaderChunkSize = 1 << 20 // 1 MiB
+
+// ErrInvalidProof is returned by a SectorReader when the host's proof does not
+// match the data it returned.
+var ErrInvalidProof = errors.New("host returned invalid proof")
+
+// A ProgramExecutor executes programs on a host. Implementations are
+// responsible for transporting the program and paying for its execution.
+type ProgramExecutor interface {
+	// ExecuteProgram executes instrs, which reference the supplied program
+	// data, against the contract, returning the response and output of each
+	// executed instruction.
+	ExecuteProgram(instrs []Instruction, data []byte) ([]RPCExecuteInstrResponse, [][]byte, error)
+}
+
+// A SectorReader is an io.ReadSeeker over a range of the data stored in a
+// contract. Data is fetched on demand using InstrReadOffset, and each chunk is
+// verified against the root of the sector containing it. The sector roots are
+// fetched using InstrSectorRoots before the first read and verified against
+// the contract's Merkle root.
+type SectorReader struct {
+	exec     ProgramExecutor
+	settings HostSettings
+	fc       types.FileContract
+	roots    []types.Hash256
+
+	offset, length uint64 // range within the contract
+	pos            uint64 // relative to offset
+
+	chunk    []byte
+	chunkOff uint64 // offset of chunk within the contract
+}
+
+// execute executes a program containing the single instruction added by fn,
+// returning its response and output.
+func (sr *SectorReader) execute(fn func(pb *ProgramBuilder)) (RPCExecuteInstrResponse, []byte, error) {
+	var buf bytes.Buffer
+	pb := NewProgramBuilder(sr.settings, &buf, 0)
+	fn(pb)
+	instrs, _, _, err := pb.Program()
+	if err != nil {
+		return RPCExecuteInstrResponse{}, nil, err
+	}
+	resps, outputs, err := sr.exec.ExecuteProgram(instrs, buf.Bytes())
+	if err != nil {
+		return RPCExecuteInstrResponse{}, nil, fmt.Errorf("failed to execute program: %w", err)
+	} else if len(resps) != 1 || len(outputs) != 1 {
+		return RPCExecuteInstrResponse{}, nil, fmt.Errorf("expected 1 instruction response, got %v", len(resps))
+	} else if resps[0].Error != nil {
+		return RPCExecuteInstrResponse{}, nil, fmt.Errorf("host failed to execute instruction: %w", resps[0].Error)
+	}
+	return resps[0], outputs[0], nil
+}
+
+// fetchRoots fetches the contract's sector roots and verifies them against
+// its Merkle root.
+func (sr *SectorReader) fetchRoots() error {
+	_, output, err := sr.execute(func(pb *ProgramBuilder) {
+		pb.AddSectorRootsInstruction(sr.fc.Filesize / SectorSize)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read sector roots: %w", err)
+	}
+	var out SectorRootsOutput
+	d := types.NewBufDecoder(output)
+	out.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("failed to decode sector roots: %w", err)
+	} else if err := out.Verify(sr.fc); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	sr.roots = out.SectorRoots
+	return nil
+}
+
+// fetch reads and verifies the chunk containing the contract offset off.
+// Proofs for InstrReadOffset are relative to a single sector, so a chunk never
+// extends past the end of the sector containing off.
+func (sr *SectorReader) fetch(off uint64) error {
+	if sr.roots == nil {
+		if err := sr.fetchRoots(); err != nil {
+			return err
+		}
+	}
+
+	// proofs cover whole leaves, so expand the read to leaf boundaries
+	index, sectorOff := off/SectorSize, off%SectorSize
+	start := sectorOff - sectorOff%LeafSize
+	end := uint64(SectorSize)
+	if rangeEnd := sr.offset + sr.length - index*SectorSize; rangeEnd < end {
+		end = (rangeEnd + LeafSize - 1) / LeafSize * LeafSize
+	}
+	if end-start > sectorReaderChunkSize {
+		end = start + sectorReaderChunkSize
+	}
+	readOff, readLen := index*SectorSize+start, end-start
+
+	resp, data, err := sr.execute(func(pb *ProgramBuilder) {
+		pb.AddReadOffsetInstruction(readOff, readLen, true)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read contract data: %w", err)
+	} else if uint64(len(data)) != readLen {
+		return fmt.Errorf("host returned %v bytes, expected %v", len(data), readLen)
+	} else if !VerifyReadSectorOutput(&resp, data, sr.roots[index], start) {
+		return ErrInvalidProof
+	}
+	sr.chunk, sr.chunkOff = data, readOff
+	return nil
+}
+
+// Read implements io.Reader.
+func (sr *SectorReader) Read(p []byte) (int, error) {
+	if sr.pos >= sr.length {
+		return 0, io.EOF
+	}
+	off := sr.offset + sr.pos
+	if off < sr.chunkOff || off >= sr.chunkOff+uint64(len(sr.chunk)) {
+		if err := sr.fetch(off); err != nil {
+			return 0, err
+		}
+	}
+	buf := sr.chunk[off-sr.chunkOff:]
+	if rem := sr.length - sr.pos; uint64(len(buf)) > rem {
+		buf = buf[:rem]
+	}
+	n := copy(p, buf)
+	sr.pos += uint64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker. Offsets are relative to the range passed to
+// NewSectorReader.
+func (sr *SectorReader) Seek(offset int64, whence int) (int64, error) {
+	var base int64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = int64(sr.pos)
+	case io.SeekEnd:
+		base = int64(sr.length)
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if base+offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	sr.pos = uint64(base + offset)
+	return int64(sr.pos), nil
+}
+
+// NewSectorReader returns a SectorReader for the length bytes of fc beginning
+// at offset. Programs are built using the prices in settings and executed via
+// exec.
+func NewSectorReader(exec ProgramExecutor, settings HostSettings, fc types.FileContract, offset, length uint64) (*SectorReader, error) {
+	if fc.Filesize%SectorSize != 0 {
+		return nil, fmt.Errorf("contract size (%v bytes) is not a multiple of the sector size", fc.Filesize)
+	} else if offset > fc.Filesize || length > fc.Filesize-offset {
+		return nil, fmt.Errorf("range [%v, %v) exceeds contract size of %v bytes", offset, offset+length, fc.Filesize)
+	}
+	return &SectorReader{
+		exec:     exec,
+		settings: settings,
+		fc:       fc,
+		offset:   offset,
+		length:   length,
+	}, nil
+}
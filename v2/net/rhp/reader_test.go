@@ -0,0 +1,153 @@
+package rhp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+
+	"lukechampine.com/frand"
+)
+
+// memHost is an in-memory host that executes read programs against a single
+// contract.
+type memHost struct {
+	sectors     []*[SectorSize]byte
+	roots       []types.Hash256
+	programs    int
+	corrupt     bool
+	corruptRoot bool
+}
+
+func (h *memHost) contract() types.FileContract {
+	return types.FileContract{
+		Filesize:       uint64(len(h.sectors)) * SectorSize,
+		FileMerkleRoot: MetaRoot(h.roots),
+	}
+}
+
+func (h *memHost) ExecuteProgram(instrs []Instruction, data []byte) ([]RPCExecuteInstrResponse, [][]byte, error) {
+	h.programs++
+	if err := ValidateProgram(instrs, data, true); err != nil {
+		return nil, nil, err
+	}
+	var resps []RPCExecuteInstrResponse
+	var outputs [][]byte
+	for _, instr := range instrs {
+		var resp RPCExecuteInstrResponse
+		var out []byte
+		switch instr := instr.(type) {
+		case *InstrSectorRoots:
+			roots := append([]types.Hash256(nil), h.roots...)
+			if h.corruptRoot {
+				roots[0][0] ^= 1
+			}
+			var buf bytes.Buffer
+			e := types.NewEncoder(&buf)
+			(&SectorRootsOutput{SectorRoots: roots}).EncodeTo(e)
+			e.Flush()
+			out = buf.Bytes()
+		case *InstrReadOffset:
+			d := types.NewBufDecoder(data[instr.DataOffset:])
+			offset := d.ReadUint64()
+			d = types.NewBufDecoder(data[instr.LengthOffset:])
+			length := d.ReadUint64()
+
+			// reads are relative to a single sector
+			sector := h.sectors[offset/SectorSize]
+			offset %= SectorSize
+			out = append(out, sector[offset:][:length]...)
+			if instr.ProofRequired {
+				resp.Proof = BuildProof(sector, offset/LeafSize, (offset+length)/LeafSize, nil)
+			}
+			if h.corrupt {
+				out[0] ^= 1
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported instruction %T", instr)
+		}
+		resps = append(resps, resp)
+		outputs = append(outputs, out)
+	}
+	return resps, outputs, nil
+}
+
+func newMemHost(numSectors int) *memHost {
+	h := new(memHost)
+	for i := 0; i < numSectors; i++ {
+		sector := new([SectorSize]byte)
+		frand.Read(sector[:])
+		h.sectors = append(h.sectors, sector)
+		h.roots = append(h.roots, SectorRoot(sector))
+	}
+	return h
+}
+
+func TestSectorReader(t *testing.T) {
+	h := newMemHost(3)
+	contractData := func(offset, length uint64) []byte {
+		var buf []byte
+		for _, sector := range h.sectors {
+			buf = append(buf, sector[:]...)
+		}
+		return buf[offset:][:length]
+	}
+
+	// an unaligned range spanning all three sectors
+	offset, length := uint64(SectorSize-100), uint64(SectorSize+300)
+	sr, err := NewSectorReader(h, testSettings, h.contract(), offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, contractData(offset, length)) {
+		t.Fatal("read wrong data")
+	} else if exp := int(length/sectorReaderChunkSize + 4); h.programs > exp {
+		t.Fatalf("expected at most %v programs, got %v", exp, h.programs)
+	}
+
+	// seek to various positions and read
+	buf := make([]byte, 1000)
+	for _, pos := range []int64{0, 1, 63, 64, 100, int64(length) - 1000, int64(length) / 2} {
+		if n, err := sr.Seek(pos, io.SeekStart); err != nil {
+			t.Fatal(err)
+		} else if n != pos {
+			t.Fatalf("expected position %v, got %v", pos, n)
+		} else if _, err := io.ReadFull(sr, buf); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, contractData(offset+uint64(pos), uint64(len(buf)))) {
+			t.Fatal("read wrong data after seeking to", pos)
+		}
+	}
+	if n, err := sr.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	} else if n != int64(length)-10 {
+		t.Fatalf("expected position %v, got %v", int64(length)-10, n)
+	} else if n, err := io.ReadFull(sr, buf); !errors.Is(err, io.ErrUnexpectedEOF) || n != 10 {
+		t.Fatalf("expected short read of 10 bytes, got %v (%v)", n, err)
+	} else if _, err := sr.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("expected error when seeking to negative position")
+	}
+
+	// invalid ranges should be rejected
+	if _, err := NewSectorReader(h, testSettings, h.contract(), 3*SectorSize-10, 11); err == nil {
+		t.Fatal("expected error for range exceeding contract")
+	}
+
+	// a host returning corrupted data or sector roots should be detected
+	for _, corrupt := range []*bool{&h.corrupt, &h.corruptRoot} {
+		*corrupt = true
+		sr, err = NewSectorReader(h, testSettings, h.contract(), 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := sr.Read(buf); !errors.Is(err, ErrInvalidProof) {
+			t.Fatal("expected ErrInvalidProof, got", err)
+		}
+		*corrupt = false
+	}
+}
@@ -82,3 +82,79 @@ func TestRegistryKeyCompat(t *testing.T) {
 		}
 	}
 }
+
+func signedRegistryValue(priv types.PrivateKey, typ uint8, data []byte, revision uint64) RegistryValue {
+	v := RegistryValue{
+		Data:      data,
+		Revision:  revision,
+		Type:      typ,
+		PublicKey: priv.PublicKey(),
+	}
+	v.Signature = priv.SignHash(v.Hash())
+	return v
+}
+
+func TestValidateRegistryEntry(t *testing.T) {
+	priv := types.GeneratePrivateKey()
+	if err := ValidateRegistryEntry(signedRegistryValue(priv, EntryTypeArbitrary, []byte("foo"), 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := signedRegistryValue(priv, EntryTypeArbitrary, []byte("foo"), 1)
+	tampered.Revision++
+	wrongKey := signedRegistryValue(priv, EntryTypeArbitrary, []byte("foo"), 1)
+	wrongKey.PublicKey = types.GeneratePrivateKey().PublicKey()
+	for _, test := range []struct {
+		desc  string
+		value RegistryValue
+	}{
+		{"tampered", tampered},
+		{"wrong key", wrongKey},
+		{"oversized", signedRegistryValue(priv, EntryTypeArbitrary, make([]byte, MaxValueDataSize+1), 1)},
+		{"invalid type", signedRegistryValue(priv, 0, []byte("foo"), 1)},
+		{"short pubkey entry", signedRegistryValue(priv, EntryTypePubKey, make([]byte, 19), 1)},
+	} {
+		if err := ValidateRegistryEntry(test.value); err == nil {
+			t.Errorf("expected %v entry to be rejected", test.desc)
+		}
+	}
+}
+
+func TestValidateRegistryUpdate(t *testing.T) {
+	priv := types.GeneratePrivateKey()
+	hostID := RegistryHostID(types.GeneratePrivateKey().PublicKey())
+	otherHostID := RegistryHostID(types.GeneratePrivateKey().PublicKey())
+	arbitrary := func(data string, revision uint64) RegistryValue {
+		return signedRegistryValue(priv, EntryTypeArbitrary, []byte(data), revision)
+	}
+	pubkey := func(id types.Hash256, revision uint64) RegistryValue {
+		return signedRegistryValue(priv, EntryTypePubKey, append(id[:20:20], "foo"...), revision)
+	}
+
+	// at the same revision, order two values by work
+	less, more := arbitrary("foo", 5), arbitrary("bar", 5)
+	if less.Work().Cmp(more.Work()) > 0 {
+		less, more = more, less
+	}
+
+	tests := []struct {
+		desc   string
+		old    RegistryValue
+		update RegistryValue
+		valid  bool
+	}{
+		{"higher revision", arbitrary("foo", 5), arbitrary("foo", 6), true},
+		{"stale revision", arbitrary("foo", 5), arbitrary("foo", 4), false},
+		{"higher work", less, more, true},
+		{"lower work", more, less, false},
+		{"identical arbitrary", arbitrary("foo", 5), arbitrary("foo", 5), false},
+		{"primary replacing non-primary", pubkey(otherHostID, 5), pubkey(hostID, 5), true},
+		{"non-primary replacing primary", pubkey(hostID, 5), pubkey(otherHostID, 5), false},
+		{"primary replacing primary", pubkey(hostID, 5), pubkey(hostID, 5), false},
+	}
+	for _, test := range tests {
+		if err := ValidateRegistryUpdate(test.old, test.update, hostID); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid=%v, got %v", test.desc, test.valid, err)
+		}
+	}
+}
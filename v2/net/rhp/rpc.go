@@ -43,6 +43,7 @@ var (
 	RPCLatestRevisionID = rpc.NewSpecifier("LatestRevision")
 	RPCRenewContractID  = rpc.NewSpecifier("RenewContract")
 	RPCSettingsID       = rpc.NewSpecifier("Settings")
+	RPCSignedSettingsID = rpc.NewSpecifier("SignedSettings")
 )
 
 // Read/Write actions
@@ -728,6 +729,52 @@ func (r *RPCSettingsResponse) DecodeFrom(d *types.Decoder) {
 	r.Settings = d.ReadBytes()
 }
 
+// RPCSignedSettingsResponse contains the host's current settings, signed by the
+// host. The settings are valid until Settings.ValidUntil.
+type RPCSignedSettingsResponse struct {
+	Settings  HostSettings
+	Signature types.Signature
+}
+
+// SignSettings returns an RPCSignedSettingsResponse containing settings, signed
+// by the host's key.
+func SignSettings(priv types.PrivateKey, settings HostSettings) RPCSignedSettingsResponse {
+	return RPCSignedSettingsResponse{
+		Settings:  settings,
+		Signature: priv.SignHash(settings.SigHash()),
+	}
+}
+
+// Verify checks that the settings were signed by hostKey and have not expired.
+func (r *RPCSignedSettingsResponse) Verify(hostKey types.PublicKey, now time.Time) error {
+	if !hostKey.VerifyHash(r.Settings.SigHash(), r.Signature) {
+		return errors.New("host settings have invalid signature")
+	} else if now.After(r.Settings.ValidUntil) {
+		return fmt.Errorf("host settings expired at %v", r.Settings.ValidUntil)
+	}
+	return nil
+}
+
+// MaxLen returns the maximum encoded length of an object. Implements
+// rpc.Object.
+func (r *RPCSignedSettingsResponse) MaxLen() int {
+	return r.Settings.MaxLen() + 64
+}
+
+// EncodeTo encodes a RPCSignedSettingsResponse to an encoder. Implements
+// types.EncoderTo.
+func (r *RPCSignedSettingsResponse) EncodeTo(e *types.Encoder) {
+	r.Settings.EncodeTo(e)
+	r.Signature.EncodeTo(e)
+}
+
+// DecodeFrom decodes a RPCSignedSettingsResponse from a decoder. Implements
+// types.DecoderFrom.
+func (r *RPCSignedSettingsResponse) DecodeFrom(d *types.Decoder) {
+	r.Settings.DecodeFrom(d)
+	r.Signature.DecodeFrom(d)
+}
+
 // RPCLatestRevisionRequest requests the host send the latest revision of the
 // contract.
 type RPCLatestRevisionRequest struct {
@@ -851,6 +898,8 @@ func readInstruction(d *types.Decoder) (i Instruction) {
 		i = new(InstrReadSector)
 	case SpecInstrContractRevision:
 		i = new(InstrContractRevision)
+	case SpecInstrSectorRoots:
+		i = new(InstrSectorRoots)
 	case SpecInstrSwapSector:
 		i = new(InstrSwapSector)
 	case SpecInstrUpdateRegistry:
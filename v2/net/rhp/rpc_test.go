@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
+	"go.sia.tech/core/v2/net/rpc"
 	"go.sia.tech/core/v2/types"
 )
 
@@ -37,6 +40,15 @@ func (*HostSettings) Generate(rand *rand.Rand, size int) reflect.Value {
 	return randStruct(reflect.TypeOf(HostSettings{}), rand)
 }
 
+// Generate implements quick.Generator.
+func (*RPCSignedSettingsResponse) Generate(rand *rand.Rand, size int) reflect.Value {
+	r := &RPCSignedSettingsResponse{
+		Settings: *(*HostSettings)(nil).Generate(rand, size).Interface().(*HostSettings),
+	}
+	rand.Read(r.Signature[:])
+	return reflect.ValueOf(r)
+}
+
 // Generate implements quick.Generator.
 func (*RPCExecuteProgramRequest) Generate(rand *rand.Rand, size int) reflect.Value {
 	return randStruct(reflect.TypeOf(RPCExecuteProgramRequest{}), rand)
@@ -65,6 +77,7 @@ func TestEncoderRoundtrip(t *testing.T) {
 		&RPCLatestRevisionRequest{},
 		&RPCLatestRevisionResponse{},
 		&RPCSettingsRegisteredResponse{},
+		&RPCSignedSettingsResponse{},
 		&RPCExecuteProgramRequest{},
 		&WithdrawalMessage{},
 		&PayByEphemeralAccountRequest{},
@@ -100,3 +113,122 @@ func TestEncoderRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+func TestSettingsFingerprint(t *testing.T) {
+	settings := testSettings
+	fp := settings.Fingerprint()
+
+	// volatile fields should not affect the fingerprint
+	settings.BlockHeight += 100
+	settings.ValidUntil = settings.ValidUntil.Add(time.Hour)
+	settings.RemainingStorage += 1 << 22
+	settings.RemainingRegistryEntries++
+	if settings.Fingerprint() != fp {
+		t.Fatal("fingerprint changed when volatile fields were modified")
+	}
+
+	// prices should
+	settings.StoragePrice = settings.StoragePrice.Mul64(2)
+	if settings.Fingerprint() == fp {
+		t.Fatal("fingerprint did not change when price was modified")
+	}
+}
+
+func TestHostSettingsMaxLen(t *testing.T) {
+	// fill every field, using the longest permitted strings
+	settings := *(*HostSettings)(nil).Generate(rand.New(rand.NewSource(0)), 0).Interface().(*HostSettings)
+	settings.ValidUntil = time.Now().Round(time.Second).UTC()
+	settings.NetAddress = strings.Repeat("a", maxNetAddressLen)
+	settings.Version = strings.Repeat("1", maxVersionLen)
+	if n := types.EncodedLen(&settings); n != settings.MaxLen() {
+		t.Fatalf("MaxLen (%v) does not match encoded length (%v)", settings.MaxLen(), n)
+	}
+
+	var buf bytes.Buffer
+	var decoded HostSettings
+	if err := rpc.WriteObject(&buf, &settings); err != nil {
+		t.Fatal(err)
+	} else if err := rpc.ReadObject(&buf, &decoded); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decoded, settings) {
+		t.Fatalf("settings did not survive roundtrip: expected %v, got %v", settings, decoded)
+	}
+}
+
+func TestSliceCodec(t *testing.T) {
+	instrs := []InstrHasSector{{SectorRootOffset: 0}, {SectorRootOffset: 32}, {SectorRootOffset: 64}}
+
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	types.EncodeSlice(e, instrs)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	} else if buf.Len() != 8+len(instrs)*8 {
+		t.Fatalf("expected %v bytes, got %v", 8+len(instrs)*8, buf.Len())
+	}
+
+	var decoded []InstrHasSector
+	d := types.NewBufDecoder(buf.Bytes())
+	types.DecodeSlice(d, &decoded, len(instrs))
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decoded, instrs) {
+		t.Fatalf("expected %v, got %v", instrs, decoded)
+	}
+
+	// a slice longer than maxLen should be rejected
+	decoded = nil
+	d = types.NewBufDecoder(buf.Bytes())
+	types.DecodeSlice(d, &decoded, len(instrs)-1)
+	if d.Err() == nil {
+		t.Fatal("expected error decoding slice longer than maxLen")
+	} else if decoded != nil {
+		t.Fatal("slice should not be modified on error")
+	}
+}
+
+func TestEmptyObjects(t *testing.T) {
+	// empty objects encode to nothing, but must still round-trip, both on
+	// their own and within a stream of other objects
+	for _, obj := range []rpc.Object{new(InstrContractRevision), new(InstrSectorRoots)} {
+		var buf bytes.Buffer
+		if err := rpc.WriteRequest(&buf, RPCExecuteProgramID, obj); err != nil {
+			t.Fatal(err)
+		} else if err := rpc.WriteResponse(&buf, obj); err != nil {
+			t.Fatal(err)
+		} else if buf.Len() != 16+1 {
+			t.Fatalf("expected %v bytes, got %v", 16+1, buf.Len())
+		}
+		if id, err := rpc.ReadID(&buf); err != nil {
+			t.Fatal(err)
+		} else if id != RPCExecuteProgramID {
+			t.Fatal("wrong ID:", id)
+		} else if err := rpc.ReadRequest(&buf, obj); err != nil {
+			t.Fatal(err)
+		} else if err := rpc.ReadResponse(&buf, obj); err != nil {
+			t.Fatal(err)
+		} else if buf.Len() != 0 {
+			t.Fatal("leftover data:", buf.Len())
+		}
+	}
+
+	// an empty instruction within a program should not affect its neighbors
+	req := RPCExecuteProgramRequest{
+		Instructions: []Instruction{
+			&InstrContractRevision{},
+			&InstrHasSector{SectorRootOffset: 32},
+			&InstrSectorRoots{},
+		},
+		ProgramDataLength: 64,
+	}
+	var buf bytes.Buffer
+	if err := rpc.WriteObject(&buf, &req); err != nil {
+		t.Fatal(err)
+	}
+	var decoded RPCExecuteProgramRequest
+	if err := rpc.ReadObject(&buf, &decoded); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decoded, req) {
+		t.Fatalf("expected %v, got %v", req, decoded)
+	}
+}
@@ -281,3 +281,79 @@ func TestEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestSignedSettings(t *testing.T) {
+	hostPrivKey := types.GeneratePrivateKey()
+	hostPubKey := hostPrivKey.PublicKey()
+	settings := testSettings
+	settings.ValidUntil = time.Now().Add(time.Hour).Round(time.Second)
+
+	// serve the settings RPC over an in-memory connection
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	var m rpc.ServeMux
+	m.Handle(RPCSignedSettingsID, func(stream io.ReadWriter) error {
+		resp := SignSettings(hostPrivKey, settings)
+		return rpc.WriteResponse(stream, &resp)
+	})
+	// closing the host's session may discard frames that have not yet reached
+	// the renter, so keep it open until the renter is done
+	peerErr := make(chan error, 1)
+	renterDone := make(chan struct{})
+	go func() {
+		peerErr <- func() error {
+			sess, err := AcceptSession(c2, hostPrivKey)
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+			stream, err := sess.AcceptStream()
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			err = m.Serve(stream)
+			<-renterDone
+			return err
+		}()
+	}()
+
+	sess, err := DialSession(c1, hostPubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	stream := sess.DialStream()
+	defer stream.Close()
+	var resp RPCSignedSettingsResponse
+	if err := rpc.WriteRequest(stream, RPCSignedSettingsID, nil); err != nil {
+		t.Fatal(err)
+	} else if err := rpc.ReadResponse(stream, &resp); err != nil {
+		t.Fatal(err)
+	}
+	close(renterDone)
+	if err := <-peerErr; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resp.Verify(hostPubKey, time.Now()); err != nil {
+		t.Fatal(err)
+	} else if resp.Settings.SigHash() != settings.SigHash() {
+		t.Fatal("received settings do not match")
+	}
+
+	// verification should fail for the wrong key, tampered settings, or
+	// expired settings
+	if err := resp.Verify(types.GeneratePrivateKey().PublicKey(), time.Now()); err == nil {
+		t.Error("expected settings signed by another key to be rejected")
+	}
+	tampered := resp
+	tampered.Settings.StoragePrice = tampered.Settings.StoragePrice.Div64(2)
+	if err := tampered.Verify(hostPubKey, time.Now()); err == nil {
+		t.Error("expected tampered settings to be rejected")
+	}
+	if err := resp.Verify(hostPubKey, settings.ValidUntil.Add(time.Second)); err == nil {
+		t.Error("expected expired settings to be rejected")
+	}
+}
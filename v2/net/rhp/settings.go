@@ -180,9 +180,40 @@ func (p *HostSettings) DecodeFrom(d *types.Decoder) {
 	p.InstrWriteBaseCost.DecodeFrom(d)
 }
 
+// maxVersionLen is the maximum length of the Version string in encoded
+// HostSettings.
+const maxVersionLen = 32
+
 // MaxLen implements rpc.Object.
 func (p *HostSettings) MaxLen() int {
-	// UUID + bool + 25 types.Currency fields + 9 uint64 fields + version string + netaddress string
-	// netaddress maximum is based on RFC 1035 https://www.freesoft.org/CIE/RFC/1035/9.htm.
-	return 16 + 1 + (25 * 16) + (9 * 8) + 10 + 256
+	// bool + 28 types.Currency fields + 10 uint64 fields (including ValidUntil)
+	// + address + length-prefixed netaddress and version strings
+	return 1 + (28 * 16) + (10 * 8) + 32 + (8 + maxNetAddressLen) + (8 + maxVersionLen)
+}
+
+// SigHash returns the hash of the settings that is signed by the host.
+func (p *HostSettings) SigHash() types.Hash256 {
+	h := types.NewHasher()
+	h.E.WriteString("sia/sig/hostsettings")
+	p.EncodeTo(h.E)
+	return h.Sum()
+}
+
+// Fingerprint returns a short, stable identifier for the host's settings,
+// suitable for use as a cache key. Fields that change without any action by
+// the host operator (BlockHeight, ValidUntil, RemainingStorage, and
+// RemainingRegistryEntries) are excluded, so the fingerprint only changes when
+// the host's prices or configuration change.
+func (p HostSettings) Fingerprint() (fp [8]byte) {
+	p.BlockHeight = 0
+	p.ValidUntil = time.Time{}
+	p.RemainingStorage = 0
+	p.RemainingRegistryEntries = 0
+
+	h := types.NewHasher()
+	h.E.WriteString("sia/fingerprint/settings")
+	p.EncodeTo(h.E)
+	sum := h.Sum()
+	copy(fp[:], sum[:])
+	return
 }
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"go.sia.tech/core/v2/types"
 )
 
-// An Object can be sent and received via RPC.
+// An Object can be sent and received via RPC. Both the gateway protocol
+// (v2/net/gateway) and the v2 renter-host protocol (v2/net/rhp) use Object, so
+// a message type need only implement it once to be usable with either. The
+// root rhp/v2 package uses its own ProtocolObject instead.
 type Object interface {
 	types.EncoderTo
 	types.DecoderFrom
@@ -171,3 +175,47 @@ func ReadResponse(r io.Reader, resp Object) error {
 	}
 	return nil
 }
+
+// A HandlerFunc handles an RPC. The RPC ID has already been read from the
+// stream; the handler is responsible for reading the request object (if any)
+// and writing the response.
+type HandlerFunc func(stream io.ReadWriter) error
+
+// A ServeMux routes incoming RPCs to handlers based on their ID.
+type ServeMux struct {
+	mu       sync.Mutex
+	handlers map[Specifier]HandlerFunc
+}
+
+// Handle registers the handler for the given RPC ID. It panics if a handler
+// for id has already been registered.
+func (m *ServeMux) Handle(id Specifier, fn HandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.handlers == nil {
+		m.handlers = make(map[Specifier]HandlerFunc)
+	}
+	if _, ok := m.handlers[id]; ok {
+		panic("multiple registrations for " + id.String())
+	}
+	m.handlers[id] = fn
+}
+
+// Serve reads an RPC ID from stream and calls the corresponding handler. If
+// no handler is registered for the ID, an error is sent to the caller and
+// returned.
+func (m *ServeMux) Serve(stream io.ReadWriter) error {
+	id, err := ReadID(stream)
+	if err != nil {
+		return fmt.Errorf("couldn't read request ID: %w", err)
+	}
+	m.mu.Lock()
+	fn, ok := m.handlers[id]
+	m.mu.Unlock()
+	if !ok {
+		err := fmt.Errorf("unknown RPC ID %q", id)
+		WriteResponseErr(stream, err)
+		return err
+	}
+	return fn(stream)
+}
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+)
+
+type objString string
+
+func (s *objString) EncodeTo(e *types.Encoder)   { e.WriteString(string(*s)) }
+func (s *objString) DecodeFrom(d *types.Decoder) { *s = objString(d.ReadString()) }
+func (s *objString) MaxLen() int                 { return 100 }
+
+func TestServeMux(t *testing.T) {
+	rpcGreet := NewSpecifier("greet")
+	rpcFarewell := NewSpecifier("farewell")
+	respond := func(prefix objString) HandlerFunc {
+		return func(stream io.ReadWriter) error {
+			var name objString
+			if err := ReadRequest(stream, &name); err != nil {
+				return err
+			}
+			resp := prefix + name
+			return WriteResponse(stream, &resp)
+		}
+	}
+	var mux ServeMux
+	mux.Handle(rpcGreet, respond("Hello, "))
+	mux.Handle(rpcFarewell, respond("Goodbye, "))
+
+	call := func(id Specifier, name objString) (objString, error, error) {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- mux.Serve(c2) }()
+		// write the request concurrently, since the server may respond
+		// before reading it
+		go WriteRequest(c1, id, &name)
+		var resp objString
+		err := ReadResponse(c1, &resp)
+		return resp, err, <-serveErr
+	}
+
+	for _, test := range []struct {
+		id  Specifier
+		exp objString
+	}{
+		{rpcGreet, "Hello, foo"},
+		{rpcFarewell, "Goodbye, foo"},
+	} {
+		resp, err, serveErr := call(test.id, "foo")
+		if err != nil {
+			t.Fatal(err)
+		} else if serveErr != nil {
+			t.Fatal(serveErr)
+		} else if resp != test.exp {
+			t.Fatalf("expected %q, got %q", test.exp, resp)
+		}
+	}
+
+	// unknown RPCs should be rejected
+	if _, err, serveErr := call(NewSpecifier("unknown"), "foo"); serveErr == nil {
+		t.Fatal("expected Serve to reject unknown RPC")
+	} else if err == nil {
+		t.Fatal("expected caller to receive error for unknown RPC")
+	}
+}
@@ -64,6 +64,16 @@ func (c Currency) Cmp(v Currency) int {
 	}
 }
 
+// Less returns true if c < v.
+func (c Currency) Less(v Currency) bool {
+	return c.Cmp(v) < 0
+}
+
+// Greater returns true if c > v.
+func (c Currency) Greater(v Currency) bool {
+	return c.Cmp(v) > 0
+}
+
 // Add returns c+v. If the result would overflow, Add panics.
 //
 // It is safe to use Add in any context where the sum cannot exceed the total
@@ -107,6 +117,16 @@ func (c Currency) SubWithUnderflow(v Currency) (Currency, bool) {
 //
 // Note that it is safe to multiply any two Currency values that are below 2^64.
 func (c Currency) Mul64(v uint64) Currency {
+	p, overflow := c.Mul64WithOverflow(v)
+	if overflow {
+		panic("overflow")
+	}
+	return p
+}
+
+// Mul64WithOverflow returns c*v, along with a boolean indicating whether the
+// result overflowed.
+func (c Currency) Mul64WithOverflow(v uint64) (Currency, bool) {
 	// NOTE: this is the overflow-checked equivalent of:
 	//
 	//   hi, lo := bits.Mul64(c.Lo, v)
@@ -115,10 +135,7 @@ func (c Currency) Mul64(v uint64) Currency {
 	hi0, lo0 := bits.Mul64(c.Lo, v)
 	hi1, lo1 := bits.Mul64(c.Hi, v)
 	hi2, c0 := bits.Add64(hi0, lo1, 0)
-	if hi1 != 0 || c0 != 0 {
-		panic("overflow")
-	}
-	return Currency{lo0, hi2}
+	return Currency{lo0, hi2}, hi1 != 0 || c0 != 0
 }
 
 // Div returns c/v. If v == 0, Div panics.
@@ -254,8 +271,12 @@ func (c Currency) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + c.ExactString() + `"`), nil
 }
 
-// UnmarshalJSON implements json.Unmarshaler.
+// UnmarshalJSON implements json.Unmarshaler. As with the standard library's
+// types, null is a no-op.
 func (c *Currency) UnmarshalJSON(b []byte) (err error) {
+	if string(b) == "null" {
+		return nil
+	}
 	*c, err = parseExactCurrency(strings.Trim(string(b), `"`))
 	return
 }
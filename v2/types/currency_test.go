@@ -50,10 +50,31 @@ func TestCurrencyCmp(t *testing.T) {
 			NewCurrency(math.MaxUint64, 0),
 			1,
 		},
+		{
+			maxCurrency,
+			maxCurrency,
+			0,
+		},
+		{
+			maxCurrency,
+			ZeroCurrency,
+			1,
+		},
+		{
+			maxCurrency.Sub(NewCurrency64(1)),
+			maxCurrency,
+			-1,
+		},
 	}
 	for _, tt := range tests {
 		if got := tt.a.Cmp(tt.b); got != tt.want {
 			t.Errorf("Currency.Cmp(%d, %d) expected = %d, want %d", tt.a, tt.b, got, tt.want)
+		} else if got := tt.a.Equals(tt.b); got != (tt.want == 0) {
+			t.Errorf("Currency.Equals(%d, %d) = %t", tt.a, tt.b, got)
+		} else if got := tt.a.Less(tt.b); got != (tt.want < 0) {
+			t.Errorf("Currency.Less(%d, %d) = %t", tt.a, tt.b, got)
+		} else if got := tt.a.Greater(tt.b); got != (tt.want > 0) {
+			t.Errorf("Currency.Greater(%d, %d) = %t", tt.a, tt.b, got)
 		}
 	}
 }
@@ -304,6 +325,60 @@ func TestCurrencyMul64(t *testing.T) {
 	}
 }
 
+func TestCurrencyMul64WithOverflow(t *testing.T) {
+	tests := []struct {
+		a         Currency
+		b         uint64
+		want      Currency
+		overflows bool
+	}{
+		{
+			ZeroCurrency,
+			math.MaxUint64,
+			ZeroCurrency,
+			false,
+		},
+		{
+			Siacoins(30),
+			50,
+			Siacoins(1500),
+			false,
+		},
+		{
+			maxCurrency,
+			1,
+			maxCurrency,
+			false,
+		},
+		{
+			maxCurrency,
+			2,
+			maxCurrency.Sub(NewCurrency64(1)),
+			true,
+		},
+		{
+			NewCurrency(0, 1<<63),
+			2,
+			ZeroCurrency,
+			true,
+		},
+		{
+			NewCurrency(math.MaxUint64, math.MaxUint64>>1),
+			2,
+			maxCurrency.Sub(NewCurrency64(1)),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		got, overflows := tt.a.Mul64WithOverflow(tt.b)
+		if tt.overflows != overflows {
+			t.Errorf("Currency.Mul64WithOverflow(%d, %d) overflow %t, want %t", tt.a, tt.b, overflows, tt.overflows)
+		} else if !got.Equals(tt.want) {
+			t.Errorf("Currency.Mul64WithOverflow(%d, %d) expected = %v, got %v", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
 func TestCurrencyDiv(t *testing.T) {
 	tests := []struct {
 		a, b, want Currency
@@ -494,6 +569,14 @@ func TestCurrencyJSON(t *testing.T) {
 			t.Errorf("Currency.UnmarshalJSON(%s) = %d, want %d", buf, c, tt.val)
 		}
 	}
+
+	// null should be a no-op
+	c := NewCurrency64(5)
+	if err := c.UnmarshalJSON([]byte("null")); err != nil {
+		t.Error("Currency.UnmarshalJSON(null) err =", err)
+	} else if !c.Equals(NewCurrency64(5)) {
+		t.Errorf("Currency.UnmarshalJSON(null) modified value: %d", c)
+	}
 }
 
 func TestParseCurrency(t *testing.T) {
@@ -119,12 +119,17 @@ func EncodedLen(v interface{}) int {
 	return buf.Len()
 }
 
+// ErrDecodeLimit is returned when a Decoder attempts to read past the limit of
+// its io.LimitedReader, as opposed to the underlying stream itself ending.
+var ErrDecodeLimit = errors.New("message truncated at decoder limit")
+
 // A Decoder reads values from an underlying stream. Callers MUST check
 // (*Decoder).Err before using any decoded values.
 type Decoder struct {
-	lr  io.LimitedReader
-	buf [64]byte
-	err error
+	lr    io.LimitedReader
+	limit int64
+	buf   [64]byte
+	err   error
 }
 
 // SetErr sets the Decoder's error if it has not already been set. SetErr should
@@ -151,6 +156,9 @@ func (d *Decoder) Read(p []byte) (int, error) {
 		}
 		var read int
 		read, d.err = io.ReadFull(&d.lr, d.buf[:want])
+		if d.err != nil && d.lr.N == 0 && d.limit > 0 {
+			d.err = fmt.Errorf("%w (%v bytes)", ErrDecodeLimit, d.limit)
+		}
 		n += copy(p[n:], d.buf[:read])
 	}
 	return n, d.err
@@ -209,10 +217,12 @@ func (d *Decoder) ReadString() string {
 	return string(d.ReadBytes())
 }
 
-// NewDecoder returns a Decoder that wraps the provided stream.
+// NewDecoder returns a Decoder that wraps the provided stream. If decoding
+// exceeds the limit of lr, the Decoder's error will wrap ErrDecodeLimit.
 func NewDecoder(lr io.LimitedReader) *Decoder {
 	return &Decoder{
-		lr: lr,
+		lr:    lr,
+		limit: lr.N,
 	}
 }
 
@@ -223,10 +233,40 @@ type DecoderFrom interface {
 
 // NewBufDecoder returns a Decoder for the provided byte slice.
 func NewBufDecoder(buf []byte) *Decoder {
-	return NewDecoder(io.LimitedReader{
+	d := NewDecoder(io.LimitedReader{
 		R: bytes.NewReader(buf),
 		N: int64(len(buf)),
 	})
+	d.limit = 0 // the end of buf is the end of the stream, not a limit
+	return d
+}
+
+// EncodeSlice writes a length-prefixed slice of objects to e.
+func EncodeSlice[T any, P interface {
+	*T
+	EncoderTo
+}](e *Encoder, s []T) {
+	e.WritePrefix(len(s))
+	for i := range s {
+		P(&s[i]).EncodeTo(e)
+	}
+}
+
+// DecodeSlice reads a length-prefixed slice of objects from d into s. If the
+// length prefix exceeds maxLen, DecodeSlice sets d.Err and leaves s unchanged.
+func DecodeSlice[T any, P interface {
+	*T
+	DecoderFrom
+}](d *Decoder, s *[]T, maxLen int) {
+	n := d.ReadPrefix()
+	if n > maxLen {
+		d.SetErr(fmt.Errorf("slice length exceeds maximum (%v > %v)", n, maxLen))
+		return
+	}
+	*s = make([]T, n)
+	for i := range *s {
+		P(&(*s)[i]).DecodeFrom(d)
+	}
 }
 
 // A Hasher streams objects into an instance of Sia's hash function.
@@ -329,10 +369,28 @@ func (se StateElement) EncodeTo(e *Encoder) {
 	e.writeMerkleProof(se.MerkleProof)
 }
 
+// EncodeTo implements types.EncoderTo.
+func (cf CoveredFields) EncodeTo(e *Encoder) {
+	for _, indices := range [...][]uint64{cf.SiacoinInputs, cf.SiacoinOutputs, cf.SiafundInputs, cf.SiafundOutputs} {
+		e.WritePrefix(len(indices))
+		for _, i := range indices {
+			e.WriteUint64(i)
+		}
+	}
+}
+
+func (e *Encoder) writeCoveredFields(cf *CoveredFields) {
+	e.WriteBool(cf != nil)
+	if cf != nil {
+		cf.EncodeTo(e)
+	}
+}
+
 // EncodeTo implements types.EncoderTo.
 func (in SiacoinInput) EncodeTo(e *Encoder) {
 	in.Parent.EncodeTo(e)
 	in.SpendPolicy.EncodeTo(e)
+	e.writeCoveredFields(in.CoveredFields)
 	e.WritePrefix(len(in.Signatures))
 	for _, sig := range in.Signatures {
 		sig.EncodeTo(e)
@@ -351,6 +409,7 @@ func (in SiafundInput) EncodeTo(e *Encoder) {
 	in.Parent.EncodeTo(e)
 	in.ClaimAddress.EncodeTo(e)
 	in.SpendPolicy.EncodeTo(e)
+	e.writeCoveredFields(in.CoveredFields)
 	e.WritePrefix(len(in.Signatures))
 	for _, sig := range in.Signatures {
 		sig.EncodeTo(e)
@@ -450,6 +509,7 @@ const (
 	opPublicKey
 	opThreshold
 	opUnlockConditions
+	opOpaque
 )
 
 // EncodeTo implements types.EncoderTo.
@@ -478,6 +538,9 @@ func (p SpendPolicy) EncodeTo(e *Encoder) {
 				p.PublicKeys[i].EncodeTo(e)
 			}
 			e.WriteUint8(p.SignaturesRequired)
+		case PolicyTypeOpaque:
+			e.WriteUint8(opOpaque)
+			Address(p).EncodeTo(e)
 		default:
 			panic(fmt.Sprintf("unhandled policy type %T", p))
 		}
@@ -571,6 +634,68 @@ func (txn Transaction) EncodeTo(e *Encoder) {
 	}
 }
 
+// blockVersion is the version of the Block encoding written by EncodeTo. Each
+// transaction is prefixed with its encoded length, and the block ends with a
+// checksum, so that a corrupted block is rejected rather than decoded into a
+// malformed one.
+const blockVersion = 1
+
+// blockChecksum returns a hash covering a block header and the hashes of the
+// full encodings of the block's transactions.
+func blockChecksum(header BlockHeader, txnHashes []Hash256) Hash256 {
+	h := hasherPool.Get().(*Hasher)
+	defer hasherPool.Put(h)
+	h.Reset()
+	h.E.WriteString("sia/checksum/block")
+	header.EncodeTo(h.E)
+	h.E.WritePrefix(len(txnHashes))
+	for _, th := range txnHashes {
+		th.EncodeTo(h.E)
+	}
+	return h.Sum()
+}
+
+// EncodeTo implements types.EncoderTo.
+func (b Block) EncodeTo(e *Encoder) {
+	e.WriteUint8(blockVersion)
+	b.Header.EncodeTo(e)
+	e.WritePrefix(len(b.Transactions))
+	// encode each transaction once, reusing the encoding for its length
+	// prefix, body, and checksum hash
+	var buf bytes.Buffer
+	te := NewEncoder(&buf)
+	txnHashes := make([]Hash256, len(b.Transactions))
+	for i, txn := range b.Transactions {
+		buf.Reset()
+		txn.EncodeTo(te)
+		te.Flush()
+		e.WriteBytes(buf.Bytes())
+		txnHashes[i] = blake2b.Sum256(buf.Bytes())
+	}
+	blockChecksum(b.Header, txnHashes).EncodeTo(e)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteTo implements io.WriterTo. Transactions are encoded and written one at a
+// time, so the full encoding of b is never held in memory.
+func (b Block) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	e := NewEncoder(cw)
+	b.EncodeTo(e)
+	err := e.Flush()
+	return cw.n, err
+}
+
 // DecodeFrom implements types.DecoderFrom.
 func (h *Hash256) DecodeFrom(d *Decoder) { d.Read(h[:]) }
 
@@ -670,6 +795,10 @@ func (p *SpendPolicy) DecodeFrom(d *Decoder) {
 			}
 			uc.SignaturesRequired = d.ReadUint8()
 			return SpendPolicy{uc}, nil
+		case opOpaque:
+			var addr Address
+			addr.DecodeFrom(d)
+			return PolicyOpaque(addr), nil
 		default:
 			return SpendPolicy{}, fmt.Errorf("unknown policy (opcode %v)", op)
 		}
@@ -699,10 +828,30 @@ func (se *StateElement) DecodeFrom(d *Decoder) {
 	se.MerkleProof = d.readMerkleProof()
 }
 
+// DecodeFrom implements types.DecoderFrom.
+func (cf *CoveredFields) DecodeFrom(d *Decoder) {
+	for _, indices := range [...]*[]uint64{&cf.SiacoinInputs, &cf.SiacoinOutputs, &cf.SiafundInputs, &cf.SiafundOutputs} {
+		*indices = make([]uint64, d.ReadPrefix())
+		for i := range *indices {
+			(*indices)[i] = d.ReadUint64()
+		}
+	}
+}
+
+func (d *Decoder) readCoveredFields() *CoveredFields {
+	if !d.ReadBool() {
+		return nil
+	}
+	cf := new(CoveredFields)
+	cf.DecodeFrom(d)
+	return cf
+}
+
 // DecodeFrom implements types.DecoderFrom.
 func (in *SiacoinInput) DecodeFrom(d *Decoder) {
 	in.Parent.DecodeFrom(d)
 	in.SpendPolicy.DecodeFrom(d)
+	in.CoveredFields = d.readCoveredFields()
 	in.Signatures = make([]Signature, d.ReadPrefix())
 	for i := range in.Signatures {
 		in.Signatures[i].DecodeFrom(d)
@@ -721,6 +870,7 @@ func (in *SiafundInput) DecodeFrom(d *Decoder) {
 	in.Parent.DecodeFrom(d)
 	in.ClaimAddress.DecodeFrom(d)
 	in.SpendPolicy.DecodeFrom(d)
+	in.CoveredFields = d.readCoveredFields()
 	in.Signatures = make([]Signature, d.ReadPrefix())
 	for i := range in.Signatures {
 		in.Signatures[i].DecodeFrom(d)
@@ -871,3 +1021,32 @@ func (txn *Transaction) DecodeFrom(d *Decoder) {
 		txn.MinerFee.DecodeFrom(d)
 	}
 }
+
+// DecodeFrom implements types.DecoderFrom.
+func (b *Block) DecodeFrom(d *Decoder) {
+	if version := d.ReadUint8(); version != blockVersion {
+		d.SetErr(fmt.Errorf("unsupported block version (%v)", version))
+		return
+	}
+	b.Header.DecodeFrom(d)
+	b.Transactions = make([]Transaction, d.ReadPrefix())
+	// the checksum covers the transactions' encoded bytes, rather than their
+	// decoded values, so that non-canonical encodings are rejected
+	txnHashes := make([]Hash256, len(b.Transactions))
+	for i := range b.Transactions {
+		buf := d.ReadBytes()
+		txnHashes[i] = blake2b.Sum256(buf)
+		td := NewBufDecoder(buf)
+		b.Transactions[i].DecodeFrom(td)
+		if err := td.Err(); err != nil {
+			d.SetErr(fmt.Errorf("could not decode transaction %v: %w", i, err))
+		} else if td.lr.N != 0 {
+			d.SetErr(fmt.Errorf("transaction %v has %v trailing bytes", i, td.lr.N))
+		}
+	}
+	var checksum Hash256
+	checksum.DecodeFrom(d)
+	if d.Err() == nil && checksum != blockChecksum(b.Header, txnHashes) {
+		d.SetErr(errors.New("block checksum mismatch"))
+	}
+}
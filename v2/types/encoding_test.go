@@ -3,6 +3,7 @@ package types
 import (
 	"bytes"
 	"encoding"
+	"errors"
 	"io"
 	"math/rand"
 	"reflect"
@@ -12,7 +13,7 @@ import (
 
 // Generate implements quick.Generator.
 func (p SpendPolicy) Generate(rand *rand.Rand, size int) reflect.Value {
-	switch rand.Intn(4) + 1 {
+	switch rand.Intn(5) + 1 {
 	case opAbove:
 		return reflect.ValueOf(PolicyAbove(rand.Uint64()))
 	case opPublicKey:
@@ -38,6 +39,10 @@ func (p SpendPolicy) Generate(rand *rand.Rand, size int) reflect.Value {
 			rand.Read(p.PublicKeys[i][:])
 		}
 		return reflect.ValueOf(SpendPolicy{p})
+	case opOpaque:
+		var addr Address
+		rand.Read(addr[:])
+		return reflect.ValueOf(PolicyOpaque(addr))
 	}
 	panic("unreachable")
 }
@@ -154,3 +159,180 @@ func TestMarshalTextRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+type maxWriteRecorder struct {
+	bytes.Buffer
+	maxWrite int
+}
+
+func (w *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.maxWrite {
+		w.maxWrite = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestBlockWriteTo(t *testing.T) {
+	b := Block{
+		Header: BlockHeader{
+			Height:    7,
+			ParentID:  BlockID{0: 0xAA, 31: 0xBB},
+			Nonce:     1009,
+			Timestamp: CurrentTimestamp(),
+		},
+		Transactions: make([]Transaction, 100),
+	}
+	rng := rand.New(rand.NewSource(0))
+	for i := range b.Transactions {
+		v, ok := quick.Value(reflect.TypeOf(Transaction{}), rng)
+		if !ok {
+			t.Fatal("could not generate value")
+		}
+		b.Transactions[i] = v.Interface().(Transaction)
+	}
+
+	// buffered encoding
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	b.EncodeTo(e)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// streamed encoding
+	var w maxWriteRecorder
+	n, err := b.WriteTo(&w)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo reported %v bytes, expected %v", n, buf.Len())
+	} else if !bytes.Equal(w.Bytes(), buf.Bytes()) {
+		t.Fatal("streamed encoding does not match buffered encoding")
+	} else if w.maxWrite >= buf.Len() {
+		t.Fatal("block was not streamed")
+	}
+
+	var decBlock Block
+	d := NewBufDecoder(w.Bytes())
+	decBlock.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if decBlock.ID() != b.ID() || len(decBlock.Transactions) != len(b.Transactions) {
+		t.Fatal("block did not survive roundtrip")
+	}
+	for i := range b.Transactions {
+		if decBlock.Transactions[i].ID() != b.Transactions[i].ID() {
+			t.Fatalf("transaction %v did not survive roundtrip", i)
+		}
+	}
+}
+
+func TestBlockEncoding(t *testing.T) {
+	b := Block{
+		Header: BlockHeader{
+			Height:    7,
+			ParentID:  BlockID{0: 0xAA, 31: 0xBB},
+			Nonce:     1009,
+			Timestamp: CurrentTimestamp(),
+		},
+		Transactions: make([]Transaction, 3),
+	}
+	rng := rand.New(rand.NewSource(0))
+	for i := range b.Transactions {
+		v, ok := quick.Value(reflect.TypeOf(Transaction{}), rng)
+		if !ok {
+			t.Fatal("could not generate value")
+		}
+		b.Transactions[i] = v.Interface().(Transaction)
+	}
+	encode := func(b Block) []byte {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		b.EncodeTo(e)
+		e.Flush()
+		return buf.Bytes()
+	}
+	decode := func(buf []byte) (b Block, err error) {
+		d := NewBufDecoder(buf)
+		b.DecodeFrom(d)
+		return b, d.Err()
+	}
+	equal := func(a, b Block) bool {
+		if a.Header != b.Header || len(a.Transactions) != len(b.Transactions) {
+			return false
+		}
+		for i := range a.Transactions {
+			if !a.Transactions[i].StrictEqual(&b.Transactions[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// roundtrip
+	buf := encode(b)
+	if buf[0] != blockVersion {
+		t.Fatalf("expected version %v, got %v", blockVersion, buf[0])
+	} else if dec, err := decode(buf); err != nil {
+		t.Fatal(err)
+	} else if !equal(dec, b) {
+		t.Fatal("block did not survive roundtrip")
+	} else if dec, err := decode(encode(Block{})); err != nil || !equal(dec, Block{}) {
+		t.Fatal("empty block did not survive roundtrip:", err)
+	}
+
+	// corrupting any byte should cause decoding to fail
+	for i := range buf {
+		corrupt := append([]byte(nil), buf...)
+		corrupt[i] ^= 1 << (i % 8)
+		if _, err := decode(corrupt); err == nil {
+			t.Fatalf("decoded block with corrupted byte %v", i)
+		}
+	}
+	// as should truncating it
+	if _, err := decode(buf[:len(buf)-1]); err == nil {
+		t.Fatal("decoded truncated block")
+	}
+
+	// each transaction should be prefixed with the length of its encoding
+	d := NewBufDecoder(buf[1:])
+	var h BlockHeader
+	h.DecodeFrom(d)
+	if n := d.ReadPrefix(); n != len(b.Transactions) {
+		t.Fatalf("expected %v transactions, got %v", len(b.Transactions), n)
+	}
+	for i, txn := range b.Transactions {
+		if n := d.ReadPrefix(); n != EncodedLen(txn) {
+			t.Fatalf("transaction %v: expected length prefix %v, got %v", i, EncodedLen(txn), n)
+		}
+		d.Read(make([]byte, EncodedLen(txn)))
+	}
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoderLimit(t *testing.T) {
+	// reading past the configured limit should report ErrDecodeLimit
+	d := NewDecoder(io.LimitedReader{R: bytes.NewReader(make([]byte, 100)), N: 10})
+	d.Read(make([]byte, 16))
+	if !errors.Is(d.Err(), ErrDecodeLimit) {
+		t.Fatalf("expected ErrDecodeLimit, got %v", d.Err())
+	}
+
+	// the underlying stream ending before the limit should not
+	d = NewDecoder(io.LimitedReader{R: bytes.NewReader(make([]byte, 5)), N: 100})
+	d.ReadUint64()
+	if errors.Is(d.Err(), ErrDecodeLimit) {
+		t.Fatal("did not expect ErrDecodeLimit when stream ended")
+	} else if !errors.Is(d.Err(), io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", d.Err())
+	}
+
+	// nor should reaching the end of a buffer
+	d = NewBufDecoder(make([]byte, 5))
+	d.ReadUint64()
+	if d.Err() == nil || errors.Is(d.Err(), ErrDecodeLimit) {
+		t.Fatalf("expected EOF error, got %v", d.Err())
+	}
+}
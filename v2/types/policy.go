@@ -54,10 +54,22 @@ type PolicyTypeUnlockConditions struct {
 	SignaturesRequired uint8
 }
 
+// PolicyTypeOpaque is the address of a policy whose contents are not known.
+// It is useful when constructing a transaction template for co-signers: the
+// input's address can be referenced without revealing the concrete policy.
+// Opaque policies can never be satisfied; they must be replaced with the
+// concrete policy before the transaction is validated.
+type PolicyTypeOpaque Address
+
+// PolicyOpaque returns a policy whose address is addr, but which can never be
+// satisfied.
+func PolicyOpaque(addr Address) SpendPolicy { return SpendPolicy{PolicyTypeOpaque(addr)} }
+
 func (PolicyTypeAbove) isPolicy()            {}
 func (PolicyTypePublicKey) isPolicy()        {}
 func (PolicyTypeThreshold) isPolicy()        {}
 func (PolicyTypeUnlockConditions) isPolicy() {}
+func (PolicyTypeOpaque) isPolicy()           {}
 
 func (uc PolicyTypeUnlockConditions) root() Hash256 {
 	buf := make([]byte, 65)
@@ -114,6 +126,8 @@ func (p SpendPolicy) Address() Address {
 		// NOTE: to preserve compatibility, we use the original address
 		// derivation code for these policies
 		return Address(uc.root())
+	} else if addr, ok := p.Type.(PolicyTypeOpaque); ok {
+		return Address(addr)
 	}
 	h := hasherPool.Get().(*Hasher)
 	defer hasherPool.Put(h)
@@ -165,6 +179,11 @@ func (p SpendPolicy) String() string {
 		sb.WriteString("],")
 		sb.WriteString(strconv.FormatUint(uint64(p.SignaturesRequired), 10))
 		sb.WriteByte(')')
+
+	case PolicyTypeOpaque:
+		sb.WriteString("opaque(")
+		sb.WriteString(hex.EncodeToString(p[:]))
+		sb.WriteByte(')')
 	}
 	return sb.String()
 }
@@ -220,6 +239,17 @@ func ParseSpendPolicy(s string) (SpendPolicy, error) {
 		_, err = hex.Decode(pk[:], []byte(t))
 		return
 	}
+	parseAddress := func() (addr Address) {
+		t := nextToken()
+		if err != nil {
+			return
+		} else if len(t) != 64 {
+			err = fmt.Errorf("invalid address length (%d)", len(t))
+			return
+		}
+		_, err = hex.Decode(addr[:], []byte(t))
+		return
+	}
 	var parseSpendPolicy func() SpendPolicy
 	parseSpendPolicy = func() SpendPolicy {
 		typ := nextToken()
@@ -264,6 +294,8 @@ func ParseSpendPolicy(s string) (SpendPolicy, error) {
 					SignaturesRequired: uint8(sigsRequired),
 				},
 			}
+		case "opaque":
+			return PolicyOpaque(parseAddress())
 		default:
 			if err == nil {
 				err = fmt.Errorf("unrecognized policy type %q", typ)
@@ -290,12 +322,21 @@ func (p *SpendPolicy) UnmarshalText(b []byte) (err error) {
 	return
 }
 
-// MarshalJSON implements json.Marshaler.
+// MarshalJSON implements json.Marshaler. The zero SpendPolicy, which has no
+// string representation, is encoded as null.
 func (p SpendPolicy) MarshalJSON() ([]byte, error) {
+	if p.Type == nil {
+		return []byte("null"), nil
+	}
 	return []byte(`"` + p.String() + `"`), nil
 }
 
-// UnmarshalJSON implements json.Unmarshaler.
+// UnmarshalJSON implements json.Unmarshaler. As with the other types in this
+// package, null is a no-op, so decoding the null written for the zero
+// SpendPolicy into a fresh value yields the zero SpendPolicy.
 func (p *SpendPolicy) UnmarshalJSON(b []byte) (err error) {
+	if string(b) == "null" {
+		return nil
+	}
 	return p.UnmarshalText(bytes.Trim(b, `"`))
 }
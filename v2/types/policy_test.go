@@ -86,6 +86,11 @@ func TestPolicyAddressString(t *testing.T) {
 			}},
 			want: "addr:2f4a4a64712545bde8d38776377da2794d54685284a3768f78884643dad33a9a3822a0f4dc39",
 		},
+		{
+			// opaque policies have the address they were constructed from
+			policy: PolicyOpaque(StandardAddress(publicKeys[0])),
+			want:   "addr:4c9de1b2775091af2be8f427b1886f2120cdfe074fb3bc3b6011e281f36309e2468424667b70",
+		},
 	}
 	for _, tt := range tests {
 		if got := tt.policy.Address().String(); got != tt.want {
@@ -182,6 +187,10 @@ func TestPolicyJSON(t *testing.T) {
 			SpendPolicy{PolicyTypeUnlockConditions{}},
 			"uc(0,[],0)",
 		},
+		{
+			PolicyOpaque(Address{0: 0xAA, 31: 0xBB}),
+			"opaque(aa000000000000000000000000000000000000000000000000000000000000bb)",
+		},
 	}
 
 	for _, test := range tests {
@@ -233,4 +242,31 @@ func TestPolicyJSON(t *testing.T) {
 			t.Fatalf("unmarshal should have errored on input %s", test)
 		}
 	}
+
+	// the zero policy has no string representation, so it is encoded as null
+	data, err := json.Marshal(SpendPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "null" {
+		t.Fatalf("expected zero policy to marshal as null, got %s", data)
+	}
+	var p SpendPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	} else if p.Type != nil {
+		t.Fatalf("expected zero policy, got %v", p)
+	}
+
+	// a transaction template with an unset policy should survive a roundtrip
+	txn := Transaction{SiacoinInputs: []SiacoinInput{{Signatures: []Signature{{1}}}}}
+	data, err = json.Marshal(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decTxn Transaction
+	if err := json.Unmarshal(data, &decTxn); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(decTxn, txn) {
+		t.Fatalf("transaction did not survive JSON roundtrip: %s", data)
+	}
 }
@@ -147,23 +147,64 @@ func (fc FileContract) MissedHostOutput() SiacoinOutput {
 	}
 }
 
+// IsFinalized reports whether the contract has been finalized, i.e. whether
+// its revision number is MaxRevisionNumber. A finalized contract cannot be
+// revised further.
+func (fc FileContract) IsFinalized() bool {
+	return fc.RevisionNumber == MaxRevisionNumber
+}
+
+// HasValidKeys reports whether both the renter and host public keys are
+// non-zero. A contract with a zero key can never be validly signed.
+func (fc FileContract) HasValidKeys() bool {
+	return fc.RenterPublicKey != (PublicKey{}) && fc.HostPublicKey != (PublicKey{})
+}
+
 // A SiacoinInput spends an unspent SiacoinElement in the state accumulator by
 // revealing its public key and signing the transaction.
+//
+// Signatures must be ordered according to the position of their corresponding
+// keys in the SpendPolicy, as encountered by a depth-first traversal. Keys that
+// do not sign are simply skipped; for example, a 2-of-3 policy signed by the
+// first and third keys requires exactly those two signatures, in that order.
+//
+// By default, the signatures cover the entire transaction. If CoveredFields is
+// non-nil, they instead cover only the specified inputs and outputs; see
+// CoveredFields for details.
 type SiacoinInput struct {
-	Parent      SiacoinElement
-	SpendPolicy SpendPolicy
-	Signatures  []Signature
+	Parent        SiacoinElement
+	SpendPolicy   SpendPolicy
+	CoveredFields *CoveredFields
+	Signatures    []Signature
 }
 
 // A SiafundInput spends an unspent SiafundElement in the state accumulator by
 // revealing its public key and signing the transaction. Inputs also include a
 // ClaimAddress, specifying the recipient of the siacoins that were earned by
-// the SiafundElement.
+// the SiafundElement. Signatures are ordered, and may be restricted to a
+// subset of the transaction, in the same manner as those of a SiacoinInput.
 type SiafundInput struct {
-	Parent       SiafundElement
-	ClaimAddress Address
-	SpendPolicy  SpendPolicy
-	Signatures   []Signature
+	Parent        SiafundElement
+	ClaimAddress  Address
+	SpendPolicy   SpendPolicy
+	CoveredFields *CoveredFields
+	Signatures    []Signature
+}
+
+// CoveredFields specifies, by index, the subset of a transaction's inputs and
+// outputs that an input's signatures cover. This allows multiple parties to
+// contribute to a transaction without signing each other's contributions, e.g.
+// in a coinjoin. The set must include the input being signed, and each list
+// must be strictly increasing.
+//
+// Signers should take care when using CoveredFields: fields that are not
+// covered, including the miner fee and any file contracts, may be changed
+// freely by other parties without invalidating the signature.
+type CoveredFields struct {
+	SiacoinInputs  []uint64
+	SiacoinOutputs []uint64
+	SiafundInputs  []uint64
+	SiafundOutputs []uint64
 }
 
 // A FileContractRevision updates the state of an existing file contract.
@@ -213,6 +254,50 @@ func (fcr *FileContractResolution) HasFinalization() bool {
 	return fcr.Finalization != (FileContract{})
 }
 
+// A ResolutionMode identifies the manner in which a FileContractResolution
+// resolves its contract.
+type ResolutionMode uint8
+
+// Possible resolution modes.
+const (
+	ResolutionMissed ResolutionMode = iota
+	ResolutionRenewal
+	ResolutionStorageProof
+	ResolutionFinalization
+)
+
+// String implements fmt.Stringer.
+func (m ResolutionMode) String() string {
+	switch m {
+	case ResolutionMissed:
+		return "missed"
+	case ResolutionRenewal:
+		return "renewal"
+	case ResolutionStorageProof:
+		return "storage proof"
+	case ResolutionFinalization:
+		return "finalization"
+	default:
+		return fmt.Sprintf("ResolutionMode(%d)", uint8(m))
+	}
+}
+
+// Mode returns the manner in which the resolution resolves its contract. A
+// valid resolution contains at most one of a renewal, storage proof, or
+// finalization; if it contains none, it is a missed resolution.
+func (fcr *FileContractResolution) Mode() ResolutionMode {
+	switch {
+	case fcr.HasRenewal():
+		return ResolutionRenewal
+	case fcr.HasStorageProof():
+		return ResolutionStorageProof
+	case fcr.HasFinalization():
+		return ResolutionFinalization
+	default:
+		return ResolutionMissed
+	}
+}
+
 // A FileContractRenewal renews a file contract.
 type FileContractRenewal struct {
 	FinalRevision   FileContract
@@ -280,12 +365,22 @@ type FileContractElement struct {
 	FileContract
 }
 
+// RemainingDuration returns the number of blocks between currentHeight and the
+// start of the contract's proof window, or 0 if the window has already started.
+// This is the duration used when pricing storage for the contract.
+func (fce FileContractElement) RemainingDuration(currentHeight uint64) uint64 {
+	if currentHeight >= fce.WindowStart {
+		return 0
+	}
+	return fce.WindowStart - currentHeight
+}
+
 // An Attestation associates a key-value pair with an identity. For example,
 // hosts attest to their network address by setting Key to "HostAnnouncement"
-// and Value to their address, thereby allowing renters to discover them.
-// Generally, an attestation for a particular key is considered to overwrite any
-// previous attestations with the same key. (This allows hosts to announce a new
-// network address, for example.)
+// and Value to their encoded address (see the rhp package), thereby allowing
+// renters to discover them. Generally, an attestation for a particular key is
+// considered to overwrite any previous attestations with the same key. (This
+// allows hosts to announce a new network address, for example.)
 type Attestation struct {
 	PublicKey PublicKey
 	Key       string
@@ -360,6 +455,7 @@ func (txn *Transaction) ID() TransactionID {
 		fcr.StorageProof.WindowStart.EncodeTo(h.E)
 		fcr.Finalization.EncodeTo(h.E)
 	}
+	h.E.WritePrefix(len(txn.Attestations))
 	for _, a := range txn.Attestations {
 		a.EncodeTo(h.E)
 	}
@@ -369,6 +465,28 @@ func (txn *Transaction) ID() TransactionID {
 	return TransactionID(h.Sum())
 }
 
+// Equal reports whether txn and other have the same semantic content, i.e.
+// whether they have the same ID. Signatures and Merkle proofs are ignored, so
+// two copies of a transaction whose proofs were updated against different
+// accumulator states will compare equal.
+func (txn *Transaction) Equal(other *Transaction) bool {
+	return txn.ID() == other.ID()
+}
+
+// StrictEqual reports whether txn and other are identical, including their
+// signatures and Merkle proofs.
+func (txn *Transaction) StrictEqual(other *Transaction) bool {
+	return txn.fullHash() == other.fullHash()
+}
+
+func (txn *Transaction) fullHash() Hash256 {
+	h := hasherPool.Get().(*Hasher)
+	defer hasherPool.Put(h)
+	h.Reset()
+	txn.EncodeTo(h.E)
+	return h.Sum()
+}
+
 // DeepCopy returns a copy of txn that does not alias any of its memory.
 func (txn *Transaction) DeepCopy() Transaction {
 	c := *txn
@@ -376,12 +494,14 @@ func (txn *Transaction) DeepCopy() Transaction {
 	for i := range c.SiacoinInputs {
 		c.SiacoinInputs[i].Parent.MerkleProof = append([]Hash256(nil), c.SiacoinInputs[i].Parent.MerkleProof...)
 		c.SiacoinInputs[i].Signatures = append([]Signature(nil), c.SiacoinInputs[i].Signatures...)
+		c.SiacoinInputs[i].CoveredFields = c.SiacoinInputs[i].CoveredFields.deepCopy()
 	}
 	c.SiacoinOutputs = append([]SiacoinOutput(nil), c.SiacoinOutputs...)
 	c.SiafundInputs = append([]SiafundInput(nil), c.SiafundInputs...)
 	for i := range c.SiafundInputs {
 		c.SiafundInputs[i].Parent.MerkleProof = append([]Hash256(nil), c.SiafundInputs[i].Parent.MerkleProof...)
 		c.SiafundInputs[i].Signatures = append([]Signature(nil), c.SiafundInputs[i].Signatures...)
+		c.SiafundInputs[i].CoveredFields = c.SiafundInputs[i].CoveredFields.deepCopy()
 	}
 	c.SiafundOutputs = append([]SiafundOutput(nil), c.SiafundOutputs...)
 	c.FileContracts = append([]FileContract(nil), c.FileContracts...)
@@ -402,6 +522,29 @@ func (txn *Transaction) DeepCopy() Transaction {
 	return c
 }
 
+func (cf *CoveredFields) deepCopy() *CoveredFields {
+	if cf == nil {
+		return nil
+	}
+	return &CoveredFields{
+		SiacoinInputs:  append([]uint64(nil), cf.SiacoinInputs...),
+		SiacoinOutputs: append([]uint64(nil), cf.SiacoinOutputs...),
+		SiafundInputs:  append([]uint64(nil), cf.SiafundInputs...),
+		SiafundOutputs: append([]uint64(nil), cf.SiafundOutputs...),
+	}
+}
+
+// ContractOperations returns the file contract operations within txn: newly
+// formed contracts, revisions, and resolutions. Resolutions are returned in
+// transaction order; use their Mode method to determine how each one resolves
+// its contract.
+func (txn *Transaction) ContractOperations() (formations []FileContract, revisions []FileContractRevision, resolutions []FileContractResolution) {
+	formations = append(formations, txn.FileContracts...)
+	revisions = append(revisions, txn.FileContractRevisions...)
+	resolutions = append(resolutions, txn.FileContractResolutions...)
+	return
+}
+
 // SiacoinOutputID returns the ID of the siacoin output at index i.
 func (txn *Transaction) SiacoinOutputID(i int) ElementID {
 	return ElementID{
@@ -412,6 +555,11 @@ func (txn *Transaction) SiacoinOutputID(i int) ElementID {
 
 // SiafundClaimOutputID returns the ID of the siacoin claim output for the
 // siafund input at index i.
+//
+// Claim outputs are not listed in the transaction; instead, each siafund input
+// implicitly creates exactly one claim output, paid to its ClaimAddress. The
+// number of claim outputs therefore always equals the number of siafund inputs,
+// which SiafundOutputID relies upon.
 func (txn *Transaction) SiafundClaimOutputID(i int) ElementID {
 	return ElementID{
 		Source: Hash256(txn.ID()),
@@ -435,6 +583,54 @@ func (txn *Transaction) FileContractID(i int) ElementID {
 	}
 }
 
+// TransactionOutputIDs contains the IDs of every element created by a
+// transaction, grouped by type.
+type TransactionOutputIDs struct {
+	SiacoinOutputs []ElementID
+	ClaimOutputs   []ElementID
+	SiafundOutputs []ElementID
+	FileContracts  []ElementID
+	// RenewedFileContracts contains the ID of the new contract created by each
+	// resolution that renews its parent contract.
+	RenewedFileContracts []ElementID
+	// ResolutionOutputs contains the IDs of the renter and host outputs
+	// created by each file contract resolution, in that order.
+	ResolutionOutputs []ElementID
+}
+
+// OutputIDs returns the IDs of every element created by txn.
+func (txn *Transaction) OutputIDs() TransactionOutputIDs {
+	txid := Hash256(txn.ID())
+	var index uint64
+	nextIDs := func(n int) []ElementID {
+		if n == 0 {
+			return nil
+		}
+		ids := make([]ElementID, n)
+		for i := range ids {
+			ids[i] = ElementID{Source: txid, Index: index}
+			index++
+		}
+		return ids
+	}
+
+	ids := TransactionOutputIDs{
+		SiacoinOutputs: nextIDs(len(txn.SiacoinOutputs)),
+		ClaimOutputs:   nextIDs(len(txn.SiafundInputs)),
+		SiafundOutputs: nextIDs(len(txn.SiafundOutputs)),
+		FileContracts:  nextIDs(len(txn.FileContracts)),
+	}
+	// resolutions create (optionally) a renewed contract, followed by the
+	// renter and host outputs
+	for i := range txn.FileContractResolutions {
+		if txn.FileContractResolutions[i].HasRenewal() {
+			ids.RenewedFileContracts = append(ids.RenewedFileContracts, nextIDs(1)...)
+		}
+		ids.ResolutionOutputs = append(ids.ResolutionOutputs, nextIDs(2)...)
+	}
+	return ids
+}
+
 // EphemeralSiacoinElement returns txn.SiacoinOutputs[i] as an ephemeral
 // SiacoinElement.
 func (txn *Transaction) EphemeralSiacoinElement(i int) SiacoinElement {
@@ -473,8 +669,11 @@ func (h BlockHeader) ParentIndex() ChainIndex {
 	}
 }
 
-// ID returns a hash that uniquely identifies a block.
-func (h BlockHeader) ID() BlockID {
+// MiningBytes returns the 80-byte buffer hashed by ID. Mining hardware grinds
+// the nonce, which occupies bytes [32:40]; the remaining fields of the header
+// are either covered by the Commitment or not hashed at all. To serialize the
+// full header, use EncodeTo.
+func (h BlockHeader) MiningBytes() []byte {
 	// NOTE: although in principle we only need to hash 48 bytes of data, we
 	// must ensure compatibility with existing Sia mining hardware, which
 	// expects an 80-byte buffer with the nonce at [32:40].
@@ -483,7 +682,12 @@ func (h BlockHeader) ID() BlockID {
 	binary.LittleEndian.PutUint64(buf[32:], h.Nonce)
 	binary.LittleEndian.PutUint64(buf[40:], uint64(h.Timestamp.Unix()))
 	copy(buf[48:], h.Commitment[:])
-	return BlockID(HashBytes(buf))
+	return buf
+}
+
+// ID returns a hash that uniquely identifies a block.
+func (h BlockHeader) ID() BlockID {
+	return BlockID(HashBytes(h.MiningBytes()))
 }
 
 // CurrentTimestamp returns the current time, rounded to the nearest second. The
@@ -530,6 +734,13 @@ type Work struct {
 
 // Add returns w+v, wrapping on overflow.
 func (w Work) Add(v Work) Work {
+	r, _ := w.AddWithOverflow(v)
+	return r
+}
+
+// AddWithOverflow returns w+v, along with a boolean indicating whether the
+// result overflowed.
+func (w Work) AddWithOverflow(v Work) (Work, bool) {
 	var r Work
 	var sum, c uint64
 	for i := 24; i >= 0; i -= 8 {
@@ -538,6 +749,18 @@ func (w Work) Add(v Work) Work {
 		sum, c = bits.Add64(wi, vi, c)
 		binary.BigEndian.PutUint64(r.NumHashes[i:], sum)
 	}
+	return r, c != 0
+}
+
+// SaturatingAdd returns w+v, or the maximum representable Work if the result
+// would overflow.
+func (w Work) SaturatingAdd(v Work) Work {
+	r, overflow := w.AddWithOverflow(v)
+	if overflow {
+		for i := range r.NumHashes {
+			r.NumHashes[i] = 0xFF
+		}
+	}
 	return r
 }
 
@@ -607,14 +830,7 @@ func WorkRequiredForHash(id BlockID) Work {
 	}
 
 	// To get the expected number of hashes required, simply divide 2^256 by id.
-	//
-	// TODO: write a zero-alloc uint256 division instead of using big.Int
-	maxTarget := new(big.Int).Lsh(big.NewInt(1), 256)
-	idInt := new(big.Int).SetBytes(id[:])
-	quo := maxTarget.Div(maxTarget, idInt)
-	var w Work
-	quo.FillBytes(w.NumHashes[:])
-	return w
+	return Work{NumHashes: maxDiv(uint256FromBytes(id)).bytes()}
 }
 
 // HashRequiringWork returns the best BlockID that the given amount of Work
@@ -632,14 +848,24 @@ func HashRequiringWork(w Work) BlockID {
 			0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
 		}
 	}
-	maxTarget := new(big.Int).Lsh(big.NewInt(1), 256)
-	workInt := new(big.Int).SetBytes(w.NumHashes[:])
-	quo := maxTarget.Div(maxTarget, workInt)
-	var id BlockID
-	quo.FillBytes(id[:])
-	return id
+	return BlockID(maxDiv(uint256FromBytes(w.NumHashes)).bytes())
 }
 
+// WorkToTarget converts a difficulty, expressed as Work, to the target that a
+// BlockID must meet (see MeetsTarget). It is equivalent to HashRequiringWork:
+// the target is 2^256 / w, rounded down.
+func WorkToTarget(w Work) BlockID { return HashRequiringWork(w) }
+
+// TargetToWork converts a target to the expected amount of Work required to
+// meet it. It is equivalent to WorkRequiredForHash: the Work is 2^256 / t,
+// rounded down.
+//
+// Because both conversions round down, TargetToWork(WorkToTarget(w)) is never
+// less than w; it is exactly w for any w below 2^128, and the error grows with
+// w above that. Targets are stable: WorkToTarget(TargetToWork(t)) == t for any
+// t produced by WorkToTarget.
+func TargetToWork(t BlockID) Work { return WorkRequiredForHash(t) }
+
 // HashBytes computes the hash of b using Sia's hash function.
 func HashBytes(b []byte) Hash256 { return blake2b.Sum256(b) }
 
@@ -674,7 +900,13 @@ func marshalJSONHex(prefix string, data []byte) ([]byte, error) {
 	return []byte(`"` + stringerHex(prefix, data) + `"`), nil
 }
 
+// unmarshalJSONHex unmarshals a JSON string produced by marshalJSONHex. As with
+// every type in this package, null is a no-op, following the convention of
+// encoding/json.
 func unmarshalJSONHex(dst []byte, prefix string, data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
 	return unmarshalHex(dst, prefix, bytes.Trim(data, `"`))
 }
 
@@ -720,6 +952,31 @@ func (ci *ChainIndex) UnmarshalText(b []byte) (err error) {
 	return nil
 }
 
+// chainIndexKeyLen is the length of a ChainIndex's binary key encoding.
+const chainIndexKeyLen = 8 + len(BlockID{})
+
+// MarshalBinary implements encoding.BinaryMarshaler. Unlike EncodeTo, which
+// writes the height in little-endian order, MarshalBinary returns a fixed-width
+// key with a big-endian height followed by the ID, so that keys compare
+// byte-wise in the same order as their indices: by height, then by ID. This
+// makes it suitable for keying chain databases.
+func (ci ChainIndex) MarshalBinary() ([]byte, error) {
+	b := make([]byte, chainIndexKeyLen)
+	binary.BigEndian.PutUint64(b, ci.Height)
+	copy(b[8:], ci.ID[:])
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (ci *ChainIndex) UnmarshalBinary(b []byte) error {
+	if len(b) != chainIndexKeyLen {
+		return fmt.Errorf("decoding chain index key failed: expected %v bytes, got %v", chainIndexKeyLen, len(b))
+	}
+	ci.Height = binary.BigEndian.Uint64(b)
+	copy(ci.ID[:], b[8:])
+	return nil
+}
+
 // ParseChainIndex parses a chain index from a string.
 func ParseChainIndex(s string) (ci ChainIndex, err error) {
 	err = ci.UnmarshalText([]byte(s))
@@ -779,8 +1036,11 @@ func (a Address) MarshalJSON() ([]byte, error) {
 	return marshalJSONHex("addr", append(a[:], checksum[:6]...))
 }
 
-// UnmarshalJSON implements json.Unmarshaler.
+// UnmarshalJSON implements json.Unmarshaler. null is a no-op.
 func (a *Address) UnmarshalJSON(b []byte) (err error) {
+	if string(b) == "null" {
+		return nil
+	}
 	return a.UnmarshalText(bytes.Trim(b, `"`))
 }
 
@@ -872,8 +1132,11 @@ func (w *Work) UnmarshalText(b []byte) error {
 	return nil
 }
 
-// UnmarshalJSON implements json.Unmarshaler.
+// UnmarshalJSON implements json.Unmarshaler. null is a no-op.
 func (w *Work) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
 	return w.UnmarshalText(bytes.Trim(b, `"`))
 }
 
@@ -1,7 +1,13 @@
 package types
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestWork(t *testing.T) {
@@ -29,6 +35,373 @@ func TestWork(t *testing.T) {
 	}
 }
 
+func TestWorkSaturatingAdd(t *testing.T) {
+	var max Work
+	for i := range max.NumHashes {
+		max.NumHashes[i] = 0xFF
+	}
+	one := Work{NumHashes: [32]byte{31: 1}}
+	nearMax := max.Sub(one)
+
+	if sum, overflow := nearMax.AddWithOverflow(one); overflow || sum != max {
+		t.Fatalf("expected %v without overflow, got %v (overflow: %v)", max, sum, overflow)
+	} else if _, overflow := max.AddWithOverflow(one); !overflow {
+		t.Fatal("expected overflow")
+	}
+
+	// Add wraps, while SaturatingAdd clamps
+	if sum := max.Add(one); sum != (Work{}) {
+		t.Fatalf("expected Add to wrap to 0, got %v", sum)
+	} else if sum := max.SaturatingAdd(one); sum != max {
+		t.Fatalf("expected SaturatingAdd to clamp to %v, got %v", max, sum)
+	} else if sum := nearMax.SaturatingAdd(max); sum != max {
+		t.Fatalf("expected SaturatingAdd to clamp to %v, got %v", max, sum)
+	} else if sum := nearMax.SaturatingAdd(one); sum != max {
+		t.Fatalf("expected %v, got %v", max, sum)
+	}
+
+	// a saturated total must never compare as less than a smaller total
+	if nearMax.SaturatingAdd(nearMax).Cmp(nearMax) < 0 {
+		t.Fatal("saturated work compares as less than its addend")
+	}
+}
+
+func TestRemainingDuration(t *testing.T) {
+	fce := FileContractElement{FileContract: FileContract{WindowStart: 100, WindowEnd: 110}}
+	tests := []struct {
+		height uint64
+		exp    uint64
+	}{
+		{0, 100},
+		{99, 1},
+		{100, 0},
+		{105, 0},
+		{200, 0},
+	}
+	for _, test := range tests {
+		if got := fce.RemainingDuration(test.height); got != test.exp {
+			t.Errorf("expected remaining duration %v at height %v, got %v", test.exp, test.height, got)
+		}
+	}
+}
+
+func TestTransactionEqual(t *testing.T) {
+	txn := Transaction{
+		SiacoinInputs: []SiacoinInput{{
+			Parent: SiacoinElement{
+				StateElement: StateElement{
+					ID:          ElementID{Source: Hash256{1}, Index: 2},
+					LeafIndex:   3,
+					MerkleProof: []Hash256{{4}, {5}},
+				},
+			},
+			SpendPolicy: AnyoneCanSpend(),
+			Signatures:  []Signature{{6}},
+		}},
+		SiacoinOutputs: []SiacoinOutput{{Value: Siacoins(1)}},
+		MinerFee:       Siacoins(1),
+	}
+
+	// updating the Merkle proof does not change the semantic content
+	updated := txn.DeepCopy()
+	updated.SiacoinInputs[0].Parent.MerkleProof = []Hash256{{7}, {8}, {9}}
+	if !txn.Equal(&updated) {
+		t.Fatal("transactions with different proofs should be equal")
+	} else if txn.StrictEqual(&updated) {
+		t.Fatal("transactions with different proofs should not be strictly equal")
+	}
+
+	// neither does changing a signature
+	resigned := txn.DeepCopy()
+	resigned.SiacoinInputs[0].Signatures[0][0] ^= 1
+	if !txn.Equal(&resigned) {
+		t.Fatal("transactions with different signatures should be equal")
+	} else if txn.StrictEqual(&resigned) {
+		t.Fatal("transactions with different signatures should not be strictly equal")
+	}
+
+	// changing an output does
+	modified := txn.DeepCopy()
+	modified.SiacoinOutputs[0].Value = Siacoins(2)
+	if txn.Equal(&modified) {
+		t.Fatal("transactions with different outputs should not be equal")
+	}
+
+	dup := txn.DeepCopy()
+	if !txn.StrictEqual(&dup) {
+		t.Fatal("copy of transaction should be strictly equal")
+	}
+}
+
+func TestOutputIDs(t *testing.T) {
+	txn := Transaction{
+		SiacoinInputs:  []SiacoinInput{{Parent: SiacoinElement{StateElement: StateElement{ID: ElementID{Source: Hash256{1}}}}}},
+		SiacoinOutputs: []SiacoinOutput{{Value: Siacoins(1)}, {Value: Siacoins(2)}},
+		SiafundInputs: []SiafundInput{
+			{Parent: SiafundElement{StateElement: StateElement{ID: ElementID{Source: Hash256{2}}}}},
+			{Parent: SiafundElement{StateElement: StateElement{ID: ElementID{Source: Hash256{3}}}}},
+		},
+		SiafundOutputs: []SiafundOutput{{Value: 1}, {Value: 2}, {Value: 3}},
+		FileContracts:  []FileContract{{Filesize: 1}, {Filesize: 2}},
+		FileContractResolutions: []FileContractResolution{
+			{Parent: FileContractElement{StateElement: StateElement{ID: ElementID{Source: Hash256{4}}}}},
+			{
+				Parent:  FileContractElement{StateElement: StateElement{ID: ElementID{Source: Hash256{5}}}},
+				Renewal: FileContractRenewal{InitialRevision: FileContract{Filesize: 3}},
+			},
+		},
+	}
+
+	ids := txn.OutputIDs()
+	check := func(name string, got []ElementID, exp func(int) ElementID, n int) {
+		t.Helper()
+		if len(got) != n {
+			t.Fatalf("expected %v %v, got %v", n, name, len(got))
+		}
+		for i := range got {
+			if got[i] != exp(i) {
+				t.Errorf("%v %v: expected %v, got %v", name, i, exp(i), got[i])
+			}
+		}
+	}
+	check("siacoin outputs", ids.SiacoinOutputs, txn.SiacoinOutputID, 2)
+	check("claim outputs", ids.ClaimOutputs, txn.SiafundClaimOutputID, 2)
+	check("siafund outputs", ids.SiafundOutputs, txn.SiafundOutputID, 3)
+	check("file contracts", ids.FileContracts, txn.FileContractID, 2)
+
+	// resolutions follow the file contracts: the first creates a renter and
+	// host output, and the second creates a renewed contract followed by a
+	// renter and host output
+	next := func(i int) ElementID { return txn.FileContractID(len(txn.FileContracts) + i) }
+	check("renewed contracts", ids.RenewedFileContracts, func(int) ElementID { return next(2) }, 1)
+	resolutionIndices := []int{0, 1, 3, 4}
+	check("resolution outputs", ids.ResolutionOutputs, func(i int) ElementID { return next(resolutionIndices[i]) }, 4)
+
+	// every ID should be unique
+	seen := make(map[ElementID]bool)
+	for _, group := range [][]ElementID{ids.SiacoinOutputs, ids.ClaimOutputs, ids.SiafundOutputs, ids.FileContracts, ids.RenewedFileContracts, ids.ResolutionOutputs} {
+		for _, id := range group {
+			if seen[id] {
+				t.Fatalf("duplicate ID %v", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestContractOperations(t *testing.T) {
+	txn := Transaction{
+		FileContracts:         []FileContract{{Filesize: 1}},
+		FileContractRevisions: []FileContractRevision{{Revision: FileContract{RevisionNumber: 1}}},
+		FileContractResolutions: []FileContractResolution{
+			{Renewal: FileContractRenewal{RenterRollover: Siacoins(1)}},
+			{StorageProof: StorageProof{WindowStart: ChainIndex{Height: 1}}},
+			{Finalization: FileContract{RevisionNumber: MaxRevisionNumber}},
+			{},
+		},
+	}
+	formations, revisions, resolutions := txn.ContractOperations()
+	if len(formations) != 1 || len(revisions) != 1 || len(resolutions) != 4 {
+		t.Fatalf("wrong number of operations: %v formations, %v revisions, %v resolutions", len(formations), len(revisions), len(resolutions))
+	}
+	exp := []ResolutionMode{ResolutionRenewal, ResolutionStorageProof, ResolutionFinalization, ResolutionMissed}
+	for i, fcr := range resolutions {
+		if fcr.Mode() != exp[i] {
+			t.Errorf("resolution %v: expected mode %v, got %v", i, exp[i], fcr.Mode())
+		}
+	}
+
+	// the returned slices should not alias the transaction
+	formations[0].Filesize = 2
+	if txn.FileContracts[0].Filesize != 1 {
+		t.Fatal("ContractOperations aliased transaction memory")
+	}
+}
+
+func TestMapKeyJSON(t *testing.T) {
+	// maps keyed by identifier types must marshal deterministically, which
+	// encoding/json guarantees only if the key type implements
+	// encoding.TextMarshaler
+	balances := make(map[Address]Currency)
+	contracts := make(map[ElementID]uint64)
+	for i := 0; i < 20; i++ {
+		balances[Address{byte(i)}] = Siacoins(uint32(i))
+		contracts[ElementID{Source: Hash256{byte(i)}, Index: uint64(i)}] = uint64(i)
+	}
+	for _, m := range []interface{}{balances, contracts} {
+		js, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if js2, _ := json.Marshal(m); !bytes.Equal(js, js2) {
+				t.Fatalf("nondeterministic JSON for %T:\n%s\n%s", m, js, js2)
+			}
+		}
+
+		dec := reflect.New(reflect.TypeOf(m))
+		if err := json.Unmarshal(js, dec.Interface()); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(dec.Elem().Interface(), m) {
+			t.Fatalf("%T did not survive JSON roundtrip", m)
+		}
+	}
+}
+
+func TestNullJSON(t *testing.T) {
+	// following encoding/json, unmarshaling null should leave every type
+	// unchanged, whether the type implements json.Unmarshaler or only
+	// encoding.TextUnmarshaler
+	c := Siacoins(1)
+	p := AnyoneCanSpend()
+	vals := []interface{}{
+		&Hash256{1},
+		&ChainIndex{Height: 1},
+		&ElementID{Index: 1},
+		&Address{1},
+		&BlockID{1},
+		&PublicKey{1},
+		&TransactionID{1},
+		&Signature{1},
+		&Work{NumHashes: [32]byte{1}},
+		&c,
+		&p,
+	}
+	for _, v := range vals {
+		orig := reflect.ValueOf(v).Elem().Interface()
+		if err := json.Unmarshal([]byte("null"), v); err != nil {
+			t.Errorf("%T: unmarshaling null failed: %v", v, err)
+		} else if !reflect.DeepEqual(reflect.ValueOf(v).Elem().Interface(), orig) {
+			t.Errorf("%T: unmarshaling null modified value", v)
+		}
+	}
+}
+
+func TestChainIndexBinary(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	indices := make([]ChainIndex, 200)
+	for i := range indices {
+		indices[i].Height = rng.Uint64() >> (rng.Intn(8) * 8)
+		rng.Read(indices[i].ID[:])
+	}
+	// include indices with equal heights, differing only by ID
+	indices[1].Height = indices[0].Height
+	indices[1].ID[31] = indices[0].ID[31] + 1
+
+	keys := make([][]byte, len(indices))
+	for i, ci := range indices {
+		key, err := ci.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(key) != chainIndexKeyLen {
+			t.Fatalf("expected key length %v, got %v", chainIndexKeyLen, len(key))
+		}
+		var dec ChainIndex
+		if err := dec.UnmarshalBinary(key); err != nil {
+			t.Fatal(err)
+		} else if dec != ci {
+			t.Fatalf("chain index did not survive roundtrip: expected %v, got %v", ci, dec)
+		}
+		keys[i] = key
+	}
+
+	// byte-wise ordering of keys should match ordering by height, then ID
+	for i := range indices {
+		for j := range indices {
+			a, b := indices[i], indices[j]
+			exp := bytes.Compare(a.ID[:], b.ID[:])
+			if a.Height < b.Height {
+				exp = -1
+			} else if a.Height > b.Height {
+				exp = 1
+			}
+			if got := bytes.Compare(keys[i], keys[j]); got != exp {
+				t.Fatalf("comparing %v and %v: expected %v, got %v", a, b, exp, got)
+			}
+		}
+	}
+
+	var ci ChainIndex
+	if err := ci.UnmarshalBinary(keys[0][:chainIndexKeyLen-1]); err == nil {
+		t.Fatal("expected error decoding truncated key")
+	}
+}
+
+func TestBlockHeaderMiningBytes(t *testing.T) {
+	h := BlockHeader{
+		Height:       1234,
+		ParentID:     BlockID{1, 2, 3},
+		Nonce:        0x0102030405060708,
+		Timestamp:    time.Unix(734600000, 0).UTC(),
+		MinerAddress: Address{4, 5, 6},
+		Commitment:   Hash256{7, 8, 9},
+	}
+	buf := h.MiningBytes()
+	if len(buf) != 80 {
+		t.Fatalf("expected 80-byte mining buffer, got %v bytes", len(buf))
+	} else if binary.LittleEndian.Uint64(buf[32:40]) != h.Nonce {
+		t.Fatal("nonce not placed at [32:40]")
+	} else if binary.LittleEndian.Uint64(buf[40:48]) != uint64(h.Timestamp.Unix()) {
+		t.Fatal("timestamp not placed at [40:48]")
+	} else if !bytes.Equal(buf[48:], h.Commitment[:]) {
+		t.Fatal("commitment not placed at [48:80]")
+	} else if BlockID(HashBytes(buf)) != h.ID() {
+		t.Fatal("ID is not the hash of the mining buffer")
+	}
+
+	// grinding the nonce in the buffer should match changing the header
+	binary.LittleEndian.PutUint64(buf[32:], h.Nonce+1)
+	h.Nonce++
+	if BlockID(HashBytes(buf)) != h.ID() {
+		t.Fatal("modified mining buffer does not match modified header")
+	}
+
+	// the full header, unlike the mining buffer, survives an encoding roundtrip
+	var enc bytes.Buffer
+	e := NewEncoder(&enc)
+	h.EncodeTo(e)
+	e.Flush()
+	var dec BlockHeader
+	d := NewBufDecoder(enc.Bytes())
+	dec.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if dec != h {
+		t.Fatalf("header did not survive roundtrip: expected %v, got %v", h, dec)
+	}
+}
+
+func TestWorkTargetConversion(t *testing.T) {
+	// a simple case
+	target := WorkToTarget(Work{NumHashes: [32]byte{31: 4}})
+	if target != (BlockID{0b01000000}) {
+		t.Fatalf("expected target %v, got %v", BlockID{0b01000000}, target)
+	} else if w := TargetToWork(target); w.NumHashes != ([32]byte{31: 4}) {
+		t.Fatalf("expected work 4, got %v", w)
+	}
+
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 10000; i++ {
+		// choose work with a random bit length
+		var w Work
+		n := 1 + rng.Intn(32)
+		rng.Read(w.NumHashes[32-n:])
+		if w.Cmp(Work{NumHashes: [32]byte{31: 1}}) <= 0 {
+			continue
+		}
+
+		target := WorkToTarget(w)
+		rt := TargetToWork(target)
+		if rt.Cmp(w) < 0 {
+			t.Fatalf("roundtrip of %v produced less work (%v)", w, rt)
+		} else if n <= 16 && rt != w {
+			t.Fatalf("roundtrip of %v should be exact, got %v", w, rt)
+		} else if WorkToTarget(rt) != target {
+			t.Fatalf("target %v is not stable", target)
+		}
+	}
+}
+
 func BenchmarkWork(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// A uint256 is a 256-bit unsigned integer, stored as little-endian 64-bit
+// limbs. It exists solely to convert between Work and BlockIDs without
+// allocating.
+type uint256 [4]uint64
+
+func uint256FromBytes(b [32]byte) (u uint256) {
+	for i := range u {
+		u[i] = binary.BigEndian.Uint64(b[32-8*(i+1):])
+	}
+	return
+}
+
+func (u uint256) bytes() (b [32]byte) {
+	for i := range u {
+		binary.BigEndian.PutUint64(b[32-8*(i+1):], u[i])
+	}
+	return
+}
+
+// maxDiv returns floor(2^256 / d). It panics if d is 0 or 1.
+//
+// 2^256 cannot be represented, so instead we compute (2^256-1) / d using
+// Knuth's Algorithm D, then correct the quotient if the remainder is d-1.
+func maxDiv(d uint256) uint256 {
+	n := len(d)
+	for n > 0 && d[n-1] == 0 {
+		n--
+	}
+	if n == 0 || (n == 1 && d[0] == 1) {
+		panic("maxDiv: divisor must be greater than 1")
+	}
+
+	var q, r uint256
+	if n == 1 {
+		// short division
+		var rem uint64
+		for i := len(q) - 1; i >= 0; i-- {
+			q[i], rem = bits.Div64(rem, ^uint64(0), d[0])
+		}
+		r[0] = rem
+	} else {
+		// normalize, such that the top bit of the divisor is set
+		s := uint(bits.LeadingZeros64(d[n-1]))
+		var vn uint256
+		for i := n - 1; i > 0; i-- {
+			vn[i] = d[i]<<s | d[i-1]>>(64-s)
+		}
+		vn[0] = d[0] << s
+		// the dividend is all ones, so shifting it is easy
+		var un [5]uint64
+		for i := range un[:4] {
+			un[i] = ^uint64(0)
+		}
+		un[0] <<= s
+		un[4] = ^uint64(0) >> (64 - s) // 0 when s == 0
+
+		for j := len(q) - n; j >= 0; j-- {
+			// estimate the next quotient limb
+			var qhat, rhat uint64
+			overflow := false
+			if un[j+n] >= vn[n-1] {
+				qhat = ^uint64(0)
+				var c uint64
+				rhat, c = bits.Add64(un[j+n-1], vn[n-1], 0)
+				overflow = c != 0
+			} else {
+				qhat, rhat = bits.Div64(un[j+n], un[j+n-1], vn[n-1])
+			}
+			for !overflow {
+				ph, pl := bits.Mul64(qhat, vn[n-2])
+				if ph < rhat || (ph == rhat && pl <= un[j+n-2]) {
+					break
+				}
+				qhat--
+				var c uint64
+				rhat, c = bits.Add64(rhat, vn[n-1], 0)
+				overflow = c != 0
+			}
+
+			// multiply and subtract
+			var k, c uint64
+			for i := 0; i < n; i++ {
+				ph, pl := bits.Mul64(qhat, vn[i])
+				pl, c = bits.Add64(pl, k, 0)
+				ph += c
+				un[i+j], c = bits.Sub64(un[i+j], pl, 0)
+				k = ph + c
+			}
+			un[j+n], c = bits.Sub64(un[j+n], k, 0)
+			if c != 0 {
+				// qhat was one too large; add back
+				qhat--
+				c = 0
+				for i := 0; i < n; i++ {
+					un[i+j], c = bits.Add64(un[i+j], vn[i], c)
+				}
+				un[j+n] += c
+			}
+			q[j] = qhat
+		}
+
+		// unnormalize the remainder
+		for i := 0; i < n-1; i++ {
+			r[i] = un[i]>>s | un[i+1]<<(64-s)
+		}
+		r[n-1] = un[n-1]>>s | un[n]<<(64-s)
+	}
+
+	// if (2^256-1) mod d == d-1, then d divides 2^256 one more time
+	r1, c := bits.Add64(r[0], 1, 0)
+	r[0] = r1
+	for i := 1; i < len(r); i++ {
+		r[i], c = bits.Add64(r[i], 0, c)
+	}
+	if r == d {
+		c = 1
+		for i := range q {
+			q[i], c = bits.Add64(q[i], 0, c)
+		}
+	}
+	return q
+}
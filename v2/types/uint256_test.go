@@ -0,0 +1,73 @@
+package types
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func bigMaxDiv(d [32]byte) (q [32]byte) {
+	max := new(big.Int).Lsh(big.NewInt(1), 256)
+	max.Div(max, new(big.Int).SetBytes(d[:])).FillBytes(q[:])
+	return
+}
+
+func TestMaxDiv(t *testing.T) {
+	check := func(d [32]byte) {
+		t.Helper()
+		if got, exp := maxDiv(uint256FromBytes(d)).bytes(), bigMaxDiv(d); got != exp {
+			t.Fatalf("2^256 / %x: expected %x, got %x", d, exp, got)
+		}
+	}
+
+	// edge cases: small divisors, powers of two, and values around limb
+	// boundaries
+	var max [32]byte
+	for i := range max {
+		max[i] = 0xFF
+	}
+	check(max)
+	check([32]byte{31: 2})
+	check([32]byte{31: 3})
+	for i := 1; i < 256; i++ {
+		var d [32]byte
+		d[31-i/8] = 1 << (i % 8)
+		check(d)
+		// 2^i - 1 and 2^i + 1
+		n := new(big.Int).Lsh(big.NewInt(1), uint(i))
+		n.Sub(n, big.NewInt(1)).FillBytes(d[:])
+		if i > 1 {
+			check(d)
+		}
+		n.Add(n, big.NewInt(2)).FillBytes(d[:])
+		check(d)
+	}
+
+	// random divisors of every length
+	rng := rand.New(rand.NewSource(0))
+	for i := 0; i < 100000; i++ {
+		var d [32]byte
+		n := 1 + rng.Intn(32)
+		rng.Read(d[32-n:])
+		if new(big.Int).SetBytes(d[:]).Cmp(big.NewInt(1)) <= 0 {
+			continue
+		}
+		check(d)
+	}
+}
+
+func BenchmarkMaxDiv(b *testing.B) {
+	d := [32]byte{0, 0, 0, 0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC, 0xDE, 0xF0}
+	b.Run("uint256", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			maxDiv(uint256FromBytes(d))
+		}
+	})
+	b.Run("big.Int", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bigMaxDiv(d)
+		}
+	})
+}